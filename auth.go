@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+
+	"github.com/chhlga/budge/internal/config"
+	"github.com/chhlga/budge/internal/oauth"
+)
+
+// runAuth signs in with OAuth2 and stores the token for later runs. The
+// device code grant is used unless -loopback asks for a browser redirect.
+func runAuth(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("budge auth", flag.ContinueOnError)
+	loopback := flags.Bool("loopback", false, "sign in through a browser redirect to a local port instead of a device code")
+	name := flags.String("account", "", "name of the account to sign in to, needed when several use OAuth2")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	acct, err := authAccount(cfg, *name)
+	if err != nil {
+		return err
+	}
+
+	store, err := tokenStore(acct)
+	if err != nil {
+		return err
+	}
+	client := acct.Credentials.OAuth2.OAuth2Client()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var token *oauth.Token
+	if *loopback {
+		token, err = client.LoopbackAuth(ctx, func(authURL string) error {
+			fmt.Printf("Open this URL to sign in:\n\n  %s\n\n", authURL)
+			openBrowser(authURL)
+			return nil
+		})
+	} else {
+		var code *oauth.DeviceCode
+		code, err = client.DeviceAuth(ctx)
+		if err != nil {
+			return err
+		}
+		if code.Message != "" {
+			fmt.Println(code.Message)
+		} else {
+			fmt.Printf("Visit %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+		}
+		fmt.Println("Waiting for you to sign in...")
+		token, err = client.DeviceToken(ctx, code)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := store.Save(token); err != nil {
+		return err
+	}
+	fmt.Printf("Signed in as %s, token stored in %s\n", acct.Credentials.Username, store.Path())
+	return nil
+}
+
+// authAccount picks the account to sign in to: the named one, or the only
+// account that uses OAuth2
+func authAccount(cfg *config.Config, name string) (config.AccountConfig, error) {
+	var candidates []config.AccountConfig
+	for _, acct := range cfg.AccountList() {
+		if name != "" && acct.Name != name {
+			continue
+		}
+		if acct.UsesOAuth2() {
+			candidates = append(candidates, acct)
+		} else if name != "" {
+			return acct, fmt.Errorf("account %s signs in with a password, not xoauth2 or oauthbearer", name)
+		}
+	}
+
+	switch {
+	case len(candidates) == 1:
+		return candidates[0], nil
+	case name != "":
+		return config.AccountConfig{}, fmt.Errorf("no account named %s", name)
+	case len(candidates) == 0:
+		return config.AccountConfig{}, fmt.Errorf("neither credentials nor smtp auth is xoauth2 or oauthbearer, nothing to sign in to")
+	default:
+		return config.AccountConfig{}, fmt.Errorf("several accounts use OAuth2, choose one with -account")
+	}
+}
+
+// tokenStore returns where the OAuth2 token of an account's user lives
+func tokenStore(acct config.AccountConfig) (*oauth.Store, error) {
+	path := acct.Credentials.OAuth2.TokenFile
+	if path == "" {
+		var err error
+		if path, err = oauth.DefaultStorePath(acct.Credentials.Username); err != nil {
+			return nil, fmt.Errorf("failed to locate token store: %w", err)
+		}
+	}
+	return oauth.NewStore(path), nil
+}
+
+// openBrowser tries to show url in the default browser. The URL has been
+// printed as well, so failing to start one is not an error.
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	_ = cmd.Start()
+}
@@ -1,39 +1,230 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"net/mail"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
+	"github.com/chhlga/budge/internal/oauth"
+	"github.com/chhlga/budge/internal/secret"
 	"gopkg.in/yaml.v3"
 )
 
-// Config represents the application configuration
+// Config represents the application configuration. A single account is
+// configured with the top-level server, smtp, credentials and identities
+// sections; several go in Accounts instead.
 type Config struct {
 	Server      ServerConfig      `yaml:"server"`
+	SMTP        SMTPConfig        `yaml:"smtp"`
 	Credentials CredentialsConfig `yaml:"credentials"`
+	Identities  []IdentityConfig  `yaml:"identities"`
+	Accounts    []AccountConfig   `yaml:"accounts"`
 	Behavior    BehaviorConfig    `yaml:"behavior"`
 	Display     DisplayConfig     `yaml:"display"`
 }
 
-// ServerConfig contains IMAP server settings
+// AccountConfig is one mail account with its own connection. Name labels
+// it in the account switcher and defaults to the username; DefaultFolder,
+// UnifiedMailboxes and SpecialMailboxes default to the behavior settings
+// of the same name.
+type AccountConfig struct {
+	Name             string            `yaml:"name"`
+	Server           ServerConfig      `yaml:"server"`
+	SMTP             SMTPConfig        `yaml:"smtp"`
+	Credentials      CredentialsConfig `yaml:"credentials"`
+	Identities       []IdentityConfig  `yaml:"identities"`
+	DefaultFolder    string            `yaml:"default_folder"`
+	UnifiedMailboxes []string          `yaml:"unified_mailboxes"`
+	SpecialMailboxes map[string]string `yaml:"special_mailboxes"`
+}
+
+// SpecialUses are the keys of special_mailboxes, the RFC 6154 roles a
+// mailbox can be given when the server doesn't mark it
+var SpecialUses = []string{"sent", "drafts", "archive", "all", "flagged", "junk", "trash"}
+
+// Inboxes returns the mailboxes the account adds to All Inboxes, its
+// default folder unless unified_mailboxes lists others
+func (a AccountConfig) Inboxes() []string {
+	if len(a.UnifiedMailboxes) > 0 {
+		return a.UnifiedMailboxes
+	}
+	return []string{a.DefaultFolder}
+}
+
+// AccountList returns the configured accounts. Without an accounts section
+// the top-level sections form the only account.
+func (c *Config) AccountList() []AccountConfig {
+	if len(c.Accounts) > 0 {
+		return c.Accounts
+	}
+	return []AccountConfig{{
+		Name:             c.Credentials.Username,
+		Server:           c.Server,
+		SMTP:             c.SMTP,
+		Credentials:      c.Credentials,
+		Identities:       c.Identities,
+		DefaultFolder:    c.Behavior.DefaultFolder,
+		UnifiedMailboxes: c.Behavior.UnifiedMailboxes,
+		SpecialMailboxes: c.Behavior.SpecialMailboxes,
+	}}
+}
+
+// setAccounts stores accounts returned by AccountList after changing them
+func (c *Config) setAccounts(accounts []AccountConfig) {
+	if len(c.Accounts) > 0 {
+		c.Accounts = accounts
+		return
+	}
+	a := accounts[0]
+	c.Server, c.SMTP, c.Credentials, c.Identities = a.Server, a.SMTP, a.Credentials, a.Identities
+}
+
+// ServerConfig contains IMAP server settings. Timeout is the number of
+// seconds an IMAP command may wait for the server to send anything, and
+// Keepalive the number of idle seconds after which the connection is
+// checked; zero picks the default.
 type ServerConfig struct {
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
-	TLS      bool   `yaml:"tls"`
-	STARTTLS bool   `yaml:"starttls"`
+	Host      string `yaml:"host"`
+	Port      int    `yaml:"port"`
+	TLS       bool   `yaml:"tls"`
+	STARTTLS  bool   `yaml:"starttls"`
+	Timeout   int    `yaml:"timeout"`
+	Keepalive int    `yaml:"keepalive"`
+}
+
+// SMTPConfig contains outgoing SMTP server settings.
+// Username and password default to the IMAP credentials when left empty,
+// and From defaults to the IMAP username. Sent messages are copied to the
+// Sent mailbox unless SkipSentCopy is set for providers that file them
+// on their own, such as Gmail. MaxAttachmentMB is the total attachment
+// size above which compose shows a warning. UndoSendSeconds holds sent
+// messages back for that long so the send can still be undone.
+type SMTPConfig struct {
+	Host            string `yaml:"host"`
+	Port            int    `yaml:"port"`
+	TLS             bool   `yaml:"tls"`
+	STARTTLS        bool   `yaml:"starttls"`
+	Auth            string `yaml:"auth"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	From            string `yaml:"from"`
+	SkipSentCopy    bool   `yaml:"skip_sent_copy"`
+	MaxAttachmentMB int    `yaml:"max_attachment_mb"`
+	UndoSendSeconds int    `yaml:"undo_send_seconds"`
 }
 
-// CredentialsConfig contains authentication credentials
+// Enabled reports whether an outgoing server has been configured
+func (s SMTPConfig) Enabled() bool {
+	return s.Host != ""
+}
+
+// IdentityConfig is an address the user can send from. The signature is
+// either given inline or read from SignatureFile, which may start with ~/
+// or be relative to the config file. BccSelf sends a copy to Address.
+type IdentityConfig struct {
+	Name          string `yaml:"name"`
+	Address       string `yaml:"address"`
+	ReplyTo       string `yaml:"reply_to"`
+	Signature     string `yaml:"signature"`
+	SignatureFile string `yaml:"signature_file"`
+	BccSelf       bool   `yaml:"bcc_self"`
+}
+
+// CredentialsConfig contains authentication credentials. Auth selects how
+// to sign in to IMAP: login (the default) with the password, or xoauth2 or
+// oauthbearer with the token obtained by running budge auth.
+//
+// The password comes from exactly one of Password, PasswordCommand (run
+// through the shell, its first line of output is used) or Keyring (the
+// system keyring entry for the username under the service "budge"). Load
+// resolves the latter two into Password.
 type CredentialsConfig struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Username        string       `yaml:"username"`
+	Password        string       `yaml:"password"`
+	PasswordCommand string       `yaml:"password_command"`
+	Keyring         bool         `yaml:"keyring"`
+	Auth            string       `yaml:"auth"`
+	OAuth2          OAuth2Config `yaml:"oauth2"`
+
+	// resolved is set once Password holds the result of another source
+	resolved bool
+}
+
+// passwordSources counts the password sources that are configured
+func (c CredentialsConfig) passwordSources() int {
+	n := 0
+	if c.Password != "" && !c.resolved {
+		n++
+	}
+	if c.PasswordCommand != "" {
+		n++
+	}
+	if c.Keyring {
+		n++
+	}
+	return n
+}
+
+// resolvePassword fills Password from the command or keyring
+func (c *CredentialsConfig) resolvePassword() error {
+	var err error
+	switch {
+	case c.PasswordCommand != "":
+		c.Password, err = secret.FromCommand(context.Background(), c.PasswordCommand)
+	case c.Keyring:
+		c.Password, err = secret.FromKeyring(c.Username)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.resolved = true
+	return nil
+}
+
+// OAuth2Config describes the OAuth2 client used by the xoauth2 and
+// oauthbearer mechanisms. Provider fills in the endpoints and scopes for
+// microsoft or google; the URLs and scopes given here override them, which
+// also allows any other authorization server. TokenFile defaults to a file
+// per username under ~/.local/share/budge/tokens.
+type OAuth2Config struct {
+	Provider      string   `yaml:"provider"`
+	Tenant        string   `yaml:"tenant"`
+	ClientID      string   `yaml:"client_id"`
+	ClientSecret  string   `yaml:"client_secret"`
+	Scopes        []string `yaml:"scopes"`
+	AuthURL       string   `yaml:"auth_url"`
+	TokenURL      string   `yaml:"token_url"`
+	DeviceAuthURL string   `yaml:"device_auth_url"`
+	TokenFile     string   `yaml:"token_file"`
+}
+
+// UsesOAuth2 reports whether IMAP or SMTP signs in with an OAuth2 token
+func (a AccountConfig) UsesOAuth2() bool {
+	return isOAuth2Mechanism(a.Credentials.Auth) || (a.SMTP.Enabled() && isOAuth2Mechanism(a.SMTP.Auth))
+}
+
+func isOAuth2Mechanism(auth string) bool {
+	switch strings.ToLower(auth) {
+	case "xoauth2", "oauthbearer":
+		return true
+	}
+	return false
 }
 
 // BehaviorConfig contains application behavior settings
 type BehaviorConfig struct {
-	DefaultFolder string `yaml:"default_folder"`
-	PageSize      int    `yaml:"page_size"`
-	PollInterval  int    `yaml:"poll_interval"`
+	DefaultFolder    string            `yaml:"default_folder"`
+	UnifiedMailboxes []string          `yaml:"unified_mailboxes"`
+	SpecialMailboxes map[string]string `yaml:"special_mailboxes"`
+	PageSize         int               `yaml:"page_size"`
+	PollInterval     int               `yaml:"poll_interval"`
+	SubscribedOnly   bool              `yaml:"subscribed_only"`
 }
 
 // DisplayConfig contains display preferences
@@ -70,12 +261,28 @@ func Load(path string) (*Config, error) {
 	if cfg.Display.Theme == "" {
 		cfg.Display.Theme = "auto"
 	}
+	accounts := cfg.AccountList()
+	for i := range accounts {
+		accounts[i].applyDefaults(cfg.Behavior)
+	}
+	cfg.setAccounts(accounts)
 
 	// Validate the configuration
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
 	}
 
+	accounts = cfg.AccountList()
+	for i := range accounts {
+		if err := accounts[i].load(filepath.Dir(path)); err != nil {
+			if len(cfg.Accounts) > 0 {
+				return nil, fmt.Errorf("account %s: %w", accounts[i].Name, err)
+			}
+			return nil, err
+		}
+	}
+	cfg.setAccounts(accounts)
+
 	// Check file permissions
 	if err := CheckPermissions(path); err != nil {
 		// Log warning but don't fail
@@ -87,20 +294,259 @@ func Load(path string) (*Config, error) {
 
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
-	if c.Server.Host == "" {
+	if len(c.Accounts) == 0 {
+		return c.AccountList()[0].validate()
+	}
+
+	if c.Server.Host != "" || c.Credentials.Username != "" {
+		return fmt.Errorf("configure either the top-level server and credentials or accounts, not both")
+	}
+	if c.SMTP != (SMTPConfig{}) || len(c.Identities) > 0 {
+		return fmt.Errorf("top-level smtp and identities are not used with accounts, set them per account")
+	}
+	names := make(map[string]bool)
+	for i, account := range c.Accounts {
+		if account.Name == "" {
+			account.Name = account.Credentials.Username
+		}
+		if err := account.validate(); err != nil {
+			return fmt.Errorf("account %d (%s): %w", i+1, account.Name, err)
+		}
+		if names[account.Name] {
+			return fmt.Errorf("account %d: name %q is used more than once", i+1, account.Name)
+		}
+		names[account.Name] = true
+	}
+	return nil
+}
+
+// applyDefaults fills in what an account inherits when left empty
+func (a *AccountConfig) applyDefaults(behavior BehaviorConfig) {
+	if a.Name == "" {
+		a.Name = a.Credentials.Username
+	}
+	if a.DefaultFolder == "" {
+		a.DefaultFolder = behavior.DefaultFolder
+	}
+	if len(a.UnifiedMailboxes) == 0 {
+		a.UnifiedMailboxes = behavior.UnifiedMailboxes
+	}
+	if len(a.SpecialMailboxes) == 0 {
+		a.SpecialMailboxes = behavior.SpecialMailboxes
+	}
+	if a.SMTP.MaxAttachmentMB == 0 {
+		a.SMTP.MaxAttachmentMB = 25
+	}
+	if a.SMTP.Enabled() {
+		if a.SMTP.Username == "" {
+			a.SMTP.Username = a.Credentials.Username
+		}
+		if a.SMTP.From == "" {
+			a.SMTP.From = a.Credentials.Username
+		}
+	}
+}
+
+// validate checks the settings of a single account
+func (a AccountConfig) validate() error {
+	if a.Server.Host == "" {
 		return fmt.Errorf("server host cannot be empty")
 	}
-	if c.Server.Port < 1 || c.Server.Port > 65535 {
-		return fmt.Errorf("server port must be between 1 and 65535, got %d", c.Server.Port)
+	if a.Server.Port < 1 || a.Server.Port > 65535 {
+		return fmt.Errorf("server port must be between 1 and 65535, got %d", a.Server.Port)
 	}
 
-	if c.Server.TLS && c.Server.STARTTLS {
+	if a.Server.TLS && a.Server.STARTTLS {
 		return fmt.Errorf("cannot enable both TLS and STARTTLS, choose one")
 	}
 
-	if c.Credentials.Username == "" {
+	if a.Server.Timeout < 0 {
+		return fmt.Errorf("server timeout cannot be negative, got %d", a.Server.Timeout)
+	}
+	if a.Server.Keepalive < 0 {
+		return fmt.Errorf("server keepalive cannot be negative, got %d", a.Server.Keepalive)
+	}
+
+	if a.Credentials.Username == "" {
 		return fmt.Errorf("credentials username cannot be empty")
 	}
+	switch strings.ToLower(a.Credentials.Auth) {
+	case "", "login", "xoauth2", "oauthbearer":
+	default:
+		return fmt.Errorf("credentials auth must be one of login, xoauth2 or oauthbearer, got %q", a.Credentials.Auth)
+	}
+	switch sources := a.Credentials.passwordSources(); {
+	case sources > 1:
+		return fmt.Errorf("credentials: set only one of password, password_command or keyring")
+	case sources == 0 && !isOAuth2Mechanism(a.Credentials.Auth):
+		return fmt.Errorf("credentials: set one of password, password_command or keyring")
+	}
+	if a.UsesOAuth2() {
+		if err := a.Credentials.OAuth2.validate(); err != nil {
+			return err
+		}
+	}
+
+	if a.SMTP.Enabled() {
+		if a.SMTP.Port < 1 || a.SMTP.Port > 65535 {
+			return fmt.Errorf("smtp port must be between 1 and 65535, got %d", a.SMTP.Port)
+		}
+		if a.SMTP.TLS && a.SMTP.STARTTLS {
+			return fmt.Errorf("cannot enable both TLS and STARTTLS for smtp, choose one")
+		}
+		switch strings.ToLower(a.SMTP.Auth) {
+		case "", "plain", "login", "xoauth2", "oauthbearer":
+		default:
+			return fmt.Errorf("smtp auth must be one of plain, login, xoauth2 or oauthbearer, got %q", a.SMTP.Auth)
+		}
+	}
+	for i, id := range a.Identities {
+		if id.Address == "" {
+			return fmt.Errorf("identity %d: address cannot be empty", i+1)
+		}
+		if _, err := mail.ParseAddress(id.Address); err != nil {
+			return fmt.Errorf("identity %d: invalid address %q: %w", i+1, id.Address, err)
+		}
+		if id.ReplyTo != "" {
+			if _, err := mail.ParseAddressList(id.ReplyTo); err != nil {
+				return fmt.Errorf("identity %d: invalid reply_to %q: %w", i+1, id.ReplyTo, err)
+			}
+		}
+		if id.Signature != "" && id.SignatureFile != "" {
+			return fmt.Errorf("identity %d: set either signature or signature_file, not both", i+1)
+		}
+	}
+
+	for use, mailbox := range a.SpecialMailboxes {
+		if !slices.Contains(SpecialUses, use) {
+			return fmt.Errorf("special_mailboxes: unknown role %q, use one of %s", use, strings.Join(SpecialUses, ", "))
+		}
+		if mailbox == "" {
+			return fmt.Errorf("special_mailboxes: %s needs a mailbox name", use)
+		}
+	}
+	roles := make(map[string]string)
+	for _, use := range SpecialUses {
+		mailbox, ok := a.SpecialMailboxes[use]
+		if !ok {
+			continue
+		}
+		if other, taken := roles[mailbox]; taken {
+			return fmt.Errorf("special_mailboxes: %s and %s both name %q, give each role its own mailbox", other, use, mailbox)
+		}
+		roles[mailbox] = use
+	}
+
+	if a.SMTP.MaxAttachmentMB < 0 {
+		return fmt.Errorf("smtp max_attachment_mb cannot be negative, got %d", a.SMTP.MaxAttachmentMB)
+	}
+	if a.SMTP.UndoSendSeconds < 0 {
+		return fmt.Errorf("smtp undo_send_seconds cannot be negative, got %d", a.SMTP.UndoSendSeconds)
+	}
+
+	return nil
+}
+
+// load resolves what the account refers to outside the config file: the
+// password, signature files and the token file path
+func (a *AccountConfig) load(configDir string) error {
+	if err := a.Credentials.resolvePassword(); err != nil {
+		return fmt.Errorf("failed to get password: %w", err)
+	}
+	if a.SMTP.Enabled() && a.SMTP.Password == "" {
+		a.SMTP.Password = a.Credentials.Password
+	}
+
+	if err := a.loadSignatures(configDir); err != nil {
+		return err
+	}
+
+	if tokenFile := a.Credentials.OAuth2.TokenFile; tokenFile != "" {
+		path, err := expandPath(tokenFile, configDir)
+		if err != nil {
+			return err
+		}
+		a.Credentials.OAuth2.TokenFile = path
+	}
+	return nil
+}
+
+// validate checks the OAuth2 client settings
+func (o OAuth2Config) validate() error {
+	if o.ClientID == "" {
+		return fmt.Errorf("oauth2 client_id cannot be empty")
+	}
+	if o.Provider != "" {
+		if _, _, ok := oauth.Provider(o.Provider, o.Tenant); !ok {
+			return fmt.Errorf("oauth2 provider must be microsoft or google, got %q", o.Provider)
+		}
+		return nil
+	}
+	if o.TokenURL == "" {
+		return fmt.Errorf("oauth2 needs a provider or a token_url")
+	}
+	return nil
+}
+
+// OAuth2Client returns the OAuth2 client described by the configuration,
+// with the provider's endpoints and scopes filled in
+func (o OAuth2Config) OAuth2Client() *oauth.Config {
+	endpoint, scopes, _ := oauth.Provider(o.Provider, o.Tenant)
+	if o.AuthURL != "" {
+		endpoint.AuthURL = o.AuthURL
+	}
+	if o.TokenURL != "" {
+		endpoint.TokenURL = o.TokenURL
+	}
+	if o.DeviceAuthURL != "" {
+		endpoint.DeviceAuthURL = o.DeviceAuthURL
+	}
+	if len(o.Scopes) > 0 {
+		scopes = o.Scopes
+	}
+
+	return &oauth.Config{
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
+		Scopes:       scopes,
+		Endpoint:     endpoint,
+	}
+}
+
+// expandPath resolves a leading ~/ and paths relative to the config file
+func expandPath(path, configDir string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve home directory for %s: %w", path, err)
+		}
+		return filepath.Join(home, rest), nil
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(configDir, path), nil
+	}
+	return path, nil
+}
+
+// loadSignatures reads identity signature files into Signature
+func (a *AccountConfig) loadSignatures(configDir string) error {
+	for i := range a.Identities {
+		id := &a.Identities[i]
+		if id.SignatureFile == "" {
+			continue
+		}
+
+		path, err := expandPath(id.SignatureFile, configDir)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read signature for identity %s: %w", id.Address, err)
+		}
+		id.Signature = string(data)
+	}
 
 	return nil
 }
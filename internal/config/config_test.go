@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -172,6 +173,22 @@ func TestValidate_InvalidPortTooHigh(t *testing.T) {
 	}
 }
 
+func TestValidate_NegativeTimeouts(t *testing.T) {
+	for _, server := range []ServerConfig{
+		{Host: "imap.example.com", Port: 993, Timeout: -1},
+		{Host: "imap.example.com", Port: 993, Keepalive: -5},
+	} {
+		cfg := &Config{
+			Server:      server,
+			Credentials: CredentialsConfig{Username: "user@example.com"},
+		}
+
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected validation error for %+v, got nil", server)
+		}
+	}
+}
+
 func TestValidate_ValidConfig(t *testing.T) {
 	cfg := &Config{
 		Server: ServerConfig{
@@ -276,3 +293,483 @@ credentials:
 		t.Errorf("Expected default theme 'auto', got '%s'", cfg.Display.Theme)
 	}
 }
+
+func TestLoad_SMTPDefaultsToIMAPCredentials(t *testing.T) {
+	configData := `
+server:
+  host: imap.example.com
+  port: 993
+  tls: true
+smtp:
+  host: smtp.example.com
+  port: 465
+  tls: true
+credentials:
+  username: user@example.com
+  password: "secret"
+`
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if !cfg.SMTP.Enabled() {
+		t.Fatal("Expected SMTP to be enabled")
+	}
+	if cfg.SMTP.Username != "user@example.com" {
+		t.Errorf("Expected SMTP username 'user@example.com', got '%s'", cfg.SMTP.Username)
+	}
+	if cfg.SMTP.Password != "secret" {
+		t.Errorf("Expected SMTP password 'secret', got '%s'", cfg.SMTP.Password)
+	}
+	if cfg.SMTP.From != "user@example.com" {
+		t.Errorf("Expected SMTP from 'user@example.com', got '%s'", cfg.SMTP.From)
+	}
+	if cfg.SMTP.MaxAttachmentMB != 25 {
+		t.Errorf("Expected default attachment limit 25, got %d", cfg.SMTP.MaxAttachmentMB)
+	}
+}
+
+func TestValidate_SMTP(t *testing.T) {
+	tests := []struct {
+		name    string
+		smtp    SMTPConfig
+		wantErr bool
+	}{
+		{"disabled", SMTPConfig{}, false},
+		{"valid", SMTPConfig{Host: "smtp.example.com", Port: 587, STARTTLS: true, Auth: "login"}, false},
+		{"invalid port", SMTPConfig{Host: "smtp.example.com", Port: 0}, true},
+		{"tls and starttls", SMTPConfig{Host: "smtp.example.com", Port: 465, TLS: true, STARTTLS: true}, true},
+		{"unknown auth", SMTPConfig{Host: "smtp.example.com", Port: 465, TLS: true, Auth: "cram-md5"}, true},
+		{"negative attachment limit", SMTPConfig{MaxAttachmentMB: -1}, true},
+		{"undo send delay", SMTPConfig{UndoSendSeconds: 10}, false},
+		{"negative undo send delay", SMTPConfig{UndoSendSeconds: -5}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server: ServerConfig{
+					Host: "imap.example.com",
+					Port: 993,
+					TLS:  true,
+				},
+				SMTP: tt.smtp,
+				Credentials: CredentialsConfig{
+					Username: "user@example.com",
+					Password: "secret",
+				},
+			}
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_OAuth2(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials CredentialsConfig
+		smtp        SMTPConfig
+		wantErr     bool
+	}{
+		{"password login", CredentialsConfig{Auth: "login", Password: "secret"}, SMTPConfig{}, false},
+		{"unknown auth", CredentialsConfig{Auth: "cram-md5"}, SMTPConfig{}, true},
+		{"provider", CredentialsConfig{Auth: "xoauth2", OAuth2: OAuth2Config{Provider: "microsoft", ClientID: "id"}}, SMTPConfig{}, false},
+		{"missing client id", CredentialsConfig{Auth: "xoauth2", OAuth2: OAuth2Config{Provider: "google"}}, SMTPConfig{}, true},
+		{"unknown provider", CredentialsConfig{Auth: "oauthbearer", OAuth2: OAuth2Config{Provider: "example", ClientID: "id"}}, SMTPConfig{}, true},
+		{"custom endpoint", CredentialsConfig{Auth: "oauthbearer", OAuth2: OAuth2Config{ClientID: "id", TokenURL: "http://127.0.0.1:8080/token"}}, SMTPConfig{}, false},
+		{"no endpoint", CredentialsConfig{Auth: "oauthbearer", OAuth2: OAuth2Config{ClientID: "id"}}, SMTPConfig{}, true},
+		{"smtp only", CredentialsConfig{}, SMTPConfig{Host: "smtp.example.com", Port: 587, Auth: "xoauth2"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.credentials.Username = "user@example.com"
+			cfg := &Config{
+				Server:      ServerConfig{Host: "imap.example.com", Port: 993, TLS: true},
+				SMTP:        tt.smtp,
+				Credentials: tt.credentials,
+			}
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_PasswordSources(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials CredentialsConfig
+		wantErr     bool
+	}{
+		{"password", CredentialsConfig{Password: "secret"}, false},
+		{"command", CredentialsConfig{PasswordCommand: "pass show mail"}, false},
+		{"keyring", CredentialsConfig{Keyring: true}, false},
+		{"none", CredentialsConfig{}, true},
+		{"password and command", CredentialsConfig{Password: "secret", PasswordCommand: "pass show mail"}, true},
+		{"command and keyring", CredentialsConfig{PasswordCommand: "pass show mail", Keyring: true}, true},
+		{"oauth2 needs none", CredentialsConfig{Auth: "xoauth2", OAuth2: OAuth2Config{Provider: "google", ClientID: "id"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.credentials.Username = "user@example.com"
+			cfg := &Config{
+				Server:      ServerConfig{Host: "imap.example.com", Port: 993, TLS: true},
+				Credentials: tt.credentials,
+			}
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad_PasswordCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	configContent := `server:
+  host: imap.example.com
+  port: 993
+  tls: true
+smtp:
+  host: smtp.example.com
+  port: 465
+  tls: true
+credentials:
+  username: user@example.com
+  password_command: "printf 'from-command\\n'"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	if cfg.Credentials.Password != "from-command" {
+		t.Errorf("Expected password from command, got %q", cfg.Credentials.Password)
+	}
+	if cfg.SMTP.Password != "from-command" {
+		t.Errorf("Expected SMTP to reuse the command's password, got %q", cfg.SMTP.Password)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected loaded config to stay valid, got %v", err)
+	}
+}
+
+func TestLoad_FailingPasswordCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	configContent := `server:
+  host: imap.example.com
+  port: 993
+  tls: true
+credentials:
+  username: user@example.com
+  password_command: "echo 'pass: mail is not in the password store' >&2; exit 1"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("Expected error for a failing password_command")
+	}
+	if !strings.Contains(err.Error(), "mail is not in the password store") {
+		t.Errorf("Expected the command's stderr in the error, got %q", err.Error())
+	}
+}
+
+func TestLoad_Accounts(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	configContent := `accounts:
+  - name: Work
+    server:
+      host: imap.work.example.com
+      port: 993
+      tls: true
+    smtp:
+      host: smtp.work.example.com
+      port: 465
+      tls: true
+    credentials:
+      username: me@work.example.com
+      password_command: "printf 'work-secret'"
+    identities:
+      - address: me@work.example.com
+    default_folder: Projects
+    unified_mailboxes: [INBOX, Projects]
+  - server:
+      host: imap.home.example.com
+      port: 993
+      tls: true
+    credentials:
+      username: me@home.example.com
+      password: home-secret
+behavior:
+  default_folder: INBOX
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	accounts := cfg.AccountList()
+	if len(accounts) != 2 {
+		t.Fatalf("Expected 2 accounts, got %d", len(accounts))
+	}
+
+	work, home := accounts[0], accounts[1]
+	if work.Name != "Work" || work.DefaultFolder != "Projects" {
+		t.Errorf("Expected Work account opening Projects, got %q opening %q", work.Name, work.DefaultFolder)
+	}
+	if work.Credentials.Password != "work-secret" || work.SMTP.Password != "work-secret" {
+		t.Errorf("Expected the password command to feed IMAP and SMTP, got %q and %q", work.Credentials.Password, work.SMTP.Password)
+	}
+	if work.SMTP.From != "me@work.example.com" {
+		t.Errorf("Expected SMTP from to default to the username, got %q", work.SMTP.From)
+	}
+	if home.Name != "me@home.example.com" {
+		t.Errorf("Expected unnamed account to be named after its username, got %q", home.Name)
+	}
+	if home.DefaultFolder != "INBOX" {
+		t.Errorf("Expected default folder from behavior, got %q", home.DefaultFolder)
+	}
+	if got := work.Inboxes(); len(got) != 2 || got[1] != "Projects" {
+		t.Errorf("Expected Work to add INBOX and Projects to All Inboxes, got %v", got)
+	}
+	if got := home.Inboxes(); len(got) != 1 || got[0] != "INBOX" {
+		t.Errorf("Expected All Inboxes to default to the default folder, got %v", got)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected loaded config to stay valid, got %v", err)
+	}
+}
+
+func TestAccountList_TopLevelAccount(t *testing.T) {
+	cfg := &Config{
+		Server:      ServerConfig{Host: "imap.example.com", Port: 993, TLS: true},
+		Credentials: CredentialsConfig{Username: "user@example.com", Password: "secret"},
+		Behavior:    BehaviorConfig{DefaultFolder: "INBOX"},
+	}
+
+	accounts := cfg.AccountList()
+	if len(accounts) != 1 {
+		t.Fatalf("Expected the top-level sections to form one account, got %d", len(accounts))
+	}
+	if accounts[0].Server.Host != "imap.example.com" || accounts[0].Name != "user@example.com" {
+		t.Errorf("Expected account for user@example.com on imap.example.com, got %+v", accounts[0])
+	}
+}
+
+func TestValidate_Accounts(t *testing.T) {
+	account := func(name, user string) AccountConfig {
+		return AccountConfig{
+			Name:        name,
+			Server:      ServerConfig{Host: "imap.example.com", Port: 993, TLS: true},
+			Credentials: CredentialsConfig{Username: user, Password: "secret"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"valid", Config{Accounts: []AccountConfig{account("Work", "a@example.com"), account("Home", "b@example.com")}}, ""},
+		{"duplicate names", Config{Accounts: []AccountConfig{account("Work", "a@example.com"), account("Work", "b@example.com")}}, "used more than once"},
+		{"duplicate usernames", Config{Accounts: []AccountConfig{account("", "a@example.com"), account("", "a@example.com")}}, "used more than once"},
+		{"invalid account", Config{Accounts: []AccountConfig{account("Work", "")}}, "account 1 (Work)"},
+		{"special mailboxes", Config{Accounts: []AccountConfig{func() AccountConfig {
+			a := account("Work", "a@example.com")
+			a.SpecialMailboxes = map[string]string{"sent": "Gesendet", "trash": "Papierkorb"}
+			return a
+		}()}}, ""},
+		{"unknown special use", Config{Accounts: []AccountConfig{func() AccountConfig {
+			a := account("Work", "a@example.com")
+			a.SpecialMailboxes = map[string]string{"outbox": "Postausgang"}
+			return a
+		}()}}, "unknown role"},
+		{"shared special mailbox", Config{Accounts: []AccountConfig{func() AccountConfig {
+			a := account("Work", "a@example.com")
+			a.SpecialMailboxes = map[string]string{"archive": "Archiv", "all": "Archiv"}
+			return a
+		}()}}, `archive and all both name "Archiv"`},
+		{"inherited special mailboxes", Config{
+			Server:      ServerConfig{Host: "imap.example.com", Port: 993, TLS: true},
+			Credentials: CredentialsConfig{Username: "a@example.com", Password: "secret"},
+			Behavior:    BehaviorConfig{SpecialMailboxes: map[string]string{"sent": ""}},
+		}, "needs a mailbox name"},
+		{"mixed with top level", Config{
+			Server:   ServerConfig{Host: "imap.example.com", Port: 993},
+			Accounts: []AccountConfig{account("Work", "a@example.com")},
+		}, "not both"},
+		{"top-level smtp with accounts", Config{
+			SMTP:     SMTPConfig{Host: "smtp.example.com", Port: 587},
+			Accounts: []AccountConfig{account("Work", "a@example.com")},
+		}, "set them per account"},
+		{"top-level identities with accounts", Config{
+			Identities: []IdentityConfig{{Address: "alias@example.com"}},
+			Accounts:   []AccountConfig{account("Work", "a@example.com")},
+		}, "set them per account"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOAuth2Config_OverridesProvider(t *testing.T) {
+	client := OAuth2Config{
+		Provider: "google",
+		ClientID: "id",
+		TokenURL: "http://127.0.0.1:8080/token",
+	}.OAuth2Client()
+
+	if client.Endpoint.TokenURL != "http://127.0.0.1:8080/token" {
+		t.Errorf("Expected token_url to override the provider, got %s", client.Endpoint.TokenURL)
+	}
+	if client.Endpoint.DeviceAuthURL != "https://oauth2.googleapis.com/device/code" {
+		t.Errorf("Expected provider device endpoint to be kept, got %s", client.Endpoint.DeviceAuthURL)
+	}
+	if len(client.Scopes) != 1 || client.Scopes[0] != "https://mail.google.com/" {
+		t.Errorf("Expected provider scopes, got %v", client.Scopes)
+	}
+}
+
+func TestLoad_Identities(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "oncall.sig"), []byte("On-call rotation\n"), 0600); err != nil {
+		t.Fatalf("Failed to write signature: %v", err)
+	}
+
+	configData := `
+server:
+  host: imap.example.com
+  port: 993
+  tls: true
+credentials:
+  username: user@example.com
+  password: "secret"
+identities:
+  - name: Jane Doe
+    address: jane@example.com
+    signature: "Jane"
+  - name: Support
+    address: support@example.com
+    reply_to: tickets@example.com
+    signature_file: oncall.sig
+    bcc_self: true
+`
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	if len(cfg.Identities) != 2 {
+		t.Fatalf("Expected 2 identities, got %d", len(cfg.Identities))
+	}
+	support := cfg.Identities[1]
+	if support.Signature != "On-call rotation\n" {
+		t.Errorf("Expected signature read from file, got %q", support.Signature)
+	}
+	if !support.BccSelf || support.ReplyTo != "tickets@example.com" {
+		t.Errorf("Unexpected identity %+v", support)
+	}
+}
+
+func TestLoad_MissingSignatureFile(t *testing.T) {
+	configData := `
+server:
+  host: imap.example.com
+  port: 993
+  tls: true
+credentials:
+  username: user@example.com
+identities:
+  - address: jane@example.com
+    signature_file: missing.sig
+`
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Error("Expected error for a missing signature file")
+	}
+}
+
+func TestValidate_Identities(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity IdentityConfig
+		wantErr  bool
+	}{
+		{"valid", IdentityConfig{Name: "Jane", Address: "jane@example.com", ReplyTo: "a@example.com, b@example.com"}, false},
+		{"missing address", IdentityConfig{Name: "Jane"}, true},
+		{"invalid address", IdentityConfig{Address: "not an address"}, true},
+		{"invalid reply-to", IdentityConfig{Address: "jane@example.com", ReplyTo: "nope"}, true},
+		{"both signatures", IdentityConfig{Address: "jane@example.com", Signature: "x", SignatureFile: "x.sig"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:      ServerConfig{Host: "imap.example.com", Port: 993, TLS: true},
+				Credentials: CredentialsConfig{Username: "user@example.com", Password: "secret"},
+				Identities:  []IdentityConfig{tt.identity},
+			}
+
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package email
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// LoadAttachment reads a file from disk into an Attachment. The content
+// type is taken from the file extension and falls back to sniffing the
+// first bytes of the file.
+func LoadAttachment(path string) (Attachment, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return Attachment{}, fmt.Errorf("%s is a directory", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return Attachment{
+		Filename:    filepath.Base(path),
+		ContentType: DetectContentType(path, data),
+		Size:        int64(len(data)),
+		Data:        data,
+	}, nil
+}
+
+// DetectContentType returns the bare media type for a file, without
+// parameters such as charset
+func DetectContentType(filename string, data []byte) string {
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// TotalSize returns the combined size of the given attachments
+func TotalSize(attachments []Attachment) int64 {
+	var total int64
+	for _, att := range attachments {
+		total += att.Size
+	}
+	return total
+}
@@ -0,0 +1,56 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAttachment(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte("hello\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	att, err := LoadAttachment(path)
+	if err != nil {
+		t.Fatalf("LoadAttachment() failed: %v", err)
+	}
+
+	if att.Filename != "notes.txt" {
+		t.Errorf("Expected filename 'notes.txt', got '%s'", att.Filename)
+	}
+	if att.ContentType != "text/plain" {
+		t.Errorf("Expected content type without parameters 'text/plain', got '%s'", att.ContentType)
+	}
+	if att.Size != 6 || string(att.Data) != "hello\n" {
+		t.Errorf("Unexpected data %q (size %d)", att.Data, att.Size)
+	}
+
+	if _, err := LoadAttachment(dir); err == nil {
+		t.Error("Expected error when attaching a directory")
+	}
+	if _, err := LoadAttachment(filepath.Join(dir, "missing.pdf")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     []byte
+		expected string
+	}{
+		{"report.pdf", nil, "application/pdf"},
+		{"photo.PNG", nil, "image/png"},
+		{"no-extension", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"blob", []byte{0x00, 0x01, 0x02}, "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		if got := DetectContentType(tt.filename, tt.data); got != tt.expected {
+			t.Errorf("DetectContentType(%q) = %q, expected %q", tt.filename, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+// Build serializes an outgoing message into RFC 5322 bytes, the inverse of
+// Parse. A text-only body becomes a single text/plain part, an HTML body
+// multipart/alternative, and attachments wrap either in multipart/mixed.
+// Non-ASCII subjects and names are written as RFC 2047 encoded words.
+// A Date and Message-ID are generated when the message does not carry them.
+// Bcc recipients are left out of the headers.
+func Build(msg *Message) ([]byte, error) {
+	return build(msg, false)
+}
+
+// BuildDraft serializes a message for storage in the Drafts mailbox.
+// Unlike Build it keeps the Bcc header so the draft can be reopened intact.
+func BuildDraft(msg *Message) ([]byte, error) {
+	return build(msg, true)
+}
+
+func build(msg *Message, keepBcc bool) ([]byte, error) {
+	if msg == nil {
+		return nil, fmt.Errorf("cannot build nil message")
+	}
+	if len(msg.From) == 0 {
+		return nil, fmt.Errorf("message has no sender")
+	}
+
+	var h mail.Header
+
+	date := msg.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	h.SetDate(date)
+
+	h.SetAddressList("From", toMailAddresses(msg.From))
+	if len(msg.To) > 0 {
+		h.SetAddressList("To", toMailAddresses(msg.To))
+	}
+	if len(msg.Cc) > 0 {
+		h.SetAddressList("Cc", toMailAddresses(msg.Cc))
+	}
+	if keepBcc && len(msg.Bcc) > 0 {
+		h.SetAddressList("Bcc", toMailAddresses(msg.Bcc))
+	}
+	if len(msg.ReplyTo) > 0 {
+		h.SetAddressList("Reply-To", toMailAddresses(msg.ReplyTo))
+	}
+	h.SetSubject(msg.Subject)
+
+	if msg.MessageID != "" {
+		h.SetMessageID(strings.Trim(msg.MessageID, "<>"))
+	} else if err := h.GenerateMessageIDWithHostname(domainOf(msg.From[0].Email)); err != nil {
+		return nil, fmt.Errorf("failed to generate message ID: %w", err)
+	}
+
+	if msg.InReplyTo != "" {
+		h.SetMsgIDList("In-Reply-To", []string{strings.Trim(msg.InReplyTo, "<>")})
+	}
+	if len(msg.References) > 0 {
+		refs := make([]string, len(msg.References))
+		for i, ref := range msg.References {
+			refs[i] = strings.Trim(ref, "<>")
+		}
+		h.SetMsgIDList("References", refs)
+	}
+
+	h.Set("MIME-Version", "1.0")
+
+	var text, html string
+	if msg.Body != nil {
+		text, html = msg.Body.Text, msg.Body.HTML
+		if text == "" && html != "" {
+			// Give text-only clients something readable
+			text = PlainText(msg.Body)
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch {
+	case len(msg.Attachments) > 0:
+		err = writeMixed(&buf, h, text, html, msg.Attachments)
+	case html != "":
+		err = writeAlternative(&buf, h, text, html)
+	default:
+		err = writeSingle(&buf, h, text)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeSingle writes a text/plain only message
+func writeSingle(buf *bytes.Buffer, h mail.Header, text string) error {
+	setTextHeader(&h.Header, "text/plain", text)
+
+	w, err := mail.CreateSingleInlineWriter(buf, h)
+	if err != nil {
+		return fmt.Errorf("failed to create message writer: %w", err)
+	}
+	if err := writeText(w, text); err != nil {
+		return fmt.Errorf("failed to write message body: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to finish message: %w", err)
+	}
+	return nil
+}
+
+// writeAlternative writes a multipart/alternative message with text and
+// HTML versions of the body
+func writeAlternative(buf *bytes.Buffer, h mail.Header, text, html string) error {
+	iw, err := mail.CreateInlineWriter(buf, h)
+	if err != nil {
+		return fmt.Errorf("failed to create message writer: %w", err)
+	}
+	if err := writeAlternativeParts(iw, text, html); err != nil {
+		return err
+	}
+	if err := iw.Close(); err != nil {
+		return fmt.Errorf("failed to finish message: %w", err)
+	}
+	return nil
+}
+
+// writeMixed writes a multipart/mixed message: the body first, as a single
+// text part or a nested multipart/alternative, followed by the attachments
+func writeMixed(buf *bytes.Buffer, h mail.Header, text, html string, attachments []Attachment) error {
+	mw, err := mail.CreateWriter(buf, h)
+	if err != nil {
+		return fmt.Errorf("failed to create message writer: %w", err)
+	}
+
+	if html != "" {
+		iw, err := mw.CreateInline()
+		if err != nil {
+			return fmt.Errorf("failed to create body part: %w", err)
+		}
+		if err := writeAlternativeParts(iw, text, html); err != nil {
+			return err
+		}
+		if err := iw.Close(); err != nil {
+			return fmt.Errorf("failed to finish body part: %w", err)
+		}
+	} else {
+		var ih mail.InlineHeader
+		setTextHeader(&ih.Header, "text/plain", text)
+		tw, err := mw.CreateSingleInline(ih)
+		if err != nil {
+			return fmt.Errorf("failed to create text part: %w", err)
+		}
+		if err := writeText(tw, text); err != nil {
+			return fmt.Errorf("failed to write message body: %w", err)
+		}
+		if err := tw.Close(); err != nil {
+			return fmt.Errorf("failed to finish text part: %w", err)
+		}
+	}
+
+	for _, att := range attachments {
+		contentType := att.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		var ah mail.AttachmentHeader
+		ah.SetContentType(contentType, nil)
+		ah.SetFilename(att.Filename)
+		if strings.EqualFold(contentType, "message/rfc822") {
+			// RFC 2046 forbids encoding message/rfc822 bodies
+			ah.Set("Content-Transfer-Encoding", "8bit")
+		}
+
+		aw, err := mw.CreateAttachment(ah)
+		if err != nil {
+			return fmt.Errorf("failed to create attachment %s: %w", att.Filename, err)
+		}
+		if _, err := aw.Write(att.Data); err != nil {
+			return fmt.Errorf("failed to write attachment %s: %w", att.Filename, err)
+		}
+		if err := aw.Close(); err != nil {
+			return fmt.Errorf("failed to finish attachment %s: %w", att.Filename, err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return fmt.Errorf("failed to finish message: %w", err)
+	}
+	return nil
+}
+
+func writeAlternativeParts(iw *mail.InlineWriter, text, html string) error {
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain", text},
+		{"text/html", html},
+	}
+
+	for _, part := range parts {
+		var ih mail.InlineHeader
+		setTextHeader(&ih.Header, part.contentType, part.content)
+
+		pw, err := iw.CreatePart(ih)
+		if err != nil {
+			return fmt.Errorf("failed to create %s part: %w", part.contentType, err)
+		}
+		if err := writeText(pw, part.content); err != nil {
+			return fmt.Errorf("failed to write %s part: %w", part.contentType, err)
+		}
+		if err := pw.Close(); err != nil {
+			return fmt.Errorf("failed to finish %s part: %w", part.contentType, err)
+		}
+	}
+	return nil
+}
+
+// setTextHeader sets a UTF-8 content type and the transfer encoding that
+// suits the content
+func setTextHeader(h *message.Header, contentType, content string) {
+	h.SetContentType(contentType, map[string]string{"charset": "utf-8"})
+	h.Set("Content-Transfer-Encoding", textEncoding(content))
+}
+
+// textEncoding picks quoted-printable for mostly ASCII text, where it stays
+// readable, and base64 for text dominated by non-ASCII characters, where
+// quoted-printable would triple the size
+func textEncoding(content string) string {
+	nonASCII := 0
+	for i := 0; i < len(content); i++ {
+		if content[i] >= 0x80 {
+			nonASCII++
+		}
+	}
+	if nonASCII*3 > len(content) {
+		return "base64"
+	}
+	return "quoted-printable"
+}
+
+// writeText writes text with CRLF line endings, the canonical form for
+// text/* bodies
+func writeText(w io.Writer, text string) error {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	_, err := io.WriteString(w, strings.ReplaceAll(text, "\n", "\r\n"))
+	return err
+}
+
+// ParseAddressList parses a comma separated list of addresses as typed by a user
+func ParseAddressList(list string) ([]Address, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	parsed, err := mail.ParseAddressList(list)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address list %q: %w", list, err)
+	}
+
+	addresses := make([]Address, len(parsed))
+	for i, addr := range parsed {
+		addresses[i] = Address{
+			Name:  addr.Name,
+			Email: addr.Address,
+		}
+	}
+	return addresses, nil
+}
+
+func toMailAddresses(addrs []Address) []*mail.Address {
+	out := make([]*mail.Address, len(addrs))
+	for i, addr := range addrs {
+		out[i] = &mail.Address{
+			Name:    addr.Name,
+			Address: addr.Email,
+		}
+	}
+	return out
+}
+
+func domainOf(address string) string {
+	if idx := strings.LastIndex(address, "@"); idx >= 0 && idx < len(address)-1 {
+		return address[idx+1:]
+	}
+	return "localhost"
+}
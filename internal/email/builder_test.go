@@ -0,0 +1,350 @@
+package email
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuild_PlainTextRoundTrip(t *testing.T) {
+	msg := &Message{
+		From:    []Address{{Name: "Alice", Email: "alice@example.com"}},
+		To:      []Address{{Email: "bob@example.com"}},
+		Cc:      []Address{{Email: "charlie@example.com"}},
+		Bcc:     []Address{{Email: "secret@example.com"}},
+		Subject: "Hello",
+		Body:    &Body{Text: "Hi Bob,\nhow are you?\n"},
+	}
+
+	raw, err := Build(msg)
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+
+	if strings.Contains(string(raw), "secret@example.com") {
+		t.Error("Bcc recipients must not appear in the message headers")
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	if len(parsed.From) != 1 || parsed.From[0] != msg.From[0] {
+		t.Errorf("Expected From %v, got %v", msg.From, parsed.From)
+	}
+	if len(parsed.To) != 1 || parsed.To[0].Email != "bob@example.com" {
+		t.Errorf("Expected To 'bob@example.com', got %v", parsed.To)
+	}
+	if len(parsed.Cc) != 1 || parsed.Cc[0].Email != "charlie@example.com" {
+		t.Errorf("Expected Cc 'charlie@example.com', got %v", parsed.Cc)
+	}
+	if parsed.Subject != "Hello" {
+		t.Errorf("Expected subject 'Hello', got '%s'", parsed.Subject)
+	}
+	if parsed.MessageID == "" || !strings.HasSuffix(parsed.MessageID, "@example.com>") {
+		t.Errorf("Expected generated Message-ID on sender domain, got '%s'", parsed.MessageID)
+	}
+	if parsed.Date.IsZero() {
+		t.Error("Expected a Date header")
+	}
+	if got := strings.ReplaceAll(parsed.Body.Text, "\r\n", "\n"); got != msg.Body.Text {
+		t.Errorf("Expected body %q, got %q", msg.Body.Text, got)
+	}
+}
+
+func TestBuild_EncodedWordHeaders(t *testing.T) {
+	msg := &Message{
+		From:    []Address{{Name: "Zoë Müller", Email: "zoe@example.com"}},
+		To:      []Address{{Name: "山田 太郎", Email: "taro@example.jp"}},
+		Subject: "Grüße aus Köln — 日本",
+		Body:    &Body{Text: "Hallo"},
+	}
+
+	raw, err := Build(msg)
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+
+	header, _, _ := bytes.Cut(raw, []byte("\r\n\r\n"))
+	for _, b := range header {
+		if b >= 0x80 {
+			t.Fatalf("Expected ASCII-only headers, got %q", header)
+		}
+	}
+	if !bytes.Contains(header, []byte("=?utf-8?")) {
+		t.Errorf("Expected RFC 2047 encoded words, got %q", header)
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if parsed.Subject != msg.Subject {
+		t.Errorf("Expected subject '%s', got '%s'", msg.Subject, parsed.Subject)
+	}
+	if parsed.From[0].Name != "Zoë Müller" || parsed.To[0].Name != "山田 太郎" {
+		t.Errorf("Expected decoded names, got %v / %v", parsed.From, parsed.To)
+	}
+}
+
+func TestBuild_Alternative(t *testing.T) {
+	msg := &Message{
+		From:    []Address{{Email: "alice@example.com"}},
+		To:      []Address{{Email: "bob@example.com"}},
+		Subject: "Styled",
+		Body:    &Body{Text: "Hello *world*", HTML: "<p>Hello <b>world</b></p>"},
+	}
+
+	raw, err := Build(msg)
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+	if !strings.Contains(string(raw), "Content-Type: multipart/alternative") {
+		t.Errorf("Expected multipart/alternative message, got:\n%s", raw)
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if got := normalizeCRLF(parsed.Body.Text); got != msg.Body.Text {
+		t.Errorf("Expected text %q, got %q", msg.Body.Text, got)
+	}
+	if got := normalizeCRLF(parsed.Body.HTML); got != msg.Body.HTML {
+		t.Errorf("Expected HTML %q, got %q", msg.Body.HTML, got)
+	}
+}
+
+func TestBuild_HTMLOnlyGetsTextAlternative(t *testing.T) {
+	msg := &Message{
+		From: []Address{{Email: "alice@example.com"}},
+		Body: &Body{HTML: "<p>Only <b>HTML</b></p>"},
+	}
+
+	raw, err := Build(msg)
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if !strings.Contains(parsed.Body.Text, "Only") {
+		t.Errorf("Expected a plain text version derived from HTML, got %q", parsed.Body.Text)
+	}
+}
+
+func TestBuild_MixedWithAlternativeAndAttachments(t *testing.T) {
+	binary := []byte{0x00, 0xff, 0x10, 0x80, 'P', 'N', 'G'}
+	msg := &Message{
+		From:    []Address{{Email: "alice@example.com"}},
+		To:      []Address{{Email: "bob@example.com"}},
+		Subject: "Files",
+		Body:    &Body{Text: "See attached", HTML: "<p>See attached</p>"},
+		Attachments: []Attachment{
+			{Filename: "image.png", ContentType: "image/png", Data: binary},
+			{Filename: "résumé.txt", ContentType: "text/plain", Data: []byte("plain attachment\n")},
+		},
+	}
+
+	raw, err := Build(msg)
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+	if !strings.Contains(string(raw), "Content-Type: multipart/mixed") {
+		t.Errorf("Expected multipart/mixed message")
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if normalizeCRLF(parsed.Body.Text) != "See attached" || normalizeCRLF(parsed.Body.HTML) != "<p>See attached</p>" {
+		t.Errorf("Unexpected body %+v", parsed.Body)
+	}
+	if len(parsed.Attachments) != 2 {
+		t.Fatalf("Expected 2 attachments, got %d", len(parsed.Attachments))
+	}
+	for i, want := range msg.Attachments {
+		got := parsed.Attachments[i]
+		if got.Filename != want.Filename || got.ContentType != want.ContentType {
+			t.Errorf("Attachment %d: expected %s (%s), got %s (%s)", i, want.Filename, want.ContentType, got.Filename, got.ContentType)
+		}
+		if !bytes.Equal(got.Data, want.Data) {
+			t.Errorf("Attachment %d: data mismatch, got %q", i, got.Data)
+		}
+	}
+}
+
+func TestBuild_TransferEncodingChoice(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		encoding string
+	}{
+		{"ascii", "Plain ASCII text\n", "quoted-printable"},
+		{"mostly latin", "Grüße, bis morgen!\n", "quoted-printable"},
+		{"cjk", "今日は良い天気ですね。明日も晴れるでしょう。\n", "base64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := Build(&Message{
+				From: []Address{{Email: "alice@example.com"}},
+				Body: &Body{Text: tt.text},
+			})
+			if err != nil {
+				t.Fatalf("Build() failed: %v", err)
+			}
+			if !strings.Contains(string(raw), "Content-Transfer-Encoding: "+tt.encoding) {
+				t.Errorf("Expected %s encoding, got:\n%s", tt.encoding, raw)
+			}
+
+			parsed, err := Parse(raw)
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			if got := normalizeCRLF(parsed.Body.Text); got != tt.text {
+				t.Errorf("Expected text %q, got %q", tt.text, got)
+			}
+		})
+	}
+}
+
+func TestBuild_RoundTripTestdata(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.eml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Failed to list testdata: %v", err)
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Failed to read test file: %v", err)
+			}
+
+			orig, err := Parse(data)
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+
+			raw, err := Build(orig)
+			if err != nil {
+				t.Fatalf("Build() failed: %v", err)
+			}
+
+			again, err := Parse(raw)
+			if err != nil {
+				t.Fatalf("Parse() of built message failed: %v", err)
+			}
+
+			if again.Subject != orig.Subject {
+				t.Errorf("Subject: expected '%s', got '%s'", orig.Subject, again.Subject)
+			}
+			if again.MessageID != orig.MessageID {
+				t.Errorf("Message-ID: expected '%s', got '%s'", orig.MessageID, again.MessageID)
+			}
+			if !again.Date.Equal(orig.Date) {
+				t.Errorf("Date: expected %v, got %v", orig.Date, again.Date)
+			}
+			if again.InReplyTo != orig.InReplyTo || strings.Join(again.References, " ") != strings.Join(orig.References, " ") {
+				t.Errorf("Threading headers changed: %q %v -> %q %v", orig.InReplyTo, orig.References, again.InReplyTo, again.References)
+			}
+			for _, pair := range [][2][]Address{{orig.From, again.From}, {orig.To, again.To}, {orig.Cc, again.Cc}} {
+				if len(pair[0]) != len(pair[1]) {
+					t.Errorf("Address list changed: %v -> %v", pair[0], pair[1])
+					continue
+				}
+				for i := range pair[0] {
+					if pair[0][i] != pair[1][i] {
+						t.Errorf("Address changed: %v -> %v", pair[0][i], pair[1][i])
+					}
+				}
+			}
+			if orig.Body.Text != "" && normalizeCRLF(again.Body.Text) != normalizeCRLF(orig.Body.Text) {
+				t.Errorf("Text body changed: %q -> %q", orig.Body.Text, again.Body.Text)
+			}
+			if normalizeCRLF(again.Body.HTML) != normalizeCRLF(orig.Body.HTML) {
+				t.Errorf("HTML body changed: %q -> %q", orig.Body.HTML, again.Body.HTML)
+			}
+			if len(again.Attachments) != len(orig.Attachments) {
+				t.Fatalf("Expected %d attachments, got %d", len(orig.Attachments), len(again.Attachments))
+			}
+			for i := range orig.Attachments {
+				if !bytes.Equal(again.Attachments[i].Data, orig.Attachments[i].Data) {
+					t.Errorf("Attachment %d data changed", i)
+				}
+			}
+		})
+	}
+}
+
+func normalizeCRLF(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
+
+func TestBuildDraft_KeepsBcc(t *testing.T) {
+	msg := &Message{
+		From: []Address{{Email: "alice@example.com"}},
+		Bcc:  []Address{{Email: "secret@example.com"}},
+		Body: &Body{Text: "unfinished"},
+	}
+
+	raw, err := BuildDraft(msg)
+	if err != nil {
+		t.Fatalf("BuildDraft() failed: %v", err)
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(parsed.Bcc) != 1 || parsed.Bcc[0].Email != "secret@example.com" {
+		t.Errorf("Expected Bcc to survive in drafts, got %v", parsed.Bcc)
+	}
+}
+
+func TestBuild_RequiresSender(t *testing.T) {
+	if _, err := Build(&Message{To: []Address{{Email: "bob@example.com"}}}); err == nil {
+		t.Error("Expected error for message without sender")
+	}
+}
+
+func TestParseAddressList(t *testing.T) {
+	addrs, err := ParseAddressList("Bob <bob@example.com>, carol@example.com")
+	if err != nil {
+		t.Fatalf("ParseAddressList() failed: %v", err)
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("Expected 2 addresses, got %d", len(addrs))
+	}
+	if addrs[0].Name != "Bob" || addrs[0].Email != "bob@example.com" {
+		t.Errorf("Unexpected first address %v", addrs[0])
+	}
+
+	if addrs, err := ParseAddressList("   "); err != nil || addrs != nil {
+		t.Errorf("Expected empty list for blank input, got %v, %v", addrs, err)
+	}
+
+	if _, err := ParseAddressList("not an address"); err == nil {
+		t.Error("Expected error for invalid address")
+	}
+}
+
+func TestMessage_Recipients(t *testing.T) {
+	msg := &Message{
+		To:  []Address{{Email: "a@example.com"}},
+		Cc:  []Address{{Email: "b@example.com"}},
+		Bcc: []Address{{Email: "c@example.com"}},
+	}
+
+	got := msg.Recipients()
+	if strings.Join(got, ",") != "a@example.com,b@example.com,c@example.com" {
+		t.Errorf("Unexpected recipients %v", got)
+	}
+}
@@ -0,0 +1,74 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NewForward creates a draft forwarding orig. Inline forwards quote the
+// original below a "Forwarded message" header block; otherwise the raw
+// original is attached as message/rfc822 so it reaches the recipient intact.
+func NewForward(orig *Message, raw []byte, asAttachment bool) *Message {
+	draft := &Message{
+		Subject: prefixSubject("Fwd: ", orig.Subject),
+		Body:    &Body{},
+	}
+
+	if asAttachment {
+		draft.Attachments = []Attachment{{
+			Filename:    forwardFilename(orig.Subject),
+			ContentType: "message/rfc822",
+			Size:        int64(len(raw)),
+			Data:        raw,
+		}}
+		return draft
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n\n---------- Forwarded message ----------\n")
+	if len(orig.From) > 0 {
+		fmt.Fprintf(&sb, "From: %s\n", formatAddresses(orig.From))
+	}
+	if !orig.Date.IsZero() {
+		fmt.Fprintf(&sb, "Date: %s\n", orig.Date.Format("Mon, Jan 02, 2006 at 15:04"))
+	}
+	fmt.Fprintf(&sb, "Subject: %s\n", orig.Subject)
+	if len(orig.To) > 0 {
+		fmt.Fprintf(&sb, "To: %s\n", formatAddresses(orig.To))
+	}
+	if len(orig.Cc) > 0 {
+		fmt.Fprintf(&sb, "Cc: %s\n", formatAddresses(orig.Cc))
+	}
+	sb.WriteString("\n")
+	sb.WriteString(PlainText(orig.Body))
+
+	draft.Body.Text = sb.String()
+	draft.Attachments = append(draft.Attachments, orig.Attachments...)
+	return draft
+}
+
+func formatAddresses(addrs []Address) string {
+	formatted := make([]string, len(addrs))
+	for i, addr := range addrs {
+		formatted[i] = addr.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// forwardFilename derives a safe attachment name from a subject
+func forwardFilename(subject string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\\:*?"<>|`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(subject))
+
+	if name == "" {
+		name = "forwarded message"
+	}
+	if runes := []rune(name); len(runes) > 60 {
+		name = strings.TrimSpace(string(runes[:60]))
+	}
+	return name + ".eml"
+}
@@ -0,0 +1,109 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewForward_Inline(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "multipart.eml"))
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+
+	orig, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	fwd := NewForward(orig, data, false)
+
+	if fwd.Subject != "Fwd: Multipart Alternative Email" {
+		t.Errorf("Expected 'Fwd: ' subject, got '%s'", fwd.Subject)
+	}
+	if len(fwd.To) != 0 {
+		t.Errorf("Expected forward without recipients, got %v", fwd.To)
+	}
+	if len(fwd.Attachments) != 0 {
+		t.Errorf("Expected no attachments for inline forward, got %d", len(fwd.Attachments))
+	}
+
+	for _, want := range []string{
+		"---------- Forwarded message ----------",
+		"From: Alice <alice@example.com>",
+		"Subject: Multipart Alternative Email",
+		"To: Bob <bob@example.com>",
+		"Cc: charlie@example.com",
+		"This is the plain text version.",
+	} {
+		if !strings.Contains(fwd.Body.Text, want) {
+			t.Errorf("Expected forward body to contain %q, got:\n%s", want, fwd.Body.Text)
+		}
+	}
+}
+
+func TestNewForward_AsAttachment(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "plain.eml"))
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+
+	orig, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	fwd := NewForward(orig, data, true)
+
+	if len(fwd.Attachments) != 1 {
+		t.Fatalf("Expected one attachment, got %d", len(fwd.Attachments))
+	}
+	att := fwd.Attachments[0]
+	if att.ContentType != "message/rfc822" {
+		t.Errorf("Expected message/rfc822 attachment, got '%s'", att.ContentType)
+	}
+	if att.Filename != "Plain Text Email.eml" {
+		t.Errorf("Expected filename 'Plain Text Email.eml', got '%s'", att.Filename)
+	}
+	if att.Size != int64(len(data)) || string(att.Data) != string(data) {
+		t.Error("Expected the raw original to be attached unchanged")
+	}
+
+	fwd.From = []Address{{Email: "bob@example.com"}}
+	fwd.To = []Address{{Email: "tickets@example.com"}}
+	raw, err := Build(fwd)
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+
+	out := string(raw)
+	for _, want := range []string{
+		"Content-Type: multipart/mixed",
+		"Content-Type: message/rfc822",
+		"Content-Transfer-Encoding: 8bit",
+		"Message-ID: <plain123@example.com>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected built message to contain %q", want)
+		}
+	}
+}
+
+func TestForwardFilename(t *testing.T) {
+	tests := []struct {
+		subject  string
+		expected string
+	}{
+		{"Invoice 42", "Invoice 42.eml"},
+		{"a/b: c?", "a_b_ c_.eml"},
+		{"   ", "forwarded message.eml"},
+	}
+
+	for _, tt := range tests {
+		if got := forwardFilename(tt.subject); got != tt.expected {
+			t.Errorf("forwardFilename(%q) = %q, want %q", tt.subject, got, tt.expected)
+		}
+	}
+}
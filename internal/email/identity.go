@@ -0,0 +1,64 @@
+package email
+
+import "strings"
+
+// signatureDelimiter separates the body from the signature (RFC 3676)
+const signatureDelimiter = "-- \n"
+
+// Identity is an address the user sends mail as
+type Identity struct {
+	From      Address
+	ReplyTo   []Address
+	Signature string
+	BccSelf   bool
+}
+
+// MatchIdentity returns the index of the identity the original message was
+// addressed to, checking To before Cc, or -1 when none matches
+func MatchIdentity(identities []Identity, orig *Message) int {
+	if orig == nil {
+		return -1
+	}
+
+	for _, list := range [][]Address{orig.To, orig.Cc} {
+		for _, addr := range list {
+			for i, id := range identities {
+				if strings.EqualFold(addr.Email, id.From.Email) {
+					return i
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// SignatureBlock returns the signature with its delimiter line
+func SignatureBlock(sig string) string {
+	return signatureDelimiter + strings.TrimRight(sig, "\n")
+}
+
+// AppendSignature adds a signature block after the text, separated by a
+// blank line
+func AppendSignature(text, sig string) string {
+	if strings.TrimSpace(sig) == "" {
+		return text
+	}
+
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return "\n" + SignatureBlock(sig)
+	}
+	return text + "\n\n" + SignatureBlock(sig)
+}
+
+// ReplaceSignature swaps the old signature block for a new one. When the
+// old block is no longer in the text the new one is appended instead.
+func ReplaceSignature(text, oldSig, newSig string) string {
+	if strings.TrimSpace(oldSig) != "" {
+		block := SignatureBlock(oldSig)
+		if idx := strings.LastIndex(text, block); idx >= 0 {
+			text = strings.TrimRight(text[:idx], "\n") + text[idx+len(block):]
+		}
+	}
+	return AppendSignature(text, newSig)
+}
@@ -0,0 +1,55 @@
+package email
+
+import "testing"
+
+func TestMatchIdentity(t *testing.T) {
+	identities := []Identity{
+		{From: Address{Email: "me@example.com"}},
+		{From: Address{Email: "team@example.com"}},
+		{From: Address{Email: "oncall@example.com"}},
+	}
+
+	tests := []struct {
+		name     string
+		orig     *Message
+		expected int
+	}{
+		{"to alias", &Message{To: []Address{{Email: "Team@Example.com"}}}, 1},
+		{"cc only", &Message{To: []Address{{Email: "other@example.com"}}, Cc: []Address{{Email: "oncall@example.com"}}}, 2},
+		{"to wins over cc", &Message{To: []Address{{Email: "oncall@example.com"}}, Cc: []Address{{Email: "me@example.com"}}}, 2},
+		{"no match", &Message{To: []Address{{Email: "other@example.com"}}}, -1},
+		{"nil", nil, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchIdentity(identities, tt.orig); got != tt.expected {
+				t.Errorf("Expected identity %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	if got := AppendSignature("", "Alice"); got != "\n-- \nAlice" {
+		t.Errorf("Unexpected signature on empty body %q", got)
+	}
+	if got := AppendSignature("Hi\n\n", "Alice\n"); got != "Hi\n\n-- \nAlice" {
+		t.Errorf("Unexpected signature after text %q", got)
+	}
+	if got := AppendSignature("Hi", "  "); got != "Hi" {
+		t.Errorf("Expected blank signature to be ignored, got %q", got)
+	}
+
+	body := AppendSignature("Hello", "Alice\nPersonal")
+	swapped := ReplaceSignature(body, "Alice\nPersonal", "Support Team")
+	if swapped != "Hello\n\n-- \nSupport Team" {
+		t.Errorf("Unexpected swapped signature %q", swapped)
+	}
+	if got := ReplaceSignature(swapped, "Support Team", ""); got != "Hello" {
+		t.Errorf("Expected signature to be removed, got %q", got)
+	}
+	if got := ReplaceSignature("Edited away", "Alice", "Bob"); got != "Edited away\n\n-- \nBob" {
+		t.Errorf("Expected new signature to be appended, got %q", got)
+	}
+}
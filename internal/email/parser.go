@@ -122,6 +122,15 @@ func Parse(data []byte) (*Message, error) {
 			return nil, fmt.Errorf("failed to read part: %w", err)
 		}
 
+		if ah, ok := part.Header.(*mail.AttachmentHeader); ok {
+			att, err := readAttachment(ah, part.Body)
+			if err != nil {
+				return nil, err
+			}
+			msg.Attachments = append(msg.Attachments, att)
+			continue
+		}
+
 		// Try to get content type from the part header
 		contentTypeStr := part.Header.Get("Content-Type")
 		partType := contentTypeStr
@@ -150,10 +159,26 @@ func Parse(data []byte) (*Message, error) {
 			continue
 
 		default:
-			// Could be an attachment, but we'll handle that in future enhancements
 			continue
 		}
 	}
 
 	return msg, nil
 }
+
+func readAttachment(h *mail.AttachmentHeader, body io.Reader) (Attachment, error) {
+	filename, _ := h.Filename()
+	contentType, _, _ := h.ContentType()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("failed to read attachment %s: %w", filename, err)
+	}
+
+	return Attachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Data:        data,
+	}, nil
+}
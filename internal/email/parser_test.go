@@ -136,6 +136,42 @@ func TestParse_Multipart(t *testing.T) {
 	}
 }
 
+func TestParse_Attachment(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "attachment.eml"))
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+
+	msg, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	if len(msg.From) != 1 || msg.From[0].Name != "Zoë" {
+		t.Errorf("Expected decoded sender name 'Zoë', got %v", msg.From)
+	}
+
+	if msg.Subject != "Quarterly report — übersicht" {
+		t.Errorf("Expected decoded subject, got '%s'", msg.Subject)
+	}
+
+	if msg.Body.Text == "" || msg.Body.HTML == "" {
+		t.Error("Expected both text and HTML bodies from the nested alternative part")
+	}
+
+	if len(msg.Attachments) != 1 {
+		t.Fatalf("Expected 1 attachment, got %d", len(msg.Attachments))
+	}
+
+	att := msg.Attachments[0]
+	if att.Filename != "report.csv" || att.ContentType != "text/csv" {
+		t.Errorf("Unexpected attachment %s (%s)", att.Filename, att.ContentType)
+	}
+	if string(att.Data) != "region,total\nnorth,42\n" || att.Size != int64(len(att.Data)) {
+		t.Errorf("Unexpected attachment data %q (size %d)", att.Data, att.Size)
+	}
+}
+
 func TestParse_InvalidEmail(t *testing.T) {
 	invalidData := []byte("This is not a valid email")
 
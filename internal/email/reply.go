@@ -0,0 +1,130 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+)
+
+// NewReply creates a draft answering orig. The draft is addressed to the
+// Reply-To or From of the original; with all set, every other To and Cc
+// recipient is kept except the addresses listed in self. The threading
+// headers are filled in and the original text is quoted with "> ".
+func NewReply(orig *Message, self []string, all bool) *Message {
+	isSelf := make(map[string]bool, len(self))
+	for _, addr := range self {
+		isSelf[strings.ToLower(addr)] = true
+	}
+
+	replyTo := orig.ReplyTo
+	if len(replyTo) == 0 {
+		replyTo = orig.From
+	}
+
+	draft := &Message{
+		Subject:   prefixSubject("Re: ", orig.Subject),
+		InReplyTo: orig.MessageID,
+		Body:      &Body{Text: quoteBody(orig)},
+	}
+
+	if orig.MessageID != "" {
+		draft.References = append(append([]string{}, orig.References...), orig.MessageID)
+	} else {
+		draft.References = append([]string{}, orig.References...)
+	}
+
+	seen := make(map[string]bool)
+	draft.To = appendUnique(nil, replyTo, seen, nil)
+
+	if all {
+		draft.To = appendUnique(draft.To, orig.To, seen, isSelf)
+		draft.Cc = appendUnique(nil, orig.Cc, seen, isSelf)
+	}
+
+	// Replying to our own message goes back to its recipients
+	if len(draft.To) > 0 && allSelf(draft.To, isSelf) {
+		draft.To = appendUnique(nil, orig.To, make(map[string]bool), isSelf)
+		if len(draft.To) == 0 {
+			draft.To = replyTo
+		}
+	}
+
+	return draft
+}
+
+// PlainText returns the body as plain text, converting HTML when the
+// message has no text part
+func PlainText(body *Body) string {
+	if body == nil {
+		return ""
+	}
+
+	text := body.Text
+	if text == "" && body.HTML != "" {
+		converter := md.NewConverter("", true, nil)
+		if markdown, err := converter.ConvertString(body.HTML); err == nil {
+			text = sanitizeMarkdown(markdown)
+		}
+	}
+
+	return strings.ReplaceAll(text, "\r\n", "\n")
+}
+
+func quoteBody(orig *Message) string {
+	var sb strings.Builder
+
+	from := "Unknown"
+	if len(orig.From) > 0 {
+		from = orig.From[0].String()
+	}
+
+	if orig.Date.IsZero() {
+		fmt.Fprintf(&sb, "%s wrote:\n", from)
+	} else {
+		fmt.Fprintf(&sb, "On %s, %s wrote:\n", orig.Date.Format("Mon, Jan 02, 2006 at 15:04"), from)
+	}
+
+	text := strings.TrimRight(PlainText(orig.Body), "\n")
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, ">") {
+			sb.WriteString(">" + line + "\n")
+		} else if line == "" {
+			sb.WriteString(">\n")
+		} else {
+			sb.WriteString("> " + line + "\n")
+		}
+	}
+	sb.WriteString("\n")
+
+	return sb.String()
+}
+
+// prefixSubject adds prefix unless the subject already starts with it
+func prefixSubject(prefix, subject string) string {
+	if strings.HasPrefix(strings.ToLower(subject), strings.ToLower(prefix)) {
+		return subject
+	}
+	return prefix + subject
+}
+
+func appendUnique(dst, src []Address, seen, skip map[string]bool) []Address {
+	for _, addr := range src {
+		key := strings.ToLower(addr.Email)
+		if key == "" || seen[key] || skip[key] {
+			continue
+		}
+		seen[key] = true
+		dst = append(dst, addr)
+	}
+	return dst
+}
+
+func allSelf(addrs []Address, isSelf map[string]bool) bool {
+	for _, addr := range addrs {
+		if !isSelf[strings.ToLower(addr.Email)] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewReply_ThreadingHeaders(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "multipart.eml"))
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+
+	orig, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	reply := NewReply(orig, []string{"bob@example.com"}, false)
+
+	if reply.Subject != "Re: Multipart Alternative Email" {
+		t.Errorf("Expected 'Re: ' subject, got '%s'", reply.Subject)
+	}
+	if reply.InReplyTo != "<multipart123@example.com>" {
+		t.Errorf("Expected In-Reply-To '<multipart123@example.com>', got '%s'", reply.InReplyTo)
+	}
+
+	expectedRefs := []string{"<thread1@example.com>", "<previous@example.com>", "<multipart123@example.com>"}
+	if strings.Join(reply.References, " ") != strings.Join(expectedRefs, " ") {
+		t.Errorf("Expected References %v, got %v", expectedRefs, reply.References)
+	}
+
+	if len(reply.To) != 1 || reply.To[0].Email != "alice@example.com" {
+		t.Errorf("Expected reply to alice@example.com, got %v", reply.To)
+	}
+	if len(reply.Cc) != 0 {
+		t.Errorf("Expected no Cc on a plain reply, got %v", reply.Cc)
+	}
+
+	if !strings.Contains(reply.Body.Text, "> This is the plain text version.\n") {
+		t.Errorf("Expected quoted original body, got:\n%s", reply.Body.Text)
+	}
+	if !strings.Contains(reply.Body.Text, "Alice <alice@example.com> wrote:") {
+		t.Errorf("Expected attribution line, got:\n%s", reply.Body.Text)
+	}
+}
+
+func TestNewReply_AllExcludesSelf(t *testing.T) {
+	orig := &Message{
+		From:      []Address{{Email: "alice@example.com"}},
+		ReplyTo:   []Address{{Email: "list@example.com"}},
+		To:        []Address{{Email: "me@example.com"}, {Email: "dave@example.com"}},
+		Cc:        []Address{{Email: "ME@example.com"}, {Email: "erin@example.com"}, {Email: "list@example.com"}},
+		Subject:   "RE: Plans",
+		MessageID: "<abc@example.com>",
+		Body:      &Body{Text: "line one\n\n> earlier quote\n"},
+	}
+
+	reply := NewReply(orig, []string{"me@example.com"}, true)
+
+	var to, cc []string
+	for _, a := range reply.To {
+		to = append(to, a.Email)
+	}
+	for _, a := range reply.Cc {
+		cc = append(cc, a.Email)
+	}
+
+	if strings.Join(to, ",") != "list@example.com,dave@example.com" {
+		t.Errorf("Unexpected To %v", to)
+	}
+	if strings.Join(cc, ",") != "erin@example.com" {
+		t.Errorf("Unexpected Cc %v", cc)
+	}
+	if reply.Subject != "RE: Plans" {
+		t.Errorf("Expected subject to keep existing prefix, got '%s'", reply.Subject)
+	}
+	if !strings.Contains(reply.Body.Text, "> line one\n>\n>> earlier quote\n") {
+		t.Errorf("Unexpected quoting:\n%s", reply.Body.Text)
+	}
+}
+
+func TestNewReply_ToOwnMessage(t *testing.T) {
+	orig := &Message{
+		From: []Address{{Email: "me@example.com"}},
+		To:   []Address{{Email: "bob@example.com"}},
+		Body: &Body{Text: "hello"},
+	}
+
+	reply := NewReply(orig, []string{"me@example.com"}, false)
+	if len(reply.To) != 1 || reply.To[0].Email != "bob@example.com" {
+		t.Errorf("Expected reply to own message to go to bob@example.com, got %v", reply.To)
+	}
+}
+
+func TestPlainText_FallsBackToHTML(t *testing.T) {
+	text := PlainText(&Body{HTML: "<p>Hello <strong>world</strong></p>"})
+	if !strings.Contains(text, "Hello") || strings.Contains(text, "<p>") {
+		t.Errorf("Expected HTML converted to text, got %q", text)
+	}
+}
+
+func TestBuild_ThreadingHeaders(t *testing.T) {
+	raw, err := Build(&Message{
+		From:       []Address{{Email: "bob@example.com"}},
+		To:         []Address{{Email: "alice@example.com"}},
+		Subject:    "Re: Hi",
+		InReplyTo:  "<a@example.com>",
+		References: []string{"<root@example.com>", "<a@example.com>"},
+		Body:       &Body{Text: "ok"},
+	})
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+
+	parsed, err := Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if parsed.InReplyTo != "<a@example.com>" {
+		t.Errorf("Expected In-Reply-To '<a@example.com>', got '%s'", parsed.InReplyTo)
+	}
+	if len(parsed.References) != 2 || parsed.References[0] != "<root@example.com>" {
+		t.Errorf("Expected References preserved, got %v", parsed.References)
+	}
+}
@@ -0,0 +1,113 @@
+package email
+
+import (
+	"fmt"
+	"strings"
+)
+
+// templateHeaders are the fields editable in an external editor, in order
+var templateHeaders = []string{"To", "Cc", "Bcc", "Subject"}
+
+// TemplateError reports a problem in an edited message template
+type TemplateError struct {
+	Line int
+	Err  error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// FormatTemplate renders a draft as editable text: To, Cc, Bcc and Subject
+// headers, a blank line, then the body
+func FormatTemplate(draft *Message) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "To: %s\n", formatAddresses(draft.To))
+	fmt.Fprintf(&sb, "Cc: %s\n", formatAddresses(draft.Cc))
+	fmt.Fprintf(&sb, "Bcc: %s\n", formatAddresses(draft.Bcc))
+	fmt.Fprintf(&sb, "Subject: %s\n", draft.Subject)
+	sb.WriteString("\n")
+	if draft.Body != nil {
+		sb.WriteString(draft.Body.Text)
+	}
+
+	return sb.String()
+}
+
+// ParseTemplate reads text produced by FormatTemplate after the user edited
+// it. Header lines may be folded with leading whitespace; unknown or
+// malformed headers are reported as a *TemplateError.
+func ParseTemplate(text string) (*Message, error) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+
+	draft := &Message{Body: &Body{}}
+	values := make(map[string]string)
+	headerLine := make(map[string]int)
+
+	var current string
+	bodyStart := len(lines)
+
+	for i, line := range lines {
+		if line == "" {
+			bodyStart = i + 1
+			break
+		}
+
+		// Folded continuation of the previous header
+		if line[0] == ' ' || line[0] == '\t' {
+			if current == "" {
+				return nil, &TemplateError{Line: i + 1, Err: fmt.Errorf("continuation line without a header")}
+			}
+			values[current] += " " + strings.TrimSpace(line)
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, &TemplateError{Line: i + 1, Err: fmt.Errorf("malformed header %q, expected \"Name: value\"", line)}
+		}
+
+		key := canonicalTemplateHeader(strings.TrimSpace(name))
+		if key == "" {
+			return nil, &TemplateError{Line: i + 1, Err: fmt.Errorf("unknown header %q", strings.TrimSpace(name))}
+		}
+		if _, dup := values[key]; dup {
+			return nil, &TemplateError{Line: i + 1, Err: fmt.Errorf("duplicate %s header", key)}
+		}
+
+		current = key
+		values[key] = strings.TrimSpace(value)
+		headerLine[key] = i + 1
+	}
+
+	lists := map[string]*[]Address{"To": &draft.To, "Cc": &draft.Cc, "Bcc": &draft.Bcc}
+	for _, key := range []string{"To", "Cc", "Bcc"} {
+		addrs, err := ParseAddressList(values[key])
+		if err != nil {
+			return nil, &TemplateError{Line: headerLine[key], Err: err}
+		}
+		*lists[key] = addrs
+	}
+	draft.Subject = values["Subject"]
+
+	if bodyStart < len(lines) {
+		draft.Body.Text = strings.Join(lines[bodyStart:], "\n")
+	}
+
+	return draft, nil
+}
+
+func canonicalTemplateHeader(name string) string {
+	for _, h := range templateHeaders {
+		if strings.EqualFold(h, name) {
+			return h
+		}
+	}
+	return ""
+}
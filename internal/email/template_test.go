@@ -0,0 +1,81 @@
+package email
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTemplate_RoundTrip(t *testing.T) {
+	draft := &Message{
+		To:      []Address{{Name: "Bob", Email: "bob@example.com"}, {Email: "carol@example.com"}},
+		Cc:      []Address{{Email: "dave@example.com"}},
+		Subject: "Re: Plans",
+		Body:    &Body{Text: "Sounds good.\n\nTo: not a header\n"},
+	}
+
+	parsed, err := ParseTemplate(FormatTemplate(draft))
+	if err != nil {
+		t.Fatalf("ParseTemplate() failed: %v", err)
+	}
+
+	if len(parsed.To) != 2 || parsed.To[0] != draft.To[0] || parsed.To[1] != draft.To[1] {
+		t.Errorf("Expected To %v, got %v", draft.To, parsed.To)
+	}
+	if len(parsed.Cc) != 1 || parsed.Cc[0].Email != "dave@example.com" {
+		t.Errorf("Expected Cc %v, got %v", draft.Cc, parsed.Cc)
+	}
+	if len(parsed.Bcc) != 0 {
+		t.Errorf("Expected empty Bcc, got %v", parsed.Bcc)
+	}
+	if parsed.Subject != draft.Subject {
+		t.Errorf("Expected subject '%s', got '%s'", draft.Subject, parsed.Subject)
+	}
+	if parsed.Body.Text != draft.Body.Text {
+		t.Errorf("Expected body %q, got %q", draft.Body.Text, parsed.Body.Text)
+	}
+}
+
+func TestParseTemplate_FoldedAndCaseInsensitive(t *testing.T) {
+	parsed, err := ParseTemplate("to: bob@example.com,\n  carol@example.com\nSUBJECT: Hi\n\nBody")
+	if err != nil {
+		t.Fatalf("ParseTemplate() failed: %v", err)
+	}
+	if len(parsed.To) != 2 {
+		t.Errorf("Expected folded To to yield 2 addresses, got %v", parsed.To)
+	}
+	if parsed.Subject != "Hi" || parsed.Body.Text != "Body" {
+		t.Errorf("Unexpected subject/body %q / %q", parsed.Subject, parsed.Body.Text)
+	}
+}
+
+func TestParseTemplate_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		line int
+	}{
+		{"missing colon", "To: bob@example.com\nSubject Hi\n\nBody", 2},
+		{"unknown header", "To: bob@example.com\nFrom: me@example.com\n\nBody", 2},
+		{"duplicate header", "Subject: a\nSubject: b\n\n", 2},
+		{"bad address", "Subject: a\nTo: not an address\n\n", 2},
+		{"stray continuation", " leading space\n\n", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTemplate(tt.text)
+
+			var tmplErr *TemplateError
+			if !errors.As(err, &tmplErr) {
+				t.Fatalf("Expected TemplateError, got %v", err)
+			}
+			if tmplErr.Line != tt.line {
+				t.Errorf("Expected error on line %d, got %d", tt.line, tmplErr.Line)
+			}
+			if !strings.HasPrefix(tmplErr.Error(), "line ") {
+				t.Errorf("Expected error message to mention the line, got %q", tmplErr.Error())
+			}
+		})
+	}
+}
@@ -70,3 +70,14 @@ func (m *Message) IsUnread() bool {
 func (m *Message) IsFlagged() bool {
 	return m.HasFlag("\\Flagged")
 }
+
+// Recipients returns the envelope addresses of every To, Cc and Bcc recipient
+func (m *Message) Recipients() []string {
+	recipients := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	for _, list := range [][]Address{m.To, m.Cc, m.Bcc} {
+		for _, addr := range list {
+			recipients = append(recipients, addr.Email)
+		}
+	}
+	return recipients
+}
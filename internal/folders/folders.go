@@ -0,0 +1,108 @@
+// Package folders remembers how the mailbox tree was left, so collapsed
+// folders stay collapsed between runs
+package folders
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/chhlga/budge/internal/fsutil"
+)
+
+// State records the collapsed mailboxes of every account in a JSON file
+type State struct {
+	mu        sync.Mutex
+	path      string
+	collapsed map[string]map[string]bool
+}
+
+// file is the layout of the state file
+type file struct {
+	Collapsed map[string][]string `json:"collapsed"`
+}
+
+// DefaultPath returns $XDG_DATA_HOME/budge/folders.json, falling back to
+// ~/.local/share/budge/folders.json
+func DefaultPath() (string, error) {
+	return fsutil.DataDir("folders.json")
+}
+
+// Load reads the state stored at path. A missing file is an empty state.
+func Load(path string) (*State, error) {
+	s := &State{path: path, collapsed: make(map[string]map[string]bool)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read folder state: %w", err)
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse folder state %s: %w", path, err)
+	}
+	for account, mailboxes := range f.Collapsed {
+		s.collapsed[account] = make(map[string]bool, len(mailboxes))
+		for _, mailbox := range mailboxes {
+			s.collapsed[account][mailbox] = true
+		}
+	}
+	return s, nil
+}
+
+// Collapsed returns the collapsed mailboxes of account
+func (s *State) Collapsed(account string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var mailboxes []string
+	for mailbox := range s.collapsed[account] {
+		mailboxes = append(mailboxes, mailbox)
+	}
+	sort.Strings(mailboxes)
+	return mailboxes
+}
+
+// SetCollapsed records whether a mailbox of account is collapsed and
+// saves the state
+func (s *State) SetCollapsed(account, mailbox string, collapsed bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if collapsed {
+		if s.collapsed[account] == nil {
+			s.collapsed[account] = make(map[string]bool)
+		}
+		s.collapsed[account][mailbox] = true
+	} else {
+		delete(s.collapsed[account], mailbox)
+	}
+	return s.save()
+}
+
+// save replaces the state file through a temporary file so a crash never
+// leaves a truncated one behind
+func (s *State) save() error {
+	f := file{Collapsed: make(map[string][]string)}
+	for account, mailboxes := range s.collapsed {
+		for mailbox := range mailboxes {
+			f.Collapsed[account] = append(f.Collapsed[account], mailbox)
+		}
+		sort.Strings(f.Collapsed[account])
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode folder state: %w", err)
+	}
+
+	if err := fsutil.WriteFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write folder state: %w", err)
+	}
+	return nil
+}
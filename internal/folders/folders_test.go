@@ -0,0 +1,41 @@
+package folders
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestState_persistsCollapsedMailboxes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budge", "folders.json")
+
+	state, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := state.Collapsed("work"); len(got) != 0 {
+		t.Fatalf("Expected nothing collapsed without a file, got %v", got)
+	}
+
+	for _, mailbox := range []string{"Projects", "Archive/2023"} {
+		if err := state.SetCollapsed("work", mailbox, true); err != nil {
+			t.Fatalf("SetCollapsed() error: %v", err)
+		}
+	}
+	if err := state.SetCollapsed("home", "Lists", true); err != nil {
+		t.Fatalf("SetCollapsed() error: %v", err)
+	}
+	if err := state.SetCollapsed("work", "Projects", false); err != nil {
+		t.Fatalf("SetCollapsed() error: %v", err)
+	}
+
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := reloaded.Collapsed("work"); len(got) != 1 || got[0] != "Archive/2023" {
+		t.Errorf("Expected only Archive/2023 collapsed for work, got %v", got)
+	}
+	if got := reloaded.Collapsed("home"); len(got) != 1 || got[0] != "Lists" {
+		t.Errorf("Expected Lists collapsed for home, got %v", got)
+	}
+}
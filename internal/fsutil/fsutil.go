@@ -0,0 +1,52 @@
+// Package fsutil holds the file handling shared by the state budge keeps
+// on disk
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DataDir returns $XDG_DATA_HOME/budge, falling back to
+// ~/.local/share/budge, joined with elem
+func DataDir(elem ...string) (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(append([]string{dataHome, "budge"}, elem...)...), nil
+}
+
+// WriteFileAtomic replaces the file at path through a temporary file in
+// the same directory, so a crash never leaves a truncated one behind. The
+// directory is created when missing and the file is only readable by the
+// user.
+func WriteFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
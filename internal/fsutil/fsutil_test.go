@@ -0,0 +1,57 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDataDir(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", "/data")
+	got, err := DataDir("tokens", "me.json")
+	if err != nil {
+		t.Fatalf("DataDir() error: %v", err)
+	}
+	if want := filepath.Join("/data", "budge", "tokens", "me.json"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("HOME", "/home/me")
+	got, err = DataDir()
+	if err != nil {
+		t.Fatalf("DataDir() error: %v", err)
+	}
+	if want := filepath.Join("/home/me", ".local", "share", "budge"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestWriteFileAtomic_replacesFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "budge")
+	path := filepath.Join(dir, "state.json")
+
+	for _, data := range []string{"first", "second"} {
+		if err := WriteFileAtomic(path, []byte(data)); err != nil {
+			t.Fatalf("WriteFileAtomic() error: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	if string(data) != "second" {
+		t.Errorf("Expected the latest content, got %q", data)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left, got %d entries", len(entries))
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the file to be private, got %v", info.Mode().Perm())
+	}
+}
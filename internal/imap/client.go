@@ -3,18 +3,37 @@ package imap
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"math"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/chhlga/budge/internal/oauth"
+	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-sasl"
 )
 
+// Supported values for Options.AuthMechanism. An empty mechanism logs in
+// with the password; the OAuth2 mechanisms take their token from
+// Options.OAuth2Token.
+const (
+	AuthLogin       = "login"
+	AuthXOAuth2     = "xoauth2"
+	AuthOAuthBearer = "oauthbearer"
+)
+
+// UpdateHandler receives the changes MonitorMailbox observes. Callbacks
+// run on the connection's reader goroutine and must not block.
 type UpdateHandler struct {
-	OnNewMail func(mailbox string, count uint32)
-	OnExpunge func(mailbox string, seqNum uint32)
-	Mailbox   string
+	OnNewMail      func(mailbox string, count uint32)
+	OnExpunge      func(mailbox string, seqNum uint32)
+	OnFlagsChanged func(mailbox string, seqNum, uid uint32, flags []string)
+	Mailbox        string
 }
 
 type ConnectionState int
@@ -29,21 +48,45 @@ const (
 type Client struct {
 	mu            sync.RWMutex
 	client        *imapclient.Client
+	wire          *wireConn
 	state         ConnectionState
 	opts          *Options
 	updateHandler *UpdateHandler
+	stateHandler  func(ConnectionState)
+	// lost is set when the connection died under an operation and cleared
+	// once a new session is authenticated
+	lost bool
+	// lastUsed is when the last operation finished, for the keepalive
+	lastUsed time.Time
+	// ops holds a token while an operation owns the connection
+	ops chan struct{}
 }
 
 type Options struct {
-	Host                 string
-	Port                 int
-	TLS                  bool
-	STARTTLS             bool
-	Username             string
-	Password             string
+	Host          string
+	Port          int
+	TLS           bool
+	STARTTLS      bool
+	Username      string
+	Password      string
+	AuthMechanism string
+	// OAuth2Token returns the access token for the OAuth2 mechanisms. It
+	// is called on every login so an expired token can be refreshed.
+	OAuth2Token          func(ctx context.Context) (string, error)
 	MaxReconnectAttempts int
 	InitialBackoff       time.Duration
 	MaxBackoff           time.Duration
+	// CommandTimeout is how long an operation may wait for the server to
+	// send anything; a connection that stays silent longer is closed and
+	// ErrTimeout returned
+	CommandTimeout time.Duration
+	// KeepaliveInterval is how long the connection may sit unused before
+	// Keepalive checks it with a NOOP
+	KeepaliveInterval time.Duration
+
+	// tlsConfig replaces the TLS settings derived from Host; tests use it
+	// to trust their server
+	tlsConfig *tls.Config
 }
 
 func NewClient(opts *Options) *Client {
@@ -56,10 +99,17 @@ func NewClient(opts *Options) *Client {
 	if opts.MaxBackoff == 0 {
 		opts.MaxBackoff = 30 * time.Second
 	}
+	if opts.CommandTimeout == 0 {
+		opts.CommandTimeout = 30 * time.Second
+	}
+	if opts.KeepaliveInterval == 0 {
+		opts.KeepaliveInterval = 30 * time.Second
+	}
 
 	return &Client{
 		opts:  opts,
 		state: StateDisconnected,
+		ops:   make(chan struct{}, 1),
 	}
 }
 
@@ -71,36 +121,71 @@ func (c *Client) Connect(ctx context.Context) error {
 		return ErrAlreadyConnected
 	}
 
-	c.state = StateConnecting
+	c.setState(StateConnecting)
 
-	addr := fmt.Sprintf("%s:%d", c.opts.Host, c.opts.Port)
+	client, wire, err := c.dial(nil)
+	if err != nil {
+		c.setState(StateDisconnected)
+		return &ConnectionError{Op: "dial", Err: err}
+	}
 
-	var client *imapclient.Client
-	var err error
+	c.client, c.wire = client, wire
+	c.setState(StateConnected)
+
+	return nil
+}
 
-	dialOpts := &imapclient.Options{
-		TLSConfig: &tls.Config{
-			ServerName: c.opts.Host,
-		},
+// dial opens a new connection to the configured server. Unilateral
+// responses are passed to handler when it is not nil. The returned
+// wireConn is the connection under the client, for withDeadline.
+func (c *Client) dial(handler *imapclient.UnilateralDataHandler) (*imapclient.Client, *wireConn, error) {
+	addr := net.JoinHostPort(c.opts.Host, strconv.Itoa(c.opts.Port))
+	dialer := &net.Dialer{Timeout: c.opts.CommandTimeout}
+	tlsConfig := c.opts.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: c.opts.Host}
 	}
 
+	var conn net.Conn
+	var err error
 	if c.opts.TLS {
-		client, err = imapclient.DialTLS(addr, dialOpts)
-	} else if c.opts.STARTTLS {
-		client, err = imapclient.DialStartTLS(addr, dialOpts)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
 	} else {
-		client, err = imapclient.DialInsecure(addr, dialOpts)
+		conn, err = dialer.Dial("tcp", addr)
 	}
-
 	if err != nil {
-		c.state = StateDisconnected
-		return &ConnectionError{Op: "dial", Err: err}
+		return nil, nil, err
 	}
 
-	c.client = client
-	c.state = StateConnected
+	wire := newWireConn(conn)
+	client := imapclient.New(wire, &imapclient.Options{UnilateralDataHandler: handler})
+	if !c.opts.TLS && c.opts.STARTTLS {
+		err := c.withDeadline(wire, func() error {
+			return startTLS(client, wire, tlsConfig)
+		})
+		if err != nil {
+			_ = client.Close()
+			return nil, nil, err
+		}
+	}
+	return client, wire, nil
+}
 
-	return nil
+// startTLS upgrades conn with STARTTLS. wire sends the command rather than
+// imapclient, so that the decrypted stream still passes through it.
+func startTLS(conn *imapclient.Client, wire *wireConn, config *tls.Config) error {
+	if err := conn.WaitGreeting(); err != nil {
+		return err
+	}
+	if conn.State() != imap.ConnStateNotAuthenticated {
+		return errors.New("server sent PREAUTH on unencrypted connection")
+	}
+	if err := wire.startTLS(config); err != nil {
+		return err
+	}
+	// Capabilities announced before TLS can't be trusted
+	_, err := conn.Capability().Wait()
+	return err
 }
 
 func (c *Client) Authenticate(ctx context.Context) error {
@@ -111,17 +196,67 @@ func (c *Client) Authenticate(ctx context.Context) error {
 		return ErrNotConnected
 	}
 
-	if err := c.client.Login(c.opts.Username, c.opts.Password).Wait(); err != nil {
+	auth, err := c.saslClient(ctx)
+	if err != nil {
+		return &AuthenticationError{Username: c.opts.Username, Err: err}
+	}
+
+	err = c.withDeadline(c.wire, func() error {
+		return c.login(c.client, auth)
+	})
+	if errors.Is(err, ErrTimeout) {
+		return &ConnectionError{Op: "login", Err: err}
+	}
+	if err != nil {
 		return &AuthenticationError{
 			Username: c.opts.Username,
 			Err:      err,
 		}
 	}
 
-	c.state = StateAuthenticated
+	c.lost = false
+	c.setState(StateAuthenticated)
 	return nil
 }
 
+// saslClient returns the SASL client for the configured mechanism, or nil
+// when logging in with the password
+func (c *Client) saslClient(ctx context.Context) (sasl.Client, error) {
+	switch strings.ToLower(c.opts.AuthMechanism) {
+	case "", AuthLogin:
+		return nil, nil
+	case AuthXOAuth2, AuthOAuthBearer:
+	default:
+		return nil, fmt.Errorf("unknown authentication mechanism %q", c.opts.AuthMechanism)
+	}
+
+	if c.opts.OAuth2Token == nil {
+		return nil, errors.New("no OAuth2 token configured")
+	}
+	token, err := c.opts.OAuth2Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.ToLower(c.opts.AuthMechanism) == AuthXOAuth2 {
+		return oauth.NewXOAuth2Client(c.opts.Username, token), nil
+	}
+	return sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+		Username: c.opts.Username,
+		Token:    token,
+		Host:     c.opts.Host,
+		Port:     c.opts.Port,
+	}), nil
+}
+
+// login authenticates conn with LOGIN, or with auth when it is set
+func (c *Client) login(conn *imapclient.Client, auth sasl.Client) error {
+	if auth == nil {
+		return conn.Login(c.opts.Username, c.opts.Password).Wait()
+	}
+	return conn.Authenticate(auth)
+}
+
 func (c *Client) Disconnect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -134,10 +269,10 @@ func (c *Client) Disconnect() error {
 		if err := c.client.Logout().Wait(); err != nil {
 			_ = c.client.Close() // Best effort close, ignore error after failed logout
 		}
-		c.client = nil
+		c.client, c.wire = nil, nil
 	}
 
-	c.state = StateDisconnected
+	c.setState(StateDisconnected)
 	return nil
 }
 
@@ -182,6 +317,27 @@ func (c *Client) calculateBackoff(attempt int) time.Duration {
 	return time.Duration(backoff)
 }
 
+// setState records a state transition and reports it to the state
+// handler. The caller must hold c.mu.
+func (c *Client) setState(state ConnectionState) {
+	if c.state == state {
+		return
+	}
+	c.state = state
+	if c.stateHandler != nil {
+		c.stateHandler(state)
+	}
+}
+
+// SetStateHandler registers a function called on every connection state
+// change, including those made while reconnecting in the background. It is
+// called with the client locked and must not block or call the client.
+func (c *Client) SetStateHandler(handler func(ConnectionState)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stateHandler = handler
+}
+
 func (c *Client) State() ConnectionState {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -215,51 +371,207 @@ func (c *Client) GetUpdateHandler() *UpdateHandler {
 	return c.updateHandler
 }
 
-// MonitorMailbox starts monitoring the current mailbox for updates
-func (c *Client) MonitorMailbox(ctx context.Context, mailbox string, interval time.Duration) {
+// Op is an operation run by Do. selected holds the SELECT response for the
+// mailbox passed to Do, or nil when no mailbox was requested.
+type Op func(conn *imapclient.Client, selected *imap.SelectData) error
+
+// Do runs op with exclusive use of the connection. Operations run one at a
+// time, so none can change the selected mailbox under another; an operation
+// that needs a mailbox names it and Do selects it first. Do gives up with
+// ctx.Err() when ctx ends before the operation gets its turn, and returns
+// ctx.Err() instead of the result when ctx ended while it ran, so callers
+// never act on results nobody is waiting for any more.
+//
+// When the connection turns out to be dead, Do reconnects with backoff.
+// op is only run again if it never got to send anything, i.e. selecting
+// its mailbox failed; the server may already have applied a command whose
+// answer was lost. An operation the server stops answering for
+// CommandTimeout fails with ErrTimeout and is not retried, since the
+// server may just be slow; the next operation reconnects.
+func (c *Client) Do(ctx context.Context, mailbox string, op Op) error {
+	return c.do(ctx, mailbox, op, false)
+}
+
+// DoIdempotent is Do for operations that can safely run twice, such as
+// fetches, searches and setting flags. When the connection dies while op
+// runs, it is run once more on the new connection.
+func (c *Client) DoIdempotent(ctx context.Context, mailbox string, op Op) error {
+	return c.do(ctx, mailbox, op, true)
+}
+
+func (c *Client) do(ctx context.Context, mailbox string, op Op, idempotent bool) error {
+	select {
+	case c.ops <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.ops }()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if c.connectionLost() {
+		if err := c.reconnectLost(ctx); err != nil {
+			return err
+		}
+	}
+
+	started, err := c.run(mailbox, op)
+	if err != nil && !errors.Is(err, ErrTimeout) && ctx.Err() == nil && c.connectionLost() {
+		if err := c.reconnectLost(ctx); err != nil {
+			return err
+		}
+		if idempotent || !started {
+			_, err = c.run(mailbox, op)
+		}
+	}
+	c.mu.Lock()
+	c.lastUsed = time.Now()
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Ping checks that the session is usable, reconnecting when it was lost
+func (c *Client) Ping(ctx context.Context) error {
+	return c.DoIdempotent(ctx, "", func(conn *imapclient.Client, _ *imap.SelectData) error {
+		return conn.Noop().Wait()
+	})
+}
+
+// run selects mailbox and runs op, reporting whether op was started
+func (c *Client) run(mailbox string, op Op) (bool, error) {
+	c.mu.RLock()
+	conn, wire := c.client, c.wire
+	c.mu.RUnlock()
+	if conn == nil || !c.IsConnected() {
+		return false, ErrNotConnected
+	}
+
+	started := false
+	err := c.withDeadline(wire, func() error {
+		var selected *imap.SelectData
+		if mailbox != "" {
+			data, err := conn.Select(mailbox, nil).Wait()
+			if err != nil {
+				return fmt.Errorf("failed to select mailbox %s: %w", mailbox, err)
+			}
+			selected = data
+		}
+
+		started = true
+		return op(conn, selected)
+	})
+	return started, err
+}
+
+// withDeadline runs fn and closes conn when the server sends nothing for
+// CommandTimeout. A command stuck on a half-open connection then fails
+// with ErrTimeout instead of hanging forever, while a large fetch on a
+// slow link goes on for as long as data keeps arriving.
+func (c *Client) withDeadline(conn *wireConn, fn func() error) error {
+	var mu sync.Mutex
+	var timer *time.Timer
+	done, timedOut := false, false
+	start := time.Now()
+
+	mu.Lock()
+	timer = time.AfterFunc(c.opts.CommandTimeout, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if done {
+			return
+		}
+
+		last := conn.lastActivity()
+		if last.Before(start) {
+			last = start
+		}
+		if silent := time.Since(last); silent < c.opts.CommandTimeout {
+			timer.Reset(c.opts.CommandTimeout - silent)
+			return
+		}
+		timedOut = true
+		_ = conn.Close()
+	})
+	mu.Unlock()
+
+	err := fn()
+
+	mu.Lock()
+	defer mu.Unlock()
+	done = true
+	timer.Stop()
+	if timedOut {
+		return fmt.Errorf("%w: no answer for %s", ErrTimeout, c.opts.CommandTimeout)
+	}
+	return err
+}
+
+// Keepalive checks the connection with a NOOP whenever it has been unused
+// for KeepaliveInterval, until ctx is cancelled. A connection that died
+// quietly is noticed and replaced before the user needs it.
+func (c *Client) Keepalive(ctx context.Context) {
 	go func() {
-		ticker := time.NewTicker(interval)
+		ticker := time.NewTicker(c.opts.KeepaliveInterval / 2)
 		defer ticker.Stop()
 
-		var prevCount uint32
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				if c.client == nil || c.state != StateAuthenticated {
-					continue
-				}
+			}
 
-				selectData, err := c.client.Select(mailbox, nil).Wait()
-				if err != nil {
-					continue
-				}
+			c.mu.RLock()
+			idle := time.Since(c.lastUsed) >= c.opts.KeepaliveInterval
+			active := c.client != nil || c.lost
+			c.mu.RUnlock()
 
-				currentCount := selectData.NumMessages
-				handler := c.GetUpdateHandler()
-				if handler != nil && currentCount > prevCount {
-					handler.OnNewMail(mailbox, currentCount)
+			if idle && active {
+				// A timed out NOOP leaves the connection closed, the
+				// second one reconnects
+				if err := c.Ping(ctx); errors.Is(err, ErrTimeout) {
+					_ = c.Ping(ctx)
 				}
-				prevCount = currentCount
 			}
 		}
 	}()
 }
 
-// CheckForNewMessages checks if there are new messages in the specified mailbox
-func (c *Client) CheckForNewMessages(ctx context.Context, mailbox string) (uint32, error) {
-	c.mu.RLock()
+// connectionLost reports whether an established session has gone away,
+// either just now or during an earlier operation that could not reconnect
+func (c *Client) connectionLost() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lost {
+		return true
+	}
 	if c.client == nil {
-		c.mu.RUnlock()
-		return 0, ErrNotConnected
+		return false
 	}
-	c.mu.RUnlock()
 
-	selectData, err := c.client.Select(mailbox, nil).Wait()
-	if err != nil {
-		return 0, fmt.Errorf("failed to select mailbox: %w", err)
+	select {
+	case <-c.client.Closed():
+		c.lost = true
+		return true
+	default:
+		return false
 	}
+}
 
-	return selectData.NumMessages, nil
+// reconnectLost replaces a dead connection
+func (c *Client) reconnectLost(ctx context.Context) error {
+	if err := c.Reconnect(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &ConnectionError{Op: "reconnect", Err: fmt.Errorf("%w: %v", ErrConnectionLost, err)}
+	}
+	return nil
 }
@@ -1,10 +1,28 @@
 package imap
 
 import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
+	"math/big"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/chhlga/budge/internal/oauth"
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-sasl"
 )
 
 type mockIMAPConn struct {
@@ -223,3 +241,628 @@ func TestClient_UpdateHandlerNil(t *testing.T) {
 		t.Error("Expected nil handler when not set")
 	}
 }
+
+func TestClient_DoSelectsMailboxPerOperation(t *testing.T) {
+	opts := startTestServer(t, imap.CapSet{imap.CapIMAP4rev1: {}, imap.CapIMAP4rev2: {}})
+	client := connectTestClient(t, opts)
+
+	if err := client.Do(context.Background(), "", func(conn *imapclient.Client, selected *imap.SelectData) error {
+		if selected != nil {
+			t.Errorf("Expected no SELECT data without a mailbox")
+		}
+		return conn.Create("Archive", nil).Wait()
+	}); err != nil {
+		t.Fatalf("Do() error: %v", err)
+	}
+	appendTestMessage(t, client.Client())
+
+	var wg sync.WaitGroup
+	running := make(chan struct{}, 1)
+	for i := 0; i < 10; i++ {
+		mailbox, want := "INBOX", uint32(1)
+		if i%2 == 1 {
+			mailbox, want = "Archive", 0
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := client.Do(context.Background(), mailbox, func(conn *imapclient.Client, selected *imap.SelectData) error {
+				select {
+				case running <- struct{}{}:
+				default:
+					t.Errorf("Expected operations not to overlap")
+				}
+				defer func() { <-running }()
+
+				// Another operation selecting a mailbox now would change the result
+				time.Sleep(5 * time.Millisecond)
+				status, err := conn.Status(mailbox, &imap.StatusOptions{NumMessages: true}).Wait()
+				if err != nil {
+					return err
+				}
+				if selected.NumMessages != want || *status.NumMessages != want {
+					t.Errorf("Expected %d messages in %s, got %d", want, mailbox, selected.NumMessages)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Errorf("Do() error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClient_DoHonoursCancellation(t *testing.T) {
+	opts := startTestServer(t, imap.CapSet{imap.CapIMAP4rev1: {}})
+	client := connectTestClient(t, opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ran := false
+	err := client.Do(ctx, "INBOX", func(*imapclient.Client, *imap.SelectData) error {
+		ran = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if ran {
+		t.Errorf("Expected a cancelled operation not to run")
+	}
+
+	// Cancelled while running: the result is discarded
+	ctx, cancel = context.WithCancel(context.Background())
+	err = client.Do(ctx, "INBOX", func(*imapclient.Client, *imap.SelectData) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled after cancelling mid-operation, got %v", err)
+	}
+}
+
+func TestClient_DoRequiresConnection(t *testing.T) {
+	client := NewClient(&Options{Host: "localhost", Port: 993})
+
+	err := client.Do(context.Background(), "INBOX", func(*imapclient.Client, *imap.SelectData) error {
+		return nil
+	})
+	if !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+}
+
+func TestClient_DoReconnectsAfterConnectionDrop(t *testing.T) {
+	opts := startTestServer(t, imap.CapSet{imap.CapIMAP4rev1: {}})
+	opts.InitialBackoff = time.Millisecond
+	client := connectTestClient(t, opts)
+	appendTestMessage(t, client.Client())
+
+	var mu sync.Mutex
+	var states []ConnectionState
+	client.SetStateHandler(func(state ConnectionState) {
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, state)
+	})
+
+	dead := client.Client()
+	_ = dead.Close()
+	<-dead.Closed()
+
+	var count uint32
+	err := client.Do(context.Background(), "INBOX", func(_ *imapclient.Client, selected *imap.SelectData) error {
+		count = selected.NumMessages
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Do() error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected the mailbox to be selected again with 1 message, got %d", count)
+	}
+	if client.State() != StateAuthenticated {
+		t.Errorf("Expected StateAuthenticated, got %v", client.State())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []ConnectionState{StateDisconnected, StateConnecting, StateConnected, StateAuthenticated}
+	if len(states) != len(want) {
+		t.Fatalf("Expected transitions %v, got %v", want, states)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("Expected transitions %v, got %v", want, states)
+			break
+		}
+	}
+}
+
+func TestClient_DoRetriesOnlyIdempotentOps(t *testing.T) {
+	opts := startTestServer(t, imap.CapSet{imap.CapIMAP4rev1: {}})
+	opts.InitialBackoff = time.Millisecond
+	client := connectTestClient(t, opts)
+
+	// The connection dies after the command went out, as if its answer
+	// was lost on the way back
+	dropping := func(runs *int) Op {
+		return func(conn *imapclient.Client, _ *imap.SelectData) error {
+			*runs++
+			if *runs > 1 {
+				return nil
+			}
+			_ = conn.Close()
+			<-conn.Closed()
+			return conn.Noop().Wait()
+		}
+	}
+
+	var runs int
+	if err := client.Do(context.Background(), "INBOX", dropping(&runs)); err == nil {
+		t.Errorf("Expected the failure to be reported")
+	}
+	if runs != 1 {
+		t.Errorf("Expected Do not to run the operation again, ran %d times", runs)
+	}
+	if client.State() != StateAuthenticated {
+		t.Errorf("Expected Do to reconnect anyway, got %v", client.State())
+	}
+
+	runs = 0
+	if err := client.DoIdempotent(context.Background(), "INBOX", dropping(&runs)); err != nil {
+		t.Fatalf("DoIdempotent() error: %v", err)
+	}
+	if runs != 2 {
+		t.Errorf("Expected DoIdempotent to run the operation again, ran %d times", runs)
+	}
+}
+
+func TestClient_SubscribedUsesLSUB(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	tests := []struct {
+		name   string
+		listen func(t *testing.T) net.Listener
+		server *tls.Config
+		setup  func(opts *Options)
+	}{
+		{name: "plain", listen: listenTest},
+		{
+			name:   "TLS",
+			listen: func(t *testing.T) net.Listener { return tls.NewListener(listenTest(t), serverTLS) },
+			setup:  func(opts *Options) { opts.TLS = true },
+		},
+		{
+			name:   "STARTTLS",
+			listen: listenTest,
+			server: serverTLS,
+			setup:  func(opts *Options) { opts.STARTTLS = true },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := serveTestServer(t, tt.listen(t), imap.CapSet{imap.CapIMAP4rev1: {}}, nil, tt.server)
+			if tt.setup != nil {
+				opts.tlsConfig = clientTLS
+				tt.setup(opts)
+			}
+
+			addr := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
+			dial := imapclient.DialInsecure
+			if opts.TLS {
+				dial = imapclient.DialTLS
+			}
+			other, err := dial(addr, &imapclient.Options{TLSConfig: clientTLS})
+			if err != nil {
+				t.Fatalf("Dial() error: %v", err)
+			}
+			defer other.Close()
+			if err := other.Login(opts.Username, opts.Password).Wait(); err != nil {
+				t.Fatalf("Login() error: %v", err)
+			}
+			for _, name := range []string{"Sent", "Archive", "Entwürfe"} {
+				if err := other.Create(name, nil).Wait(); err != nil {
+					t.Fatalf("Create(%s) error: %v", name, err)
+				}
+			}
+			for _, name := range []string{"Sent", "Entwürfe"} {
+				if err := other.Subscribe(name).Wait(); err != nil {
+					t.Fatalf("Subscribe(%s) error: %v", name, err)
+				}
+			}
+
+			client := connectTestClient(t, opts)
+			for i := 0; i < 2; i++ {
+				names, err := client.Subscribed(context.Background())
+				if err != nil {
+					t.Fatalf("Subscribed() error: %v", err)
+				}
+				got := strings.Join(names, ",")
+				if !strings.Contains(got, "Sent") || !strings.Contains(got, "Entwürfe") || strings.Contains(got, "Archive") {
+					t.Errorf("Expected Sent and Entwürfe to be subscribed, got %q", got)
+				}
+
+				// imapclient carries on where LSUB left the connection
+				err = client.Do(context.Background(), "", func(conn *imapclient.Client, _ *imap.SelectData) error {
+					listed, err := conn.List("", "*", nil).Collect()
+					if err == nil && len(listed) != 4 {
+						t.Errorf("Expected 4 mailboxes, got %d", len(listed))
+					}
+					return err
+				})
+				if err != nil {
+					t.Fatalf("Do() error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLSUB(t *testing.T) {
+	tests := []struct {
+		resp string
+		name string
+		ok   bool
+	}{
+		{`* LSUB (\HasNoChildren) "/" Sent` + "\r\n", "Sent", true},
+		{`* lsub () "." "Work.\"Q1\""` + "\r\n", `Work."Q1"`, true},
+		{`* LSUB () NIL inbox` + "\r\n", "INBOX", true},
+		{"* LSUB () \"/\" {10}\r\nA &- B/C D\r\n", "A & B/C D", true},
+		{`* LSUB () "/" "Entw&APw-rfe &- Co"` + "\r\n", "Entwürfe & Co", true},
+		{`* LIST () "/" Sent` + "\r\n", "", false},
+		{`* 3 EXISTS` + "\r\n", "", false},
+	}
+
+	for _, tt := range tests {
+		name, _, ok := parseLSUB([]byte(tt.resp))
+		if name != tt.name || ok != tt.ok {
+			t.Errorf("parseLSUB(%q): expected %q (%v), got %q (%v)", tt.resp, tt.name, tt.ok, name, ok)
+		}
+	}
+}
+
+// testTLSConfigs returns a server configuration with a self-signed
+// certificate for 127.0.0.1 and a client configuration trusting it
+func testTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "budge test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+	return server, client
+}
+
+func TestClient_DoReportsLostConnection(t *testing.T) {
+	opts := startTestServer(t, imap.CapSet{imap.CapIMAP4rev1: {}})
+	opts.InitialBackoff = time.Millisecond
+	opts.MaxReconnectAttempts = 2
+	client := connectTestClient(t, opts)
+
+	// Nothing listens on the server's port any more
+	opts.Port = 1
+	_ = client.Client().Close()
+	<-client.Client().Closed()
+
+	err := client.Do(context.Background(), "", func(*imapclient.Client, *imap.SelectData) error {
+		t.Errorf("Expected the operation not to run without a connection")
+		return nil
+	})
+	if !errors.Is(err, ErrConnectionLost) || !IsConnectionError(err) {
+		t.Errorf("Expected ErrConnectionLost, got %v", err)
+	}
+}
+
+// startStalledServer accepts logins and then stops answering, like a
+// server behind a connection that went half-open
+func startStalledServer(t *testing.T) *Options {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = conn.Write([]byte("* OK [CAPABILITY IMAP4rev1] ready\r\n"))
+
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					tag, command, _ := strings.Cut(scanner.Text(), " ")
+					if strings.HasPrefix(strings.ToUpper(command), "LOGIN") {
+						_, _ = conn.Write([]byte(tag + " OK logged in\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return &Options{
+		Host:           "127.0.0.1",
+		Port:           addr.Port,
+		Username:       "user",
+		Password:       "pass",
+		CommandTimeout: 100 * time.Millisecond,
+	}
+}
+
+func TestClient_DoTimesOut(t *testing.T) {
+	client := connectTestClient(t, startStalledServer(t))
+
+	start := time.Now()
+	err := client.Ping(context.Background())
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+	if !IsConnectionError(err) {
+		t.Errorf("Expected a timeout to count as a connection error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the deadline to end the command quickly, took %v", elapsed)
+	}
+	if !client.connectionLost() {
+		t.Errorf("Expected the stalled connection to be dropped")
+	}
+}
+
+// slowListener hands out connections that send in small pieces with a
+// pause before each, like a server on a slow link
+type slowListener struct {
+	net.Listener
+	pause time.Duration
+}
+
+func (l slowListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return slowConn{Conn: conn, pause: l.pause}, nil
+}
+
+type slowConn struct {
+	net.Conn
+	pause time.Duration
+}
+
+func (c slowConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), 256)]
+		time.Sleep(c.pause)
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func TestClient_DoWaitsWhileDataArrives(t *testing.T) {
+	opts := serveTestServer(t, slowListener{Listener: listenTest(t), pause: 10 * time.Millisecond}, imap.CapSet{imap.CapIMAP4rev1: {}}, nil, nil)
+	opts.CommandTimeout = 100 * time.Millisecond
+
+	message := testMessage + strings.Repeat("A slow line of the message body.\r\n", 500)
+	append := dialOther(t, opts).Append("INBOX", int64(len(message)), nil)
+	if _, err := append.Write([]byte(message)); err != nil {
+		t.Fatalf("Append.Write() error: %v", err)
+	}
+	if err := append.Close(); err != nil {
+		t.Fatalf("Append.Close() error: %v", err)
+	}
+	if _, err := append.Wait(); err != nil {
+		t.Fatalf("Append.Wait() error: %v", err)
+	}
+
+	client := connectTestClient(t, opts)
+	start := time.Now()
+	var body []byte
+	err := client.Do(context.Background(), "INBOX", func(conn *imapclient.Client, _ *imap.SelectData) error {
+		section := &imap.FetchItemBodySection{}
+		msgs, err := conn.Fetch(imap.SeqSetNum(1), &imap.FetchOptions{BodySection: []*imap.FetchItemBodySection{section}}).Collect()
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 1 {
+			body = msgs[0].FindBodySection(section)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Do() error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*opts.CommandTimeout {
+		t.Fatalf("Expected the fetch to take well over CommandTimeout, took %v", elapsed)
+	}
+	if string(body) != message {
+		t.Errorf("Expected the whole message, got %d of %d bytes", len(body), len(message))
+	}
+}
+
+func TestClient_KeepaliveReplacesDeadConnection(t *testing.T) {
+	opts := startTestServer(t, imap.CapSet{imap.CapIMAP4rev1: {}})
+	opts.InitialBackoff = time.Millisecond
+	opts.KeepaliveInterval = 50 * time.Millisecond
+	client := connectTestClient(t, opts)
+
+	reconnected := make(chan struct{}, 1)
+	client.SetStateHandler(func(state ConnectionState) {
+		if state == StateAuthenticated {
+			reconnected <- struct{}{}
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.Keepalive(ctx)
+
+	_ = client.Client().Close()
+
+	select {
+	case <-reconnected:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Expected keepalive to reconnect")
+	}
+}
+
+// oauthSession adds the OAuth2 mechanisms to a memory server session,
+// accepting "token" as the access token for "user"
+type oauthSession struct {
+	imapserver.Session
+}
+
+func (s *oauthSession) AuthenticateMechanisms() []string {
+	return []string{oauth.XOAuth2, sasl.OAuthBearer}
+}
+
+func (s *oauthSession) Authenticate(mech string) (sasl.Server, error) {
+	check := func(username, token string) error {
+		if token != "token" {
+			return errors.New("invalid token")
+		}
+		return s.Session.Login(username, "pass")
+	}
+
+	switch mech {
+	case oauth.XOAuth2:
+		return &xoauth2Server{check: check}, nil
+	case sasl.OAuthBearer:
+		return sasl.NewOAuthBearerServer(func(opts sasl.OAuthBearerOptions) *sasl.OAuthBearerError {
+			if check(opts.Username, opts.Token) != nil {
+				return &sasl.OAuthBearerError{Status: "invalid_token"}
+			}
+			return nil
+		}), nil
+	}
+	return nil, errors.New("unsupported mechanism")
+}
+
+// xoauth2Server is the server side of XOAUTH2, which go-sasl lacks
+type xoauth2Server struct {
+	check func(username, token string) error
+}
+
+func (s *xoauth2Server) Next(response []byte) ([]byte, bool, error) {
+	if response == nil {
+		return []byte{}, false, nil
+	}
+	parts := strings.Split(string(response), "\x01")
+	if len(parts) < 2 {
+		return nil, true, errors.New("malformed response")
+	}
+	username := strings.TrimPrefix(parts[0], "user=")
+	token := strings.TrimPrefix(parts[1], "auth=Bearer ")
+	return nil, true, s.check(username, token)
+}
+
+func TestClient_AuthenticateWithOAuth2(t *testing.T) {
+	wrap := func(s imapserver.Session) imapserver.Session { return &oauthSession{Session: s} }
+
+	for _, mech := range []string{AuthXOAuth2, AuthOAuthBearer} {
+		t.Run(mech, func(t *testing.T) {
+			opts := startWrappedTestServer(t, nil, wrap)
+			opts.Password = ""
+			opts.AuthMechanism = mech
+
+			var calls int
+			opts.OAuth2Token = func(ctx context.Context) (string, error) {
+				calls++
+				return "token", nil
+			}
+
+			client := connectTestClient(t, opts)
+			if calls != 1 {
+				t.Errorf("Expected the token to be requested once, got %d", calls)
+			}
+
+			var names []string
+			err := client.Do(context.Background(), "", func(conn *imapclient.Client, _ *imap.SelectData) error {
+				mailboxes, err := conn.List("", "*", nil).Collect()
+				for _, mbox := range mailboxes {
+					names = append(names, mbox.Mailbox)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatalf("Do() error: %v", err)
+			}
+			if len(names) != 1 || names[0] != "INBOX" {
+				t.Errorf("Expected to list INBOX after authenticating, got %v", names)
+			}
+		})
+	}
+}
+
+func TestClient_AuthenticateRejectedToken(t *testing.T) {
+	opts := startWrappedTestServer(t, nil, func(s imapserver.Session) imapserver.Session {
+		return &oauthSession{Session: s}
+	})
+	opts.AuthMechanism = AuthXOAuth2
+	opts.OAuth2Token = func(ctx context.Context) (string, error) { return "expired", nil }
+
+	client := NewClient(opts)
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	defer client.Disconnect()
+
+	var authErr *AuthenticationError
+	if err := client.Authenticate(ctx); !errors.As(err, &authErr) {
+		t.Fatalf("Expected AuthenticationError for a rejected token, got %v", err)
+	}
+}
+
+func TestClient_AuthenticateTokenSourceError(t *testing.T) {
+	opts := startTestServer(t, nil)
+	opts.AuthMechanism = AuthOAuthBearer
+	opts.OAuth2Token = func(ctx context.Context) (string, error) { return "", oauth.ErrNoToken }
+
+	client := NewClient(opts)
+	ctx := context.Background()
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	defer client.Disconnect()
+
+	if err := client.Authenticate(ctx); !errors.Is(err, oauth.ErrNoToken) {
+		t.Fatalf("Expected ErrNoToken to be reported, got %v", err)
+	}
+}
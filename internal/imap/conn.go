@@ -0,0 +1,283 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxLiteral bounds the literals read in answers to wireConn's own
+// commands, which only carry names
+const maxLiteral = 1 << 20
+
+// wireConn is the network connection under an imapclient.Client. It notes
+// when data last arrived, so that a command whose answer is still coming
+// in is not taken for a stuck one. While the client is idle it can also
+// run the few commands imapclient has no method for; STARTTLS is one of
+// them, so that the stream stays readable here after the upgrade.
+type wireConn struct {
+	lastRead atomic.Int64
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	conn net.Conn
+	// reading is set while imapclient waits in Read, held while one of
+	// wireConn's own commands has the connection
+	reading bool
+	held    bool
+	// deadline is the read deadline imapclient asked for
+	deadline time.Time
+	// pending is data read past the answer to wireConn's own command
+	pending []byte
+	tags    int
+}
+
+func newWireConn(conn net.Conn) *wireConn {
+	c := &wireConn{conn: conn}
+	c.cond = sync.NewCond(&c.mu)
+	c.touch()
+	return c
+}
+
+func (c *wireConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		for c.held {
+			c.cond.Wait()
+		}
+		if len(c.pending) > 0 {
+			n := copy(p, c.pending)
+			c.pending = c.pending[n:]
+			return n, nil
+		}
+
+		conn := c.conn
+		c.reading = true
+		c.mu.Unlock()
+		n, err := conn.Read(p)
+		c.mu.Lock()
+		c.reading = false
+		c.cond.Broadcast()
+
+		if n > 0 {
+			c.touch()
+		}
+		// hold cuts a waiting read short with a deadline in the past
+		if n == 0 && c.held && errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *wireConn) Write(p []byte) (int, error) {
+	return c.current().Write(p)
+}
+
+func (c *wireConn) Close() error {
+	return c.current().Close()
+}
+
+func (c *wireConn) LocalAddr() net.Addr {
+	return c.current().LocalAddr()
+}
+
+func (c *wireConn) RemoteAddr() net.Addr {
+	return c.current().RemoteAddr()
+}
+
+func (c *wireConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *wireConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	if c.held {
+		return nil // applied by release
+	}
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *wireConn) SetWriteDeadline(t time.Time) error {
+	return c.current().SetWriteDeadline(t)
+}
+
+func (c *wireConn) current() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *wireConn) touch() {
+	c.lastRead.Store(time.Now().UnixNano())
+}
+
+// lastActivity returns when data last arrived
+func (c *wireConn) lastActivity() time.Time {
+	return time.Unix(0, c.lastRead.Load())
+}
+
+// command runs an IMAP command imapclient has no method for and returns
+// the untagged responses that came with it. imapclient must have no
+// command in flight.
+func (c *wireConn) command(command string) ([][]byte, error) {
+	conn := c.hold()
+	r := bufio.NewReader(heldReader{c, conn})
+	responses, err := c.exchange(conn, r, command)
+	rest, _ := r.Peek(r.Buffered())
+	c.release(nil, rest)
+	return responses, err
+}
+
+// startTLS upgrades the connection with STARTTLS. Anything the server
+// sent in the clear after its answer is dropped.
+func (c *wireConn) startTLS(config *tls.Config) error {
+	conn := c.hold()
+	_, err := c.exchange(conn, bufio.NewReader(heldReader{c, conn}), "STARTTLS")
+
+	var upgraded net.Conn
+	if err == nil {
+		tlsConn := tls.Client(conn, config)
+		if err = tlsConn.Handshake(); err == nil {
+			upgraded = tlsConn
+		}
+	}
+	c.release(upgraded, nil)
+	return err
+}
+
+// hold takes the connection from imapclient, waking a Read it is blocked
+// in, and returns it
+func (c *wireConn) hold() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.held {
+		c.cond.Wait()
+	}
+	c.held = true
+	if c.reading {
+		_ = c.conn.SetReadDeadline(time.Unix(1, 0))
+		for c.reading {
+			c.cond.Wait()
+		}
+	}
+	_ = c.conn.SetReadDeadline(time.Time{})
+	return c.conn
+}
+
+// release hands the connection back to imapclient, replaced by upgraded
+// when that is set, along with the data read past the last answer
+func (c *wireConn) release(upgraded net.Conn, rest []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if upgraded != nil {
+		c.conn = upgraded
+	}
+	c.pending = append(c.pending, rest...)
+	_ = c.conn.SetReadDeadline(c.deadline)
+	c.held = false
+	c.cond.Broadcast()
+}
+
+// exchange sends command on the held conn and reads responses from r up
+// to the tagged one
+func (c *wireConn) exchange(conn net.Conn, r *bufio.Reader, command string) ([][]byte, error) {
+	c.tags++
+	tag := "W" + strconv.Itoa(c.tags)
+	if _, err := io.WriteString(conn, tag+" "+command+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	var untagged [][]byte
+	for {
+		resp, err := readResponse(r)
+		if err != nil {
+			return nil, err
+		}
+		status, ok := bytes.CutPrefix(resp, []byte(tag+" "))
+		if !ok {
+			untagged = append(untagged, resp)
+			continue
+		}
+		if !bytes.HasPrefix(bytes.ToUpper(status), []byte("OK")) {
+			name, _, _ := strings.Cut(command, " ")
+			return nil, fmt.Errorf("%s failed: %s", name, bytes.TrimSpace(status))
+		}
+		return untagged, nil
+	}
+}
+
+// readResponse reads a response line along with the literals it announces
+func readResponse(r *bufio.Reader) ([]byte, error) {
+	var resp []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		resp = append(resp, line...)
+
+		size, ok := literalSize(line)
+		if !ok {
+			return resp, nil
+		}
+		if size > maxLiteral {
+			return nil, fmt.Errorf("literal of %d bytes in response", size)
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(r, literal); err != nil {
+			return nil, err
+		}
+		resp = append(resp, literal...)
+	}
+}
+
+// literalSize returns the size of the literal announced at the end of a
+// response line, as in {5}
+func literalSize(line []byte) (int, bool) {
+	line = bytes.TrimRight(line, "\r\n")
+	open := bytes.LastIndexByte(line, '{')
+	if open < 0 || !bytes.HasSuffix(line, []byte("}")) {
+		return 0, false
+	}
+	size, err := strconv.Atoi(string(line[open+1 : len(line)-1]))
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// heldReader reads from the connection while wireConn holds it, noting
+// progress like Read does
+type heldReader struct {
+	c    *wireConn
+	conn net.Conn
+}
+
+func (r heldReader) Read(p []byte) (int, error) {
+	n, err := r.conn.Read(p)
+	if n > 0 {
+		r.c.touch()
+	}
+	return n, err
+}
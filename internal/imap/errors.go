@@ -38,3 +38,13 @@ func (e *AuthenticationError) Error() string {
 func (e *AuthenticationError) Unwrap() error {
 	return e.Err
 }
+
+// IsConnectionError reports whether err means the server could not be
+// reached or stopped answering, as opposed to rejecting a command
+func IsConnectionError(err error) bool {
+	var connErr *ConnectionError
+	return errors.Is(err, ErrNotConnected) ||
+		errors.Is(err, ErrConnectionLost) ||
+		errors.Is(err, ErrTimeout) ||
+		errors.As(err, &connErr)
+}
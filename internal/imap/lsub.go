@@ -0,0 +1,171 @@
+package imap
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Subscribed returns the names of the subscribed mailboxes, for servers
+// without LIST-EXTENDED that only report them to LSUB. imapclient has no
+// method for LSUB, so the connection under it sends the command.
+func (c *Client) Subscribed(ctx context.Context) ([]string, error) {
+	var names []string
+	err := c.DoIdempotent(ctx, "", func(_ *imapclient.Client, _ *imap.SelectData) error {
+		c.mu.RLock()
+		wire := c.wire
+		c.mu.RUnlock()
+
+		responses, err := wire.command(`LSUB "" "*"`)
+		if err != nil {
+			return err
+		}
+
+		names = names[:0]
+		for _, resp := range responses {
+			name, attrs, ok := parseLSUB(resp)
+			// \Noselect marks a parent that only has subscribed children
+			if ok && !hasAttr(attrs, imap.MailboxAttrNoSelect) {
+				names = append(names, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+	return names, nil
+}
+
+// parseLSUB reads the attributes and the mailbox of an LSUB response such
+// as `* LSUB (\HasNoChildren) "/" Sent`. ok is false for other responses.
+func parseLSUB(resp []byte) (name string, attrs []imap.MailboxAttr, ok bool) {
+	const prefix = "* LSUB ("
+	if len(resp) < len(prefix) || !strings.EqualFold(string(resp[:len(prefix)]), prefix) {
+		return "", nil, false
+	}
+	rest := resp[len(prefix):]
+
+	end := bytes.IndexByte(rest, ')')
+	if end < 0 {
+		return "", nil, false
+	}
+	for _, attr := range strings.Fields(string(rest[:end])) {
+		attrs = append(attrs, imap.MailboxAttr(attr))
+	}
+
+	rest, ok = bytes.CutPrefix(rest[end+1:], []byte(" "))
+	if !ok {
+		return "", nil, false
+	}
+	if _, rest, ok = readAString(rest); !ok { // hierarchy delimiter
+		return "", nil, false
+	}
+	if rest, ok = bytes.CutPrefix(rest, []byte(" ")); !ok {
+		return "", nil, false
+	}
+	raw, _, ok := readAString(rest)
+	if !ok {
+		return "", nil, false
+	}
+
+	if strings.EqualFold(string(raw), "INBOX") {
+		return "INBOX", attrs, true
+	}
+	name, err := decodeMailbox(string(raw))
+	if err != nil {
+		return "", nil, false
+	}
+	return name, attrs, true
+}
+
+// readAString reads a quoted string, a literal or an atom off the start
+// of b
+func readAString(b []byte) (value, rest []byte, ok bool) {
+	switch {
+	case len(b) == 0:
+		return nil, nil, false
+	case b[0] == '"':
+		for i := 1; i < len(b); i++ {
+			switch b[i] {
+			case '\\':
+				i++
+				if i < len(b) {
+					value = append(value, b[i])
+				}
+			case '"':
+				return value, b[i+1:], true
+			default:
+				value = append(value, b[i])
+			}
+		}
+		return nil, nil, false
+	case b[0] == '{':
+		header, data, found := bytes.Cut(b, []byte("}\r\n"))
+		if !found {
+			return nil, nil, false
+		}
+		size, err := strconv.Atoi(string(header[1:]))
+		if err != nil || size < 0 || size > len(data) {
+			return nil, nil, false
+		}
+		return data[:size], data[size:], true
+	default:
+		end := bytes.IndexAny(b, " \r\n")
+		if end < 0 {
+			end = len(b)
+		}
+		return b[:end], b[end:], end > 0
+	}
+}
+
+// decodeMailbox decodes a mailbox name from the modified UTF-7 of RFC 3501
+func decodeMailbox(name string) (string, error) {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(name, '&')
+		if start < 0 {
+			sb.WriteString(name)
+			return sb.String(), nil
+		}
+		sb.WriteString(name[:start])
+		name = name[start+1:]
+
+		end := strings.IndexByte(name, '-')
+		if end < 0 {
+			return "", errors.New("unterminated modified UTF-7 in mailbox name")
+		}
+		if end == 0 {
+			sb.WriteByte('&')
+		} else {
+			b, err := base64.RawStdEncoding.DecodeString(strings.ReplaceAll(name[:end], ",", "/"))
+			if err != nil || len(b)%2 != 0 {
+				return "", fmt.Errorf("invalid modified UTF-7 in mailbox name: %q", name[:end])
+			}
+			units := make([]uint16, len(b)/2)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(b[2*i:])
+			}
+			sb.WriteString(string(utf16.Decode(units)))
+		}
+		name = name[end+1:]
+	}
+}
+
+func hasAttr(attrs []imap.MailboxAttr, attr imap.MailboxAttr) bool {
+	for _, a := range attrs {
+		if strings.EqualFold(string(a), string(attr)) {
+			return true
+		}
+	}
+	return false
+}
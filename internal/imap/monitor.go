@@ -0,0 +1,198 @@
+package imap
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// MonitorMailbox watches mailbox until ctx is cancelled and reports changes
+// to the update handler. It runs on a dedicated connection so the mailbox
+// selected on the main connection is left alone. Servers that support IDLE
+// push changes as they happen; on other servers the mailbox is polled with
+// NOOP every interval. Lost connections are re-established with backoff.
+func (c *Client) MonitorMailbox(ctx context.Context, mailbox string, interval time.Duration) {
+	go func() {
+		attempt := 0
+		for {
+			established, _ := c.monitor(ctx, mailbox, interval)
+			if ctx.Err() != nil {
+				return
+			}
+			if established {
+				attempt = 0
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(c.calculateBackoff(attempt)):
+				attempt++
+			}
+		}
+	}()
+}
+
+// monitor runs a single monitoring session. established reports whether the
+// mailbox was selected before the session ended.
+func (c *Client) monitor(ctx context.Context, mailbox string, interval time.Duration) (established bool, err error) {
+	watcher := &mailboxWatcher{client: c, mailbox: mailbox}
+
+	conn, wire, err := c.dial(watcher.handler())
+	if err != nil {
+		return false, &ConnectionError{Op: "dial", Err: err}
+	}
+	defer conn.Close()
+
+	// Closing the connection unblocks whatever command is in flight
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	auth, err := c.saslClient(ctx)
+	if err != nil {
+		return false, &AuthenticationError{Username: c.opts.Username, Err: err}
+	}
+
+	err = c.withDeadline(wire, func() error {
+		if err := c.login(conn, auth); err != nil {
+			return &AuthenticationError{Username: c.opts.Username, Err: err}
+		}
+
+		data, err := conn.Select(mailbox, &imap.SelectOptions{ReadOnly: true}).Wait()
+		if err != nil {
+			return err
+		}
+		watcher.start(data.NumMessages)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if conn.Caps().Has(imap.CapIdle) {
+		return true, c.idle(ctx, conn, wire)
+	}
+	return true, c.poll(ctx, conn, wire, interval)
+}
+
+// idle keeps the connection in IDLE until ctx is cancelled. IDLE is renewed
+// every KeepaliveInterval; waiting for the server to confirm the end of
+// each IDLE proves the connection is still alive.
+func (c *Client) idle(ctx context.Context, conn *imapclient.Client, wire *wireConn) error {
+	for {
+		cmd, err := conn.Idle()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-conn.Closed():
+			return ErrConnectionLost
+		case <-time.After(c.opts.KeepaliveInterval):
+		}
+
+		err = c.withDeadline(wire, func() error {
+			if err := cmd.Close(); err != nil {
+				return err
+			}
+			return cmd.Wait()
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// poll asks the server for pending changes every interval
+func (c *Client) poll(ctx context.Context, conn *imapclient.Client, wire *wireConn, interval time.Duration) error {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			err := c.withDeadline(wire, func() error {
+				return conn.Noop().Wait()
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// mailboxWatcher turns unsolicited server responses into UpdateHandler
+// callbacks
+type mailboxWatcher struct {
+	client  *Client
+	mailbox string
+
+	mu      sync.Mutex
+	started bool
+	count   uint32
+}
+
+// start records the message count reported by SELECT; responses before
+// that belong to the SELECT itself
+func (w *mailboxWatcher) start(count uint32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.started = true
+	w.count = count
+}
+
+func (w *mailboxWatcher) handler() *imapclient.UnilateralDataHandler {
+	return &imapclient.UnilateralDataHandler{
+		Mailbox: func(data *imapclient.UnilateralDataMailbox) {
+			if data.NumMessages == nil {
+				return
+			}
+
+			w.mu.Lock()
+			grew := w.started && *data.NumMessages > w.count
+			w.count = *data.NumMessages
+			w.mu.Unlock()
+
+			if h := w.client.GetUpdateHandler(); grew && h != nil && h.OnNewMail != nil {
+				h.OnNewMail(w.mailbox, *data.NumMessages)
+			}
+		},
+		Expunge: func(seqNum uint32) {
+			w.mu.Lock()
+			if w.count > 0 {
+				w.count--
+			}
+			w.mu.Unlock()
+
+			if h := w.client.GetUpdateHandler(); h != nil && h.OnExpunge != nil {
+				h.OnExpunge(w.mailbox, seqNum)
+			}
+		},
+		Fetch: func(msg *imapclient.FetchMessageData) {
+			// The message data has to be consumed even when nobody is listening
+			buf, err := msg.Collect()
+			if err != nil || buf.Flags == nil {
+				return
+			}
+
+			flags := make([]string, len(buf.Flags))
+			for i, flag := range buf.Flags {
+				flags[i] = string(flag)
+			}
+
+			if h := w.client.GetUpdateHandler(); h != nil && h.OnFlagsChanged != nil {
+				h.OnFlagsChanged(w.mailbox, buf.SeqNum, uint32(buf.UID), flags)
+			}
+		},
+	}
+}
@@ -0,0 +1,232 @@
+package imap
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+)
+
+const testMessage = "From: bob@example.com\r\nTo: user@example.com\r\nSubject: Hello\r\n\r\nHi\r\n"
+
+func startTestServer(t *testing.T, caps imap.CapSet) *Options {
+	t.Helper()
+	return startWrappedTestServer(t, caps, nil)
+}
+
+// startWrappedTestServer is startTestServer with every session passed
+// through wrap, so tests can change how the server authenticates
+func startWrappedTestServer(t *testing.T, caps imap.CapSet, wrap func(imapserver.Session) imapserver.Session) *Options {
+	t.Helper()
+	return serveTestServer(t, listenTest(t), caps, wrap, nil)
+}
+
+func listenTest(t *testing.T) net.Listener {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	return ln
+}
+
+// serveTestServer runs the test server on ln. It offers STARTTLS when
+// tlsConfig is set.
+func serveTestServer(t *testing.T, ln net.Listener, caps imap.CapSet, wrap func(imapserver.Session) imapserver.Session, tlsConfig *tls.Config) *Options {
+	t.Helper()
+
+	memServer := imapmemserver.New()
+	user := imapmemserver.NewUser("user", "pass")
+	if err := user.Create("INBOX", nil); err != nil {
+		t.Fatalf("Create(INBOX) error: %v", err)
+	}
+	memServer.AddUser(user)
+
+	server := imapserver.New(&imapserver.Options{
+		NewSession: func(conn *imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			var session imapserver.Session = memServer.NewSession()
+			if wrap != nil {
+				session = wrap(session)
+			}
+			return session, nil, nil
+		},
+		Caps:         caps,
+		TLSConfig:    tlsConfig,
+		InsecureAuth: true,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		_ = server.Serve(ln)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+		<-done
+	})
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return &Options{
+		Host:     "127.0.0.1",
+		Port:     addr.Port,
+		Username: "user",
+		Password: "pass",
+	}
+}
+
+// dialOther opens a second session acting as another mail client
+func dialOther(t *testing.T, opts *Options) *imapclient.Client {
+	t.Helper()
+
+	other, err := imapclient.DialInsecure(net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port)), nil)
+	if err != nil {
+		t.Fatalf("Dial() error: %v", err)
+	}
+	t.Cleanup(func() { _ = other.Close() })
+
+	if err := other.Login(opts.Username, opts.Password).Wait(); err != nil {
+		t.Fatalf("Login() error: %v", err)
+	}
+	if _, err := other.Select("INBOX", nil).Wait(); err != nil {
+		t.Fatalf("Select() error: %v", err)
+	}
+	return other
+}
+
+func appendTestMessage(t *testing.T, c *imapclient.Client) {
+	t.Helper()
+
+	cmd := c.Append("INBOX", int64(len(testMessage)), nil)
+	if _, err := cmd.Write([]byte(testMessage)); err != nil {
+		t.Fatalf("Append.Write() error: %v", err)
+	}
+	if err := cmd.Close(); err != nil {
+		t.Fatalf("Append.Close() error: %v", err)
+	}
+	if _, err := cmd.Wait(); err != nil {
+		t.Fatalf("Append.Wait() error: %v", err)
+	}
+}
+
+type monitorEvents struct {
+	newMail chan uint32
+	expunge chan uint32
+	flags   chan []string
+}
+
+func watch(t *testing.T, opts *Options, interval time.Duration) *monitorEvents {
+	t.Helper()
+
+	events := &monitorEvents{
+		newMail: make(chan uint32, 10),
+		expunge: make(chan uint32, 10),
+		flags:   make(chan []string, 10),
+	}
+
+	client := NewClient(opts)
+	client.SetUpdateHandler(&UpdateHandler{
+		Mailbox:        "INBOX",
+		OnNewMail:      func(_ string, count uint32) { events.newMail <- count },
+		OnExpunge:      func(_ string, seqNum uint32) { events.expunge <- seqNum },
+		OnFlagsChanged: func(_ string, _, _ uint32, flags []string) { events.flags <- flags },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	client.MonitorMailbox(ctx, "INBOX", interval)
+
+	return events
+}
+
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+func TestMonitorMailbox_IdleReportsChanges(t *testing.T) {
+	opts := startTestServer(t, imap.CapSet{imap.CapIMAP4rev1: {}, imap.CapIMAP4rev2: {}})
+
+	other := dialOther(t, opts)
+	appendTestMessage(t, other)
+
+	// A poll interval this long means only IDLE can deliver in time
+	events := watch(t, opts, time.Hour)
+	time.Sleep(300 * time.Millisecond)
+
+	appendTestMessage(t, other)
+	if count := receive(t, events.newMail, "new mail"); count != 2 {
+		t.Errorf("Expected message count 2, got %d", count)
+	}
+
+	if err := other.Store(imap.SeqSetNum(1), &imap.StoreFlags{
+		Op:    imap.StoreFlagsAdd,
+		Flags: []imap.Flag{imap.FlagSeen},
+	}, nil).Close(); err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+	flags := receive(t, events.flags, "flag change")
+	if len(flags) != 1 || flags[0] != string(imap.FlagSeen) {
+		t.Errorf("Expected flags [\\Seen], got %v", flags)
+	}
+
+	if err := other.Store(imap.SeqSetNum(1), &imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Silent: true,
+		Flags:  []imap.Flag{imap.FlagDeleted},
+	}, nil).Close(); err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+	// The \Deleted flag change arrives before the expunge
+	receive(t, events.flags, "flag change")
+	if err := other.Expunge().Close(); err != nil {
+		t.Fatalf("Expunge() error: %v", err)
+	}
+	if seqNum := receive(t, events.expunge, "expunge"); seqNum != 1 {
+		t.Errorf("Expected expunge of message 1, got %d", seqNum)
+	}
+}
+
+func TestMonitorMailbox_PollsWithoutIdle(t *testing.T) {
+	opts := startTestServer(t, imap.CapSet{imap.CapIMAP4rev1: {}})
+
+	events := watch(t, opts, 50*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+
+	appendTestMessage(t, dialOther(t, opts))
+	if count := receive(t, events.newMail, "new mail"); count != 1 {
+		t.Errorf("Expected message count 1, got %d", count)
+	}
+}
+
+func connectTestClient(t *testing.T, opts *Options) *Client {
+	t.Helper()
+
+	client := NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	if err := client.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect() })
+	return client
+}
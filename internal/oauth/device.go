@@ -0,0 +1,89 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DeviceCode is the response to a device authorization request (RFC 8628)
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	// Google names the field verification_url
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	// Message is a ready-made instruction some providers include
+	Message string `json:"message"`
+}
+
+// DeviceAuth starts the device authorization grant. The user then visits
+// the verification URI and enters the user code while DeviceToken waits.
+func (c *Config) DeviceAuth(ctx context.Context) (*DeviceCode, error) {
+	if c.Endpoint.DeviceAuthURL == "" {
+		return nil, fmt.Errorf("oauth2: no device authorization endpoint configured")
+	}
+
+	form := url.Values{
+		"client_id": {c.ClientID},
+		"scope":     {strings.Join(c.Scopes, " ")},
+	}
+
+	var code DeviceCode
+	if err := c.post(ctx, c.Endpoint.DeviceAuthURL, form, &code); err != nil {
+		return nil, err
+	}
+	if code.VerificationURI == "" {
+		code.VerificationURI = code.VerificationURL
+	}
+	if code.DeviceCode == "" || code.VerificationURI == "" {
+		return nil, fmt.Errorf("oauth2: incomplete device authorization response")
+	}
+	return &code, nil
+}
+
+// DeviceToken polls the token endpoint until the user has approved the
+// request, it was denied, or the device code expired
+func (c *Config) DeviceToken(ctx context.Context, code *DeviceCode) (*Token, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if code.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(code.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("oauth2: device authorization not completed: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+
+		token, err := c.requestToken(ctx, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {code.DeviceCode},
+		})
+
+		var oauthErr *Error
+		switch {
+		case err == nil:
+			return token, nil
+		case errors.As(err, &oauthErr) && oauthErr.Code == "authorization_pending":
+			continue
+		case errors.As(err, &oauthErr) && oauthErr.Code == "slow_down":
+			interval += 5 * time.Second
+			continue
+		default:
+			return nil, err
+		}
+	}
+}
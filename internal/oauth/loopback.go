@@ -0,0 +1,121 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// loopbackResult is what the redirect handler received
+type loopbackResult struct {
+	code string
+	err  error
+}
+
+// LoopbackAuth runs the authorization code grant with PKCE. It listens on
+// a random port on 127.0.0.1, passes the authorization URL to open, and
+// exchanges the code the browser is redirected back with.
+func (c *Config) LoopbackAuth(ctx context.Context, open func(authURL string) error) (*Token, error) {
+	if c.Endpoint.AuthURL == "" {
+		return nil, fmt.Errorf("oauth2: no authorization endpoint configured")
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("oauth2: failed to listen for redirect: %w", err)
+	}
+	redirectURI := "http://" + ln.Addr().String() + "/"
+
+	results := make(chan loopbackResult, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := readRedirect(r.URL.Query(), state)
+		if result.err != nil {
+			http.Error(w, "Sign-in failed: "+result.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Signed in to budge. You can close this window.")
+		}
+		select {
+		case results <- result:
+		default:
+		}
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(c.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+		"access_type":           {"offline"},
+	}
+	authURL := c.Endpoint.AuthURL
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + params.Encode()
+	} else {
+		authURL += "?" + params.Encode()
+	}
+	if err := open(authURL); err != nil {
+		return nil, err
+	}
+
+	var result loopbackResult
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("oauth2: authorization not completed: %w", ctx.Err())
+	case result = <-results:
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	return c.requestToken(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {result.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+}
+
+// readRedirect extracts the authorization code from the redirect query
+func readRedirect(query url.Values, state string) loopbackResult {
+	if code := query.Get("error"); code != "" {
+		return loopbackResult{err: &Error{Code: code, Description: query.Get("error_description")}}
+	}
+	if query.Get("state") != state {
+		return loopbackResult{err: errors.New("oauth2: redirect state does not match")}
+	}
+	if query.Get("code") == "" {
+		return loopbackResult{err: errors.New("oauth2: redirect carried no authorization code")}
+	}
+	return loopbackResult{code: query.Get("code")}
+}
+
+// randomString returns a URL-safe random value for state and PKCE
+func randomString() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("oauth2: failed to generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
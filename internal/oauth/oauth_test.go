@@ -0,0 +1,342 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testProvider is a local stand-in for an authorization server
+type testProvider struct {
+	mu       sync.Mutex
+	requests []url.Values
+	// token answers a token request with a status and JSON body
+	token func(form url.Values) (int, any)
+	// challenge is the PKCE challenge of the last authorization request
+	challenge string
+}
+
+func startTestProvider(t *testing.T) (*testProvider, *Config) {
+	t.Helper()
+
+	p := &testProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		p.record(r.PostForm)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"device_code":      "device-code",
+			"user_code":        "ABCD-EFGH",
+			"verification_url": "https://example.com/device",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		p.record(r.PostForm)
+		p.mu.Lock()
+		token := p.token
+		p.mu.Unlock()
+		status, body := token(r.PostForm)
+		writeJSON(w, status, body)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return p, &Config{
+		ClientID: "client",
+		Scopes:   []string{"mail"},
+		Endpoint: Endpoint{
+			AuthURL:       srv.URL + "/authorize",
+			TokenURL:      srv.URL + "/token",
+			DeviceAuthURL: srv.URL + "/device",
+		},
+	}
+}
+
+func (p *testProvider) record(form url.Values) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, form)
+}
+
+func (p *testProvider) tokenRequests() []url.Values {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var forms []url.Values
+	for _, form := range p.requests {
+		if form.Get("grant_type") != "" {
+			forms = append(forms, form)
+		}
+	}
+	return forms
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func TestDeviceFlow(t *testing.T) {
+	p, config := startTestProvider(t)
+
+	var polls int
+	p.token = func(form url.Values) (int, any) {
+		polls++
+		if polls == 1 {
+			return http.StatusBadRequest, map[string]string{"error": "authorization_pending"}
+		}
+		return http.StatusOK, map[string]any{
+			"access_token":  "access",
+			"refresh_token": "refresh",
+			"expires_in":    3600,
+		}
+	}
+
+	ctx := context.Background()
+	code, err := config.DeviceAuth(ctx)
+	if err != nil {
+		t.Fatalf("DeviceAuth() error: %v", err)
+	}
+	if code.UserCode != "ABCD-EFGH" {
+		t.Errorf("Expected user code 'ABCD-EFGH', got '%s'", code.UserCode)
+	}
+	if code.VerificationURI != "https://example.com/device" {
+		t.Errorf("Expected verification_url to be used as the URI, got '%s'", code.VerificationURI)
+	}
+
+	token, err := config.DeviceToken(ctx, code)
+	if err != nil {
+		t.Fatalf("DeviceToken() error: %v", err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Errorf("Expected access and refresh tokens, got %+v", token)
+	}
+	if !token.Valid() {
+		t.Errorf("Expected a fresh token to be valid, expiry %v", token.Expiry)
+	}
+
+	forms := p.tokenRequests()
+	if len(forms) != 2 {
+		t.Fatalf("Expected to poll twice, got %d requests", len(forms))
+	}
+	if forms[1].Get("device_code") != "device-code" || forms[1].Get("client_id") != "client" {
+		t.Errorf("Expected device code and client id in the token request, got %v", forms[1])
+	}
+}
+
+func TestDeviceFlow_Denied(t *testing.T) {
+	p, config := startTestProvider(t)
+	p.token = func(form url.Values) (int, any) {
+		return http.StatusBadRequest, map[string]string{"error": "access_denied", "error_description": "user declined"}
+	}
+
+	ctx := context.Background()
+	code, err := config.DeviceAuth(ctx)
+	if err != nil {
+		t.Fatalf("DeviceAuth() error: %v", err)
+	}
+
+	_, err = config.DeviceToken(ctx, code)
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "access_denied" {
+		t.Fatalf("Expected access_denied error, got %v", err)
+	}
+}
+
+func TestLoopbackAuth(t *testing.T) {
+	p, config := startTestProvider(t)
+	p.token = func(form url.Values) (int, any) {
+		sum := sha256.Sum256([]byte(form.Get("code_verifier")))
+		if form.Get("code") != "auth-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge {
+			return http.StatusBadRequest, map[string]string{"error": "invalid_grant"}
+		}
+		return http.StatusOK, map[string]any{"access_token": "access", "refresh_token": "refresh"}
+	}
+
+	// Play the browser: follow the authorization URL straight back to the
+	// redirect URI with a code
+	open := func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		query := u.Query()
+		p.challenge = query.Get("code_challenge")
+
+		redirect, err := url.Parse(query.Get("redirect_uri"))
+		if err != nil {
+			return err
+		}
+		redirect.RawQuery = url.Values{"code": {"auth-code"}, "state": {query.Get("state")}}.Encode()
+
+		go func() {
+			resp, err := http.Get(redirect.String())
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := config.LoopbackAuth(ctx, open)
+	if err != nil {
+		t.Fatalf("LoopbackAuth() error: %v", err)
+	}
+	if token.AccessToken != "access" {
+		t.Errorf("Expected access token 'access', got '%s'", token.AccessToken)
+	}
+}
+
+func TestLoopbackAuth_StateMismatch(t *testing.T) {
+	_, config := startTestProvider(t)
+
+	open := func(authURL string) error {
+		u, _ := url.Parse(authURL)
+		redirect := u.Query().Get("redirect_uri") + "?code=auth-code&state=forged"
+		go func() {
+			resp, err := http.Get(redirect)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := config.LoopbackAuth(ctx, open); err == nil {
+		t.Fatal("Expected a redirect with the wrong state to be rejected")
+	}
+}
+
+func TestTokenSource_RefreshesAndStores(t *testing.T) {
+	p, config := startTestProvider(t)
+	p.token = func(form url.Values) (int, any) {
+		if form.Get("refresh_token") != "refresh" {
+			return http.StatusBadRequest, map[string]string{"error": "invalid_grant"}
+		}
+		return http.StatusOK, map[string]any{"access_token": "renewed", "expires_in": 3600}
+	}
+
+	store := NewStore(filepath.Join(t.TempDir(), "tokens", "user.json"))
+	if err := store.Save(&Token{AccessToken: "stale", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	source := NewTokenSource(config, store)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		token, err := source.AccessToken(ctx)
+		if err != nil {
+			t.Fatalf("AccessToken() error: %v", err)
+		}
+		if token != "renewed" {
+			t.Errorf("Expected refreshed token 'renewed', got '%s'", token)
+		}
+	}
+	if n := len(p.tokenRequests()); n != 1 {
+		t.Errorf("Expected a single refresh, got %d", n)
+	}
+
+	stored, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if stored.AccessToken != "renewed" || stored.RefreshToken != "refresh" {
+		t.Errorf("Expected the renewed token to be stored with the old refresh token, got %+v", stored)
+	}
+}
+
+func TestTokenSource_RefreshRejected(t *testing.T) {
+	p, config := startTestProvider(t)
+	p.token = func(form url.Values) (int, any) {
+		return http.StatusBadRequest, map[string]string{"error": "invalid_grant"}
+	}
+
+	store := NewStore(filepath.Join(t.TempDir(), "user.json"))
+	if err := store.Save(&Token{AccessToken: "stale", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	_, err := NewTokenSource(config, store).AccessToken(context.Background())
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Fatalf("Expected invalid_grant error, got %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "tokens", "user.json"))
+
+	if _, err := store.Load(); !errors.Is(err, ErrNoToken) {
+		t.Fatalf("Expected ErrNoToken before anything is stored, got %v", err)
+	}
+
+	if err := store.Save(&Token{AccessToken: "access", RefreshToken: "refresh"}); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+
+	info, err := os.Stat(store.Path())
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected token file mode 0600, got %o", perm)
+	}
+
+	token, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if token.RefreshToken != "refresh" {
+		t.Errorf("Expected refresh token to round-trip, got %+v", token)
+	}
+}
+
+func TestXOAuth2Client(t *testing.T) {
+	mech, ir, err := NewXOAuth2Client("user@example.com", "token").Start()
+	if err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	if mech != "XOAUTH2" {
+		t.Errorf("Expected mechanism XOAUTH2, got %s", mech)
+	}
+	if want := "user=user@example.com\x01auth=Bearer token\x01\x01"; string(ir) != want {
+		t.Errorf("Expected initial response %q, got %q", want, ir)
+	}
+}
+
+func TestProvider(t *testing.T) {
+	endpoint, scopes, ok := Provider("microsoft", "contoso.onmicrosoft.com")
+	if !ok {
+		t.Fatal("Expected microsoft to be a known provider")
+	}
+	if endpoint.TokenURL != "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/token" {
+		t.Errorf("Expected tenant in token URL, got %s", endpoint.TokenURL)
+	}
+	if len(scopes) == 0 {
+		t.Error("Expected default scopes")
+	}
+
+	if _, _, ok := Provider("example", ""); ok {
+		t.Error("Expected unknown provider to be reported")
+	}
+}
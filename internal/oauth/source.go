@@ -0,0 +1,54 @@
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// TokenSource hands out access tokens, refreshing them when they expire
+// and storing the renewed token so the next start doesn't refresh again
+type TokenSource struct {
+	config *Config
+	store  *Store
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewTokenSource returns a source for the token kept in store
+func NewTokenSource(config *Config, store *Store) *TokenSource {
+	return &TokenSource{config: config, store: store}
+}
+
+// AccessToken returns a currently valid access token
+func (s *TokenSource) AccessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil {
+		token, err := s.store.Load()
+		if err != nil {
+			return "", err
+		}
+		s.token = token
+	}
+
+	if s.token.Valid() {
+		return s.token.AccessToken, nil
+	}
+	if s.token.RefreshToken == "" {
+		return "", fmt.Errorf("OAuth2 token expired and cannot be refreshed, run budge auth again")
+	}
+
+	token, err := s.config.Refresh(ctx, s.token.RefreshToken)
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh OAuth2 token: %w", err)
+	}
+	if err := s.store.Save(token); err != nil {
+		return "", err
+	}
+
+	s.token = token
+	return token.AccessToken, nil
+}
@@ -0,0 +1,73 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chhlga/budge/internal/fsutil"
+)
+
+// Store keeps a token in a JSON file only the user can read
+type Store struct {
+	path string
+}
+
+// NewStore returns a store backed by the file at path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// DefaultStorePath returns where the token for username is kept,
+// $XDG_DATA_HOME/budge/tokens or ~/.local/share/budge/tokens
+func DefaultStorePath(username string) (string, error) {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, username) + ".json"
+
+	return fsutil.DataDir("tokens", name)
+}
+
+// Path returns the file the token is stored in
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Load reads the stored token, returning ErrNoToken when there is none
+func (s *Store) Load() (*Token, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token: %w", err)
+	}
+
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse token file %s: %w", s.path, err)
+	}
+	if token.AccessToken == "" && token.RefreshToken == "" {
+		return nil, ErrNoToken
+	}
+	return &token, nil
+}
+
+// Save replaces the stored token through a temporary file so a crash never
+// leaves a truncated token behind
+func (s *Store) Save(token *Token) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	if err := fsutil.WriteFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write token: %w", err)
+	}
+	return nil
+}
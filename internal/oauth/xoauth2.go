@@ -0,0 +1,29 @@
+package oauth
+
+import "github.com/emersion/go-sasl"
+
+// XOAuth2 is the SASL mechanism Google and Microsoft accept alongside the
+// standard OAUTHBEARER
+const XOAuth2 = "XOAUTH2"
+
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+// NewXOAuth2Client returns a SASL client authenticating username with an
+// OAuth2 access token
+func NewXOAuth2Client(username, token string) sasl.Client {
+	return &xoauth2Client{username: username, token: token}
+}
+
+func (c *xoauth2Client) Start() (string, []byte, error) {
+	ir := "user=" + c.username + "\x01auth=Bearer " + c.token + "\x01\x01"
+	return XOAuth2, []byte(ir), nil
+}
+
+// Next answers the JSON error challenge a server sends after rejecting the
+// token with an empty response, after which the server fails the command
+func (c *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	return []byte{}, nil
+}
@@ -0,0 +1,301 @@
+package outbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chhlga/budge/internal/fsutil"
+)
+
+var ErrNotFound = errors.New("message not found in outbox")
+
+// ErrUnreadable is reported by List and Due for entries that could not be
+// read. They are moved aside as <id>.bad, so each is reported once, and
+// the other entries are returned along with the error.
+var ErrUnreadable = errors.New("unreadable outbox entry")
+
+// Entry is a message waiting to be submitted
+type Entry struct {
+	ID          string    `json:"id"`
+	From        string    `json:"from"`
+	Recipients  []string  `json:"recipients"`
+	Subject     string    `json:"subject"`
+	Raw         []byte    `json:"raw"`
+	QueuedAt    time.Time `json:"queued_at"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	// Held entries failed permanently and wait for the user to edit or
+	// cancel them instead of being retried
+	Held bool `json:"held,omitempty"`
+}
+
+// Outbox is a persistent queue of outgoing messages. Every entry is kept
+// in its own file so a crash never loses more than the message being
+// written.
+type Outbox struct {
+	mu   sync.Mutex
+	dir  string
+	opts *Options
+}
+
+type Options struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Open returns the outbox stored in dir, creating the directory if needed
+func Open(dir string, opts *Options) (*Outbox, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.InitialBackoff == 0 {
+		opts.InitialBackoff = 30 * time.Second
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = 30 * time.Minute
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+
+	return &Outbox{dir: dir, opts: opts}, nil
+}
+
+// DefaultDir returns $XDG_DATA_HOME/budge/outbox, falling back to
+// ~/.local/share/budge/outbox
+func DefaultDir() (string, error) {
+	return fsutil.DataDir("outbox")
+}
+
+// Add queues a message for immediate delivery
+func (o *Outbox) Add(from string, recipients []string, subject string, raw []byte) (Entry, error) {
+	return o.Schedule(from, recipients, subject, raw, time.Now())
+}
+
+// Schedule queues a message that becomes due at the given time
+func (o *Outbox) Schedule(from string, recipients []string, subject string, raw []byte, at time.Time) (Entry, error) {
+	id, err := newID()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{
+		ID:          id,
+		From:        from,
+		Recipients:  recipients,
+		Subject:     subject,
+		Raw:         raw,
+		QueuedAt:    time.Now(),
+		NextAttempt: at,
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.write(entry); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// List returns every queued entry, oldest first. Entries that cannot be
+// read are set aside and reported with ErrUnreadable.
+func (o *Outbox) List() ([]Entry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.list()
+}
+
+// Len returns the number of queued entries
+func (o *Outbox) Len() int {
+	entries, _ := o.List()
+	return len(entries)
+}
+
+// Get returns a single entry
+func (o *Outbox) Get(id string) (Entry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.read(id)
+}
+
+// Due returns the entries whose next attempt is at or before now, with
+// the entries set aside reported like List does
+func (o *Outbox) Due(now time.Time) ([]Entry, error) {
+	entries, err := o.List()
+	if err != nil && !errors.Is(err, ErrUnreadable) {
+		return nil, err
+	}
+
+	due := entries[:0]
+	for _, entry := range entries {
+		if !entry.Held && !entry.NextAttempt.After(now) {
+			due = append(due, entry)
+		}
+	}
+	return due, err
+}
+
+// Remove drops an entry, either because it was sent or cancelled
+func (o *Outbox) Remove(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := os.Remove(o.path(id)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("failed to remove outbox entry: %w", err)
+	}
+	return nil
+}
+
+// Defer records a failed attempt and schedules the next one with
+// exponential backoff
+func (o *Outbox) Defer(id string, cause error) (Entry, error) {
+	return o.update(id, func(entry *Entry) {
+		entry.NextAttempt = time.Now().Add(o.calculateBackoff(entry.Attempts))
+		entry.Attempts++
+		entry.LastError = cause.Error()
+	})
+}
+
+// Hold records a permanent failure; the entry stays queued but is not
+// retried until Retry is called
+func (o *Outbox) Hold(id string, cause error) (Entry, error) {
+	return o.update(id, func(entry *Entry) {
+		entry.Attempts++
+		entry.LastError = cause.Error()
+		entry.Held = true
+	})
+}
+
+// Retry makes an entry due immediately
+func (o *Outbox) Retry(id string) (Entry, error) {
+	return o.update(id, func(entry *Entry) {
+		entry.NextAttempt = time.Now()
+		entry.Held = false
+	})
+}
+
+func (o *Outbox) update(id string, fn func(*Entry)) (Entry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry, err := o.read(id)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	fn(&entry)
+
+	if err := o.write(entry); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+func (o *Outbox) calculateBackoff(attempt int) time.Duration {
+	backoff := float64(o.opts.InitialBackoff) * math.Pow(2, float64(attempt))
+	if backoff > float64(o.opts.MaxBackoff) {
+		return o.opts.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+func (o *Outbox) list() ([]Entry, error) {
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(files))
+	var unreadable []error
+	for _, file := range files {
+		id, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok || file.IsDir() {
+			continue
+		}
+		entry, err := o.read(id)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			// Removed since the directory was read
+		case err != nil:
+			unreadable = append(unreadable, o.setAside(id, err))
+		default:
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].QueuedAt.Before(entries[j].QueuedAt)
+	})
+	return entries, errors.Join(unreadable...)
+}
+
+// setAside moves an entry that could not be read out of the queue, so
+// the others are still sent, and describes what happened to it
+func (o *Outbox) setAside(id string, cause error) error {
+	bad := id + ".bad"
+	if err := os.Rename(o.path(id), filepath.Join(o.dir, bad)); err != nil {
+		return fmt.Errorf("%w %s: %v", ErrUnreadable, id, cause)
+	}
+	return fmt.Errorf("%w, moved to %s: %v", ErrUnreadable, bad, cause)
+}
+
+func (o *Outbox) read(id string) (Entry, error) {
+	data, err := os.ReadFile(o.path(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Entry{}, ErrNotFound
+		}
+		return Entry{}, fmt.Errorf("failed to read outbox entry: %w", err)
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return Entry{}, fmt.Errorf("corrupt outbox entry %s: %w", id, err)
+	}
+	return entry, nil
+}
+
+// write stores an entry through a temporary file so readers never see a
+// half-written message
+func (o *Outbox) write(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox entry: %w", err)
+	}
+
+	if err := fsutil.WriteFileAtomic(o.path(entry.ID), data); err != nil {
+		return fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+	return nil
+}
+
+func (o *Outbox) path(id string) string {
+	return filepath.Join(o.dir, id+".json")
+}
+
+// newID returns a sortable, collision-resistant entry name
+func newID() (string, error) {
+	var suffix [4]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", fmt.Errorf("failed to generate outbox id: %w", err)
+	}
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(suffix[:])), nil
+}
@@ -0,0 +1,189 @@
+package outbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestOutbox(t *testing.T) *Outbox {
+	t.Helper()
+
+	o, err := Open(t.TempDir(), &Options{InitialBackoff: time.Minute, MaxBackoff: 5 * time.Minute})
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	return o
+}
+
+func TestOutbox_AddPersistsAcrossOpen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+
+	o, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	first, err := o.Add("me@example.com", []string{"bob@example.com"}, "First", []byte("Subject: First\r\n\r\nHi\r\n"))
+	if err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+	if _, err := o.Add("me@example.com", []string{"carol@example.com"}, "Second", []byte("Subject: Second\r\n\r\nHi\r\n")); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+
+	reopened, err := Open(dir, nil)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	entries, err := reopened.List()
+	if err != nil {
+		t.Fatalf("List() error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].ID != first.ID || entries[0].Subject != "First" {
+		t.Errorf("Expected oldest entry first, got %+v", entries[0])
+	}
+	if string(entries[0].Raw) != "Subject: First\r\n\r\nHi\r\n" {
+		t.Errorf("Expected raw message to round-trip, got %q", entries[0].Raw)
+	}
+	if reopened.Len() != 2 {
+		t.Errorf("Expected Len() 2, got %d", reopened.Len())
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("Expected outbox directory mode 0700, got %o", info.Mode().Perm())
+	}
+}
+
+func TestOutbox_DeferBacksOff(t *testing.T) {
+	o := openTestOutbox(t)
+
+	entry, err := o.Add("me@example.com", []string{"bob@example.com"}, "Hi", []byte("x"))
+	if err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+
+	due, _ := o.Due(time.Now())
+	if len(due) != 1 {
+		t.Fatalf("Expected a new entry to be due immediately, got %d", len(due))
+	}
+
+	wantDelays := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute}
+	for i, want := range wantDelays {
+		before := time.Now()
+		entry, err = o.Defer(entry.ID, errors.New("dial tcp: no route to host"))
+		if err != nil {
+			t.Fatalf("Defer() error: %v", err)
+		}
+		delay := entry.NextAttempt.Sub(before)
+		if delay < want || delay > want+time.Second {
+			t.Errorf("Attempt %d: expected backoff %v, got %v", i+1, want, delay)
+		}
+	}
+
+	if entry.Attempts != 4 || entry.LastError != "dial tcp: no route to host" {
+		t.Errorf("Expected 4 attempts with the last error, got %+v", entry)
+	}
+	if due, _ := o.Due(time.Now()); len(due) != 0 {
+		t.Errorf("Expected deferred entry not to be due, got %d", len(due))
+	}
+	if due, _ := o.Due(time.Now().Add(6 * time.Minute)); len(due) != 1 {
+		t.Errorf("Expected deferred entry to be due after its backoff")
+	}
+}
+
+func TestOutbox_HoldAndRetry(t *testing.T) {
+	o := openTestOutbox(t)
+
+	entry, _ := o.Add("me@example.com", []string{"nobody@example.com"}, "Hi", []byte("x"))
+
+	held, err := o.Hold(entry.ID, errors.New("550 no such user"))
+	if err != nil {
+		t.Fatalf("Hold() error: %v", err)
+	}
+	if !held.Held {
+		t.Fatalf("Expected entry to be held")
+	}
+	if due, _ := o.Due(time.Now().Add(time.Hour)); len(due) != 0 {
+		t.Errorf("Expected held entry never to be due")
+	}
+
+	if _, err := o.Retry(entry.ID); err != nil {
+		t.Fatalf("Retry() error: %v", err)
+	}
+	if due, _ := o.Due(time.Now()); len(due) != 1 {
+		t.Errorf("Expected retried entry to be due")
+	}
+}
+
+func TestOutbox_Remove(t *testing.T) {
+	o := openTestOutbox(t)
+
+	entry, _ := o.Add("me@example.com", []string{"bob@example.com"}, "Hi", []byte("x"))
+
+	if err := o.Remove(entry.ID); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	if o.Len() != 0 {
+		t.Errorf("Expected empty outbox, got %d", o.Len())
+	}
+	if err := o.Remove(entry.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := o.Defer(entry.ID, errors.New("x")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound from Defer, got %v", err)
+	}
+}
+
+func TestOutbox_SetsAsideUnreadableEntries(t *testing.T) {
+	o := openTestOutbox(t)
+
+	valid, _ := o.Add("me@example.com", []string{"bob@example.com"}, "Hi", []byte("x"))
+	if err := os.WriteFile(filepath.Join(o.dir, "0-garbage.json"), []byte("{not json"), 0600); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	due, err := o.Due(time.Now())
+	if !errors.Is(err, ErrUnreadable) {
+		t.Errorf("Expected ErrUnreadable, got %v", err)
+	}
+	if len(due) != 1 || due[0].ID != valid.ID {
+		t.Fatalf("Expected the valid entry to still be due, got %+v", due)
+	}
+	if _, err := os.Stat(filepath.Join(o.dir, "0-garbage.bad")); err != nil {
+		t.Errorf("Expected the garbage entry to be moved aside: %v", err)
+	}
+
+	entries, err := o.List()
+	if err != nil {
+		t.Fatalf("Expected the moved entry to be reported once, got %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 entry, got %d", len(entries))
+	}
+}
+
+func TestOutbox_Schedule(t *testing.T) {
+	o := openTestOutbox(t)
+
+	at := time.Now().Add(2 * time.Hour)
+	if _, err := o.Schedule("me@example.com", []string{"bob@example.com"}, "Later", []byte("x"), at); err != nil {
+		t.Fatalf("Schedule() error: %v", err)
+	}
+
+	if due, _ := o.Due(time.Now()); len(due) != 0 {
+		t.Errorf("Expected scheduled entry not to be due yet, got %d", len(due))
+	}
+	due, _ := o.Due(at)
+	if len(due) != 1 || due[0].Attempts != 0 {
+		t.Errorf("Expected scheduled entry to be due at its time, got %+v", due)
+	}
+}
@@ -0,0 +1,91 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/zalando/go-keyring"
+)
+
+// KeyringService is the service name passwords are stored under in the
+// system keyring
+const KeyringService = "budge"
+
+// commandTimeout leaves time to unlock a GPG key at a pinentry prompt
+const commandTimeout = 2 * time.Minute
+
+var ErrNotInKeyring = errors.New("no password stored in the system keyring")
+
+// CommandError reports a password command that failed or printed nothing
+type CommandError struct {
+	Command string
+	Stderr  string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("password_command %q failed: %v", e.Command, e.Err)
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// FromCommand runs command through the shell and returns the first line
+// it prints, the convention pass and similar tools follow
+func FromCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("no output after %s", commandTimeout)
+		}
+		return "", &CommandError{Command: command, Stderr: strings.TrimSpace(stderr.String()), Err: err}
+	}
+
+	password, _, _ := strings.Cut(stdout.String(), "\n")
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", &CommandError{Command: command, Err: errors.New("printed no password")}
+	}
+	return password, nil
+}
+
+// FromKeyring reads the password of username from the system keyring:
+// the Secret Service on Linux, the Keychain on macOS and the Credential
+// Manager on Windows
+func FromKeyring(username string) (string, error) {
+	password, err := keyring.Get(KeyringService, username)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", fmt.Errorf("%w for %s, run budge keyring to add it", ErrNotInKeyring, username)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read password from the system keyring: %w", err)
+	}
+	return password, nil
+}
+
+// StoreInKeyring saves the password of username in the system keyring
+func StoreInKeyring(username, password string) error {
+	if err := keyring.Set(KeyringService, username, password); err != nil {
+		return fmt.Errorf("failed to store password in the system keyring: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestFromCommand(t *testing.T) {
+	password, err := FromCommand(context.Background(), `printf 'hunter2\nurl: mail.example.com\n'`)
+	if err != nil {
+		t.Fatalf("FromCommand() error: %v", err)
+	}
+	if password != "hunter2" {
+		t.Errorf("Expected first line 'hunter2', got %q", password)
+	}
+}
+
+func TestFromCommand_Failure(t *testing.T) {
+	_, err := FromCommand(context.Background(), "echo 'gpg: decryption failed' >&2; exit 2")
+
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected CommandError, got %v", err)
+	}
+	if cmdErr.Stderr != "gpg: decryption failed" {
+		t.Errorf("Expected stderr to be reported, got %q", cmdErr.Stderr)
+	}
+	if !strings.Contains(err.Error(), "exit status 2") {
+		t.Errorf("Expected exit status in error, got %q", err.Error())
+	}
+}
+
+func TestFromCommand_NoOutput(t *testing.T) {
+	_, err := FromCommand(context.Background(), "true")
+
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected CommandError for empty output, got %v", err)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	keyring.MockInit()
+
+	if _, err := FromKeyring("user@example.com"); !errors.Is(err, ErrNotInKeyring) {
+		t.Fatalf("Expected ErrNotInKeyring before storing, got %v", err)
+	}
+
+	if err := StoreInKeyring("user@example.com", "secret"); err != nil {
+		t.Fatalf("StoreInKeyring() error: %v", err)
+	}
+
+	password, err := FromKeyring("user@example.com")
+	if err != nil {
+		t.Fatalf("FromKeyring() error: %v", err)
+	}
+	if password != "secret" {
+		t.Errorf("Expected 'secret', got %q", password)
+	}
+}
@@ -0,0 +1,263 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/chhlga/budge/internal/oauth"
+	"github.com/emersion/go-sasl"
+	gosmtp "github.com/emersion/go-smtp"
+)
+
+// Supported values for Options.AuthMechanism. An empty mechanism picks
+// PLAIN when the server advertises it and falls back to LOGIN. The OAuth2
+// mechanisms take their token from Options.OAuth2Token.
+const (
+	AuthPlain       = "plain"
+	AuthLogin       = "login"
+	AuthXOAuth2     = "xoauth2"
+	AuthOAuthBearer = "oauthbearer"
+)
+
+type Client struct {
+	opts *Options
+}
+
+type Options struct {
+	Host          string
+	Port          int
+	TLS           bool
+	STARTTLS      bool
+	Username      string
+	Password      string
+	AuthMechanism string
+	Timeout       time.Duration
+	// OAuth2Token returns the access token for the OAuth2 mechanisms
+	OAuth2Token func(ctx context.Context) (string, error)
+}
+
+func NewClient(opts *Options) *Client {
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	return &Client{
+		opts: opts,
+	}
+}
+
+// Send delivers a fully formed RFC 5322 message to the given envelope
+// recipients. A fresh connection is opened for every call, so the client
+// is safe for concurrent use.
+func (c *Client) Send(ctx context.Context, from string, recipients []string, msg []byte) error {
+	if from == "" {
+		return ErrNoSender
+	}
+	if len(recipients) == 0 {
+		return ErrNoRecipients
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	client, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Unblock any pending network read when the context expires
+	stop := context.AfterFunc(ctx, func() { _ = client.Close() })
+	defer stop()
+
+	if err := c.authenticate(ctx, client); err != nil {
+		return c.contextErr(ctx, err)
+	}
+
+	if err := c.transmit(client, from, recipients, msg); err != nil {
+		return c.contextErr(ctx, err)
+	}
+
+	// The message has already been accepted at this point, so a failed
+	// QUIT is not worth reporting
+	_ = client.Quit()
+
+	return nil
+}
+
+func (c *Client) dial(ctx context.Context) (*gosmtp.Client, error) {
+	addr := fmt.Sprintf("%s:%d", c.opts.Host, c.opts.Port)
+	tlsConfig := &tls.Config{
+		ServerName: c.opts.Host,
+	}
+
+	var conn net.Conn
+	var err error
+
+	if c.opts.TLS {
+		dialer := &tls.Dialer{Config: tlsConfig}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		dialer := &net.Dialer{}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	if err != nil {
+		return nil, &ConnectionError{Op: "dial", Err: c.contextErr(ctx, err)}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if c.opts.STARTTLS {
+		client, err := gosmtp.NewClientStartTLS(conn, tlsConfig)
+		if err != nil {
+			return nil, &ConnectionError{Op: "starttls", Err: c.contextErr(ctx, err)}
+		}
+		return client, nil
+	}
+
+	return gosmtp.NewClient(conn), nil
+}
+
+func (c *Client) authenticate(ctx context.Context, client *gosmtp.Client) error {
+	if c.opts.Username == "" {
+		return nil
+	}
+
+	var saslClient sasl.Client
+
+	switch strings.ToLower(c.opts.AuthMechanism) {
+	case AuthPlain:
+		if !client.SupportsAuth(sasl.Plain) {
+			return &AuthenticationError{Username: c.opts.Username, Err: ErrAuthNotSupported}
+		}
+		saslClient = sasl.NewPlainClient("", c.opts.Username, c.opts.Password)
+	case AuthLogin:
+		if !client.SupportsAuth(sasl.Login) {
+			return &AuthenticationError{Username: c.opts.Username, Err: ErrAuthNotSupported}
+		}
+		saslClient = sasl.NewLoginClient(c.opts.Username, c.opts.Password)
+	case AuthXOAuth2, AuthOAuthBearer:
+		mech := oauth.XOAuth2
+		if strings.ToLower(c.opts.AuthMechanism) == AuthOAuthBearer {
+			mech = sasl.OAuthBearer
+		}
+		if !client.SupportsAuth(mech) {
+			return &AuthenticationError{Username: c.opts.Username, Err: ErrAuthNotSupported}
+		}
+		if c.opts.OAuth2Token == nil {
+			return &AuthenticationError{Username: c.opts.Username, Err: errors.New("no OAuth2 token configured")}
+		}
+		token, err := c.opts.OAuth2Token(ctx)
+		if err != nil {
+			return &AuthenticationError{Username: c.opts.Username, Err: err}
+		}
+		if mech == oauth.XOAuth2 {
+			saslClient = oauth.NewXOAuth2Client(c.opts.Username, token)
+		} else {
+			saslClient = sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+				Username: c.opts.Username,
+				Token:    token,
+				Host:     c.opts.Host,
+				Port:     c.opts.Port,
+			})
+		}
+	case "":
+		switch {
+		case client.SupportsAuth(sasl.Plain):
+			saslClient = sasl.NewPlainClient("", c.opts.Username, c.opts.Password)
+		case client.SupportsAuth(sasl.Login):
+			saslClient = sasl.NewLoginClient(c.opts.Username, c.opts.Password)
+		default:
+			return &AuthenticationError{Username: c.opts.Username, Err: ErrAuthNotSupported}
+		}
+	default:
+		return &AuthenticationError{
+			Username: c.opts.Username,
+			Err:      fmt.Errorf("unknown authentication mechanism %q", c.opts.AuthMechanism),
+		}
+	}
+
+	if err := client.Auth(saslClient); err != nil {
+		return &AuthenticationError{Username: c.opts.Username, Err: err}
+	}
+
+	return nil
+}
+
+func (c *Client) transmit(client *gosmtp.Client, from string, recipients []string, msg []byte) error {
+	var mailOpts *gosmtp.MailOptions
+	if !isASCII(from) || !allASCII(recipients) {
+		mailOpts = &gosmtp.MailOptions{UTF8: true}
+	}
+
+	if err := client.Mail(from, mailOpts); err != nil {
+		return &SendError{Op: "mail from", Err: err}
+	}
+
+	for _, rcpt := range recipients {
+		if err := client.Rcpt(rcpt, nil); err != nil {
+			return &RecipientError{Recipient: rcpt, Err: err}
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return &SendError{Op: "data", Err: err}
+	}
+
+	if _, err := w.Write(normalizeCRLF(msg)); err != nil {
+		_ = w.Close()
+		return &SendError{Op: "data", Err: err}
+	}
+
+	if err := w.Close(); err != nil {
+		return &SendError{Op: "data", Err: err}
+	}
+
+	return nil
+}
+
+// contextErr replaces network errors caused by an expired context with
+// ErrTimeout so callers can tell a slow server from a broken one.
+func (c *Client) contextErr(ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	return ctx.Err()
+}
+
+// normalizeCRLF makes sure every line ends with CRLF as SMTP requires
+func normalizeCRLF(msg []byte) []byte {
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func allASCII(ss []string) bool {
+	for _, s := range ss {
+		if !isASCII(s) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,372 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	gosmtp "github.com/emersion/go-smtp"
+)
+
+type testBackend struct {
+	mu         sync.Mutex
+	mechanisms []string
+	from       string
+	rcpts      []string
+	data       string
+	authUser   string
+}
+
+func (b *testBackend) NewSession(c *gosmtp.Conn) (gosmtp.Session, error) {
+	return &testSession{backend: b}, nil
+}
+
+type testSession struct {
+	backend *testBackend
+}
+
+func (s *testSession) AuthMechanisms() []string {
+	return s.backend.mechanisms
+}
+
+func (s *testSession) Auth(mech string) (sasl.Server, error) {
+	check := func(username, password string) error {
+		if username != "user" || password != "pass" {
+			return errors.New("invalid credentials")
+		}
+		s.backend.mu.Lock()
+		s.backend.authUser = username
+		s.backend.mu.Unlock()
+		return nil
+	}
+
+	switch mech {
+	case sasl.Plain:
+		return sasl.NewPlainServer(func(identity, username, password string) error {
+			return check(username, password)
+		}), nil
+	case sasl.Login:
+		return &loginServer{check: check}, nil
+	case sasl.OAuthBearer:
+		return sasl.NewOAuthBearerServer(func(opts sasl.OAuthBearerOptions) *sasl.OAuthBearerError {
+			if opts.Token != "token" || check(opts.Username, "pass") != nil {
+				return &sasl.OAuthBearerError{Status: "invalid_token"}
+			}
+			return nil
+		}), nil
+	}
+	return nil, gosmtp.ErrAuthUnknownMechanism
+}
+
+func (s *testSession) Mail(from string, opts *gosmtp.MailOptions) error {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+	s.backend.from = from
+	return nil
+}
+
+func (s *testSession) Rcpt(to string, opts *gosmtp.RcptOptions) error {
+	if strings.HasPrefix(to, "reject@") {
+		return &gosmtp.SMTPError{Code: 550, Message: "no such user"}
+	}
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+	s.backend.rcpts = append(s.backend.rcpts, to)
+	return nil
+}
+
+func (s *testSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+	s.backend.data = string(data)
+	return nil
+}
+
+func (s *testSession) Reset()        {}
+func (s *testSession) Logout() error { return nil }
+
+// loginServer is a minimal server side of the LOGIN mechanism, which
+// go-sasl only implements for clients
+type loginServer struct {
+	check    func(username, password string) error
+	username string
+	step     int
+}
+
+func (l *loginServer) Next(response []byte) ([]byte, bool, error) {
+	switch l.step {
+	case 0:
+		l.step++
+		if response != nil {
+			l.username = string(response)
+			l.step++
+			return []byte("Password:"), false, nil
+		}
+		return []byte("Username:"), false, nil
+	case 1:
+		l.step++
+		l.username = string(response)
+		return []byte("Password:"), false, nil
+	default:
+		return nil, true, l.check(l.username, string(response))
+	}
+}
+
+func startSMTPServer(t *testing.T, mechanisms ...string) (*net.TCPAddr, *testBackend) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+
+	backend := &testBackend{mechanisms: mechanisms}
+	server := gosmtp.NewServer(backend)
+	server.Domain = "localhost"
+	server.AllowInsecureAuth = true
+
+	done := make(chan struct{})
+	go func() {
+		_ = server.Serve(ln)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		_ = server.Close()
+		<-done
+	})
+
+	return ln.Addr().(*net.TCPAddr), backend
+}
+
+func TestClient_SendWithPlainAuth(t *testing.T) {
+	addr, backend := startSMTPServer(t, sasl.Plain)
+
+	client := NewClient(&Options{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "user",
+		Password: "pass",
+	})
+
+	msg := []byte("Subject: hi\nFrom: alice@example.com\n\nHello\n")
+	err := client.Send(context.Background(), "alice@example.com", []string{"bob@example.com", "carol@example.com"}, msg)
+	if err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	if backend.authUser != "user" {
+		t.Errorf("Expected server to authenticate 'user', got '%s'", backend.authUser)
+	}
+	if backend.from != "alice@example.com" {
+		t.Errorf("Expected MAIL FROM 'alice@example.com', got '%s'", backend.from)
+	}
+	if len(backend.rcpts) != 2 {
+		t.Errorf("Expected 2 recipients, got %v", backend.rcpts)
+	}
+	if !strings.Contains(backend.data, "Subject: hi\r\n") {
+		t.Errorf("Expected CRLF-normalized message data, got %q", backend.data)
+	}
+}
+
+func TestClient_SendWithLoginAuth(t *testing.T) {
+	addr, backend := startSMTPServer(t, sasl.Login)
+
+	client := NewClient(&Options{
+		Host:          addr.IP.String(),
+		Port:          addr.Port,
+		Username:      "user",
+		Password:      "pass",
+		AuthMechanism: AuthLogin,
+	})
+
+	err := client.Send(context.Background(), "alice@example.com", []string{"bob@example.com"}, []byte("Subject: hi\r\n\r\nHello\r\n"))
+	if err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if backend.authUser != "user" {
+		t.Errorf("Expected server to authenticate 'user', got '%s'", backend.authUser)
+	}
+}
+
+func TestClient_SendWithOAuthBearer(t *testing.T) {
+	addr, backend := startSMTPServer(t, sasl.OAuthBearer)
+
+	client := NewClient(&Options{
+		Host:          addr.IP.String(),
+		Port:          addr.Port,
+		Username:      "user",
+		AuthMechanism: AuthOAuthBearer,
+		OAuth2Token: func(ctx context.Context) (string, error) {
+			return "token", nil
+		},
+	})
+
+	err := client.Send(context.Background(), "alice@example.com", []string{"bob@example.com"}, []byte("Subject: hi\r\n\r\nHello\r\n"))
+	if err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	if backend.authUser != "user" {
+		t.Errorf("Expected server to authenticate 'user', got '%s'", backend.authUser)
+	}
+}
+
+func TestClient_SendWrongPassword(t *testing.T) {
+	addr, _ := startSMTPServer(t, sasl.Plain)
+
+	client := NewClient(&Options{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "user",
+		Password: "wrong",
+	})
+
+	err := client.Send(context.Background(), "alice@example.com", []string{"bob@example.com"}, []byte("Subject: hi\r\n\r\n"))
+
+	var authErr *AuthenticationError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("Expected AuthenticationError, got %T: %v", err, err)
+	}
+	if authErr.Username != "user" {
+		t.Errorf("Expected username 'user', got '%s'", authErr.Username)
+	}
+}
+
+func TestClient_SendUnsupportedMechanism(t *testing.T) {
+	addr, _ := startSMTPServer(t, sasl.Plain)
+
+	client := NewClient(&Options{
+		Host:          addr.IP.String(),
+		Port:          addr.Port,
+		Username:      "user",
+		Password:      "pass",
+		AuthMechanism: AuthLogin,
+	})
+
+	err := client.Send(context.Background(), "alice@example.com", []string{"bob@example.com"}, []byte("Subject: hi\r\n\r\n"))
+	if !errors.Is(err, ErrAuthNotSupported) {
+		t.Fatalf("Expected ErrAuthNotSupported, got %v", err)
+	}
+}
+
+func TestClient_SendRejectedRecipient(t *testing.T) {
+	addr, _ := startSMTPServer(t, sasl.Plain)
+
+	client := NewClient(&Options{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "user",
+		Password: "pass",
+	})
+
+	err := client.Send(context.Background(), "alice@example.com", []string{"reject@example.com"}, []byte("Subject: hi\r\n\r\n"))
+
+	var rcptErr *RecipientError
+	if !errors.As(err, &rcptErr) {
+		t.Fatalf("Expected RecipientError, got %T: %v", err, err)
+	}
+	if rcptErr.Recipient != "reject@example.com" {
+		t.Errorf("Expected recipient 'reject@example.com', got '%s'", rcptErr.Recipient)
+	}
+}
+
+func TestClient_SendDialFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	_ = ln.Close()
+
+	client := NewClient(&Options{
+		Host:    addr.IP.String(),
+		Port:    addr.Port,
+		Timeout: 2 * time.Second,
+	})
+
+	err = client.Send(context.Background(), "alice@example.com", []string{"bob@example.com"}, []byte("Subject: hi\r\n\r\n"))
+
+	var connErr *ConnectionError
+	if !errors.As(err, &connErr) {
+		t.Fatalf("Expected ConnectionError, got %T: %v", err, err)
+	}
+	if connErr.Op != "dial" {
+		t.Errorf("Expected op 'dial', got '%s'", connErr.Op)
+	}
+}
+
+func TestClient_SendValidatesEnvelope(t *testing.T) {
+	client := NewClient(&Options{Host: "localhost", Port: 25})
+
+	if err := client.Send(context.Background(), "", []string{"bob@example.com"}, nil); !errors.Is(err, ErrNoSender) {
+		t.Errorf("Expected ErrNoSender, got %v", err)
+	}
+	if err := client.Send(context.Background(), "alice@example.com", nil, nil); !errors.Is(err, ErrNoRecipients) {
+		t.Errorf("Expected ErrNoRecipients, got %v", err)
+	}
+}
+
+func TestErrorTypes(t *testing.T) {
+	baseErr := errors.New("boom")
+
+	tests := []struct {
+		err      error
+		contains string
+	}{
+		{&ConnectionError{Op: "dial", Err: baseErr}, "dial"},
+		{&AuthenticationError{Username: "user@example.com", Err: baseErr}, "user@example.com"},
+		{&RecipientError{Recipient: "bob@example.com", Err: baseErr}, "bob@example.com"},
+		{&SendError{Op: "data", Err: baseErr}, "data"},
+	}
+
+	for _, tt := range tests {
+		if !strings.Contains(tt.err.Error(), tt.contains) {
+			t.Errorf("Error message should contain '%s', got: %s", tt.contains, tt.err.Error())
+		}
+		if !errors.Is(tt.err, baseErr) {
+			t.Errorf("Expected %T to unwrap to base error", tt.err)
+		}
+	}
+}
+
+func TestIsTemporary(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dial failure", &ConnectionError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"timeout", fmt.Errorf("%w: i/o timeout", ErrTimeout), true},
+		{"greylisted", &RecipientError{Recipient: "bob@example.com", Err: &gosmtp.SMTPError{Code: 451}}, true},
+		{"unknown user", &RecipientError{Recipient: "bob@example.com", Err: &gosmtp.SMTPError{Code: 550}}, false},
+		{"bad password", &AuthenticationError{Username: "user", Err: &gosmtp.SMTPError{Code: 535}}, false},
+		{"connection dropped", &SendError{Op: "data", Err: &net.OpError{Op: "read", Err: errors.New("reset")}}, true},
+		{"no recipients", ErrNoRecipients, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsTemporary(tt.err); got != tt.want {
+			t.Errorf("%s: expected IsTemporary() %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
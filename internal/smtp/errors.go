@@ -0,0 +1,85 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	gosmtp "github.com/emersion/go-smtp"
+)
+
+var (
+	ErrNoRecipients     = errors.New("no recipients specified")
+	ErrNoSender         = errors.New("no sender specified")
+	ErrAuthNotSupported = errors.New("server does not support the requested authentication mechanism")
+	ErrTimeout          = errors.New("operation timed out")
+)
+
+type ConnectionError struct {
+	Op  string
+	Err error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("smtp connection error during %s: %v", e.Op, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+type AuthenticationError struct {
+	Username string
+	Err      error
+}
+
+func (e *AuthenticationError) Error() string {
+	return fmt.Sprintf("smtp authentication failed for user %s: %v", e.Username, e.Err)
+}
+
+func (e *AuthenticationError) Unwrap() error {
+	return e.Err
+}
+
+type RecipientError struct {
+	Recipient string
+	Err       error
+}
+
+func (e *RecipientError) Error() string {
+	return fmt.Sprintf("smtp server rejected recipient %s: %v", e.Recipient, e.Err)
+}
+
+func (e *RecipientError) Unwrap() error {
+	return e.Err
+}
+
+type SendError struct {
+	Op  string
+	Err error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("smtp send error during %s: %v", e.Op, e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// IsTemporary reports whether a failed Send is worth retrying later: the
+// server could not be reached, timed out, or answered with a 4xx code
+func IsTemporary(err error) bool {
+	var connErr *ConnectionError
+	if errors.As(err, &connErr) || errors.Is(err, ErrTimeout) {
+		return true
+	}
+
+	var smtpErr *gosmtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Temporary()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
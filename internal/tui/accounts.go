@@ -0,0 +1,217 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/chhlga/budge/internal/cache"
+	"github.com/chhlga/budge/internal/config"
+	"github.com/chhlga/budge/internal/email"
+	"github.com/chhlga/budge/internal/imap"
+)
+
+// Account is a configured mail account together with the clients that
+// serve it. Sender is nil when the account has no smtp section.
+type Account struct {
+	Config config.AccountConfig
+	Client *imap.Client
+	Sender Sender
+}
+
+// account is the model's view of one Account: its own connection, folder
+// list, sender identities and message cache. UIDs only mean something
+// within an account, so caches aren't shared.
+type account struct {
+	name       string
+	config     config.AccountConfig
+	client     *imap.Client
+	connStates chan imap.ConnectionState
+	sender     Sender
+	mailboxes  []MailboxInfo
+	identities []email.Identity
+	cache      *cache.Cache
+
+	// online is set while an IMAP session is authenticated; sessionLost
+	// once an established session dropped, until it is restored
+	online       bool
+	sessionLost  bool
+	reconnecting bool
+
+	// retry runs the account's last command that timed out, or its failed
+	// connect, again
+	retry tea.Cmd
+
+	// subscriptions is whether the server reported which mailboxes are
+	// subscribed
+	subscriptions bool
+	counts        map[string]MailboxCounts
+
+	// monitor watches the mailbox open in the account
+	monitor *mailboxMonitor
+}
+
+func newAccount(a Account) *account {
+	acct := &account{
+		name:       a.Config.Name,
+		config:     a.Config,
+		client:     a.Client,
+		sender:     a.Sender,
+		identities: accountIdentities(a.Config),
+		cache:      cache.New(100), // Cache 100 email bodies
+	}
+	if a.Client != nil {
+		acct.connStates = watchConnectionState(a.Client)
+	}
+	return acct
+}
+
+// accountIdentities converts the configured identities, falling back to a
+// single identity for the plain sender address
+func accountIdentities(cfg config.AccountConfig) []email.Identity {
+	identities := make([]email.Identity, 0, len(cfg.Identities))
+
+	for _, idCfg := range cfg.Identities {
+		addrs, err := email.ParseAddressList(idCfg.Address)
+		if err != nil || len(addrs) != 1 {
+			continue // Rejected by config validation
+		}
+		from := addrs[0]
+		if idCfg.Name != "" {
+			from.Name = idCfg.Name
+		}
+		replyTo, _ := email.ParseAddressList(idCfg.ReplyTo)
+
+		identities = append(identities, email.Identity{
+			From:      from,
+			ReplyTo:   replyTo,
+			Signature: idCfg.Signature,
+			BccSelf:   idCfg.BccSelf,
+		})
+	}
+
+	if len(identities) == 0 {
+		if from, err := fromAddress(cfg); err == nil {
+			identities = append(identities, email.Identity{From: from})
+		}
+	}
+
+	return identities
+}
+
+// fromAddress returns the configured sender address of an account
+func fromAddress(cfg config.AccountConfig) (email.Address, error) {
+	from := cfg.SMTP.From
+	if from == "" {
+		from = cfg.Credentials.Username
+	}
+
+	addrs, err := email.ParseAddressList(from)
+	if err != nil || len(addrs) != 1 {
+		return email.Address{}, fmt.Errorf("invalid sender address %q", from)
+	}
+	return addrs[0], nil
+}
+
+// selfAddresses lists the addresses that belong to the account's user,
+// so reply-all can leave them out
+func (a *account) selfAddresses() []string {
+	self := []string{a.config.Credentials.Username}
+	if from, err := fromAddress(a.config); err == nil {
+		self = append(self, from.Email)
+	}
+	for _, id := range a.identities {
+		self = append(self, id.From.Email)
+	}
+	return self
+}
+
+// sendsAs reports whether address is one the account sends from
+func (a *account) sendsAs(address string) bool {
+	for _, self := range a.selfAddresses() {
+		if strings.EqualFold(self, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// specialMailboxes returns which of the account's mailboxes has which
+// special use
+func (a *account) specialMailboxes() SpecialMailboxes {
+	return specialMailboxes(resolveSpecialUses(a.mailboxes, a.config.SpecialMailboxes))
+}
+
+// specialMailbox returns the mailbox with a special use such as Drafts or
+// Sent. When the server has none, the generic name is returned with ok
+// set to false so the caller can create it.
+func (a *account) specialMailbox(use SpecialUse) (string, bool) {
+	if name, ok := a.specialMailboxes().Mailbox(use); ok {
+		return name, true
+	}
+	return specialUseName(use), false
+}
+
+// startMonitoring watches mailbox for changes, replacing the mailbox
+// watched before, and returns the command that waits for the first one
+func (a *account) startMonitoring(mailbox string, interval time.Duration) tea.Cmd {
+	if a.client == nil {
+		return nil
+	}
+	a.stopMonitoring()
+	a.monitor = newMailboxMonitor(a.client, mailbox, interval)
+	return waitForMailboxUpdateCmd(a.monitor)
+}
+
+// stopMonitoring stops watching the account's open mailbox
+func (a *account) stopMonitoring() {
+	if a.monitor != nil {
+		a.monitor.cancel()
+		a.monitor = nil
+	}
+}
+
+// loadCounts fetches the message counts of every mailbox that can hold
+// messages
+func (a *account) loadCounts() tea.Cmd {
+	var names []string
+	for _, mb := range a.mailboxes {
+		if !mb.NoSelect {
+			names = append(names, mb.Name)
+		}
+	}
+	return loadMailboxCountsCmd(a.name, a.client, names)
+}
+
+// accountFor returns the account that sends as from. With a single
+// account every message belongs to it.
+func accountFor(accounts []*account, from string) *account {
+	for _, acct := range accounts {
+		if acct.sendsAs(from) {
+			return acct
+		}
+	}
+	if len(accounts) == 1 {
+		return accounts[0]
+	}
+	return nil
+}
+
+// accountSender delivers each message through the outgoing server of the
+// account it is sent from, so the shared outbox can hold mail of several
+// accounts
+type accountSender []*account
+
+func (s accountSender) Send(ctx context.Context, from string, recipients []string, msg []byte) error {
+	acct := accountFor(s, from)
+	if acct == nil {
+		return fmt.Errorf("no account sends as %s", from)
+	}
+	if acct.sender == nil {
+		return fmt.Errorf("sending is not configured for %s, add an smtp section to the account", acct.name)
+	}
+	return acct.sender.Send(ctx, from, recipients, msg)
+}
@@ -0,0 +1,183 @@
+package tui
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/chhlga/budge/internal/config"
+	"github.com/chhlga/budge/internal/email"
+	"github.com/chhlga/budge/internal/imap"
+	"github.com/chhlga/budge/internal/outbox"
+)
+
+func newAccountsTestModel(work, home Sender) Model {
+	cfg := &config.Config{
+		Behavior: config.BehaviorConfig{DefaultFolder: "INBOX", PageSize: 50, PollInterval: 30},
+	}
+	accounts := []Account{
+		{
+			Config: config.AccountConfig{
+				Name:          "work",
+				Credentials:   config.CredentialsConfig{Username: "me@work.example"},
+				DefaultFolder: "INBOX",
+			},
+			Sender: work,
+		},
+		{
+			Config: config.AccountConfig{
+				Name:          "home",
+				Credentials:   config.CredentialsConfig{Username: "me@home.example"},
+				Identities:    []config.IdentityConfig{{Address: "alias@home.example"}},
+				DefaultFolder: "Inbox",
+			},
+			Sender: home,
+		},
+	}
+	return NewModel(cfg, accounts)
+}
+
+func TestMailboxList_showsAccountsWithActiveMailboxes(t *testing.T) {
+	m := newAccountsTestModel(nil, nil)
+
+	updated, _ := m.Update(MailboxesLoadedMsg{Account: "home", Mailboxes: []MailboxInfo{{Name: "Inbox"}, {Name: "Archive"}}})
+	m = updated.(Model)
+	if got := len(m.mailboxList.list.Items()); got != 3 {
+		t.Fatalf("Expected only All Inboxes and the account rows while work is active, got %d items", got)
+	}
+
+	updated, _ = m.Update(MailboxesLoadedMsg{Account: "work", Mailboxes: []MailboxInfo{{Name: "INBOX"}}})
+	m = updated.(Model)
+	items := m.mailboxList.list.Items()
+	want := []mailboxItem{
+		{name: unifiedTitle, unified: true},
+		{name: "work", account: "work"},
+		{name: "INBOX", label: "INBOX", use: SpecialInbox, depth: 1},
+		{name: "home", account: "home"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("Expected %d items, got %d", len(want), len(items))
+	}
+	for i, item := range items {
+		if item.(mailboxItem) != want[i] {
+			t.Errorf("Item %d: expected %+v, got %+v", i, want[i], item)
+		}
+	}
+}
+
+func TestAccountSelected_switchesAccount(t *testing.T) {
+	m := newAccountsTestModel(nil, nil)
+	updated, _ := m.Update(MailboxesLoadedMsg{Account: "home", Mailboxes: []MailboxInfo{{Name: "Inbox"}, {Name: "Archive"}}})
+	m = updated.(Model)
+	m.switchMailbox("INBOX")
+	ctx, cancel := context.WithCancel(context.Background())
+	m.account().monitor = &mailboxMonitor{mailbox: "INBOX", ctx: ctx, cancel: cancel}
+
+	m.mailboxList.list.Select(2) // home
+	m.state = mailboxListView
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil {
+		t.Fatalf("Expected enter on an account row to select it")
+	}
+	selected, ok := cmd().(AccountSelectedMsg)
+	if !ok || selected.Account != "home" {
+		t.Fatalf("Expected AccountSelectedMsg for home, got %#v", selected)
+	}
+
+	updated, _ = m.Update(selected)
+	m = updated.(Model)
+
+	if m.account().name != "home" {
+		t.Fatalf("Expected home to be active, got %s", m.account().name)
+	}
+	if m.currentMailbox != "" {
+		t.Errorf("Expected the mailbox of the previous account to be closed, got %q", m.currentMailbox)
+	}
+	if m.accounts[0].monitor != nil || ctx.Err() == nil {
+		t.Errorf("Expected the previous account to stop watching its mailbox")
+	}
+	if got := len(m.mailboxList.list.Items()); got != 5 {
+		t.Errorf("Expected the cached mailboxes of home to be listed, got %d items", got)
+	}
+	if ids := m.compose.identities; len(ids) != 1 || ids[0].From.Email != "alias@home.example" {
+		t.Errorf("Expected compose to offer the identities of home, got %+v", ids)
+	}
+	if m.statusBar.account != "home" {
+		t.Errorf("Expected the status bar to name the active account, got %q", m.statusBar.account)
+	}
+}
+
+func TestAccountSender_routesByFrom(t *testing.T) {
+	work, home := &fakeSender{}, &fakeSender{}
+	m := newAccountsTestModel(work, home)
+
+	sender := m.outgoing()
+	if err := sender.Send(context.Background(), "alias@home.example", []string{"a@example.com"}, []byte("x")); err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	if home.from != "alias@home.example" || work.from != "" {
+		t.Errorf("Expected mail from an identity of home to go through its server")
+	}
+
+	if err := sender.Send(context.Background(), "stranger@example.com", nil, nil); err == nil {
+		t.Errorf("Expected an error for an address no account sends as")
+	}
+}
+
+func TestConnectionState_otherAccountLeavesStatusBar(t *testing.T) {
+	m := newAccountsTestModel(nil, nil)
+
+	updated, _ := m.Update(ConnectionStateChangedMsg{Account: "home", State: imap.StateAuthenticated})
+	m = updated.(Model)
+
+	if !m.accountByName("home").online {
+		t.Errorf("Expected home to be marked online")
+	}
+	if m.statusBar.connectionState != "Disconnected" {
+		t.Errorf("Expected the status bar to keep the state of work, got %q", m.statusBar.connectionState)
+	}
+}
+
+func TestScheduleSend_checksAccountOfSender(t *testing.T) {
+	m := newAccountsTestModel(nil, &fakeSender{})
+	m.SetOutbox(openTestOutbox(t))
+
+	at := time.Now().Add(time.Hour)
+	from := func(address string) ScheduleSendRequestMsg {
+		return ScheduleSendRequestMsg{At: at, Draft: email.Message{
+			From: []email.Address{{Email: address}},
+			To:   []email.Address{{Email: "bob@example.com"}},
+		}}
+	}
+
+	_, cmd := m.Update(from("alias@home.example"))
+	if msg, ok := cmd().(SendScheduledMsg); !ok || msg.Entry.From != "alias@home.example" {
+		t.Errorf("Expected mail from home to be scheduled while work is active, got %#v", msg)
+	}
+
+	_, cmd = m.Update(from("me@work.example"))
+	if _, ok := cmd().(SendErrorMsg); !ok {
+		t.Errorf("Expected mail from work to be refused, it has no smtp section")
+	}
+}
+
+func TestDrafts_keptInAccountOfSender(t *testing.T) {
+	m := newAccountsTestModel(nil, &fakeSender{})
+	m.SetOutbox(openTestOutbox(t))
+	m.accounts[1].mailboxes = []MailboxInfo{{Name: "Inbox"}, {Name: "Entwürfe", SpecialUse: SpecialDrafts}}
+
+	updated, _ := m.Update(DraftSavedMsg{Account: "home", Mailbox: "Entwürfe", UID: 9})
+	m = updated.(Model)
+	if m.compose.DraftAccount() != "home" || m.compose.DraftUID() != 9 {
+		t.Fatalf("Expected compose to remember the draft in home, got %q UID %d", m.compose.DraftAccount(), m.compose.DraftUID())
+	}
+
+	entry := outbox.Entry{ID: "held", From: "alias@home.example", NextAttempt: time.Now().Add(time.Minute)}
+	updated, _ = m.Update(SendScheduledMsg{Entry: entry, Undo: true})
+	m = updated.(Model)
+	want := serverDraft{account: "home", mailbox: "Entwürfe", uid: 9}
+	if got := m.heldDrafts["held"]; got != want {
+		t.Errorf("Expected the draft to be held in home's Drafts mailbox, got %+v", got)
+	}
+}
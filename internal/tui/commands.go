@@ -2,178 +2,494 @@ package tui
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/chhlga/budge/internal/cache"
 	"github.com/chhlga/budge/internal/email"
+	"github.com/chhlga/budge/internal/folders"
 	imapClient "github.com/chhlga/budge/internal/imap"
+	"github.com/chhlga/budge/internal/outbox"
+	"github.com/chhlga/budge/internal/smtp"
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
 )
 
-func connectCmd(client *imapClient.Client) tea.Cmd {
+func connectCmd(account string, client *imapClient.Client) tea.Cmd {
 	return func() tea.Msg {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
 		if err := client.Connect(ctx); err != nil {
-			return ConnectErrorMsg{Err: fmt.Errorf("connection failed: %w", err)}
+			return ConnectErrorMsg{Account: account, Err: fmt.Errorf("connection failed: %w", err)}
 		}
 
 		if err := client.Authenticate(ctx); err != nil {
-			return ConnectErrorMsg{Err: fmt.Errorf("authentication failed: %w", err)}
+			_ = client.Disconnect() // Start over on retry
+			return ConnectErrorMsg{Account: account, Err: fmt.Errorf("authentication failed: %w", err)}
 		}
 
-		return ConnectCompleteMsg{}
+		return ConnectCompleteMsg{Account: account}
 	}
 }
 
-func loadMailboxesCmd(client *imapClient.Client) tea.Cmd {
+// retryable lets the user run cmd again when it fails because the server
+// did not answer in time
+func retryable(cmd tea.Cmd) tea.Cmd {
 	return func() tea.Msg {
-		if !client.IsConnected() {
-			return ErrorMsg{Err: fmt.Errorf("not connected to IMAP server")}
+		msg := cmd()
+		if errMsg, ok := msg.(ErrorMsg); ok && errors.Is(errMsg.Err, imapClient.ErrTimeout) {
+			errMsg.Retry = retryable(cmd)
+			return errMsg
 		}
+		return msg
+	}
+}
 
-		imapConn := client.Client()
-		if imapConn == nil {
-			return ErrorMsg{Err: fmt.Errorf("IMAP client not initialized")}
+// watchConnectionState forwards the client's state changes to a channel
+// the model listens on. Changes are dropped rather than stall the client
+// when the model falls behind.
+func watchConnectionState(client *imapClient.Client) chan imapClient.ConnectionState {
+	states := make(chan imapClient.ConnectionState, 16)
+	client.SetStateHandler(func(state imapClient.ConnectionState) {
+		select {
+		case states <- state:
+		default:
 		}
+	})
+	return states
+}
+
+// connectionStateUpdateMsg carries a state change reported by an
+// account's client
+type connectionStateUpdateMsg struct {
+	account string
+	state   imapClient.ConnectionState
+}
+
+// waitForConnectionStateCmd blocks until the account's client changes state
+func waitForConnectionStateCmd(account string, states <-chan imapClient.ConnectionState) tea.Cmd {
+	return func() tea.Msg {
+		return connectionStateUpdateMsg{account: account, state: <-states}
+	}
+}
 
-		listCmd := imapConn.List("", "*", nil)
-		mailboxes, err := listCmd.Collect()
+// reconnectCmd restores a lost IMAP session in the background. The client
+// backs off between attempts.
+func reconnectCmd(account string, client *imapClient.Client) tea.Cmd {
+	return func() tea.Msg {
+		return ReconnectResultMsg{Account: account, Err: client.Ping(context.Background())}
+	}
+}
+
+func loadMailboxesCmd(account string, client *imapClient.Client) tea.Cmd {
+	return retryable(func() tea.Msg {
+		var listed []*imap.ListData
+		var subscriptions bool
+		err := client.DoIdempotent(context.Background(), "", func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			// Without LIST-EXTENDED the server can't say which mailboxes
+			// are subscribed in the same response, and takes no RETURN
+			// options at all.
+			caps := imapConn.Caps()
+			extended := caps.Has(imap.CapListExtended)
+			subscriptions = extended
+			// SPECIAL-USE servers may only mark their Sent, Trash etc. when
+			// asked to. Without LIST-EXTENDED they can't be, and only the
+			// attributes of a plain LIST are there to read.
+			options := &imap.ListOptions{
+				ReturnSubscribed: extended,
+				ReturnSpecialUse: extended && caps.Has(imap.CapSpecialUse),
+			}
+			var err error
+			listed, err = imapConn.List("", "*", options).Collect()
+			return err
+		})
 		if err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to list mailboxes: %w", err)}
+			return ErrorMsg{Account: account, Err: fmt.Errorf("failed to list mailboxes: %w", err)}
 		}
 
-		names := make([]string, 0, len(mailboxes))
-		for _, mbox := range mailboxes {
-			names = append(names, mbox.Mailbox)
+		// Older servers still know LSUB. When it fails too, every mailbox
+		// counts as subscribed rather than the list going empty.
+		var subscribed map[string]bool
+		if !subscriptions {
+			if names, err := client.Subscribed(context.Background()); err == nil {
+				subscriptions, subscribed = true, make(map[string]bool, len(names))
+				for _, name := range names {
+					subscribed[name] = true
+				}
+			}
 		}
 
-		names = sortMailboxes(names)
+		mailboxes := make([]MailboxInfo, 0, len(listed))
+		for _, data := range listed {
+			info := newMailboxInfo(data, subscriptions)
+			if subscribed[data.Mailbox] {
+				info.Subscribed = true
+			}
+			mailboxes = append(mailboxes, info)
+		}
 
-		return MailboxesLoadedMsg{Mailboxes: names}
-	}
+		return MailboxesLoadedMsg{
+			Account:       account,
+			Mailboxes:     mailboxes,
+			Subscriptions: subscriptions,
+		}
+	})
 }
 
-func loadEmailsCmd(client *imapClient.Client, mailbox string, pageSize uint32) tea.Cmd {
+// loadMailboxCountsCmd fetches the unread and total counts of mailboxes,
+// in a single LIST-STATUS command when the server supports it and with a
+// STATUS command per mailbox otherwise. It runs in the background, so
+// failures only leave counts out.
+func loadMailboxCountsCmd(account string, client *imapClient.Client, mailboxes []string) tea.Cmd {
+	if client == nil || len(mailboxes) == 0 {
+		return nil
+	}
+
 	return func() tea.Msg {
-		if !client.IsConnected() {
-			return ErrorMsg{Err: fmt.Errorf("not connected to IMAP server")}
-		}
+		counts := make(map[string]MailboxCounts)
+		options := &imap.StatusOptions{NumMessages: true, NumUnseen: true}
+		_ = client.DoIdempotent(context.Background(), "", func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			if len(mailboxes) > 1 && imapConn.Caps().Has(imap.CapListStatus) {
+				listed, err := imapConn.List("", "*", &imap.ListOptions{ReturnStatus: options}).Collect()
+				if err != nil {
+					return err
+				}
+				for _, data := range listed {
+					if data.Status != nil {
+						counts[data.Mailbox] = newMailboxCounts(data.Status)
+					}
+				}
+				return nil
+			}
+
+			for _, name := range mailboxes {
+				data, err := imapConn.Status(name, options).Wait()
+				var imapErr *imap.Error
+				if errors.As(err, &imapErr) {
+					// The server refused this mailbox, the others may work
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				counts[name] = newMailboxCounts(data)
+			}
+			return nil
+		})
 
-		imapConn := client.Client()
-		if imapConn == nil {
-			return ErrorMsg{Err: fmt.Errorf("IMAP client not initialized")}
+		if len(counts) == 0 {
+			return nil
 		}
+		return MailboxCountsLoadedMsg{Account: account, Counts: counts}
+	}
+}
 
-		selectData, err := imapConn.Select(mailbox, nil).Wait()
+func newMailboxCounts(data *imap.StatusData) MailboxCounts {
+	var counts MailboxCounts
+	if data.NumUnseen != nil {
+		counts.Unread = *data.NumUnseen
+	}
+	if data.NumMessages != nil {
+		counts.Total = *data.NumMessages
+	}
+	return counts
+}
+
+// createMailboxCmd creates a mailbox and subscribes to it, so it is listed
+// when only subscribed mailboxes are shown
+func createMailboxCmd(account string, client *imapClient.Client, name string) tea.Cmd {
+	return func() tea.Msg {
+		err := client.Do(context.Background(), "", func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			if err := imapConn.Create(name, nil).Wait(); err != nil {
+				return err
+			}
+			return imapConn.Subscribe(name).Wait()
+		})
 		if err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to select mailbox %s: %w", mailbox, err)}
+			return MailboxErrorMsg{Err: fmt.Errorf("failed to create %s: %w", name, err)}
 		}
+		return MailboxChangedMsg{Account: account, Mailbox: name, Notice: "Created " + name}
+	}
+}
 
-		total := selectData.NumMessages
-		if total == 0 {
-			return EmailsLoadedMsg{Emails: []email.Message{}, Total: 0}
+// renameMailboxCmd renames a mailbox together with the mailboxes below it.
+// Servers leave subscriptions alone on RENAME, so a subscribed mailbox is
+// subscribed again under its new name.
+func renameMailboxCmd(account string, client *imapClient.Client, name, newName string, subscribed bool) tea.Cmd {
+	return func() tea.Msg {
+		err := client.Do(context.Background(), "", func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			if err := imapConn.Rename(name, newName, nil).Wait(); err != nil {
+				return err
+			}
+			if !subscribed {
+				return nil
+			}
+			// A stale subscription is harmless, so a failure to drop it
+			// doesn't fail the rename
+			_ = imapConn.Unsubscribe(name).Wait()
+			return imapConn.Subscribe(newName).Wait()
+		})
+		if err != nil {
+			return MailboxErrorMsg{Err: fmt.Errorf("failed to rename %s: %w", name, err)}
 		}
+		return MailboxChangedMsg{Account: account, Mailbox: name, NewName: newName, Notice: fmt.Sprintf("Renamed %s to %s", name, newName)}
+	}
+}
 
-		var seqSet imap.SeqSet
-		if total <= pageSize {
-			seqSet.AddRange(1, total)
-		} else {
-			start := total - pageSize + 1
-			seqSet.AddRange(start, total)
+// deleteMailboxCmd deletes a mailbox with its messages and drops its
+// subscription
+func deleteMailboxCmd(account string, client *imapClient.Client, name string) tea.Cmd {
+	return func() tea.Msg {
+		err := client.Do(context.Background(), "", func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			// Some servers refuse to delete the selected mailbox
+			if selected := imapConn.Mailbox(); selected != nil && selected.Name == name && imapConn.Caps().Has(imap.CapUnselect) {
+				if err := imapConn.Unselect().Wait(); err != nil {
+					return err
+				}
+			}
+			if err := imapConn.Delete(name).Wait(); err != nil {
+				return err
+			}
+			_ = imapConn.Unsubscribe(name).Wait()
+			return nil
+		})
+		if err != nil {
+			return MailboxErrorMsg{Err: fmt.Errorf("failed to delete %s: %w", name, err)}
 		}
+		return MailboxChangedMsg{Account: account, Mailbox: name, Deleted: true, Notice: "Deleted " + name}
+	}
+}
 
-		fetchOptions := &imap.FetchOptions{
-			UID:      true,
-			Envelope: true,
-			Flags:    true,
+// setSubscribedCmd subscribes to a mailbox or unsubscribes from it
+func setSubscribedCmd(account string, client *imapClient.Client, name string, subscribe bool) tea.Cmd {
+	return func() tea.Msg {
+		err := client.Do(context.Background(), "", func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			if subscribe {
+				return imapConn.Subscribe(name).Wait()
+			}
+			return imapConn.Unsubscribe(name).Wait()
+		})
+
+		action, notice := "subscribe to", "Subscribed to "+name
+		if !subscribe {
+			action, notice = "unsubscribe from", "Unsubscribed from "+name
+		}
+		if err != nil {
+			return MailboxErrorMsg{Err: fmt.Errorf("failed to %s %s: %w", action, name, err)}
 		}
+		return MailboxChangedMsg{Account: account, Mailbox: name, Notice: notice}
+	}
+}
 
-		fetchCmd := imapConn.Fetch(seqSet, fetchOptions)
-		messages := make([]email.Message, 0, pageSize)
+// loadEmailsCmd fetches the newest page of mailbox. Nothing is delivered
+// once ctx is cancelled, e.g. because the user switched folders.
+func loadEmailsCmd(ctx context.Context, account string, client *imapClient.Client, mailbox string, pageSize uint32) tea.Cmd {
+	return retryable(func() tea.Msg {
+		var messages []email.Message
+		var total uint32
+
+		err := client.DoIdempotent(ctx, mailbox, func(imapConn *imapclient.Client, selected *imap.SelectData) error {
+			total = selected.NumMessages
+			if total == 0 {
+				return nil
+			}
 
-		for {
-			msgData := fetchCmd.Next()
-			if msgData == nil {
-				break
+			var seqSet imap.SeqSet
+			if total <= pageSize {
+				seqSet.AddRange(1, total)
+			} else {
+				start := total - pageSize + 1
+				seqSet.AddRange(start, total)
 			}
 
-			var uid imap.UID
-			var envelope *imap.Envelope
-			var flags []imap.Flag
+			var err error
+			messages, err = fetchEnvelopes(imapConn, seqSet)
+			if err != nil {
+				return fmt.Errorf("failed to fetch emails: %w", err)
+			}
+			return nil
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return ErrorMsg{Account: account, Err: err}
+		}
 
-			for {
-				item := msgData.Next()
-				if item == nil {
-					break
-				}
+		if messages == nil {
+			messages = []email.Message{}
+		}
+		return EmailsLoadedMsg{Mailbox: mailbox, Emails: messages, Total: total}
+	})
+}
 
-				switch item := item.(type) {
-				case imapclient.FetchItemDataUID:
-					uid = item.UID
-				case imapclient.FetchItemDataEnvelope:
-					envelope = item.Envelope
-				case imapclient.FetchItemDataFlags:
-					flags = item.Flags
-				}
+// loadOlderEmailsCmd fetches the page of mailbox just older than the
+// message with UID before. Going by UID rather than sequence number keeps
+// mail that arrives meanwhile from shifting the page.
+func loadOlderEmailsCmd(ctx context.Context, account string, client *imapClient.Client, mailbox string, before, pageSize uint32) tea.Cmd {
+	return retryable(func() tea.Msg {
+		var messages []email.Message
+		var total uint32
+
+		err := client.DoIdempotent(ctx, mailbox, func(imapConn *imapclient.Client, selected *imap.SelectData) error {
+			total = selected.NumMessages
+			if before <= 1 {
+				return nil
 			}
 
-			if envelope != nil {
-				emailMsg := email.Message{
-					UID:     uint32(uid),
-					Subject: envelope.Subject,
-					Date:    envelope.Date,
-					Flags:   convertFlags(flags),
-				}
-
-				if len(envelope.From) > 0 {
-					emailMsg.From = convertAddresses(envelope.From)
-				}
+			var older imap.UIDSet
+			older.AddRange(1, imap.UID(before-1))
+			searchData, err := imapConn.UIDSearch(&imap.SearchCriteria{UID: []imap.UIDSet{older}}, nil).Wait()
+			if err != nil {
+				return fmt.Errorf("failed to list older emails: %w", err)
+			}
 
-				if len(envelope.To) > 0 {
-					emailMsg.To = convertAddresses(envelope.To)
-				}
+			uids := searchData.AllUIDs()
+			if len(uids) == 0 {
+				return nil
+			}
+			sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+			if len(uids) > int(pageSize) {
+				uids = uids[len(uids)-int(pageSize):]
+			}
 
-				messages = append(messages, emailMsg)
+			messages, err = fetchEnvelopes(imapConn, imap.UIDSetNum(uids...))
+			if err != nil {
+				return fmt.Errorf("failed to fetch older emails: %w", err)
 			}
+			return nil
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return ErrorMsg{Account: account, Err: err}
 		}
 
-		if err := fetchCmd.Close(); err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to fetch emails: %w", err)}
+		if messages == nil {
+			messages = []email.Message{}
 		}
+		return OlderEmailsLoadedMsg{Mailbox: mailbox, Emails: messages, Total: total}
+	})
+}
 
-		return EmailsLoadedMsg{Emails: messages, Total: total}
+// loadUnifiedCmd fetches the newest page of a mailbox merged into All
+// Inboxes and tags the result with it
+func loadUnifiedCmd(ctx context.Context, client *imapClient.Client, source MailboxRef, pageSize uint32) tea.Cmd {
+	return func() tea.Msg {
+		switch msg := loadEmailsCmd(ctx, source.Account, client, source.Mailbox, pageSize)().(type) {
+		case EmailsLoadedMsg:
+			return UnifiedEmailsLoadedMsg{Source: source, Emails: msg.Emails, Total: msg.Total}
+		case ErrorMsg:
+			if msg.Retry != nil {
+				msg.Retry = loadUnifiedCmd(ctx, client, source, pageSize)
+			}
+			return msg
+		default:
+			return msg
+		}
 	}
 }
 
-func loadEmailBodyCmd(client *imapClient.Client, c *cache.Cache, uid uint32) tea.Cmd {
-	return func() tea.Msg {
-		cacheKey := strconv.FormatUint(uint64(uid), 10)
+// fetchEnvelopes returns the list view data of the given messages
+func fetchEnvelopes(imapConn *imapclient.Client, numSet imap.NumSet) ([]email.Message, error) {
+	fetchOptions := &imap.FetchOptions{
+		UID:      true,
+		Envelope: true,
+		Flags:    true,
+	}
+
+	fetchCmd := imapConn.Fetch(numSet, fetchOptions)
+	messages := make([]email.Message, 0)
+
+	for {
+		msgData := fetchCmd.Next()
+		if msgData == nil {
+			break
+		}
+
+		var uid imap.UID
+		var envelope *imap.Envelope
+		var flags []imap.Flag
+
+		for {
+			item := msgData.Next()
+			if item == nil {
+				break
+			}
+
+			switch item := item.(type) {
+			case imapclient.FetchItemDataUID:
+				uid = item.UID
+			case imapclient.FetchItemDataEnvelope:
+				envelope = item.Envelope
+			case imapclient.FetchItemDataFlags:
+				flags = item.Flags
+			}
+		}
+
+		if envelope != nil {
+			emailMsg := email.Message{
+				UID:     uint32(uid),
+				Subject: envelope.Subject,
+				Date:    envelope.Date,
+				Flags:   convertFlags(flags),
+			}
+
+			if len(envelope.From) > 0 {
+				emailMsg.From = convertAddresses(envelope.From)
+			}
 
-		if cachedBody, ok := c.Get(cacheKey); ok {
-			if bodyStr, ok := cachedBody.(string); ok {
-				return EmailBodyLoadedMsg{UID: uid, Body: bodyStr}
+			if len(envelope.To) > 0 {
+				emailMsg.To = convertAddresses(envelope.To)
 			}
+
+			messages = append(messages, emailMsg)
 		}
+	}
+
+	if err := fetchCmd.Close(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
 
-		if !client.IsConnected() {
-			return ErrorMsg{Err: fmt.Errorf("not connected to IMAP server")}
+func loadEmailBodyCmd(ctx context.Context, account string, client *imapClient.Client, c *cache.Cache, mailbox string, uid uint32) tea.Cmd {
+	return retryable(func() tea.Msg {
+		loaded, err := fetchEmailBody(ctx, client, c, mailbox, uid)
+		if ctx.Err() != nil {
+			return nil
 		}
+		if err != nil {
+			return ErrorMsg{Account: account, Err: err}
+		}
+		loaded.Account, loaded.Mailbox = account, mailbox
+		return loaded
+	})
+}
+
+// fetchEmailBody downloads, parses and renders a full message, keeping the
+// raw bytes around for forwarding. Results are served from the cache when
+// possible. UIDs are only unique within a mailbox, so the mailbox is part
+// of the cache key.
+func fetchEmailBody(ctx context.Context, client *imapClient.Client, c *cache.Cache, mailbox string, uid uint32) (EmailBodyLoadedMsg, error) {
+	cacheKey := mailbox + "/" + strconv.FormatUint(uint64(uid), 10)
 
-		imapConn := client.Client()
-		if imapConn == nil {
-			return ErrorMsg{Err: fmt.Errorf("IMAP client not initialized")}
+	if cached, ok := c.Get(cacheKey); ok {
+		if loaded, ok := cached.(EmailBodyLoadedMsg); ok {
+			return loaded, nil
 		}
+	}
 
+	var bodyBytes []byte
+	err := client.DoIdempotent(ctx, mailbox, func(imapConn *imapclient.Client, _ *imap.SelectData) error {
 		var uidSet imap.UIDSet
 		uidSet.AddNum(imap.UID(uid))
 
@@ -185,7 +501,8 @@ func loadEmailBodyCmd(client *imapClient.Client, c *cache.Cache, uid uint32) tea
 		fetchCmd := imapConn.Fetch(uidSet, fetchOptions)
 		msgData := fetchCmd.Next()
 		if msgData == nil {
-			return ErrorMsg{Err: fmt.Errorf("email with UID %d not found", uid)}
+			_ = fetchCmd.Close()
+			return fmt.Errorf("email with UID %d not found", uid)
 		}
 
 		var bodySection imapclient.FetchItemDataBodySection
@@ -203,193 +520,317 @@ func loadEmailBodyCmd(client *imapClient.Client, c *cache.Cache, uid uint32) tea
 		}
 
 		if bodySection.Literal == nil {
-			return ErrorMsg{Err: fmt.Errorf("email body not found for UID %d", uid)}
-		}
-
-		bodyBytes, err := io.ReadAll(bodySection.Literal)
-		if err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to read email body: %w", err)}
+			_ = fetchCmd.Close()
+			return fmt.Errorf("email body not found for UID %d", uid)
 		}
 
-		parsedEmail, err := email.Parse(bodyBytes)
+		var err error
+		bodyBytes, err = io.ReadAll(bodySection.Literal)
 		if err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to parse email: %w", err)}
+			_ = fetchCmd.Close()
+			return fmt.Errorf("failed to read email body: %w", err)
 		}
 
 		if err := fetchCmd.Close(); err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to close fetch command: %w", err)}
+			return fmt.Errorf("failed to close fetch command: %w", err)
 		}
+		return nil
+	})
+	if err != nil {
+		return EmailBodyLoadedMsg{}, err
+	}
 
-		renderedBody, err := email.Render(parsedEmail.Body)
-		if err != nil || renderedBody == "" {
-			if parsedEmail.Body != nil {
-				renderedBody = parsedEmail.Body.Text
-			}
+	parsedEmail, err := email.Parse(bodyBytes)
+	if err != nil {
+		return EmailBodyLoadedMsg{}, fmt.Errorf("failed to parse email: %w", err)
+	}
+
+	renderedBody, err := email.Render(parsedEmail.Body)
+	if err != nil || renderedBody == "" {
+		if parsedEmail.Body != nil {
+			renderedBody = parsedEmail.Body.Text
 		}
+	}
 
-		c.Set(cacheKey, renderedBody)
+	loaded := EmailBodyLoadedMsg{UID: uid, Body: renderedBody, Message: parsedEmail, Raw: bodyBytes}
+	c.Set(cacheKey, loaded)
 
-		return EmailBodyLoadedMsg{UID: uid, Body: renderedBody}
-	}
+	return loaded, nil
 }
 
-func markReadCmd(client *imapClient.Client, uid uint32, read bool) tea.Cmd {
-	return func() tea.Msg {
-		if !client.IsConnected() {
-			return ErrorMsg{Err: fmt.Errorf("not connected to IMAP server")}
+func forwardEmailCmd(account string, client *imapClient.Client, c *cache.Cache, mailbox string, uid uint32, asAttachment bool) tea.Cmd {
+	return retryable(func() tea.Msg {
+		loaded, err := fetchEmailBody(context.Background(), client, c, mailbox, uid)
+		if err != nil {
+			return ErrorMsg{Account: account, Err: fmt.Errorf("failed to load email for forwarding: %w", err)}
 		}
 
-		imapConn := client.Client()
-		if imapConn == nil {
-			return ErrorMsg{Err: fmt.Errorf("IMAP client not initialized")}
+		return ForwardReadyMsg{Draft: *email.NewForward(loaded.Message, loaded.Raw, asAttachment)}
+	})
+}
+
+func openDraftCmd(account string, client *imapClient.Client, c *cache.Cache, mailbox string, uid uint32) tea.Cmd {
+	return retryable(func() tea.Msg {
+		loaded, err := fetchEmailBody(context.Background(), client, c, mailbox, uid)
+		if err != nil {
+			return ErrorMsg{Account: account, Err: fmt.Errorf("failed to open draft: %w", err)}
 		}
 
-		var uidSet imap.UIDSet
-		uidSet.AddNum(imap.UID(uid))
+		return DraftOpenedMsg{Account: account, Draft: editableDraft(loaded.Message, uid)}
+	})
+}
 
-		storeFlags := imap.StoreFlags{
-			Op:     imap.StoreFlagsSet,
-			Flags:  []imap.Flag{imap.FlagSeen},
-			Silent: true,
+// editableDraft copies the parts of a stored message that compose edits
+func editableDraft(orig *email.Message, uid uint32) email.Message {
+	return email.Message{
+		UID:         uid,
+		From:        orig.From,
+		To:          orig.To,
+		Cc:          orig.Cc,
+		Bcc:         orig.Bcc,
+		Subject:     orig.Subject,
+		InReplyTo:   orig.InReplyTo,
+		References:  orig.References,
+		Body:        &email.Body{Text: email.PlainText(orig.Body)},
+		Attachments: orig.Attachments,
+	}
+}
+
+// saveDraftCmd appends a draft to the Drafts mailbox, creating the mailbox
+// first when the server has none. A draft that was reopened from the server
+// (replaceUID != 0) is removed once the new revision is stored.
+func saveDraftCmd(account string, client *imapClient.Client, draftsMailbox string, create bool, draft email.Message, replaceUID uint32) tea.Cmd {
+	return func() tea.Msg {
+		raw, err := email.BuildDraft(&draft)
+		if err != nil {
+			return DraftErrorMsg{Err: fmt.Errorf("failed to build draft: %w", err)}
 		}
 
-		if !read {
-			storeFlags.Op = imap.StoreFlagsDel
+		var uid imap.UID
+		err = client.Do(context.Background(), "", func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			var err error
+			uid, err = appendToMailbox(imapConn, draftsMailbox, create, raw, imap.FlagDraft, imap.FlagSeen)
+			return err
+		})
+		if err != nil {
+			return DraftErrorMsg{Err: fmt.Errorf("failed to save draft: %w", err)}
 		}
 
-		if err := imapConn.Store(uidSet, &storeFlags, nil).Close(); err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to mark email as read: %w", err)}
+		if replaceUID != 0 {
+			err := client.Do(context.Background(), draftsMailbox, func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+				return removeMessage(imapConn, replaceUID)
+			})
+			if err != nil {
+				return DraftErrorMsg{Err: fmt.Errorf("draft saved but the previous version was kept: %w", err)}
+			}
 		}
 
-		return nil
+		return DraftSavedMsg{Account: account, Mailbox: draftsMailbox, UID: uint32(uid)}
 	}
 }
 
-func deleteEmailCmd(client *imapClient.Client, uid uint32) tea.Cmd {
+// saveSentCopyCmd appends the bytes of a delivered message to the Sent
+// mailbox so it shows up in other clients
+func saveSentCopyCmd(account string, client *imapClient.Client, sentMailbox string, create bool, raw []byte) tea.Cmd {
 	return func() tea.Msg {
-		if !client.IsConnected() {
-			return ErrorMsg{Err: fmt.Errorf("not connected to IMAP server")}
+		err := client.Do(context.Background(), "", func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			_, err := appendToMailbox(imapConn, sentMailbox, create, raw, imap.FlagSeen)
+			return err
+		})
+		if err != nil {
+			return SentCopyErrorMsg{Err: fmt.Errorf("message sent but not copied to %s: %w", sentMailbox, err)}
 		}
 
-		imapConn := client.Client()
-		if imapConn == nil {
-			return ErrorMsg{Err: fmt.Errorf("IMAP client not initialized")}
+		return SentCopySavedMsg{Account: account, Mailbox: sentMailbox}
+	}
+}
+
+// appendToMailbox stores a message with the given flags, creating the
+// mailbox first if asked to. The UID is zero unless the server supports
+// UIDPLUS.
+func appendToMailbox(imapConn *imapclient.Client, mailbox string, create bool, raw []byte, flags ...imap.Flag) (imap.UID, error) {
+	if create {
+		if err := imapConn.Create(mailbox, nil).Wait(); err != nil {
+			return 0, fmt.Errorf("failed to create mailbox %s: %w", mailbox, err)
 		}
+	}
 
-		var uidSet imap.UIDSet
-		uidSet.AddNum(imap.UID(uid))
+	appendCmd := imapConn.Append(mailbox, int64(len(raw)), &imap.AppendOptions{
+		Flags: flags,
+		Time:  time.Now(),
+	})
+	if _, err := appendCmd.Write(raw); err != nil {
+		_ = appendCmd.Close()
+		return 0, err
+	}
+	if err := appendCmd.Close(); err != nil {
+		return 0, err
+	}
 
-		storeFlags := imap.StoreFlags{
-			Op:     imap.StoreFlagsAdd,
-			Flags:  []imap.Flag{imap.FlagDeleted},
-			Silent: true,
-		}
+	data, err := appendCmd.Wait()
+	if err != nil {
+		return 0, err
+	}
 
-		if err := imapConn.Store(uidSet, &storeFlags, nil).Close(); err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to delete email: %w", err)}
-		}
+	return data.UID, nil
+}
 
-		if err := imapConn.Expunge().Close(); err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to expunge deleted email: %w", err)}
+// deleteDraftCmd removes a draft from the server once it has been sent
+func deleteDraftCmd(client *imapClient.Client, draftsMailbox string, uid uint32) tea.Cmd {
+	return func() tea.Msg {
+		err := client.Do(context.Background(), draftsMailbox, func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			return removeMessage(imapConn, uid)
+		})
+		if err != nil {
+			return DraftErrorMsg{Err: fmt.Errorf("failed to remove sent draft: %w", err)}
 		}
 
-		return nil
+		return DraftDeletedMsg{Mailbox: draftsMailbox, UID: uid}
 	}
 }
 
-func searchEmailsCmd(client *imapClient.Client, mailbox, query string) tea.Cmd {
-	return func() tea.Msg {
-		if !client.IsConnected() {
-			return ErrorMsg{Err: fmt.Errorf("not connected to IMAP server")}
-		}
+// removeMessage expunges a single message from the selected mailbox
+func removeMessage(imapConn *imapclient.Client, uid uint32) error {
+	var uidSet imap.UIDSet
+	uidSet.AddNum(imap.UID(uid))
 
-		imapConn := client.Client()
-		if imapConn == nil {
-			return ErrorMsg{Err: fmt.Errorf("IMAP client not initialized")}
-		}
+	storeFlags := imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Flags:  []imap.Flag{imap.FlagDeleted},
+		Silent: true,
+	}
 
-		if _, err := imapConn.Select(mailbox, nil).Wait(); err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to select mailbox %s for search: %w", mailbox, err)}
+	if err := imapConn.Store(uidSet, &storeFlags, nil).Close(); err != nil {
+		return fmt.Errorf("failed to flag message as deleted: %w", err)
+	}
+
+	// UID EXPUNGE leaves other messages flagged \Deleted alone
+	var expungeCmd *imapclient.ExpungeCommand
+	if imapConn.Caps().Has(imap.CapUIDPlus) {
+		expungeCmd = imapConn.UIDExpunge(uidSet)
+	} else {
+		expungeCmd = imapConn.Expunge()
+	}
+	if err := expungeCmd.Close(); err != nil {
+		return fmt.Errorf("failed to expunge message: %w", err)
+	}
+
+	return nil
+}
+
+func markReadCmd(account string, client *imapClient.Client, mailbox string, uid uint32, read bool) tea.Cmd {
+	return retryable(func() tea.Msg {
+		var uidSet imap.UIDSet
+		uidSet.AddNum(imap.UID(uid))
+
+		storeFlags := imap.StoreFlags{
+			Op:     imap.StoreFlagsSet,
+			Flags:  []imap.Flag{imap.FlagSeen},
+			Silent: true,
 		}
 
-		criteria := buildSearchCriteria(query)
+		if !read {
+			storeFlags.Op = imap.StoreFlagsDel
+		}
 
-		searchData, err := imapConn.UIDSearch(criteria, nil).Wait()
+		err := client.DoIdempotent(context.Background(), mailbox, func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			return imapConn.Store(uidSet, &storeFlags, nil).Close()
+		})
 		if err != nil {
-			return ErrorMsg{Err: fmt.Errorf("search failed: %w", err)}
+			return ErrorMsg{Account: account, Err: fmt.Errorf("failed to mark email as read: %w", err)}
 		}
 
-		allUIDs := searchData.AllUIDs()
-		if len(allUIDs) == 0 {
-			return EmailsLoadedMsg{Emails: []email.Message{}, Total: 0}
-		}
+		return nil
+	})
+}
 
+func deleteEmailCmd(account string, client *imapClient.Client, mailbox string, uid uint32) tea.Cmd {
+	return retryable(func() tea.Msg {
 		var uidSet imap.UIDSet
-		for _, uid := range allUIDs {
-			uidSet.AddNum(uid)
+		uidSet.AddNum(imap.UID(uid))
+
+		storeFlags := imap.StoreFlags{
+			Op:     imap.StoreFlagsAdd,
+			Flags:  []imap.Flag{imap.FlagDeleted},
+			Silent: true,
 		}
 
-		fetchOptions := &imap.FetchOptions{
-			UID:      true,
-			Envelope: true,
-			Flags:    true,
+		err := client.Do(context.Background(), mailbox, func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			if err := imapConn.Store(uidSet, &storeFlags, nil).Close(); err != nil {
+				return fmt.Errorf("failed to delete email: %w", err)
+			}
+			if err := imapConn.Expunge().Close(); err != nil {
+				return fmt.Errorf("failed to expunge deleted email: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return ErrorMsg{Account: account, Err: err}
 		}
 
-		fetchCmd := imapConn.Fetch(uidSet, fetchOptions)
-		messages := make([]email.Message, 0)
+		return nil
+	})
+}
 
-		for {
-			msgData := fetchCmd.Next()
-			if msgData == nil {
-				break
+// moveEmailCmd moves a message to another mailbox of the same account.
+// Servers without MOVE get a copy, and the original is expunged.
+func moveEmailCmd(account string, client *imapClient.Client, mailbox string, uid uint32, to string) tea.Cmd {
+	return retryable(func() tea.Msg {
+		err := client.Do(context.Background(), mailbox, func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			if _, err := imapConn.Move(imap.UIDSetNum(imap.UID(uid)), to).Wait(); err != nil {
+				return fmt.Errorf("failed to move email to %s: %w", to, err)
 			}
+			return nil
+		})
+		if err != nil {
+			return ErrorMsg{Account: account, Err: err}
+		}
 
-			var uid imap.UID
-			var envelope *imap.Envelope
-			var flags []imap.Flag
+		return EmailMovedMsg{Account: account, Mailbox: mailbox, To: to}
+	})
+}
 
-			for {
-				item := msgData.Next()
-				if item == nil {
-					break
-				}
+// searchEmailsCmd lists the messages in mailbox matching query. Nothing is
+// delivered once ctx is cancelled.
+func searchEmailsCmd(ctx context.Context, account string, client *imapClient.Client, mailbox, query string) tea.Cmd {
+	return retryable(func() tea.Msg {
+		criteria := buildSearchCriteria(query)
+		var messages []email.Message
 
-				switch item := item.(type) {
-				case imapclient.FetchItemDataUID:
-					uid = item.UID
-				case imapclient.FetchItemDataEnvelope:
-					envelope = item.Envelope
-				case imapclient.FetchItemDataFlags:
-					flags = item.Flags
-				}
+		err := client.DoIdempotent(ctx, mailbox, func(imapConn *imapclient.Client, _ *imap.SelectData) error {
+			searchData, err := imapConn.UIDSearch(criteria, nil).Wait()
+			if err != nil {
+				return fmt.Errorf("search failed: %w", err)
 			}
 
-			if envelope != nil {
-				emailMsg := email.Message{
-					UID:     uint32(uid),
-					Subject: envelope.Subject,
-					Date:    envelope.Date,
-					Flags:   convertFlags(flags),
-				}
-
-				if len(envelope.From) > 0 {
-					emailMsg.From = convertAddresses(envelope.From)
-				}
+			allUIDs := searchData.AllUIDs()
+			if len(allUIDs) == 0 {
+				return nil
+			}
 
-				if len(envelope.To) > 0 {
-					emailMsg.To = convertAddresses(envelope.To)
-				}
+			var uidSet imap.UIDSet
+			for _, uid := range allUIDs {
+				uidSet.AddNum(uid)
+			}
 
-				messages = append(messages, emailMsg)
+			messages, err = fetchEnvelopes(imapConn, uidSet)
+			if err != nil {
+				return fmt.Errorf("failed to fetch search results: %w", err)
 			}
+			return nil
+		})
+		if ctx.Err() != nil {
+			return nil
 		}
-
-		if err := fetchCmd.Close(); err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to fetch search results: %w", err)}
+		if err != nil {
+			return ErrorMsg{Account: account, Err: err}
 		}
 
-		return EmailsLoadedMsg{Emails: messages, Total: uint32(len(messages))}
-	}
+		if messages == nil {
+			messages = []email.Message{}
+		}
+		return EmailsLoadedMsg{Mailbox: mailbox, Emails: messages, Total: uint32(len(messages))}
+	})
 }
 
 func buildSearchCriteria(query string) *imap.SearchCriteria {
@@ -428,126 +869,313 @@ func convertFlags(imapFlags []imap.Flag) []string {
 	return flags
 }
 
-func sortMailboxes(mailboxes []string) []string {
-	priorityOrder := []struct {
-		name    string
-		aliases []string
-	}{
-		{"INBOX", []string{"Inbox", "inbox", "INBOX"}},
-		{"Sent", []string{"Sent", "sent", "SENT", "Sent Messages", "[Gmail]/Sent Mail"}},
-		{"Drafts", []string{"Draft", "draft", "DRAFT", "Drafts", "DRAFTS", "[Gmail]/Drafts"}},
-		{"All Mail", []string{"All Mail", "all mail", "ALL MAIL", "All mail", "[Gmail]/All Mail"}},
+// saveCollapsedCmd remembers whether a mailbox of account is collapsed in
+// the tree
+func saveCollapsedCmd(state *folders.State, account, mailbox string, collapsed bool) tea.Cmd {
+	return func() tea.Msg {
+		if err := state.SetCollapsed(account, mailbox, collapsed); err != nil {
+			return MailboxErrorMsg{Err: err}
+		}
+		return nil
 	}
+}
+
+// mailboxMonitor carries the changes the IMAP client reports for the
+// watched mailbox into the Bubble Tea loop
+type mailboxMonitor struct {
+	mailbox string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	updates chan tea.Msg
+}
 
-	used := make(map[string]bool)
-	var prioritized []string
+// mailboxUpdateMsg wraps a change so the model can wait for the next one
+// from the same monitor
+type mailboxUpdateMsg struct {
+	monitor *mailboxMonitor
+	update  tea.Msg
+}
+
+// newMailboxMonitor watches mailbox for new, expunged and changed
+// messages. The client pushes changes over IDLE when the server supports
+// it and polls every interval otherwise.
+func newMailboxMonitor(client *imapClient.Client, mailbox string, interval time.Duration) *mailboxMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	monitor := &mailboxMonitor{
+		mailbox: mailbox,
+		ctx:     ctx,
+		cancel:  cancel,
+		updates: make(chan tea.Msg, 64),
+	}
+
+	client.SetUpdateHandler(&imapClient.UpdateHandler{
+		Mailbox: mailbox,
+		OnNewMail: func(mb string, count uint32) {
+			monitor.publish(mb, NewEmailMsg{Mailbox: mb, Count: count})
+		},
+		OnExpunge: func(mb string, seqNum uint32) {
+			monitor.publish(mb, EmailExpungedMsg{Mailbox: mb, SeqNum: seqNum})
+		},
+		OnFlagsChanged: func(mb string, seqNum, uid uint32, flags []string) {
+			monitor.publish(mb, FlagsChangedMsg{Mailbox: mb, SeqNum: seqNum, UID: uid, Flags: flags})
+		},
+	})
+	client.MonitorMailbox(ctx, mailbox, interval)
 
-	for _, entry := range priorityOrder {
-		for _, name := range mailboxes {
-			if used[name] {
-				continue
+	return monitor
+}
+
+// publish queues an update without blocking the IMAP reader; when the
+// queue is full the update is dropped, as the pending ones already make
+// the model reload the mailbox
+func (m *mailboxMonitor) publish(mailbox string, msg tea.Msg) {
+	if mailbox != m.mailbox || m.ctx.Err() != nil {
+		return
+	}
+	select {
+	case m.updates <- msg:
+	default:
+	}
+}
+
+// waitForMailboxUpdateCmd blocks until the monitor reports a change or is
+// stopped
+func waitForMailboxUpdateCmd(monitor *mailboxMonitor) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case msg := <-monitor.updates:
+			return mailboxUpdateMsg{monitor: monitor, update: msg}
+		case <-monitor.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func loadAttachmentCmd(path string) tea.Cmd {
+	return func() tea.Msg {
+		att, err := email.LoadAttachment(path)
+		if err != nil {
+			return AttachmentErrorMsg{Err: err}
+		}
+		return AttachmentLoadedMsg{Attachment: att}
+	}
+}
+
+// sendEmailCmd builds and submits a message. When the server cannot be
+// reached the message is stored in the outbox instead of failing, and a
+// replaced outbox entry (replaceID) is dropped once the new version is
+// either sent or queued.
+func sendEmailCmd(sender Sender, queue *outbox.Outbox, draft email.Message, replaceID string) tea.Cmd {
+	return func() tea.Msg {
+		if len(draft.From) == 0 {
+			return SendErrorMsg{Err: fmt.Errorf("no sender address configured")}
+		}
+
+		raw, err := email.Build(&draft)
+		if err != nil {
+			return SendErrorMsg{Err: fmt.Errorf("failed to build message: %w", err)}
+		}
+
+		from, recipients := draft.From[0].Email, draft.Recipients()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		if err := sender.Send(ctx, from, recipients, raw); err != nil {
+			if queue == nil || !smtp.IsTemporary(err) {
+				return SendErrorMsg{Err: err}
 			}
 
-			for _, alias := range entry.aliases {
-				if strings.EqualFold(name, alias) {
-					prioritized = append(prioritized, name)
-					used[name] = true
-					break
-				}
+			entry, queueErr := queue.Add(from, recipients, draft.Subject, raw)
+			if queueErr != nil {
+				return SendErrorMsg{Err: fmt.Errorf("%w (saving to the outbox also failed: %v)", err, queueErr)}
 			}
+			dropOutboxEntry(queue, replaceID)
+			return EmailQueuedMsg{Entry: entry, Err: err}
 		}
+
+		dropOutboxEntry(queue, replaceID)
+		return EmailSentMsg{Subject: draft.Subject, From: from, Raw: raw}
 	}
+}
 
-	var others []string
-	for _, name := range mailboxes {
-		if !used[name] {
-			others = append(others, name)
+// scheduleSendCmd builds a message and puts it in the outbox to be sent
+// at the given time, replacing the outbox entry it was edited from
+func scheduleSendCmd(queue *outbox.Outbox, draft email.Message, at time.Time, replaceID string, undo bool) tea.Cmd {
+	return func() tea.Msg {
+		if len(draft.From) == 0 {
+			return SendErrorMsg{Err: fmt.Errorf("no sender address configured")}
 		}
-	}
 
-	sort.Slice(others, func(i, j int) bool {
-		return strings.ToLower(others[i]) < strings.ToLower(others[j])
-	})
+		raw, err := email.Build(&draft)
+		if err != nil {
+			return SendErrorMsg{Err: fmt.Errorf("failed to build message: %w", err)}
+		}
+
+		entry, err := queue.Schedule(draft.From[0].Email, draft.Recipients(), draft.Subject, raw, at)
+		if err != nil {
+			return SendErrorMsg{Err: err}
+		}
+		dropOutboxEntry(queue, replaceID)
 
-	result := prioritized
-	if len(others) > 0 {
-		result = append(result, "---")
-		result = append(result, others...)
+		return SendScheduledMsg{Entry: entry, Undo: undo}
 	}
+}
 
-	return result
+// undoSendCmd takes a message out of the outbox and back into compose
+func undoSendCmd(queue *outbox.Outbox, id string) tea.Cmd {
+	return func() tea.Msg {
+		msg := openOutboxEntryCmd(queue, id)()
+		opened, ok := msg.(OutboxEntryOpenedMsg)
+		if !ok {
+			return msg
+		}
+
+		if err := queue.Remove(id); err != nil {
+			return OutboxErrorMsg{Err: fmt.Errorf("too late to undo: %w", err)}
+		}
+		opened.ID, opened.Undone = "", id
+		return opened
+	}
 }
 
-type emailMonitor struct {
-	client     *imapClient.Client
-	mailbox    string
-	previous   uint32
-	cancelChan chan struct{}
+// dropOutboxEntry removes the outbox entry a sent message was edited from
+func dropOutboxEntry(queue *outbox.Outbox, id string) {
+	if queue == nil || id == "" {
+		return
+	}
+	// The new version is already on its way, a stale entry at worst shows
+	// up again in the outbox view where it can be cancelled
+	_ = queue.Remove(id)
 }
 
-var activeMonitors = make(map[string]*emailMonitor)
-var monitorsMu sync.Mutex
+// outboxRetryInterval is how often the outbox is checked for due messages
+const outboxRetryInterval = 15 * time.Second
 
-func startMonitoringCmd(client *imapClient.Client, mailbox string, interval time.Duration) tea.Cmd {
-	monitorsMu.Lock()
-	defer monitorsMu.Unlock()
+func outboxTickCmd() tea.Cmd {
+	return tea.Tick(outboxRetryInterval, func(time.Time) tea.Msg {
+		return OutboxTickMsg{}
+	})
+}
 
-	key := mailbox
+func loadOutboxCmd(queue *outbox.Outbox) tea.Cmd {
+	return func() tea.Msg {
+		return loadOutbox(queue)
+	}
+}
 
-	if monitor, exists := activeMonitors[key]; exists {
-		if monitor.cancelChan != nil {
-			close(monitor.cancelChan)
-		}
+// loadOutbox reads the outbox. Entries it had to set aside are reported
+// along with the others rather than in their place.
+func loadOutbox(queue *outbox.Outbox) tea.Msg {
+	entries, err := queue.List()
+	if err != nil && !errors.Is(err, outbox.ErrUnreadable) {
+		return OutboxErrorMsg{Err: err}
+	}
+	return OutboxLoadedMsg{Entries: entries, Unreadable: err}
+}
+
+// flushOutboxCmd submits every due outbox message except skip, which is
+// the entry currently open in compose
+func flushOutboxCmd(sender Sender, queue *outbox.Outbox, skip string) tea.Cmd {
+	return func() tea.Msg {
+		return flushOutbox(sender, queue, skip)
 	}
+}
 
-	monitor := &emailMonitor{
-		client:     client,
-		mailbox:    mailbox,
-		cancelChan: make(chan struct{}),
+// retryOutboxCmd makes a queued message due immediately and flushes the
+// outbox, or only reloads it when sender is nil
+func retryOutboxCmd(sender Sender, queue *outbox.Outbox, id string) tea.Cmd {
+	return func() tea.Msg {
+		if _, err := queue.Retry(id); err != nil {
+			return OutboxErrorMsg{Err: err}
+		}
+		if sender == nil {
+			return loadOutbox(queue)
+		}
+		return flushOutbox(sender, queue, "")
 	}
-	activeMonitors[key] = monitor
+}
 
-	initialCount, err := client.CheckForNewMessages(context.Background(), mailbox)
-	if err == nil {
-		monitor.previous = initialCount
+func flushOutbox(sender Sender, queue *outbox.Outbox, skip string) tea.Msg {
+	due, unreadable := queue.Due(time.Now())
+	if unreadable != nil && !errors.Is(unreadable, outbox.ErrUnreadable) {
+		return OutboxErrorMsg{Err: unreadable}
 	}
 
-	return tea.Tick(interval, func(t time.Time) tea.Msg {
-		monitorsMu.Lock()
-		monitor, exists := activeMonitors[key]
-		if !exists {
-			monitorsMu.Unlock()
-			return nil
+	var sent []outbox.Entry
+	for _, entry := range due {
+		if entry.ID == skip {
+			continue
 		}
-		monitorsMu.Unlock()
 
-		select {
-		case <-monitor.cancelChan:
-			return nil
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		err := sender.Send(ctx, entry.From, entry.Recipients, entry.Raw)
+		cancel()
+
+		switch {
+		case err == nil:
+			err = queue.Remove(entry.ID)
+			sent = append(sent, entry)
+		case smtp.IsTemporary(err):
+			_, err = queue.Defer(entry.ID, err)
 		default:
-			currentCount, err := monitor.client.CheckForNewMessages(context.Background(), monitor.mailbox)
-			if err == nil && currentCount > monitor.previous {
-				monitor.previous = currentCount
-				return NewEmailMsg{Mailbox: monitor.mailbox, Count: currentCount}
-			}
-			return nil
+			_, err = queue.Hold(entry.ID, err)
 		}
-	})
+		if err != nil && !errors.Is(err, outbox.ErrNotFound) {
+			return OutboxErrorMsg{Err: err}
+		}
+	}
+
+	msg := loadOutbox(queue)
+	loaded, ok := msg.(OutboxLoadedMsg)
+	if !ok {
+		return msg
+	}
+	return OutboxFlushedMsg{Sent: sent, Entries: loaded.Entries, Unreadable: errors.Join(unreadable, loaded.Unreadable)}
 }
 
-func stopMonitoringCmd(mailbox string) tea.Cmd {
+// cancelOutboxCmd drops a queued message without sending it
+func cancelOutboxCmd(queue *outbox.Outbox, id string) tea.Cmd {
 	return func() tea.Msg {
-		monitorsMu.Lock()
-		defer monitorsMu.Unlock()
+		if err := queue.Remove(id); err != nil && !errors.Is(err, outbox.ErrNotFound) {
+			return OutboxErrorMsg{Err: err}
+		}
+		return loadOutbox(queue)
+	}
+}
 
-		key := mailbox
-		if monitor, exists := activeMonitors[key]; exists {
-			if monitor.cancelChan != nil {
-				close(monitor.cancelChan)
-			}
-			delete(activeMonitors, key)
+// openOutboxEntryCmd turns a queued message back into an editable draft
+func openOutboxEntryCmd(queue *outbox.Outbox, id string) tea.Cmd {
+	return func() tea.Msg {
+		entry, err := queue.Get(id)
+		if err != nil {
+			return OutboxErrorMsg{Err: err}
 		}
 
-		return nil
+		orig, err := email.Parse(entry.Raw)
+		if err != nil {
+			return OutboxErrorMsg{Err: fmt.Errorf("failed to parse queued message: %w", err)}
+		}
+
+		draft := editableDraft(orig, 0)
+		draft.Bcc = bccRecipients(entry.Recipients, orig)
+		return OutboxEntryOpenedMsg{ID: id, Draft: draft}
+	}
+}
+
+// bccRecipients recovers the Bcc list of a built message, which only
+// survives in the envelope: every recipient not named in To or Cc
+func bccRecipients(recipients []string, msg *email.Message) []email.Address {
+	visible := make(map[string]bool)
+	for _, addr := range append(append([]email.Address{}, msg.To...), msg.Cc...) {
+		visible[strings.ToLower(addr.Email)] = true
+	}
+
+	var bcc []email.Address
+	for _, rcpt := range recipients {
+		if !visible[strings.ToLower(rcpt)] {
+			bcc = append(bcc, email.Address{Email: rcpt})
+		}
 	}
+	return bcc
 }
@@ -0,0 +1,157 @@
+package tui
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chhlga/budge/internal/cache"
+	"github.com/chhlga/budge/internal/email"
+	imapClient "github.com/chhlga/budge/internal/imap"
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+func connectTestClient(t *testing.T) *imapClient.Client {
+	t.Helper()
+
+	addr, cleanupServer := startIMAPMemServer(t)
+	t.Cleanup(cleanupServer)
+
+	client := imapClient.NewClient(&imapClient.Options{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "user",
+		Password: "pass",
+	})
+	t.Cleanup(func() { _ = client.Disconnect() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	if err := client.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error: %v", err)
+	}
+
+	return client
+}
+
+func draftUIDs(t *testing.T, conn *imapclient.Client, mailbox string) []imap.UID {
+	t.Helper()
+
+	if _, err := conn.Select(mailbox, nil).Wait(); err != nil {
+		t.Fatalf("Select(%s) error: %v", mailbox, err)
+	}
+	data, err := conn.UIDSearch(&imap.SearchCriteria{Flag: []imap.Flag{imap.FlagDraft}}, nil).Wait()
+	if err != nil {
+		t.Fatalf("UIDSearch() error: %v", err)
+	}
+	return data.AllUIDs()
+}
+
+func TestSaveDraftCmd_createsMailboxAndReplacesPreviousVersion(t *testing.T) {
+	client := connectTestClient(t)
+
+	draft := email.Message{
+		From:    []email.Address{{Email: "me@example.com"}},
+		Bcc:     []email.Address{{Email: "hidden@example.com"}},
+		Subject: "Half written",
+		Body:    &email.Body{Text: "first version"},
+	}
+
+	msg := saveDraftCmd("work", client, "Drafts", true, draft, 0)()
+	saved, ok := msg.(DraftSavedMsg)
+	if !ok {
+		t.Fatalf("expected DraftSavedMsg, got %T: %+v", msg, msg)
+	}
+	if saved.UID == 0 {
+		t.Fatalf("expected the server to report the draft UID")
+	}
+
+	draft.Body = &email.Body{Text: "second version"}
+	msg = saveDraftCmd("work", client, "Drafts", false, draft, saved.UID)()
+	resaved, ok := msg.(DraftSavedMsg)
+	if !ok {
+		t.Fatalf("expected DraftSavedMsg, got %T: %+v", msg, msg)
+	}
+
+	uids := draftUIDs(t, client.Client(), "Drafts")
+	if len(uids) != 1 || uint32(uids[0]) != resaved.UID {
+		t.Fatalf("expected only the latest draft (UID %d) to remain, got %v", resaved.UID, uids)
+	}
+
+	opened := openDraftCmd("work", client, cache.New(10), "Drafts", resaved.UID)()
+	reopened, ok := opened.(DraftOpenedMsg)
+	if !ok {
+		t.Fatalf("expected DraftOpenedMsg, got %T: %+v", opened, opened)
+	}
+	if reopened.Draft.UID != resaved.UID {
+		t.Errorf("expected draft UID %d, got %d", resaved.UID, reopened.Draft.UID)
+	}
+	if reopened.Draft.Subject != "Half written" {
+		t.Errorf("unexpected subject %q", reopened.Draft.Subject)
+	}
+	if len(reopened.Draft.Bcc) != 1 || reopened.Draft.Bcc[0].Email != "hidden@example.com" {
+		t.Errorf("expected Bcc to survive the round trip, got %v", reopened.Draft.Bcc)
+	}
+	if got := reopened.Draft.Body.Text; got != "second version" {
+		t.Errorf("unexpected body %q", got)
+	}
+}
+
+func TestDeleteDraftCmd_removesSentDraft(t *testing.T) {
+	client := connectTestClient(t)
+
+	draft := email.Message{From: []email.Address{{Email: "me@example.com"}}, Body: &email.Body{Text: "x"}}
+	saved, ok := saveDraftCmd("work", client, "Drafts", true, draft, 0)().(DraftSavedMsg)
+	if !ok {
+		t.Fatalf("failed to save draft")
+	}
+
+	msg := deleteDraftCmd(client, "Drafts", saved.UID)()
+	if _, ok := msg.(DraftDeletedMsg); !ok {
+		t.Fatalf("expected DraftDeletedMsg, got %T: %+v", msg, msg)
+	}
+	if uids := draftUIDs(t, client.Client(), "Drafts"); len(uids) != 0 {
+		t.Fatalf("expected Drafts to be empty, got %v", uids)
+	}
+}
+
+func TestEmailSelectedInDrafts_opensCompose(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.account().mailboxes = []MailboxInfo{{Name: "INBOX"}, {Name: "Drafts"}}
+	m.currentMailbox = "Drafts"
+
+	raw := []byte("From: me@example.com\r\nTo: bob@example.com\r\nSubject: Later\r\n\r\nTo be continued\r\n")
+	parsed, err := email.Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	m.account().cache.Set("Drafts/9", EmailBodyLoadedMsg{UID: 9, Message: parsed, Raw: raw})
+
+	updated, cmd := m.Update(EmailSelectedMsg{Email: email.Message{UID: 9}})
+	m = updated.(Model)
+	if m.state == emailReaderView {
+		t.Fatalf("expected drafts not to open in the reader")
+	}
+	if cmd == nil {
+		t.Fatalf("expected open draft command")
+	}
+
+	opened := openDraftCmd(m.account().name, m.account().client, m.account().cache, "Drafts", 9)()
+	updated, _ = m.Update(opened)
+	m = updated.(Model)
+
+	if m.state != composeView {
+		t.Fatalf("expected state=composeView, got %v", m.state)
+	}
+	if m.compose.DraftUID() != 9 {
+		t.Errorf("expected compose to remember draft UID 9, got %d", m.compose.DraftUID())
+	}
+	if got := m.compose.inputs[composeTo].Value(); got != "bob@example.com" {
+		t.Errorf("unexpected To %q", got)
+	}
+}
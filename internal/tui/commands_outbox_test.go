@@ -0,0 +1,393 @@
+package tui
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/chhlga/budge/internal/email"
+	"github.com/chhlga/budge/internal/outbox"
+	"github.com/chhlga/budge/internal/smtp"
+	gosmtp "github.com/emersion/go-smtp"
+)
+
+func openTestOutbox(t *testing.T) *outbox.Outbox {
+	t.Helper()
+
+	queue, err := outbox.Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("outbox.Open() error: %v", err)
+	}
+	return queue
+}
+
+var errOffline = &smtp.ConnectionError{Op: "dial", Err: errors.New("network is unreachable")}
+
+func TestSendEmailCmd_queuesWhenOffline(t *testing.T) {
+	queue := openTestOutbox(t)
+	m := newComposeTestModel(&fakeSender{err: errOffline})
+	m.SetOutbox(queue)
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'c'}})
+	m = updated.(Model)
+
+	draft := email.Message{
+		From:    []email.Address{{Email: "me@example.com"}},
+		To:      []email.Address{{Email: "bob@example.com"}},
+		Bcc:     []email.Address{{Email: "boss@example.com"}},
+		Subject: "Written on a train",
+		Body:    &email.Body{Text: "Tunnel ahead"},
+	}
+	msg := sendEmailCmd(m.account().sender, queue, draft, "")()
+	queued, ok := msg.(EmailQueuedMsg)
+	if !ok {
+		t.Fatalf("expected EmailQueuedMsg, got %T: %+v", msg, msg)
+	}
+	if queued.Entry.Subject != "Written on a train" || len(queued.Entry.Recipients) != 2 {
+		t.Fatalf("unexpected queued entry %+v", queued.Entry)
+	}
+	if queue.Len() != 1 {
+		t.Fatalf("expected 1 queued message, got %d", queue.Len())
+	}
+
+	updated, _ = m.Update(queued)
+	m = updated.(Model)
+	if m.state == composeView {
+		t.Fatalf("expected compose to close once the message is queued")
+	}
+	updated, _ = m.Update(loadOutboxCmd(queue)())
+	m = updated.(Model)
+	if !strings.Contains(m.statusBar.View(), "📤 1 queued") {
+		t.Errorf("expected status bar to show the outbox size, got %q", m.statusBar.View())
+	}
+}
+
+func TestSendEmailCmd_permanentFailureIsNotQueued(t *testing.T) {
+	queue := openTestOutbox(t)
+	sender := &fakeSender{err: &smtp.RecipientError{Recipient: "nobody@example.com", Err: &gosmtp.SMTPError{Code: 550}}}
+
+	draft := email.Message{
+		From: []email.Address{{Email: "me@example.com"}},
+		To:   []email.Address{{Email: "nobody@example.com"}},
+		Body: &email.Body{Text: "Hi"},
+	}
+	if _, ok := sendEmailCmd(sender, queue, draft, "")().(SendErrorMsg); !ok {
+		t.Fatalf("expected SendErrorMsg for a rejected recipient")
+	}
+	if queue.Len() != 0 {
+		t.Errorf("expected nothing queued, got %d", queue.Len())
+	}
+}
+
+func TestFlushOutbox_sendsDefersAndHolds(t *testing.T) {
+	queue := openTestOutbox(t)
+
+	first, _ := queue.Add("me@example.com", []string{"bob@example.com"}, "First", []byte("Subject: First\r\n\r\nHi\r\n"))
+
+	msg := flushOutbox(&fakeSender{err: errOffline}, queue, "")
+	flushed, ok := msg.(OutboxFlushedMsg)
+	if !ok {
+		t.Fatalf("expected OutboxFlushedMsg, got %T: %+v", msg, msg)
+	}
+	if len(flushed.Sent) != 0 || len(flushed.Entries) != 1 || flushed.Entries[0].Attempts != 1 {
+		t.Fatalf("expected the message to be deferred, got %+v", flushed)
+	}
+
+	_, _ = queue.Retry(first.ID)
+	flushed = flushOutbox(&fakeSender{err: &gosmtp.SMTPError{Code: 554}}, queue, "").(OutboxFlushedMsg)
+	if len(flushed.Entries) != 1 || !flushed.Entries[0].Held {
+		t.Fatalf("expected a permanent failure to hold the message, got %+v", flushed.Entries)
+	}
+
+	second, _ := queue.Add("me@example.com", []string{"carol@example.com"}, "Second", []byte("Subject: Second\r\n\r\nHi\r\n"))
+	_, _ = queue.Retry(first.ID)
+
+	sender := &fakeSender{}
+	flushed = flushOutbox(sender, queue, second.ID).(OutboxFlushedMsg)
+	if len(flushed.Sent) != 1 || flushed.Sent[0].ID != first.ID {
+		t.Fatalf("expected only the first message to be sent, got %+v", flushed.Sent)
+	}
+	if sender.from != "me@example.com" || string(sender.raw) != "Subject: First\r\n\r\nHi\r\n" {
+		t.Errorf("expected the queued envelope and bytes to be sent, got %q %q", sender.from, sender.raw)
+	}
+	if len(flushed.Entries) != 1 || flushed.Entries[0].ID != second.ID {
+		t.Errorf("expected the skipped message to stay queued, got %+v", flushed.Entries)
+	}
+}
+
+func TestFlushOutbox_sendsPastUnreadableEntry(t *testing.T) {
+	dir := t.TempDir()
+	queue, err := outbox.Open(dir, nil)
+	if err != nil {
+		t.Fatalf("outbox.Open() error: %v", err)
+	}
+	entry, _ := queue.Add("me@example.com", []string{"bob@example.com"}, "Hi", []byte("Subject: Hi\r\n\r\nHi\r\n"))
+	if err := os.WriteFile(filepath.Join(dir, "0-garbage.json"), []byte("garbage"), 0600); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	msg := flushOutbox(&fakeSender{}, queue, "")
+	flushed, ok := msg.(OutboxFlushedMsg)
+	if !ok {
+		t.Fatalf("expected OutboxFlushedMsg, got %T: %+v", msg, msg)
+	}
+	if len(flushed.Sent) != 1 || flushed.Sent[0].ID != entry.ID {
+		t.Errorf("expected the valid message to be sent, got %+v", flushed.Sent)
+	}
+	if !errors.Is(flushed.Unreadable, outbox.ErrUnreadable) {
+		t.Errorf("expected the garbage entry to be reported, got %v", flushed.Unreadable)
+	}
+
+	m := newComposeTestModel(nil)
+	m.SetOutbox(queue)
+	updated, _ := m.Update(OutboxLoadedMsg{Unreadable: flushed.Unreadable})
+	m = updated.(Model)
+	if !strings.Contains(m.statusBar.View(), "0-garbage.bad") {
+		t.Errorf("expected a notice about the unreadable entry, got %q", m.statusBar.View())
+	}
+}
+
+func TestOutboxEdit_reopensAndReplacesEntry(t *testing.T) {
+	queue := openTestOutbox(t)
+	m := newComposeTestModel(&fakeSender{})
+	m.SetOutbox(queue)
+
+	raw, err := email.Build(&email.Message{
+		From:    []email.Address{{Email: "me@example.com"}},
+		To:      []email.Address{{Email: "bob@example.com"}},
+		Subject: "Wrong attachment",
+		Body:    &email.Body{Text: "See attached"},
+	})
+	if err != nil {
+		t.Fatalf("Build() error: %v", err)
+	}
+	entry, _ := queue.Add("me@example.com", []string{"bob@example.com", "boss@example.com"}, "Wrong attachment", raw)
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'4'}})
+	m = updated.(Model)
+	if m.state != outboxView {
+		t.Fatalf("expected outbox view, got %v", m.state)
+	}
+	updated, _ = m.Update(cmd())
+	m = updated.(Model)
+	if m.outboxList.Len() != 1 {
+		t.Fatalf("expected one listed message, got %d", m.outboxList.Len())
+	}
+
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	updated, cmd = m.Update(cmd())
+	m = updated.(Model)
+	updated, _ = m.Update(cmd())
+	m = updated.(Model)
+
+	if m.state != composeView {
+		t.Fatalf("expected the queued message in compose, got %v", m.state)
+	}
+	if m.compose.OutboxID() != entry.ID {
+		t.Fatalf("expected compose to track outbox entry %s", entry.ID)
+	}
+	if got := m.compose.inputs[composeBcc].Value(); got != "boss@example.com" {
+		t.Errorf("expected Bcc to be recovered from the envelope, got %q", got)
+	}
+	if got := m.editedOutboxID(); got != entry.ID {
+		t.Errorf("expected the edited entry to be skipped by retries, got %q", got)
+	}
+
+	draft, err := m.compose.Draft()
+	if err != nil {
+		t.Fatalf("Draft() error: %v", err)
+	}
+	if _, ok := sendEmailCmd(m.account().sender, queue, draft, m.compose.OutboxID())().(EmailSentMsg); !ok {
+		t.Fatalf("expected the edited message to be sent")
+	}
+	if queue.Len() != 0 {
+		t.Errorf("expected the original entry to be replaced, got %d queued", queue.Len())
+	}
+}
+
+func TestOutboxView_cancelRemovesEntry(t *testing.T) {
+	queue := openTestOutbox(t)
+	m := newComposeTestModel(nil)
+	m.SetOutbox(queue)
+
+	entry, _ := queue.Add("me@example.com", []string{"bob@example.com"}, "Oops", []byte("Subject: Oops\r\n\r\n"))
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'4'}})
+	m = updated.(Model)
+	updated, _ = m.Update(cmd())
+	m = updated.(Model)
+
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'x'}})
+	request, ok := cmd().(OutboxCancelRequestMsg)
+	if !ok || request.ID != entry.ID {
+		t.Fatalf("expected a cancel request for %s, got %+v", entry.ID, request)
+	}
+	updated, _ = m.Update(cancelOutboxCmd(queue, request.ID)())
+	m = updated.(Model)
+
+	if queue.Len() != 0 || m.outboxList.Len() != 0 {
+		t.Fatalf("expected the message to be cancelled")
+	}
+	if strings.Contains(m.statusBar.View(), "queued") {
+		t.Errorf("expected the outbox counter to disappear")
+	}
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = updated.(Model)
+	if m.state != emailListView {
+		t.Errorf("expected esc to return to the previous view, got %v", m.state)
+	}
+}
+
+func composeReadyToSend(t *testing.T, m Model) Model {
+	t.Helper()
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'c'}})
+	m = updated.(Model)
+	m = typeText(m, "bob@example.com")
+	m.compose.inputs[composeSubject].SetValue("Report")
+	m.compose.body.SetValue("Wrong attachment, again")
+	return m
+}
+
+func TestUndoSend_returnsMessageToCompose(t *testing.T) {
+	queue := openTestOutbox(t)
+	sender := &fakeSender{}
+	m := newComposeTestModel(sender)
+	m.account().config.SMTP.UndoSendSeconds = 10
+	m.SetOutbox(queue)
+	m = composeReadyToSend(t, m)
+	m.compose.SetDraftUID(m.account().name, 9)
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	updated, cmd := m.Update(cmd())
+	m = updated.(Model)
+	scheduled, ok := cmd().(SendScheduledMsg)
+	if !ok || !scheduled.Undo {
+		t.Fatalf("expected an undoable SendScheduledMsg, got %+v", scheduled)
+	}
+	if sender.raw != nil {
+		t.Fatalf("expected nothing to be sent during the undo delay")
+	}
+
+	updated, _ = m.Update(scheduled)
+	m = updated.(Model)
+	if m.state == composeView {
+		t.Fatalf("expected compose to close while the send is pending")
+	}
+	if !strings.Contains(m.statusBar.View(), "Sending in 10s") {
+		t.Errorf("expected a countdown in the status bar, got %q", m.statusBar.View())
+	}
+	if draft, ok := m.heldDrafts[scheduled.Entry.ID]; !ok || draft.uid != 9 {
+		t.Fatalf("expected the server draft to be kept during the undo delay, got %+v", m.heldDrafts)
+	}
+
+	updated, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'u'}})
+	m = updated.(Model)
+	if cmd == nil {
+		t.Fatalf("expected undo command")
+	}
+	updated, _ = m.Update(cmd())
+	m = updated.(Model)
+
+	if m.state != composeView {
+		t.Fatalf("expected undo to reopen compose, got %v", m.state)
+	}
+	if got := m.compose.inputs[composeSubject].Value(); got != "Report" {
+		t.Errorf("expected the message back in compose, got subject %q", got)
+	}
+	if m.compose.OutboxID() != "" || queue.Len() != 0 {
+		t.Errorf("expected the message to be taken out of the outbox")
+	}
+	if m.compose.DraftUID() != 9 || len(m.heldDrafts) != 0 {
+		t.Errorf("expected compose to get its server draft back, got UID %d", m.compose.DraftUID())
+	}
+	if strings.Contains(m.statusBar.View(), "Sending in") {
+		t.Errorf("expected the countdown to stop")
+	}
+}
+
+func TestUndoSend_sendsWhenDelayEnds(t *testing.T) {
+	queue := openTestOutbox(t)
+	sender := &fakeSender{}
+	m := newComposeTestModel(sender)
+	m.SetOutbox(queue)
+
+	entry, _ := queue.Schedule("me@example.com", []string{"bob@example.com"}, "Report", []byte("Subject: Report\r\n\r\n"), time.Now().Add(-time.Millisecond))
+	m = composeReadyToSend(t, m)
+	m.compose.SetDraftUID(m.account().name, 9)
+	updated, _ := m.Update(SendScheduledMsg{Entry: entry, Undo: true})
+	m = updated.(Model)
+
+	updated, cmd := m.Update(undoTickMsg{id: entry.ID})
+	m = updated.(Model)
+	if m.undo != nil {
+		t.Fatalf("expected the undo window to close")
+	}
+	if cmd == nil {
+		t.Fatalf("expected the outbox to be flushed")
+	}
+	updated, _ = m.Update(cmd())
+	m = updated.(Model)
+
+	if string(sender.raw) != "Subject: Report\r\n\r\n" {
+		t.Errorf("expected the held message to be sent, got %q", sender.raw)
+	}
+	if queue.Len() != 0 {
+		t.Errorf("expected the outbox to be empty, got %d", queue.Len())
+	}
+	if len(m.heldDrafts) != 0 {
+		t.Errorf("expected the server draft to be removed once the message is sent")
+	}
+
+	if _, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'u'}}); cmd != nil {
+		if _, ok := cmd().(OutboxEntryOpenedMsg); ok {
+			t.Errorf("expected undo to do nothing once the message is sent")
+		}
+	}
+}
+
+func TestCompose_scheduleSend(t *testing.T) {
+	queue := openTestOutbox(t)
+	m := newComposeTestModel(&fakeSender{})
+	m.SetOutbox(queue)
+	m = composeReadyToSend(t, m)
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'s'}, Alt: true})
+	m = updated.(Model)
+	if !m.compose.scheduling {
+		t.Fatalf("expected alt+s to open the send-later prompt")
+	}
+	m = typeText(m, "+1h")
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	request, ok := cmd().(ScheduleSendRequestMsg)
+	if !ok {
+		t.Fatalf("expected ScheduleSendRequestMsg")
+	}
+	if wait := time.Until(request.At); wait < 59*time.Minute || wait > time.Hour {
+		t.Errorf("expected the message scheduled in an hour, got %v", wait)
+	}
+
+	_, cmd = m.Update(request)
+	scheduled, ok := cmd().(SendScheduledMsg)
+	if !ok || scheduled.Undo {
+		t.Fatalf("expected a scheduled send, got %+v", scheduled)
+	}
+	updated, _ = m.Update(scheduled)
+	m = updated.(Model)
+
+	if m.state == composeView || m.undo != nil {
+		t.Errorf("expected compose to close without an undo countdown")
+	}
+	if due, _ := queue.Due(time.Now()); len(due) != 0 {
+		t.Errorf("expected the scheduled message not to be due yet")
+	}
+	if queue.Len() != 1 {
+		t.Errorf("expected the message in the outbox, got %d", queue.Len())
+	}
+}
@@ -0,0 +1,170 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/chhlga/budge/internal/email"
+	imapClient "github.com/chhlga/budge/internal/imap"
+)
+
+func TestLoadOlderEmailsCmd_fetchesPageBelowUID(t *testing.T) {
+	addr, cleanupServer := startIMAPMemServer(t)
+	defer cleanupServer()
+
+	client := imapClient.NewClient(&imapClient.Options{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "user",
+		Password: "pass",
+	})
+	defer func() { _ = client.Disconnect() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	if err := client.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error: %v", err)
+	}
+	for i := 1; i <= 12; i++ {
+		appendMessage(t, client.Client(), "INBOX", fmt.Sprintf("Subject: message %d\r\nFrom: alice@example.com\r\n\r\nBody\r\n", i))
+	}
+
+	msg := loadOlderEmailsCmd(context.Background(), "work", client, "INBOX", 8, 5)()
+	loaded, ok := msg.(OlderEmailsLoadedMsg)
+	if !ok {
+		t.Fatalf("Expected OlderEmailsLoadedMsg, got %#v", msg)
+	}
+	if loaded.Total != 12 {
+		t.Errorf("Expected total of 12, got %d", loaded.Total)
+	}
+	if len(loaded.Emails) != 5 {
+		t.Fatalf("Expected a page of 5, got %d", len(loaded.Emails))
+	}
+	for _, msg := range loaded.Emails {
+		if msg.UID < 3 || msg.UID > 7 {
+			t.Errorf("Expected UIDs 3 to 7, got %d", msg.UID)
+		}
+	}
+
+	msg = loadOlderEmailsCmd(context.Background(), "work", client, "INBOX", 1, 5)()
+	if loaded := msg.(OlderEmailsLoadedMsg); len(loaded.Emails) != 0 {
+		t.Errorf("Expected nothing older than the first message, got %d", len(loaded.Emails))
+	}
+}
+
+// pageOf returns messages with the UIDs from down to to, newest first
+func pageOf(from, to uint32) []email.Message {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var page []email.Message
+	for uid := from; uid >= to; uid-- {
+		page = append(page, email.Message{UID: uid, Date: start.Add(time.Duration(uid) * time.Hour)})
+	}
+	return page
+}
+
+func TestEmailList_requestsOlderNearEnd(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.switchMailbox("INBOX")
+	m.emailList.SetMailbox("INBOX")
+	m.emailList.SetSize(80, 200)
+	updated, _ := m.Update(EmailsLoadedMsg{Mailbox: "INBOX", Emails: pageOf(100, 91), Total: 100})
+	m = updated.(Model)
+
+	if m.emailList.list.Title != "INBOX (10/100) [Date (Newest)]" {
+		t.Errorf("Expected the title to show what is loaded, got %q", m.emailList.list.Title)
+	}
+
+	var request LoadOlderRequestMsg
+	for i := 0; i < 10 && request.Before == 0; i++ {
+		var cmd tea.Cmd
+		m.emailList, cmd = m.emailList.Update(tea.KeyMsg{Type: tea.KeyDown})
+		request, _ = findMsg[LoadOlderRequestMsg](cmd)
+	}
+	if request.Mailbox != "INBOX" || request.Before != 91 {
+		t.Fatalf("Expected a request for messages older than UID 91, got %+v", request)
+	}
+	if !m.emailList.loadingOlder {
+		t.Errorf("Expected the list to show it is loading")
+	}
+
+	// A second page overlapping the first adds only what is new
+	updated, _ = m.Update(OlderEmailsLoadedMsg{Mailbox: "INBOX", Emails: pageOf(92, 81), Total: 100})
+	m = updated.(Model)
+	if got := len(m.emailList.emails); got != 20 {
+		t.Errorf("Expected 20 messages without duplicates, got %d", got)
+	}
+	if m.emailList.loadingOlder {
+		t.Errorf("Expected the loading indicator to clear")
+	}
+}
+
+func TestEmailList_requestsOlderNearEndOfFilteredList(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.switchMailbox("INBOX")
+	m.emailList.SetMailbox("INBOX")
+	m.emailList.SetSize(80, 200)
+	page := pageOf(100, 81)
+	for i := range page {
+		page[i].Subject = "Other"
+		if i%7 == 0 {
+			page[i].Subject = "Report"
+		}
+	}
+	updated, _ := m.Update(EmailsLoadedMsg{Mailbox: "INBOX", Emails: page, Total: 100})
+	m = updated.(Model)
+
+	m.emailList.list.SetFilterText("Report")
+	if got := len(m.emailList.list.VisibleItems()); got != 3 {
+		t.Fatalf("Expected the filter to show 3 messages, got %d", got)
+	}
+
+	var cmd tea.Cmd
+	m.emailList, cmd = m.emailList.Update(tea.KeyMsg{Type: tea.KeyDown})
+	request, _ := findMsg[LoadOlderRequestMsg](cmd)
+	if request.Before != 81 {
+		t.Errorf("Expected older messages to be requested near the end of the filtered list, got %+v", request)
+	}
+}
+
+func TestEmailList_keepsSelectionWhenMailArrives(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.switchMailbox("INBOX")
+	m.emailList.SetSize(80, 200)
+	m.emailList.SetEmails(pageOf(50, 41), 50)
+	m.emailList.list.Select(3) // UID 47
+
+	updated, _ := m.Update(EmailsLoadedMsg{Mailbox: "INBOX", Emails: pageOf(52, 41), Total: 52})
+	m = updated.(Model)
+
+	selected := m.emailList.list.SelectedItem().(emailItem)
+	if selected.msg.UID != 47 {
+		t.Errorf("Expected the cursor to stay on UID 47, got %d", selected.msg.UID)
+	}
+}
+
+// findMsg runs cmd, looking into batches, and returns the first message of
+// type T it produces
+func findMsg[T tea.Msg](cmd tea.Cmd) (T, bool) {
+	var zero T
+	if cmd == nil {
+		return zero, false
+	}
+	switch msg := cmd().(type) {
+	case T:
+		return msg, true
+	case tea.BatchMsg:
+		for _, c := range msg {
+			if found, ok := findMsg[T](c); ok {
+				return found, true
+			}
+		}
+	}
+	return zero, false
+}
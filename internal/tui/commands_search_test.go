@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/chhlga/budge/internal/email"
 	imapClient "github.com/chhlga/budge/internal/imap"
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
@@ -44,7 +45,7 @@ func TestSearchEmailsCmd_returnsResultsWhenSubjectMatches(t *testing.T) {
 
 	appendMessage(t, conn, "INBOX", "Subject: hello\r\nFrom: alice@example.com\r\nTo: bob@example.com\r\n\r\nBody\r\n")
 
-	msg := searchEmailsCmd(client, "INBOX", "hello")()
+	msg := searchEmailsCmd(context.Background(), "work", client, "INBOX", "hello")()
 	loaded, ok := msg.(EmailsLoadedMsg)
 	if !ok {
 		t.Fatalf("expected EmailsLoadedMsg, got %T", msg)
@@ -116,3 +117,43 @@ func appendMessage(t *testing.T, c *imapclient.Client, mailbox string, raw strin
 		t.Fatalf("Append.Wait() error: %v", err)
 	}
 }
+
+func TestFolderSwitch_dropsResultsForPreviousMailbox(t *testing.T) {
+	addr, cleanupServer := startIMAPMemServer(t)
+	defer cleanupServer()
+
+	client := imapClient.NewClient(&imapClient.Options{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "user",
+		Password: "pass",
+	})
+	defer func() { _ = client.Disconnect() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	if err := client.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error: %v", err)
+	}
+	appendMessage(t, client.Client(), "INBOX", "Subject: hello\r\nFrom: alice@example.com\r\n\r\nBody\r\n")
+
+	m := newComposeTestModel(nil)
+	m.account().client = client
+	m.switchMailbox("INBOX")
+	pending := m.reloadEmails()
+
+	m.switchMailbox("Archive")
+	if msg := pending(); msg != nil {
+		t.Errorf("Expected no result for the mailbox that was left, got %T", msg)
+	}
+
+	updated, _ := m.Update(EmailsLoadedMsg{Mailbox: "INBOX", Emails: []email.Message{{UID: 1}}, Total: 1})
+	m = updated.(Model)
+	if len(m.emailList.emails) != 0 {
+		t.Errorf("Expected emails of another mailbox to be ignored, got %d", len(m.emailList.emails))
+	}
+}
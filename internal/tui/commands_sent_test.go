@@ -0,0 +1,81 @@
+package tui
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+func TestSaveSentCopyCmd_appendsExactBytesAsSeen(t *testing.T) {
+	client := connectTestClient(t)
+
+	raw := []byte("From: me@example.com\r\nTo: bob@example.com\r\nSubject: Sent\r\nMessage-ID: <abc@example.com>\r\n\r\nHello\r\n")
+
+	msg := saveSentCopyCmd("user@example.com", client, "Sent", true, raw)()
+	saved, ok := msg.(SentCopySavedMsg)
+	if !ok {
+		t.Fatalf("expected SentCopySavedMsg, got %T: %+v", msg, msg)
+	}
+	if saved.Mailbox != "Sent" {
+		t.Errorf("expected mailbox 'Sent', got %q", saved.Mailbox)
+	}
+
+	conn := client.Client()
+	if _, err := conn.Select("Sent", nil).Wait(); err != nil {
+		t.Fatalf("Select(Sent) error: %v", err)
+	}
+
+	var seqSet imap.SeqSet
+	seqSet.AddNum(1)
+	fetchCmd := conn.Fetch(seqSet, &imap.FetchOptions{
+		Flags:       true,
+		BodySection: []*imap.FetchItemBodySection{{}},
+	})
+	defer fetchCmd.Close()
+
+	msgData := fetchCmd.Next()
+	if msgData == nil {
+		t.Fatalf("expected a message in Sent")
+	}
+
+	var flags []imap.Flag
+	var body []byte
+	for {
+		item := msgData.Next()
+		if item == nil {
+			break
+		}
+		switch item := item.(type) {
+		case imapclient.FetchItemDataFlags:
+			flags = item.Flags
+		case imapclient.FetchItemDataBodySection:
+			body, _ = io.ReadAll(item.Literal)
+		}
+	}
+
+	if !bytes.Equal(body, raw) {
+		t.Errorf("expected stored copy to match the sent bytes, got %q", body)
+	}
+
+	seen := false
+	for _, flag := range flags {
+		if flag == imap.FlagSeen {
+			seen = true
+		}
+	}
+	if !seen {
+		t.Errorf("expected \\Seen flag, got %v", flags)
+	}
+}
+
+func TestSaveSentCopyCmd_reportsMissingMailbox(t *testing.T) {
+	client := connectTestClient(t)
+
+	msg := saveSentCopyCmd("user@example.com", client, "Sent", false, []byte("Subject: x\r\n\r\n"))()
+	if _, ok := msg.(SentCopyErrorMsg); !ok {
+		t.Fatalf("expected SentCopyErrorMsg when Sent does not exist, got %T", msg)
+	}
+}
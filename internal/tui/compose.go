@@ -0,0 +1,715 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/filepicker"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/chhlga/budge/internal/email"
+)
+
+// Compose field indices, in tab order. The attachment list is only
+// focusable while it has entries.
+const (
+	composeTo = iota
+	composeCc
+	composeBcc
+	composeSubject
+	composeAttachments
+	composeBody
+)
+
+var composeLabels = []string{"To:", "Cc:", "Bcc:", "Subject:"}
+
+// Compose is the view for writing a new message
+type Compose struct {
+	inputs []textinput.Model
+	body   textarea.Model
+	focus  int
+	keys   KeyMap
+	err    error
+	width  int
+	height int
+
+	// Threading headers carried over from the message being answered
+	inReplyTo  string
+	references []string
+
+	attachments     []email.Attachment
+	attachmentIdx   int
+	attachmentLimit int64
+
+	// Sender identities, cycled with the Identity key
+	identities []email.Identity
+	identity   int
+
+	// File picker shown instead of the form while choosing an attachment
+	picker  filepicker.Model
+	picking bool
+
+	// UID of the server copy in the Drafts mailbox of draftAccount,
+	// replaced on the next save and removed after sending
+	draftUID     uint32
+	draftAccount string
+
+	// ID of the outbox entry being edited, replaced when this version is
+	// sent or queued
+	outboxID string
+
+	// Prompt for the time to send at, shown in place of the error line
+	schedule   textinput.Model
+	scheduling bool
+
+	// Text from the last editor session that failed to parse, reopened
+	// as-is so the user can fix it instead of starting over
+	editorText string
+}
+
+// NewCompose creates a new compose view
+func NewCompose(keys KeyMap) Compose {
+	inputs := make([]textinput.Model, len(composeLabels))
+	for i, label := range composeLabels {
+		ti := textinput.New()
+		ti.Prompt = fmt.Sprintf("%-9s ", label)
+		ti.CharLimit = 998 // RFC 5322 line length limit
+		inputs[i] = ti
+	}
+	inputs[composeTo].Placeholder = "name@example.com, ..."
+
+	body := textarea.New()
+	body.Placeholder = "Write your message..."
+	body.ShowLineNumbers = false
+	body.CharLimit = 0
+
+	picker := filepicker.New()
+	picker.AutoHeight = false
+	picker.ShowPermissions = false
+	// Esc closes the picker rather than going up a directory
+	picker.KeyMap.Back = key.NewBinding(key.WithKeys("h", "backspace", "left"), key.WithHelp("h", "back"))
+	if home, err := os.UserHomeDir(); err == nil {
+		picker.CurrentDirectory = home
+	}
+
+	schedule := textinput.New()
+	schedule.Prompt = "Send at: "
+	schedule.Placeholder = scheduleFormatHint
+
+	c := Compose{
+		inputs:   inputs,
+		body:     body,
+		keys:     keys,
+		picker:   picker,
+		schedule: schedule,
+	}
+	c.setFocus(composeTo)
+	return c
+}
+
+// SetIdentities sets the addresses the user can send from. The first one
+// is the default for new messages.
+func (c *Compose) SetIdentities(identities []email.Identity) {
+	c.identities = identities
+	c.identity = 0
+	c.resizeBody()
+}
+
+// currentIdentity returns the selected identity, if any are configured
+func (c Compose) currentIdentity() (email.Identity, bool) {
+	if c.identity >= len(c.identities) {
+		return email.Identity{}, false
+	}
+	return c.identities[c.identity], true
+}
+
+// signature returns the signature of the selected identity
+func (c Compose) signature() string {
+	id, _ := c.currentIdentity()
+	return id.Signature
+}
+
+// cycleIdentity switches to the next identity and swaps the signature
+func (c *Compose) cycleIdentity() {
+	if len(c.identities) < 2 {
+		return
+	}
+
+	oldSig := c.signature()
+	c.identity = (c.identity + 1) % len(c.identities)
+	c.setBody(email.ReplaceSignature(c.body.Value(), oldSig, c.signature()))
+}
+
+// setBody replaces the body text and leaves the cursor on the line above
+// the signature, where the message is written
+func (c *Compose) setBody(text string) {
+	c.body.SetValue(text)
+
+	sig := c.signature()
+	if strings.TrimSpace(sig) == "" || !strings.HasSuffix(text, email.SignatureBlock(sig)) {
+		return
+	}
+	for range strings.Count(email.SignatureBlock(sig), "\n") + 1 {
+		c.body.CursorUp()
+	}
+	c.body.CursorEnd()
+}
+
+// SetAttachmentLimit sets the total attachment size in bytes above which
+// a warning is shown. Zero disables the warning.
+func (c *Compose) SetAttachmentLimit(limit int64) {
+	c.attachmentLimit = limit
+}
+
+// SetSize updates the compose view dimensions
+func (c *Compose) SetSize(width, height int) {
+	c.width = width
+	c.height = height
+
+	for i := range c.inputs {
+		c.inputs[i].Width = width - 14
+	}
+
+	c.body.SetWidth(width - 2)
+	c.picker.SetHeight(max(height-4, 3)) // Title, directory and hint lines
+	c.resizeBody()
+}
+
+// resizeBody gives the body editor whatever height the header leaves free
+func (c *Compose) resizeBody() {
+	headerHeight := len(c.inputs) + len(c.attachments) + 3 // Title, separator and error line
+	if len(c.identities) > 0 {
+		headerHeight++ // From line
+	}
+	if c.overLimit() {
+		headerHeight++
+	}
+	c.body.SetHeight(max(c.height-headerHeight-1, 3))
+}
+
+// Reset clears every field and focuses the To field
+func (c *Compose) Reset() {
+	for i := range c.inputs {
+		c.inputs[i].Reset()
+	}
+	c.body.Reset()
+	c.err = nil
+	c.inReplyTo = ""
+	c.references = nil
+	c.attachments = nil
+	c.attachmentIdx = 0
+	c.picking = false
+	c.scheduling = false
+	c.schedule.Reset()
+	c.draftUID = 0
+	c.draftAccount = ""
+	c.outboxID = ""
+	c.editorText = ""
+	c.identity = 0
+	c.setBody(email.AppendSignature("", c.signature()))
+	c.resizeBody()
+	c.setFocus(composeTo)
+}
+
+// SetDraft fills the form from a prepared message such as a reply.
+// The body is focused when the draft already has recipients. A non-zero
+// UID marks the message as a draft stored on the server. The identity
+// matching draft.From is selected and its signature added if missing.
+func (c *Compose) SetDraft(draft email.Message) {
+	c.Reset()
+
+	c.inputs[composeTo].SetValue(formatAddressList(draft.To))
+	c.inputs[composeCc].SetValue(formatAddressList(draft.Cc))
+	c.inputs[composeBcc].SetValue(formatAddressList(draft.Bcc))
+	c.inputs[composeSubject].SetValue(draft.Subject)
+
+	if len(draft.From) > 0 {
+		for i, id := range c.identities {
+			if strings.EqualFold(id.From.Email, draft.From[0].Email) {
+				c.identity = i
+				break
+			}
+		}
+	}
+
+	text := ""
+	if draft.Body != nil {
+		text = draft.Body.Text
+	}
+	if sig := c.signature(); strings.TrimSpace(sig) != "" && !strings.Contains(text, email.SignatureBlock(sig)) {
+		text = email.AppendSignature(text, sig)
+	}
+	c.setBody(text)
+
+	c.inReplyTo = draft.InReplyTo
+	c.references = draft.References
+	c.attachments = draft.Attachments
+	c.draftUID = draft.UID
+	c.resizeBody()
+
+	if len(draft.To) > 0 {
+		c.setFocus(composeBody)
+	}
+}
+
+// Focus returns the command that starts the cursor blinking
+func (c Compose) Focus() tea.Cmd {
+	return textinput.Blink
+}
+
+// DraftUID returns the UID of the server copy of this draft, if any
+func (c Compose) DraftUID() uint32 {
+	return c.draftUID
+}
+
+// DraftAccount returns the account whose Drafts mailbox holds the server
+// copy of this draft
+func (c Compose) DraftAccount() string {
+	return c.draftAccount
+}
+
+// SetDraftUID records where the latest revision of the draft was stored
+func (c *Compose) SetDraftUID(account string, uid uint32) {
+	c.draftAccount = account
+	c.draftUID = uid
+}
+
+// OutboxID returns the outbox entry this message was reopened from, if any
+func (c Compose) OutboxID() string {
+	return c.outboxID
+}
+
+// SetOutboxID marks the message as an edit of a queued outbox entry
+func (c *Compose) SetOutboxID(id string) {
+	c.outboxID = id
+}
+
+// SetError shows an error below the editor
+func (c *Compose) SetError(err error) {
+	c.err = err
+}
+
+// Draft converts the form fields into a message ready to be sent
+func (c Compose) Draft() (email.Message, error) {
+	draft, err := c.fields()
+	if err != nil {
+		return email.Message{}, err
+	}
+
+	if len(draft.To)+len(draft.Cc)+len(draft.Bcc) == 0 {
+		return email.Message{}, fmt.Errorf("at least one recipient is required")
+	}
+
+	if id, ok := c.currentIdentity(); ok && id.BccSelf && !containsAddress(draft.Bcc, id.From.Email) {
+		draft.Bcc = append(draft.Bcc, email.Address{Email: id.From.Email})
+	}
+
+	return draft, nil
+}
+
+func containsAddress(addrs []email.Address, address string) bool {
+	for _, addr := range addrs {
+		if strings.EqualFold(addr.Email, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// fields converts the form into a message without requiring recipients
+func (c Compose) fields() (email.Message, error) {
+	to, err := email.ParseAddressList(c.inputs[composeTo].Value())
+	if err != nil {
+		return email.Message{}, fmt.Errorf("To: %w", err)
+	}
+	cc, err := email.ParseAddressList(c.inputs[composeCc].Value())
+	if err != nil {
+		return email.Message{}, fmt.Errorf("Cc: %w", err)
+	}
+	bcc, err := email.ParseAddressList(c.inputs[composeBcc].Value())
+	if err != nil {
+		return email.Message{}, fmt.Errorf("Bcc: %w", err)
+	}
+
+	msg := email.Message{
+		To:          to,
+		Cc:          cc,
+		Bcc:         bcc,
+		Subject:     strings.TrimSpace(c.inputs[composeSubject].Value()),
+		InReplyTo:   c.inReplyTo,
+		References:  c.references,
+		Body:        &email.Body{Text: c.body.Value()},
+		Attachments: c.attachments,
+	}
+
+	if id, ok := c.currentIdentity(); ok {
+		msg.From = []email.Address{id.From}
+		msg.ReplyTo = id.ReplyTo
+	}
+
+	return msg, nil
+}
+
+// openEditor hands the draft to the external editor. A template that
+// failed to parse last time is reopened unchanged.
+func (c *Compose) openEditor() tea.Cmd {
+	text := c.editorText
+	if text == "" {
+		draft, err := c.fields()
+		if err != nil {
+			c.err = err
+			return nil
+		}
+		text = email.FormatTemplate(&draft)
+	}
+
+	c.err = nil
+	return openEditorCmd(text)
+}
+
+// applyEditor fills the form from the edited template, keeping threading
+// headers and attachments which are not part of the file
+func (c *Compose) applyEditor(msg EditorFinishedMsg) {
+	if msg.Err != nil {
+		c.err = msg.Err
+		return
+	}
+
+	edited, err := email.ParseTemplate(msg.Text)
+	if err != nil {
+		c.editorText = msg.Text
+		c.err = fmt.Errorf("%w (ctrl+e to fix)", err)
+		return
+	}
+
+	c.editorText = ""
+	c.err = nil
+	c.inputs[composeTo].SetValue(formatAddressList(edited.To))
+	c.inputs[composeCc].SetValue(formatAddressList(edited.Cc))
+	c.inputs[composeBcc].SetValue(formatAddressList(edited.Bcc))
+	c.inputs[composeSubject].SetValue(edited.Subject)
+	c.setBody(edited.Body.Text)
+	c.setFocus(composeBody)
+}
+
+// openPicker shows the file picker, reading the current directory afresh
+func (c *Compose) openPicker() tea.Cmd {
+	c.picking = true
+	c.err = nil
+	return c.picker.Init()
+}
+
+// updatePicker handles messages while the file picker is shown
+func (c Compose) updatePicker(msg tea.Msg) (Compose, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok && msg.Type == tea.KeyEsc {
+		c.picking = false
+		return c, nil
+	}
+
+	var cmd tea.Cmd
+	c.picker, cmd = c.picker.Update(msg)
+
+	if ok, path := c.picker.DidSelectFile(msg); ok {
+		c.picking = false
+		return c, loadAttachmentCmd(path)
+	}
+
+	return c, cmd
+}
+
+// addAttachment appends a loaded file and focuses it in the list
+func (c *Compose) addAttachment(att email.Attachment) {
+	c.picking = false
+	c.attachments = append(c.attachments, att)
+	c.attachmentIdx = len(c.attachments) - 1
+	c.resizeBody()
+	c.setFocus(composeAttachments)
+}
+
+// removeAttachment drops the selected attachment
+func (c *Compose) removeAttachment() {
+	if c.attachmentIdx >= len(c.attachments) {
+		return
+	}
+
+	// Copy so drafts handed out earlier keep their attachments
+	remaining := make([]email.Attachment, 0, len(c.attachments)-1)
+	remaining = append(remaining, c.attachments[:c.attachmentIdx]...)
+	c.attachments = append(remaining, c.attachments[c.attachmentIdx+1:]...)
+
+	if c.attachmentIdx >= len(c.attachments) {
+		c.attachmentIdx = max(len(c.attachments)-1, 0)
+	}
+	c.resizeBody()
+	if len(c.attachments) == 0 {
+		c.setFocus(composeBody)
+	}
+}
+
+// updateAttachments handles keys while the attachment list is focused
+func (c Compose) updateAttachments(msg tea.KeyMsg) Compose {
+	switch msg.String() {
+	case "up", "k":
+		if c.attachmentIdx > 0 {
+			c.attachmentIdx--
+		}
+	case "down", "j":
+		if c.attachmentIdx < len(c.attachments)-1 {
+			c.attachmentIdx++
+		}
+	case "x", "d", "delete", "backspace":
+		c.removeAttachment()
+	}
+	return c
+}
+
+// overLimit reports whether the attachments exceed the configured limit
+func (c Compose) overLimit() bool {
+	return c.attachmentLimit > 0 && email.TotalSize(c.attachments) > c.attachmentLimit
+}
+
+// nextFocus returns the field after (dir 1) or before (dir -1) the current
+// one, skipping the attachment list when it is empty
+func (c Compose) nextFocus(dir int) int {
+	n := composeBody + 1
+	next := (c.focus + dir + n) % n
+	if next == composeAttachments && len(c.attachments) == 0 {
+		next = (next + dir + n) % n
+	}
+	return next
+}
+
+func formatAddressList(addrs []email.Address) string {
+	formatted := make([]string, len(addrs))
+	for i, addr := range addrs {
+		formatted[i] = addr.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func (c *Compose) setFocus(index int) {
+	if index == composeAttachments && len(c.attachments) == 0 {
+		index = composeBody
+	}
+	c.focus = index
+
+	for i := range c.inputs {
+		if i == index {
+			c.inputs[i].Focus()
+		} else {
+			c.inputs[i].Blur()
+		}
+	}
+
+	if index == composeBody {
+		c.body.Focus()
+	} else {
+		c.body.Blur()
+	}
+}
+
+// Init initializes the compose view
+func (c Compose) Init() tea.Cmd {
+	return nil
+}
+
+// Update handles messages for the compose view
+func (c Compose) Update(msg tea.Msg) (Compose, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case EditorFinishedMsg:
+		c.applyEditor(msg)
+		return c, nil
+	case AttachmentLoadedMsg:
+		c.addAttachment(msg.Attachment)
+		return c, nil
+	case AttachmentErrorMsg:
+		c.err = msg.Err
+		return c, nil
+	}
+
+	if c.picking {
+		return c.updatePicker(msg)
+	}
+	if c.scheduling {
+		return c.updateSchedule(msg)
+	}
+
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch {
+		case key.Matches(msg, c.keys.NextField):
+			c.setFocus(c.nextFocus(1))
+			return c, nil
+		case key.Matches(msg, c.keys.PrevField):
+			c.setFocus(c.nextFocus(-1))
+			return c, nil
+		case key.Matches(msg, c.keys.Attach):
+			return c, c.openPicker()
+		case key.Matches(msg, c.keys.Identity):
+			c.cycleIdentity()
+			return c, nil
+		case key.Matches(msg, c.keys.Send):
+			draft, err := c.Draft()
+			if err != nil {
+				c.err = err
+				return c, nil
+			}
+			c.err = nil
+			return c, func() tea.Msg {
+				return SendEmailRequestMsg{Draft: draft}
+			}
+		case key.Matches(msg, c.keys.Schedule):
+			if _, err := c.Draft(); err != nil {
+				c.err = err
+				return c, nil
+			}
+			c.err = nil
+			c.scheduling = true
+			return c, c.schedule.Focus()
+		case key.Matches(msg, c.keys.SaveDraft):
+			draft, err := c.fields()
+			if err != nil {
+				c.err = err
+				return c, nil
+			}
+			c.err = nil
+			return c, func() tea.Msg {
+				return SaveDraftRequestMsg{Draft: draft}
+			}
+		case key.Matches(msg, c.keys.Editor):
+			return c, c.openEditor()
+		case msg.Type == tea.KeyEsc:
+			return c, func() tea.Msg { return ComposeCancelledMsg{} }
+		}
+
+		// Editing the form directly supersedes a broken editor session
+		c.editorText = ""
+
+		if c.focus == composeAttachments {
+			return c.updateAttachments(msg), nil
+		}
+	}
+
+	switch c.focus {
+	case composeBody:
+		c.body, cmd = c.body.Update(msg)
+	case composeAttachments:
+	default:
+		c.inputs[c.focus], cmd = c.inputs[c.focus].Update(msg)
+	}
+	return c, cmd
+}
+
+// updateSchedule handles input while the send-later prompt is open
+func (c Compose) updateSchedule(msg tea.Msg) (Compose, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.Type {
+		case tea.KeyEsc:
+			c.scheduling = false
+			c.err = nil
+			c.schedule.Blur()
+			return c, nil
+		case tea.KeyEnter:
+			at, err := parseSendTime(c.schedule.Value(), time.Now())
+			if err != nil {
+				c.err = err
+				return c, nil
+			}
+			draft, err := c.Draft()
+			if err != nil {
+				c.err = err
+				return c, nil
+			}
+			c.err = nil
+			c.scheduling = false
+			c.schedule.Blur()
+			return c, func() tea.Msg {
+				return ScheduleSendRequestMsg{Draft: draft, At: at}
+			}
+		}
+	}
+
+	var cmd tea.Cmd
+	c.schedule, cmd = c.schedule.Update(msg)
+	return c, cmd
+}
+
+// View renders the compose view
+func (c Compose) View() string {
+	if c.picking {
+		return lipgloss.JoinVertical(lipgloss.Left,
+			TitleStyle.Render("Attach file"),
+			separatorStyle.Render(c.picker.CurrentDirectory),
+			c.picker.View(),
+			separatorStyle.Render("enter: attach | h: up a directory | esc: cancel"),
+		)
+	}
+
+	fields := make([]string, 0, len(c.inputs)+1)
+	if id, ok := c.currentIdentity(); ok {
+		from := fmt.Sprintf("%-9s %s", "From:", id.From.String())
+		if len(c.identities) > 1 {
+			from += separatorStyle.Render(fmt.Sprintf("  (%d/%d, ctrl+l to switch)", c.identity+1, len(c.identities)))
+		}
+		fields = append(fields, from)
+	}
+	for _, input := range c.inputs {
+		fields = append(fields, input.View())
+	}
+
+	for i, att := range c.attachments {
+		line := fmt.Sprintf("📎 %s (%s, %s)", att.Filename, att.ContentType, formatSize(att.Size))
+		if c.focus == composeAttachments && i == c.attachmentIdx {
+			line = SelectedItemStyle.Render("▶ " + line + "  x: remove")
+		} else {
+			line = "  " + line
+		}
+		fields = append(fields, line)
+	}
+
+	if c.overLimit() {
+		fields = append(fields, WarningStyle.Render(fmt.Sprintf("⚠ Attachments total %s, over the %s limit",
+			formatSize(email.TotalSize(c.attachments)), formatSize(c.attachmentLimit))))
+	}
+
+	separator := separatorStyle.Render(strings.Repeat("─", max(c.width-2, 0)))
+
+	errLine := ""
+	if c.err != nil {
+		errLine = ErrorStyle.Render(c.err.Error())
+	}
+	if c.scheduling {
+		errLine = c.schedule.View()
+		if c.err != nil {
+			errLine += "  " + ErrorStyle.Render(c.err.Error())
+		}
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left,
+		TitleStyle.Render("Compose"),
+		lipgloss.JoinVertical(lipgloss.Left, fields...),
+		separator,
+		c.body.View(),
+		errLine,
+	)
+}
+
+// formatSize renders a byte count in human readable units
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,555 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/chhlga/budge/internal/config"
+	"github.com/chhlga/budge/internal/email"
+)
+
+type fakeSender struct {
+	from       string
+	recipients []string
+	raw        []byte
+	err        error
+}
+
+func (f *fakeSender) Send(ctx context.Context, from string, recipients []string, msg []byte) error {
+	f.from = from
+	f.recipients = recipients
+	f.raw = msg
+	return f.err
+}
+
+func newComposeTestModel(sender Sender) Model {
+	cfg := &config.Config{
+		Behavior:    config.BehaviorConfig{DefaultFolder: "INBOX", PageSize: 50, PollInterval: 30},
+		Credentials: config.CredentialsConfig{Username: "me@example.com"},
+	}
+
+	m := NewModel(cfg, nil)
+	if sender != nil {
+		m.SetSender(sender)
+	}
+	m.state = emailListView
+	return m
+}
+
+func typeText(m Model, text string) Model {
+	for _, r := range text {
+		updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		m = updated.(Model)
+	}
+	return m
+}
+
+func TestComposeKey_opensComposeView(t *testing.T) {
+	m := newComposeTestModel(nil)
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'c'}})
+	m = updated.(Model)
+	if m.state != composeView {
+		t.Fatalf("expected state=composeView, got %v", m.state)
+	}
+}
+
+func TestComposeView_capturesGlobalKeys(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.state = composeView
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'q'}})
+	m = updated.(Model)
+	if cmd != nil {
+		if _, ok := cmd().(tea.QuitMsg); ok {
+			t.Fatalf("expected q to be typed, not quit")
+		}
+	}
+
+	m = typeText(m, "12")
+	if m.state != composeView {
+		t.Fatalf("expected to stay in compose view, got %v", m.state)
+	}
+	if got := m.compose.inputs[composeTo].Value(); got != "q12" {
+		t.Fatalf("expected To field 'q12', got %q", got)
+	}
+}
+
+func TestCompose_tabCyclesFields(t *testing.T) {
+	c := NewCompose(NewKeyMap())
+
+	for want := composeCc; want <= composeBody; want++ {
+		if want == composeAttachments {
+			continue // Skipped while there are no attachments
+		}
+		c, _ = c.Update(tea.KeyMsg{Type: tea.KeyTab})
+		if c.focus != want {
+			t.Fatalf("expected focus %d, got %d", want, c.focus)
+		}
+	}
+
+	c, _ = c.Update(tea.KeyMsg{Type: tea.KeyTab})
+	if c.focus != composeTo {
+		t.Fatalf("expected focus to wrap to To, got %d", c.focus)
+	}
+
+	c, _ = c.Update(tea.KeyMsg{Type: tea.KeyShiftTab})
+	if c.focus != composeBody {
+		t.Fatalf("expected shift+tab to wrap to body, got %d", c.focus)
+	}
+}
+
+func TestCompose_sendWithoutRecipientsShowsError(t *testing.T) {
+	c := NewCompose(NewKeyMap())
+
+	c, cmd := c.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	if cmd != nil {
+		t.Fatalf("expected no send request without recipients")
+	}
+	if c.err == nil {
+		t.Fatalf("expected validation error")
+	}
+}
+
+func TestCompose_sendDeliversThroughSender(t *testing.T) {
+	sender := &fakeSender{}
+	m := newComposeTestModel(sender)
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'c'}})
+	m = updated.(Model)
+	m = typeText(m, "bob@example.com")
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	m = updated.(Model)
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyTab})
+	m = updated.(Model)
+	m = typeText(m, "carol@example.com")
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	m = updated.(Model)
+	if cmd == nil {
+		t.Fatalf("expected send request command")
+	}
+
+	request, ok := cmd().(SendEmailRequestMsg)
+	if !ok {
+		t.Fatalf("expected SendEmailRequestMsg")
+	}
+
+	updated, cmd = m.Update(request)
+	m = updated.(Model)
+	if cmd == nil {
+		t.Fatalf("expected send command")
+	}
+	result := sendEmailCmd(sender, nil, withFrom(t, m, request), "")()
+	if _, ok := result.(EmailSentMsg); !ok {
+		t.Fatalf("expected EmailSentMsg, got %T", result)
+	}
+
+	if sender.from != "me@example.com" {
+		t.Errorf("expected envelope sender me@example.com, got %q", sender.from)
+	}
+	if len(sender.recipients) != 2 {
+		t.Errorf("expected To and Bcc recipients, got %v", sender.recipients)
+	}
+
+	updated, _ = m.Update(result)
+	m = updated.(Model)
+	if m.state != emailListView {
+		t.Fatalf("expected to return to email list after sending, got %v", m.state)
+	}
+	if m.statusBar.notice != "Message sent" {
+		t.Fatalf("expected status bar notice, got %q", m.statusBar.notice)
+	}
+}
+
+func TestCompose_sendFailureKeepsDraft(t *testing.T) {
+	m := newComposeTestModel(&fakeSender{err: errors.New("connection refused")})
+
+	updated, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'c'}})
+	m = updated.(Model)
+	m = typeText(m, "bob@example.com")
+
+	updated, _ = m.Update(SendErrorMsg{Err: errors.New("connection refused")})
+	m = updated.(Model)
+
+	if m.state != composeView {
+		t.Fatalf("expected to stay in compose view after failure, got %v", m.state)
+	}
+	if got := m.compose.inputs[composeTo].Value(); got != "bob@example.com" {
+		t.Fatalf("expected draft to be kept, got %q", got)
+	}
+	if !m.statusBar.noticeIsError {
+		t.Fatalf("expected error notice in status bar")
+	}
+}
+
+func TestCompose_sendWithoutSenderReportsError(t *testing.T) {
+	m := newComposeTestModel(nil)
+
+	_, cmd := m.Update(SendEmailRequestMsg{})
+	if cmd == nil {
+		t.Fatalf("expected error command")
+	}
+	if _, ok := cmd().(SendErrorMsg); !ok {
+		t.Fatalf("expected SendErrorMsg")
+	}
+}
+
+func withFrom(t *testing.T, m Model, request SendEmailRequestMsg) email.Message {
+	t.Helper()
+
+	from, err := fromAddress(m.account().config)
+	if err != nil {
+		t.Fatalf("fromAddress() error: %v", err)
+	}
+	draft := request.Draft
+	draft.From = []email.Address{from}
+	return draft
+}
+
+func TestReaderReplyAll_opensPrefilledCompose(t *testing.T) {
+	m := newComposeTestModel(nil)
+
+	listed := email.Message{UID: 7, Subject: "Plans", Flags: []string{"\\Seen"}}
+	updated, _ := m.Update(EmailSelectedMsg{Email: listed})
+	m = updated.(Model)
+
+	original := &email.Message{
+		From:      []email.Address{{Email: "alice@example.com"}},
+		To:        []email.Address{{Email: "me@example.com"}, {Email: "dave@example.com"}},
+		Subject:   "Plans",
+		MessageID: "<plans@example.com>",
+		Body:      &email.Body{Text: "Are we on?"},
+	}
+	updated, _ = m.Update(EmailBodyLoadedMsg{Account: m.account().name, UID: 7, Body: "Are we on?", Message: original})
+	m = updated.(Model)
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'R'}})
+	m = updated.(Model)
+	if cmd == nil {
+		t.Fatalf("expected reply request command")
+	}
+	updated, _ = m.Update(cmd())
+	m = updated.(Model)
+
+	if m.state != composeView {
+		t.Fatalf("expected state=composeView, got %v", m.state)
+	}
+	if got := m.compose.inputs[composeTo].Value(); got != "alice@example.com, dave@example.com" {
+		t.Fatalf("unexpected To field %q", got)
+	}
+	if m.compose.focus != composeBody {
+		t.Fatalf("expected body to be focused")
+	}
+
+	draft, err := m.compose.Draft()
+	if err != nil {
+		t.Fatalf("Draft() error: %v", err)
+	}
+	if draft.InReplyTo != "<plans@example.com>" {
+		t.Fatalf("expected In-Reply-To to be carried into the draft, got %q", draft.InReplyTo)
+	}
+
+	updated, _ = m.Update(ComposeCancelledMsg{})
+	m = updated.(Model)
+	if m.state != emailReaderView {
+		t.Fatalf("expected to return to the reader, got %v", m.state)
+	}
+}
+
+func TestReader_ignoresBodyOfPreviousMessage(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.currentMailbox = "INBOX"
+
+	for _, uid := range []uint32{7, 8} {
+		updated, _ := m.Update(EmailSelectedMsg{Email: email.Message{UID: uid, Flags: []string{"\\Seen"}}})
+		m = updated.(Model)
+	}
+
+	late := &email.Message{Subject: "Plans", Body: &email.Body{Text: "Are we on?"}}
+	updated, _ := m.Update(EmailBodyLoadedMsg{Account: m.account().name, Mailbox: "INBOX", UID: 7, Body: "Are we on?", Message: late})
+	m = updated.(Model)
+	if m.emailReader.Original() != nil {
+		t.Fatalf("Expected the body of the message left behind to be dropped")
+	}
+
+	updated, _ = m.Update(EmailBodyLoadedMsg{Account: "other", Mailbox: "INBOX", UID: 8, Message: late})
+	m = updated.(Model)
+	if m.emailReader.Original() != nil {
+		t.Fatalf("Expected a body from another account to be dropped")
+	}
+
+	current := &email.Message{Subject: "Lunch"}
+	updated, _ = m.Update(EmailBodyLoadedMsg{Account: m.account().name, Mailbox: "INBOX", UID: 8, Body: "Noon?", Message: current})
+	m = updated.(Model)
+	if m.emailReader.Original() != current {
+		t.Errorf("Expected the body of the message being read to be shown")
+	}
+}
+
+func TestForwardFromList_attachesRawOriginal(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.currentMailbox = "INBOX"
+	m.emailList.SetEmails([]email.Message{{UID: 3, Subject: "Vendor update"}}, 1)
+
+	raw := []byte("From: vendor@example.com\r\nSubject: Vendor update\r\n\r\nNew prices\r\n")
+	original, err := email.Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	m.account().cache.Set("INBOX/3", EmailBodyLoadedMsg{UID: 3, Message: original, Raw: raw})
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlF})
+	m = updated.(Model)
+	if cmd == nil {
+		t.Fatalf("expected forward request command")
+	}
+	request, ok := cmd().(ForwardRequestMsg)
+	if !ok || request.UID != 3 || !request.AsAttachment {
+		t.Fatalf("unexpected forward request %+v", request)
+	}
+
+	ready := forwardEmailCmd(m.account().name, m.account().client, m.account().cache, m.currentMailbox, request.UID, request.AsAttachment)()
+	updated, _ = m.Update(ready)
+	m = updated.(Model)
+
+	if m.state != composeView {
+		t.Fatalf("expected state=composeView, got %v", m.state)
+	}
+	if got := m.compose.inputs[composeSubject].Value(); got != "Fwd: Vendor update" {
+		t.Fatalf("unexpected subject %q", got)
+	}
+	if len(m.compose.attachments) != 1 || m.compose.attachments[0].ContentType != "message/rfc822" {
+		t.Fatalf("expected message/rfc822 attachment, got %+v", m.compose.attachments)
+	}
+	if m.compose.focus != composeTo {
+		t.Fatalf("expected To field focused for a forward")
+	}
+}
+
+func TestCompose_editorResultFillsForm(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.compose.SetDraft(email.Message{
+		To:          []email.Address{{Email: "bob@example.com"}},
+		Subject:     "Re: Plans",
+		InReplyTo:   "orig@example.com",
+		Attachments: []email.Attachment{{Filename: "a.txt"}},
+	})
+	m.state = composeView
+
+	text := "To: carol@example.com\nCc:\nBcc:\nSubject: Re: Plans v2\n\nSee you there.\n"
+	updated, _ := m.Update(EditorFinishedMsg{Text: text})
+	m = updated.(Model)
+
+	draft, err := m.compose.Draft()
+	if err != nil {
+		t.Fatalf("Draft() failed: %v", err)
+	}
+	if len(draft.To) != 1 || draft.To[0].Email != "carol@example.com" {
+		t.Errorf("Expected To carol@example.com, got %v", draft.To)
+	}
+	if draft.Subject != "Re: Plans v2" || draft.Body.Text != "See you there.\n" {
+		t.Errorf("Unexpected subject/body %q / %q", draft.Subject, draft.Body.Text)
+	}
+	if draft.InReplyTo != "orig@example.com" || len(draft.Attachments) != 1 {
+		t.Errorf("Expected threading headers and attachments to survive the editor")
+	}
+}
+
+func TestCompose_malformedEditorResultIsKeptForRetry(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.state = composeView
+
+	text := "To: bob@example.com\nSubjct: typo\n\nBody\n"
+	updated, _ := m.Update(EditorFinishedMsg{Text: text})
+	m = updated.(Model)
+
+	if m.compose.err == nil {
+		t.Fatalf("Expected a parse error to be shown")
+	}
+	if m.compose.editorText != text {
+		t.Errorf("Expected the edited text to be kept for the next editor session")
+	}
+	if m.compose.inputs[composeTo].Value() != "" {
+		t.Errorf("Expected form to be left untouched, got To %q", m.compose.inputs[composeTo].Value())
+	}
+
+	// Typing into the form abandons the broken editor text
+	m = typeText(m, "x")
+	if m.compose.editorText != "" {
+		t.Errorf("Expected editor text to be discarded after editing the form")
+	}
+}
+
+func TestEditorCommand(t *testing.T) {
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "code --wait")
+	if got := editorCommand(); len(got) != 2 || got[0] != "code" || got[1] != "--wait" {
+		t.Errorf("Expected [code --wait], got %v", got)
+	}
+
+	t.Setenv("VISUAL", "nvim")
+	if got := editorCommand(); len(got) != 1 || got[0] != "nvim" {
+		t.Errorf("Expected $VISUAL to win, got %v", got)
+	}
+
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "")
+	if got := editorCommand(); got[0] != defaultEditor {
+		t.Errorf("Expected default editor, got %v", got)
+	}
+}
+
+func TestCompose_attachAndRemove(t *testing.T) {
+	c := NewCompose(NewKeyMap())
+	c.SetAttachmentLimit(10)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte("twelve bytes"), 0o600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	c, cmd := c.Update(tea.KeyMsg{Type: tea.KeyCtrlG})
+	if !c.picking || cmd == nil {
+		t.Fatalf("expected ctrl+g to open the file picker")
+	}
+
+	c, _ = c.Update(loadAttachmentCmd(path)())
+	if c.picking {
+		t.Fatalf("expected picker to close after attaching")
+	}
+	if len(c.attachments) != 1 || c.attachments[0].ContentType != "text/plain" || c.attachments[0].Size != 12 {
+		t.Fatalf("unexpected attachments %+v", c.attachments)
+	}
+	if c.focus != composeAttachments {
+		t.Fatalf("expected the new attachment to be focused, got %d", c.focus)
+	}
+	if !strings.Contains(c.View(), "notes.txt (text/plain, 12 B)") {
+		t.Errorf("expected attachment line in view, got:\n%s", c.View())
+	}
+	if !strings.Contains(c.View(), "over the 10 B limit") {
+		t.Errorf("expected size warning in view, got:\n%s", c.View())
+	}
+
+	draft, err := c.fields()
+	if err != nil || len(draft.Attachments) != 1 {
+		t.Fatalf("expected draft to carry the attachment, got %+v (%v)", draft.Attachments, err)
+	}
+
+	c, _ = c.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'x'}})
+	if len(c.attachments) != 0 {
+		t.Fatalf("expected x to remove the attachment, got %+v", c.attachments)
+	}
+	if c.focus != composeBody {
+		t.Errorf("expected focus to move to the body, got %d", c.focus)
+	}
+	if len(draft.Attachments) != 1 {
+		t.Errorf("removing must not alter drafts handed out earlier")
+	}
+}
+
+func TestCompose_pickerEscCancels(t *testing.T) {
+	c := NewCompose(NewKeyMap())
+
+	c, _ = c.Update(tea.KeyMsg{Type: tea.KeyCtrlG})
+	c, cmd := c.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if c.picking {
+		t.Fatalf("expected esc to close the picker")
+	}
+	if cmd != nil {
+		if _, ok := cmd().(ComposeCancelledMsg); ok {
+			t.Fatalf("esc in the picker must not cancel compose")
+		}
+	}
+}
+
+func testIdentities() []email.Identity {
+	return []email.Identity{
+		{From: email.Address{Name: "Me", Email: "me@example.com"}, Signature: "Me\nPersonal"},
+		{
+			From:      email.Address{Name: "Me at Work", Email: "me@work.example.com"},
+			ReplyTo:   []email.Address{{Email: "team@work.example.com"}},
+			Signature: "Me\nACME Corp",
+			BccSelf:   true,
+		},
+	}
+}
+
+func TestCompose_identitySwitchSwapsSignature(t *testing.T) {
+	c := NewCompose(NewKeyMap())
+	c.SetIdentities(testIdentities())
+	c.Reset()
+
+	if got := c.body.Value(); got != "\n-- \nMe\nPersonal" {
+		t.Fatalf("expected the first signature in a fresh body, got %q", got)
+	}
+
+	c.inputs[composeTo].SetValue("bob@example.com")
+	c.body.SetValue("Hello Bob\n\n-- \nMe\nPersonal")
+
+	c, _ = c.Update(tea.KeyMsg{Type: tea.KeyCtrlL})
+	if c.identity != 1 {
+		t.Fatalf("expected ctrl+l to select the second identity, got %d", c.identity)
+	}
+	if got := c.body.Value(); got != "Hello Bob\n\n-- \nMe\nACME Corp" {
+		t.Fatalf("expected the signature to be swapped, got %q", got)
+	}
+	if !strings.Contains(c.View(), "Me at Work <me@work.example.com>") {
+		t.Errorf("expected the From line to show the selected identity")
+	}
+
+	draft, err := c.Draft()
+	if err != nil {
+		t.Fatalf("Draft() error: %v", err)
+	}
+	if len(draft.From) != 1 || draft.From[0].Email != "me@work.example.com" {
+		t.Errorf("expected From to follow the identity, got %+v", draft.From)
+	}
+	if len(draft.ReplyTo) != 1 || draft.ReplyTo[0].Email != "team@work.example.com" {
+		t.Errorf("expected the identity's Reply-To, got %+v", draft.ReplyTo)
+	}
+	if len(draft.Bcc) != 1 || draft.Bcc[0].Email != "me@work.example.com" {
+		t.Errorf("expected bcc_self to add the sender to Bcc, got %+v", draft.Bcc)
+	}
+
+	c, _ = c.Update(tea.KeyMsg{Type: tea.KeyCtrlL})
+	if c.identity != 0 {
+		t.Errorf("expected ctrl+l to wrap around, got %d", c.identity)
+	}
+}
+
+func TestReply_picksIdentityFromRecipients(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.account().identities = testIdentities()
+	m.compose.SetIdentities(m.account().identities)
+
+	original := &email.Message{
+		From:    []email.Address{{Email: "boss@work.example.com"}},
+		To:      []email.Address{{Email: "dev-list@work.example.com"}},
+		Cc:      []email.Address{{Email: "ME@work.example.com"}},
+		Subject: "Deadline",
+		Body:    &email.Body{Text: "Friday?"},
+	}
+	m.emailReader.SetOriginal(original)
+	m.state = emailReaderView
+
+	updated, _ := m.Update(ReplyRequestMsg{All: true})
+	m = updated.(Model)
+
+	if m.compose.identity != 1 {
+		t.Fatalf("expected the work identity to be selected, got %d", m.compose.identity)
+	}
+	if got := m.compose.inputs[composeCc].Value(); got != "" {
+		t.Errorf("expected our own address to be left out of a reply-all, got Cc %q", got)
+	}
+	if !strings.HasSuffix(m.compose.body.Value(), "-- \nMe\nACME Corp") {
+		t.Errorf("expected the work signature, got %q", m.compose.body.Value())
+	}
+}
@@ -0,0 +1,190 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/chhlga/budge/internal/imap"
+)
+
+func TestErrorMsg_lostConnectionKeepsViewAndReconnects(t *testing.T) {
+	m := newComposeTestModel(nil)
+
+	lost := &imap.ConnectionError{Op: "reconnect", Err: imap.ErrConnectionLost}
+	updated, cmd := m.Update(ErrorMsg{Err: lost})
+	m = updated.(Model)
+
+	if m.err != nil {
+		t.Errorf("Expected a connection error not to block the view, got %v", m.err)
+	}
+	if !m.account().reconnecting || cmd == nil {
+		t.Fatalf("Expected a background reconnect to start")
+	}
+
+	// A second failure while reconnecting does not start another attempt
+	updated, _ = m.Update(ErrorMsg{Err: lost})
+	m = updated.(Model)
+	if !m.account().reconnecting {
+		t.Errorf("Expected reconnect to still be in progress")
+	}
+
+	updated, cmd = m.Update(ReconnectResultMsg{Account: m.account().name, Err: lost})
+	m = updated.(Model)
+	if !m.account().reconnecting || cmd == nil {
+		t.Errorf("Expected a failed reconnect to be tried again")
+	}
+
+	updated, _ = m.Update(ReconnectResultMsg{Account: m.account().name})
+	m = updated.(Model)
+	if m.account().reconnecting {
+		t.Errorf("Expected reconnecting to end once the session is back")
+	}
+}
+
+func TestErrorMsg_reconnectsAccountOfFailedCommand(t *testing.T) {
+	m := newAccountsTestModel(nil, nil)
+
+	lost := &imap.ConnectionError{Op: "reconnect", Err: imap.ErrConnectionLost}
+	updated, cmd := m.Update(ErrorMsg{Account: "home", Err: lost})
+	m = updated.(Model)
+
+	if !m.accountByName("home").reconnecting || cmd == nil {
+		t.Errorf("Expected home to reconnect")
+	}
+	if m.account().reconnecting {
+		t.Errorf("Expected the active account to be left alone")
+	}
+	if !strings.HasPrefix(m.statusBar.notice, "home: ") {
+		t.Errorf("Expected the notice to name the account, got %q", m.statusBar.notice)
+	}
+}
+
+func TestErrorMsg_serverErrorsStillShown(t *testing.T) {
+	m := newComposeTestModel(nil)
+
+	updated, _ := m.Update(ErrorMsg{Err: errors.New("failed to select mailbox Junk: NO no such mailbox")})
+	m = updated.(Model)
+
+	if m.err == nil {
+		t.Errorf("Expected a server error to be shown")
+	}
+}
+
+func TestConnectionStateChanged_reloadsAfterReconnect(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.switchMailbox("INBOX")
+	m.err = errors.New("not connected to IMAP server")
+
+	states := []imap.ConnectionState{imap.StateAuthenticated, imap.StateDisconnected, imap.StateConnecting, imap.StateConnected}
+	for _, state := range states {
+		updated, _ := m.Update(ConnectionStateChangedMsg{Account: m.account().name, State: state})
+		m = updated.(Model)
+	}
+	if !m.account().sessionLost {
+		t.Fatalf("Expected the dropped session to be noticed")
+	}
+	if m.statusBar.connectionState != "Connected" {
+		t.Errorf("Expected status bar to follow the state, got %q", m.statusBar.connectionState)
+	}
+
+	updated, cmd := m.Update(ConnectionStateChangedMsg{Account: m.account().name, State: imap.StateAuthenticated})
+	m = updated.(Model)
+
+	if m.account().sessionLost || m.err != nil {
+		t.Errorf("Expected the restored session to clear the error, got %v", m.err)
+	}
+	if cmd == nil {
+		t.Errorf("Expected mailboxes and emails to be reloaded")
+	}
+}
+
+func TestRetryable_offersRetryOnTimeout(t *testing.T) {
+	runs := 0
+	cmd := retryable(func() tea.Msg {
+		runs++
+		return ErrorMsg{Err: fmt.Errorf("failed to list mailboxes: %w", imap.ErrTimeout)}
+	})
+
+	msg, ok := cmd().(ErrorMsg)
+	if !ok || msg.Retry == nil {
+		t.Fatalf("Expected a timed out command to offer a retry, got %#v", msg)
+	}
+	if _, ok := msg.Retry().(ErrorMsg); !ok || runs != 2 {
+		t.Errorf("Expected retry to run the command again, ran %d times", runs)
+	}
+
+	other := retryable(func() tea.Msg {
+		return ErrorMsg{Err: errors.New("NO mailbox does not exist")}
+	})
+	if msg := other().(ErrorMsg); msg.Retry != nil {
+		t.Errorf("Expected no retry for a server error")
+	}
+}
+
+func TestRetryKey_runsTimedOutCommandAgain(t *testing.T) {
+	m := newComposeTestModel(nil)
+
+	retried := false
+	retry := func() tea.Msg {
+		retried = true
+		return nil
+	}
+
+	updated, _ := m.Update(ErrorMsg{Err: fmt.Errorf("search failed: %w", imap.ErrTimeout), Retry: retry})
+	m = updated.(Model)
+	if m.err != nil {
+		t.Errorf("Expected a timeout not to block the view, got %v", m.err)
+	}
+	if !strings.Contains(m.statusBar.notice, "ctrl+r") {
+		t.Errorf("Expected the notice to offer a retry, got %q", m.statusBar.notice)
+	}
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlR})
+	m = updated.(Model)
+	if cmd == nil {
+		t.Fatalf("Expected ctrl+r to return the retry command")
+	}
+	cmd()
+	if !retried || m.account().retry != nil {
+		t.Errorf("Expected the retry to run once, retried=%v", retried)
+	}
+}
+
+func TestRetryKey_retriesActiveAccount(t *testing.T) {
+	m := newAccountsTestModel(nil, nil)
+
+	retried := false
+	retry := func() tea.Msg {
+		retried = true
+		return nil
+	}
+	updated, _ := m.Update(ErrorMsg{Account: "work", Err: fmt.Errorf("search failed: %w", imap.ErrTimeout), Retry: retry})
+	m = updated.(Model)
+
+	// home failing to connect in the background must not take over ctrl+r
+	updated, _ = m.Update(ConnectErrorMsg{Account: "home", Err: errors.New("connection refused")})
+	m = updated.(Model)
+	if m.err != nil {
+		t.Errorf("Expected a background account's failure not to block the view, got %v", m.err)
+	}
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlR})
+	if cmd == nil {
+		t.Fatalf("Expected ctrl+r to retry the search of work")
+	}
+	cmd()
+	if !retried {
+		t.Errorf("Expected the timed out search to run again")
+	}
+
+	cmd = m.switchAccount("home")
+	if m.accountByName("home").retry == nil || cmd == nil {
+		t.Fatalf("Expected the failed connect of home to be kept for retrying")
+	}
+	if !strings.Contains(m.statusBar.notice, "ctrl+r") {
+		t.Errorf("Expected switching to home to offer the retry, got %q", m.statusBar.notice)
+	}
+}
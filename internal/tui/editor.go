@@ -0,0 +1,65 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// defaultEditor is used when neither $VISUAL nor $EDITOR is set
+const defaultEditor = "vi"
+
+// editorCommand returns the user's editor split into program and
+// arguments, so values like "code --wait" work
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{defaultEditor}
+}
+
+// openEditorCmd writes text to a temporary file, suspends the TUI while the
+// external editor runs and reads the file back once it exits
+func openEditorCmd(text string) tea.Cmd {
+	f, err := os.CreateTemp("", "budge-*.eml")
+	if err != nil {
+		return func() tea.Msg {
+			return EditorFinishedMsg{Err: fmt.Errorf("failed to create draft file: %w", err)}
+		}
+	}
+	path := f.Name()
+
+	_, err = f.WriteString(text)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return func() tea.Msg {
+			return EditorFinishedMsg{Err: fmt.Errorf("failed to write draft file: %w", err)}
+		}
+	}
+
+	args := editorCommand()
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		defer os.Remove(path)
+
+		if err != nil {
+			return EditorFinishedMsg{Err: fmt.Errorf("editor %s failed: %w", args[0], err)}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return EditorFinishedMsg{Err: fmt.Errorf("failed to read draft file: %w", err)}
+		}
+
+		return EditorFinishedMsg{Text: string(data)}
+	})
+}
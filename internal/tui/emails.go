@@ -8,10 +8,16 @@ import (
 
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/chhlga/budge/internal/email"
 )
 
+// loadAheadRows is how close to the end of the list the cursor gets
+// before older messages are fetched
+const loadAheadRows = 5
+
 type SortMode int
 
 const (
@@ -80,9 +86,12 @@ func (f FilterMode) Next() FilterMode {
 	return (f + 1) % 4
 }
 
-// emailItem implements list.Item interface
+// emailItem implements list.Item interface. In the unified inbox source
+// is the mailbox the message is from and tag labels it.
 type emailItem struct {
-	msg email.Message
+	msg    email.Message
+	source MailboxRef
+	tag    string
 }
 
 func (e emailItem) Title() string {
@@ -137,6 +146,9 @@ func (d emailDelegate) Render(w io.Writer, m list.Model, index int, item list.It
 	dateStr := email.msg.Date.Format("Jan 02 15:04")
 
 	line1 := fmt.Sprintf("%-30s %s", from, dateStr)
+	if email.tag != "" {
+		line1 += "  [" + email.tag + "]"
+	}
 	line2 := subject
 
 	// Apply selection styling
@@ -151,19 +163,47 @@ func (d emailDelegate) Render(w io.Writer, m list.Model, index int, item list.It
 	fmt.Fprintf(w, "%s\n%s", line1, line2)
 }
 
-// EmailList is the email list view
+// EmailList is the email list view. When it shows several mailboxes at
+// once, sources[i] is the mailbox emails[i] is from. A single mailbox is
+// paged: older messages are requested as the cursor nears the end.
+// Messages are moved through a prompt for the destination below the list.
 type EmailList struct {
-	list       list.Model
-	keys       KeyMap
-	mailbox    string
-	total      uint32
-	emails     []email.Message
-	sortMode   SortMode
-	filterMode FilterMode
+	list         list.Model
+	keys         KeyMap
+	height       int
+	mailbox      string
+	total        uint32
+	emails       []email.Message
+	sources      []MailboxRef
+	tagAccount   bool
+	sortMode     SortMode
+	filterMode   FilterMode
+	loadingOlder bool
+
+	movePrompt textinput.Model
+	moving     *emailItem
+}
+
+func (e *EmailList) markSeenLocal(source MailboxRef, uid uint32, seen bool) {
+	for i := range e.emails {
+		if e.emails[i].UID == uid && e.sourceOf(i) == source {
+			markSeenInSlice(e.emails[i:i+1], uid, seen)
+			break
+		}
+	}
+	e.applyFiltersAndSort()
+}
+
+// sourceOf returns the mailbox emails[i] is from
+func (e *EmailList) sourceOf(i int) MailboxRef {
+	if e.sources == nil {
+		return MailboxRef{}
+	}
+	return e.sources[i]
 }
 
-func (e *EmailList) markSeenLocal(uid uint32, seen bool) {
-	e.emails = markSeenInSlice(e.emails, uid, seen)
+func (e *EmailList) setFlagsLocal(uid uint32, flags []string) {
+	e.emails = setFlagsInSlice(e.emails, uid, flags)
 	e.applyFiltersAndSort()
 }
 
@@ -184,119 +224,276 @@ func NewEmailList(keys KeyMap) EmailList {
 	l.SetFilteringEnabled(true)
 	l.SetShowHelp(false)
 
+	movePrompt := textinput.New()
+	movePrompt.Prompt = "Move to: "
+	movePrompt.Placeholder = "mailbox"
+
 	return EmailList{
-		list: l,
-		keys: keys,
+		list:       l,
+		keys:       keys,
+		movePrompt: movePrompt,
 	}
 }
 
 // SetSize updates the email list dimensions
 func (e *EmailList) SetSize(width, height int) {
-	e.list.SetSize(width, height-3)
+	e.height = height
+	e.list.SetSize(width, e.listHeight())
+}
+
+// listHeight leaves room for the title and status bar, and for the move
+// prompt while it is open
+func (e EmailList) listHeight() int {
+	if e.moving != nil {
+		return e.height - 4
+	}
+	return e.height - 3
+}
+
+// Moving reports whether the move prompt is open, so keys go to it
+func (e EmailList) Moving() bool {
+	return e.moving != nil
+}
+
+// openMove asks where to move item
+func (e *EmailList) openMove(item emailItem) tea.Cmd {
+	e.moving = &item
+	e.movePrompt.SetValue("")
+	e.list.SetHeight(e.listHeight())
+	return e.movePrompt.Focus()
+}
+
+func (e *EmailList) closeMove() {
+	e.moving = nil
+	e.movePrompt.Blur()
+	e.list.SetHeight(e.listHeight())
+}
+
+// updateMove handles input while the move prompt is open
+func (e EmailList) updateMove(msg tea.Msg) (EmailList, tea.Cmd) {
+	if keyMsg, ok := msg.(tea.KeyMsg); ok {
+		switch keyMsg.Type {
+		case tea.KeyEsc:
+			e.closeMove()
+			return e, nil
+		case tea.KeyEnter:
+			to := strings.TrimSpace(e.movePrompt.Value())
+			if to == "" {
+				return e, nil
+			}
+			item := *e.moving
+			e.closeMove()
+			return e, func() tea.Msg {
+				return MoveEmailRequestMsg{UID: item.msg.UID, Source: item.source, To: to}
+			}
+		}
+	}
+
+	var cmd tea.Cmd
+	e.movePrompt, cmd = e.movePrompt.Update(msg)
+	return e, cmd
 }
 
 // SetEmails updates the email list
 func (e *EmailList) SetEmails(emails []email.Message, total uint32) {
+	e.SetMergedEmails(emails, nil, total)
+}
+
+// SetMergedEmails shows the messages of several mailboxes in one list,
+// sources[i] being the mailbox emails[i] is from. They are tagged with
+// their mailbox, and with their account as well when they span several.
+func (e *EmailList) SetMergedEmails(emails []email.Message, sources []MailboxRef, total uint32) {
+	e.tagAccount = false
+	for _, source := range sources {
+		if source.Account != sources[0].Account {
+			e.tagAccount = true
+			break
+		}
+	}
 	e.emails = emails
+	e.sources = sources
+	e.total = total
+	e.loadingOlder = false
+	e.applyFiltersAndSort()
+}
+
+// AppendEmails adds a page of older messages. Messages already listed are
+// skipped, so a page overlapping a reload adds nothing twice.
+func (e *EmailList) AppendEmails(emails []email.Message, total uint32) {
+	listed := make(map[uint32]bool, len(e.emails))
+	for _, msg := range e.emails {
+		listed[msg.UID] = true
+	}
+	for _, msg := range emails {
+		if !listed[msg.UID] {
+			e.emails = append(e.emails, msg)
+			listed[msg.UID] = true
+		}
+	}
 	e.total = total
+	e.loadingOlder = false
 	e.applyFiltersAndSort()
 }
 
+// stopLoadingOlder clears the loading indicator of a page that failed
+func (e *EmailList) stopLoadingOlder() {
+	if e.loadingOlder {
+		e.loadingOlder = false
+		e.updateTitle()
+	}
+}
+
+// hasOlder reports whether the mailbox has messages that aren't listed yet
+func (e *EmailList) hasOlder() bool {
+	return e.sources == nil && len(e.emails) > 0 && uint32(len(e.emails)) < e.total
+}
+
+// oldestUID returns the lowest UID listed, below which older messages are
+// fetched
+func (e *EmailList) oldestUID() uint32 {
+	oldest := e.emails[0].UID
+	for _, msg := range e.emails[1:] {
+		if msg.UID < oldest {
+			oldest = msg.UID
+		}
+	}
+	return oldest
+}
+
+// loadOlder requests the next page once the cursor nears the end of the
+// list
+func (e *EmailList) loadOlder() tea.Cmd {
+	// The cursor moves through the messages the filter shows, and keys
+	// go to the filter while it is being typed
+	if e.loadingOlder || !e.hasOlder() || e.list.FilterState() == list.Filtering ||
+		e.list.Index() < len(e.list.VisibleItems())-loadAheadRows {
+		return nil
+	}
+	e.loadingOlder = true
+	e.updateTitle()
+	request := LoadOlderRequestMsg{Mailbox: e.mailbox, Before: e.oldestUID()}
+	return func() tea.Msg { return request }
+}
+
 func (e *EmailList) applyFiltersAndSort() {
-	filtered := e.filterEmails(e.emails)
+	all := make([]emailItem, len(e.emails))
+	for i, msg := range e.emails {
+		all[i] = emailItem{msg: msg}
+		if e.sources != nil {
+			all[i].source = e.sources[i]
+			all[i].tag = e.sources[i].Mailbox
+			if e.tagAccount {
+				all[i].tag = e.sources[i].Account + "/" + e.sources[i].Mailbox
+			}
+		}
+	}
+
+	filtered := e.filterEmails(all)
 	sorted := e.sortEmails(filtered)
 
+	// Keep the cursor on the same message when mail arrives above it
+	selected, hadSelection := e.list.SelectedItem().(emailItem)
+
 	items := make([]list.Item, len(sorted))
-	for i, msg := range sorted {
-		items[i] = emailItem{msg: msg}
+	for i, item := range sorted {
+		items[i] = item
 	}
 	e.list.SetItems(items)
+
+	if hadSelection && e.list.FilterState() == list.Unfiltered {
+		for i, item := range sorted {
+			if item.msg.UID == selected.msg.UID && item.source == selected.source {
+				e.list.Select(i)
+				break
+			}
+		}
+	}
 	e.updateTitle()
 }
 
-func (e *EmailList) filterEmails(emails []email.Message) []email.Message {
+func (e *EmailList) filterEmails(items []emailItem) []emailItem {
 	if e.filterMode == FilterNone {
-		return emails
+		return items
 	}
 
-	filtered := make([]email.Message, 0)
-	for _, msg := range emails {
+	filtered := make([]emailItem, 0)
+	for _, item := range items {
 		switch e.filterMode {
 		case FilterUnread:
-			if msg.IsUnread() {
-				filtered = append(filtered, msg)
+			if item.msg.IsUnread() {
+				filtered = append(filtered, item)
 			}
 		case FilterRead:
-			if !msg.IsUnread() {
-				filtered = append(filtered, msg)
+			if !item.msg.IsUnread() {
+				filtered = append(filtered, item)
 			}
 		case FilterAttachments:
-			if len(msg.Attachments) > 0 {
-				filtered = append(filtered, msg)
+			if len(item.msg.Attachments) > 0 {
+				filtered = append(filtered, item)
 			}
 		}
 	}
 	return filtered
 }
 
-func (e *EmailList) sortEmails(emails []email.Message) []email.Message {
-	sorted := make([]email.Message, len(emails))
-	copy(sorted, emails)
+func (e *EmailList) sortEmails(items []emailItem) []emailItem {
+	sorted := make([]emailItem, len(items))
+	copy(sorted, items)
 
 	switch e.sortMode {
 	case SortDateNewest:
 		sort.Slice(sorted, func(i, j int) bool {
-			return sorted[i].Date.After(sorted[j].Date)
+			return sorted[i].msg.Date.After(sorted[j].msg.Date)
 		})
 	case SortDateOldest:
 		sort.Slice(sorted, func(i, j int) bool {
-			return sorted[i].Date.Before(sorted[j].Date)
+			return sorted[i].msg.Date.Before(sorted[j].msg.Date)
 		})
 	case SortSenderAZ:
 		sort.Slice(sorted, func(i, j int) bool {
 			fromI := ""
-			if len(sorted[i].From) > 0 {
-				fromI = sorted[i].From[0].Email
+			if len(sorted[i].msg.From) > 0 {
+				fromI = sorted[i].msg.From[0].Email
 			}
 			fromJ := ""
-			if len(sorted[j].From) > 0 {
-				fromJ = sorted[j].From[0].Email
+			if len(sorted[j].msg.From) > 0 {
+				fromJ = sorted[j].msg.From[0].Email
 			}
 			return strings.ToLower(fromI) < strings.ToLower(fromJ)
 		})
 	case SortSenderZA:
 		sort.Slice(sorted, func(i, j int) bool {
 			fromI := ""
-			if len(sorted[i].From) > 0 {
-				fromI = sorted[i].From[0].Email
+			if len(sorted[i].msg.From) > 0 {
+				fromI = sorted[i].msg.From[0].Email
 			}
 			fromJ := ""
-			if len(sorted[j].From) > 0 {
-				fromJ = sorted[j].From[0].Email
+			if len(sorted[j].msg.From) > 0 {
+				fromJ = sorted[j].msg.From[0].Email
 			}
 			return strings.ToLower(fromI) > strings.ToLower(fromJ)
 		})
 	case SortSubjectAZ:
 		sort.Slice(sorted, func(i, j int) bool {
-			return strings.ToLower(sorted[i].Subject) < strings.ToLower(sorted[j].Subject)
+			return strings.ToLower(sorted[i].msg.Subject) < strings.ToLower(sorted[j].msg.Subject)
 		})
 	case SortSubjectZA:
 		sort.Slice(sorted, func(i, j int) bool {
-			return strings.ToLower(sorted[i].Subject) > strings.ToLower(sorted[j].Subject)
+			return strings.ToLower(sorted[i].msg.Subject) > strings.ToLower(sorted[j].msg.Subject)
 		})
 	case SortUnreadFirst:
 		sort.Slice(sorted, func(i, j int) bool {
-			if sorted[i].IsUnread() == sorted[j].IsUnread() {
-				return sorted[i].Date.After(sorted[j].Date)
+			if sorted[i].msg.IsUnread() == sorted[j].msg.IsUnread() {
+				return sorted[i].msg.Date.After(sorted[j].msg.Date)
 			}
-			return sorted[i].IsUnread()
+			return sorted[i].msg.IsUnread()
 		})
 	case SortReadFirst:
 		sort.Slice(sorted, func(i, j int) bool {
-			if sorted[i].IsUnread() == sorted[j].IsUnread() {
-				return sorted[i].Date.After(sorted[j].Date)
+			if sorted[i].msg.IsUnread() == sorted[j].msg.IsUnread() {
+				return sorted[i].msg.Date.After(sorted[j].msg.Date)
 			}
-			return !sorted[i].IsUnread()
+			return !sorted[i].msg.IsUnread()
 		})
 	}
 
@@ -316,10 +513,15 @@ func (e *EmailList) updateTitle() {
 	if e.total > 0 {
 		if e.filterMode != FilterNone {
 			title = fmt.Sprintf("%s (%d/%d) [%s] [%s]", e.mailbox, len(displayCount), e.total, e.filterMode, e.sortMode)
+		} else if e.hasOlder() {
+			title = fmt.Sprintf("%s (%d/%d) [%s]", e.mailbox, len(e.emails), e.total, e.sortMode)
 		} else {
 			title = fmt.Sprintf("%s (%d) [%s]", e.mailbox, e.total, e.sortMode)
 		}
 	}
+	if e.loadingOlder {
+		title += " loading older messages..."
+	}
 	e.list.Title = title
 }
 
@@ -332,33 +534,49 @@ func (e EmailList) Init() tea.Cmd {
 func (e EmailList) Update(msg tea.Msg) (EmailList, tea.Cmd) {
 	var cmd tea.Cmd
 
+	if e.moving != nil {
+		return e.updateMove(msg)
+	}
+
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch {
 		case key.Matches(msg, e.keys.Enter):
 			if selected := e.list.SelectedItem(); selected != nil {
-				email := selected.(emailItem).msg
+				item := selected.(emailItem)
 				return e, func() tea.Msg {
-					return EmailSelectedMsg{Email: email}
+					return EmailSelectedMsg{Email: item.msg, Source: item.source}
 				}
 			}
 		case key.Matches(msg, e.keys.MarkRead):
-			idx := e.list.Index()
-			if idx >= 0 && idx < len(e.emails) {
-				selectedEmail := e.emails[idx]
-				isUnread := selectedEmail.IsUnread()
+			if selected := e.list.SelectedItem(); selected != nil {
+				item := selected.(emailItem)
+				isUnread := item.msg.IsUnread()
 				return e, func() tea.Msg {
-					return MarkReadRequestMsg{UID: selectedEmail.UID, Read: isUnread}
+					return MarkReadRequestMsg{UID: item.msg.UID, Read: isUnread, Source: item.source}
 				}
 			}
+		case key.Matches(msg, e.keys.Move):
+			if selected := e.list.SelectedItem(); selected != nil {
+				return e, e.openMove(selected.(emailItem))
+			}
 		case key.Matches(msg, e.keys.Delete):
-			idx := e.list.Index()
-			if idx >= 0 && idx < len(e.emails) {
-				selectedEmail := e.emails[idx]
+			if selected := e.list.SelectedItem(); selected != nil {
+				item := selected.(emailItem)
 				return e, func() tea.Msg {
-					return DeleteEmailRequestMsg{UID: selectedEmail.UID}
+					return DeleteEmailRequestMsg{UID: item.msg.UID, Source: item.source}
 				}
 			}
+		case key.Matches(msg, e.keys.Forward), key.Matches(msg, e.keys.ForwardAs):
+			if selected := e.list.SelectedItem(); selected != nil {
+				item := selected.(emailItem)
+				request := ForwardRequestMsg{
+					UID:          item.msg.UID,
+					AsAttachment: key.Matches(msg, e.keys.ForwardAs),
+					Source:       item.source,
+				}
+				return e, func() tea.Msg { return request }
+			}
 		case key.Matches(msg, e.keys.Sort):
 			// Cycle to next sort mode
 			e.sortMode = e.sortMode.Next()
@@ -375,12 +593,17 @@ func (e EmailList) Update(msg tea.Msg) (EmailList, tea.Cmd) {
 	}
 
 	e.list, cmd = e.list.Update(msg)
+	switch msg.(type) {
+	case tea.KeyMsg, tea.MouseMsg:
+		cmd = tea.Batch(cmd, e.loadOlder())
+	}
 	return e, cmd
 }
 
 // View renders the email list
 func (e EmailList) View() string {
-	var sb strings.Builder
-	sb.WriteString(e.list.View())
-	return sb.String()
+	if e.moving != nil {
+		return lipgloss.JoinVertical(lipgloss.Left, e.list.View(), e.movePrompt.View())
+	}
+	return e.list.View()
 }
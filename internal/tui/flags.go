@@ -36,3 +36,14 @@ func markSeenInSlice(emails []email.Message, uid uint32, seen bool) []email.Mess
 
 	return emails
 }
+
+func setFlagsInSlice(emails []email.Message, uid uint32, flags []string) []email.Message {
+	for i := range emails {
+		if emails[i].UID == uid {
+			emails[i].Flags = flags
+			break
+		}
+	}
+
+	return emails
+}
@@ -7,6 +7,7 @@ type KeyMap struct {
 	// Global keys
 	Quit    key.Binding
 	Refresh key.Binding
+	Retry   key.Binding
 	Help    key.Binding
 
 	// Navigation keys
@@ -20,12 +21,42 @@ type KeyMap struct {
 	ViewMailboxes key.Binding
 	ViewEmails    key.Binding
 	ViewReader    key.Binding
+	ViewOutbox    key.Binding
 
 	// Email actions
 	MarkRead key.Binding
+	Move     key.Binding
 	Delete   key.Binding
 	Sort     key.Binding
 	Filter   key.Binding
+
+	// Mailbox actions
+	Collapse       key.Binding
+	Expand         key.Binding
+	NewMailbox     key.Binding
+	RenameMailbox  key.Binding
+	Subscribe      key.Binding
+	SubscribedOnly key.Binding
+
+	// Compose keys
+	Compose   key.Binding
+	Reply     key.Binding
+	ReplyAll  key.Binding
+	Forward   key.Binding
+	ForwardAs key.Binding
+	Send      key.Binding
+	NextField key.Binding
+	PrevField key.Binding
+	Editor    key.Binding
+	SaveDraft key.Binding
+	Attach    key.Binding
+	Identity  key.Binding
+	Schedule  key.Binding
+	UndoSend  key.Binding
+
+	// Outbox keys
+	OutboxCancel key.Binding
+	OutboxRetry  key.Binding
 }
 
 // NewKeyMap creates a new KeyMap with default bindings
@@ -36,9 +67,13 @@ func NewKeyMap() KeyMap {
 			key.WithHelp("q", "quit"),
 		),
 		Refresh: key.NewBinding(
-			key.WithKeys("r", "ctrl+r"),
+			key.WithKeys("r"),
 			key.WithHelp("r", "refresh"),
 		),
+		Retry: key.NewBinding(
+			key.WithKeys("ctrl+r"),
+			key.WithHelp("ctrl+r", "retry"),
+		),
 		Help: key.NewBinding(
 			key.WithKeys("?"),
 			key.WithHelp("?", "help"),
@@ -75,10 +110,18 @@ func NewKeyMap() KeyMap {
 			key.WithKeys("3"),
 			key.WithHelp("3", "reader"),
 		),
+		ViewOutbox: key.NewBinding(
+			key.WithKeys("4"),
+			key.WithHelp("4", "outbox"),
+		),
 		MarkRead: key.NewBinding(
 			key.WithKeys("m"),
 			key.WithHelp("m", "mark read/unread"),
 		),
+		Move: key.NewBinding(
+			key.WithKeys("M"),
+			key.WithHelp("M", "move"),
+		),
 		Delete: key.NewBinding(
 			key.WithKeys("d"),
 			key.WithHelp("d", "delete"),
@@ -91,5 +134,93 @@ func NewKeyMap() KeyMap {
 			key.WithKeys("f"),
 			key.WithHelp("f", "toggle filter"),
 		),
+		Collapse: key.NewBinding(
+			key.WithKeys("h", "left"),
+			key.WithHelp("h", "collapse"),
+		),
+		Expand: key.NewBinding(
+			key.WithKeys("l", "right"),
+			key.WithHelp("l", "expand"),
+		),
+		NewMailbox: key.NewBinding(
+			key.WithKeys("n"),
+			key.WithHelp("n", "new mailbox"),
+		),
+		RenameMailbox: key.NewBinding(
+			key.WithKeys("e"),
+			key.WithHelp("e", "rename mailbox"),
+		),
+		Subscribe: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s", "subscribe/unsubscribe"),
+		),
+		SubscribedOnly: key.NewBinding(
+			key.WithKeys("S"),
+			key.WithHelp("S", "show subscribed only/all"),
+		),
+		Compose: key.NewBinding(
+			key.WithKeys("c"),
+			key.WithHelp("c", "compose"),
+		),
+		Reply: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "reply"),
+		),
+		ReplyAll: key.NewBinding(
+			key.WithKeys("R"),
+			key.WithHelp("R", "reply all"),
+		),
+		Forward: key.NewBinding(
+			key.WithKeys("F"),
+			key.WithHelp("F", "forward inline"),
+		),
+		ForwardAs: key.NewBinding(
+			key.WithKeys("ctrl+f"),
+			key.WithHelp("ctrl+f", "forward as attachment"),
+		),
+		Send: key.NewBinding(
+			key.WithKeys("ctrl+s"),
+			key.WithHelp("ctrl+s", "send"),
+		),
+		NextField: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "next field"),
+		),
+		PrevField: key.NewBinding(
+			key.WithKeys("shift+tab"),
+			key.WithHelp("shift+tab", "previous field"),
+		),
+		Editor: key.NewBinding(
+			key.WithKeys("ctrl+e"),
+			key.WithHelp("ctrl+e", "edit in $EDITOR"),
+		),
+		SaveDraft: key.NewBinding(
+			key.WithKeys("ctrl+o"),
+			key.WithHelp("ctrl+o", "save draft"),
+		),
+		Attach: key.NewBinding(
+			key.WithKeys("ctrl+g"),
+			key.WithHelp("ctrl+g", "attach file"),
+		),
+		Identity: key.NewBinding(
+			key.WithKeys("ctrl+l"),
+			key.WithHelp("ctrl+l", "switch identity"),
+		),
+		Schedule: key.NewBinding(
+			key.WithKeys("alt+s"),
+			key.WithHelp("alt+s", "send later"),
+		),
+		UndoSend: key.NewBinding(
+			key.WithKeys("u"),
+			key.WithHelp("u", "undo send"),
+		),
+		OutboxCancel: key.NewBinding(
+			key.WithKeys("x", "delete"),
+			key.WithHelp("x", "cancel message"),
+		),
+		OutboxRetry: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "retry now"),
+		),
 	}
 }
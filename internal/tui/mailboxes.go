@@ -1,21 +1,125 @@
 package tui
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/emersion/go-imap/v2"
 )
 
 var separatorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
 
-// mailboxItem implements list.Item interface
+// unifiedTitle names the list that merges the inboxes of every account
+const unifiedTitle = "All Inboxes"
+
+// MailboxInfo is a mailbox as the server lists it. Delim separates the
+// levels of its name and is zero when the server has no hierarchy.
+// SpecialUse is the role the server marks it with until
+// resolveSpecialUses settles it.
+type MailboxInfo struct {
+	Name       string
+	Delim      rune
+	Subscribed bool
+	NoSelect   bool
+	SpecialUse SpecialUse
+}
+
+// newMailboxInfo converts a LIST response. Without subscriptions the
+// server did not report them and every mailbox counts as subscribed.
+func newMailboxInfo(data *imap.ListData, subscriptions bool) MailboxInfo {
+	info := MailboxInfo{Name: data.Mailbox, Delim: data.Delim, Subscribed: !subscriptions}
+	for _, attr := range data.Attrs {
+		switch attr {
+		case imap.MailboxAttrSubscribed:
+			info.Subscribed = true
+		case imap.MailboxAttrNoSelect, imap.MailboxAttrNonExistent:
+			info.NoSelect = true
+		default:
+			if use := specialUseOf(attr); use != "" {
+				info.SpecialUse = use
+			}
+		}
+	}
+	return info
+}
+
+// mailboxParent returns the name of the mailbox name is nested in, or an
+// empty string for a top-level mailbox
+func mailboxParent(name string, delim rune) string {
+	if delim == 0 {
+		return ""
+	}
+	if i := strings.LastIndex(name, string(delim)); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// hierarchyDelim returns the delimiter the server separates levels with,
+// or zero when its mailboxes don't nest
+func hierarchyDelim(mailboxes []MailboxInfo) rune {
+	for _, mailbox := range mailboxes {
+		if mailbox.Delim != 0 {
+			return mailbox.Delim
+		}
+	}
+	return 0
+}
+
+// checkMailboxName tidies a mailbox name typed by the user, dropping a
+// trailing delimiter, and rejects names with an empty level
+func checkMailboxName(name string, delim rune) (string, error) {
+	name = strings.TrimSpace(name)
+	if delim != 0 {
+		name = strings.TrimRight(name, string(delim))
+	}
+	if name == "" {
+		return "", errors.New("enter a name")
+	}
+	if delim != 0 && (strings.Contains(name, string(delim)+string(delim)) || strings.HasPrefix(name, string(delim))) {
+		return "", fmt.Errorf("%q has an empty level", name)
+	}
+	return name, nil
+}
+
+// renamedMailbox returns what name is called after its mailbox or one it
+// is nested in was renamed from old to renamed
+func renamedMailbox(name, old, renamed string, delim rune) string {
+	if name == old {
+		return renamed
+	}
+	if delim != 0 && strings.HasPrefix(name, old+string(delim)) {
+		return renamed + strings.TrimPrefix(name, old)
+	}
+	return name
+}
+
+// mailboxItem implements list.Item interface. Items with an account are
+// the rows of the account switcher, the others nodes of the active
+// account's mailbox tree: name is the full mailbox name, label the last
+// level of it.
 type mailboxItem struct {
-	name string
+	name         string
+	label        string
+	use          SpecialUse
+	account      string
+	depth        int
+	unified      bool
+	unsubscribed bool
+	noSelect     bool
+	children     bool
+	collapsed    bool
+	counted      bool
+	unread       uint32
+	total        uint32
 }
 
 func (m mailboxItem) Title() string       { return m.name }
@@ -30,28 +134,138 @@ func (d mailboxDelegate) Spacing() int                            { return 0 }
 func (d mailboxDelegate) Update(_ tea.Msg, _ *list.Model) tea.Cmd { return nil }
 func (d mailboxDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
 	mailbox := item.(mailboxItem)
-	isSeparator := strings.HasPrefix(mailbox.name, "---")
 
-	if isSeparator {
-		fmt.Fprint(w, separatorStyle.Render("  ─────────────────"))
-		return
+	str := mailbox.name
+	if mailbox.account == "" && !mailbox.unified {
+		marker := "  "
+		switch {
+		case mailbox.collapsed:
+			marker = "▸ "
+		case mailbox.children:
+			marker = "▾ "
+		}
+		if icon := specialUseIcon(mailbox.use); icon != "" {
+			marker += icon + " "
+		}
+		str = strings.Repeat("  ", mailbox.depth) + marker + mailbox.label
 	}
 
-	str := mailbox.name
+	counts := ""
+	if mailbox.counted {
+		style := ReadStyle
+		if mailbox.unread > 0 {
+			style = UnreadStyle
+		}
+		counts = " " + style.Render(fmt.Sprintf("%d/%d", mailbox.unread, mailbox.total))
+	}
 
-	if index == m.Index() {
+	switch {
+	case index == m.Index():
 		str = SelectedItemStyle.Render("▶ " + str)
-	} else {
+	case mailbox.account != "" || mailbox.unified:
+		str = "  " + AccountStyle.Render(str)
+	case mailbox.unsubscribed || mailbox.noSelect:
+		str = "  " + separatorStyle.Render(str)
+	default:
 		str = "  " + str
 	}
 
-	fmt.Fprint(w, str)
+	fmt.Fprint(w, str+counts)
+}
+
+// mailboxNode is a mailbox in the tree, or a level of names the server
+// lists no mailbox for
+type mailboxNode struct {
+	info     MailboxInfo
+	label    string
+	children []*mailboxNode
+}
+
+// buildMailboxTree nests mailboxes by their hierarchy delimiter. Levels
+// without a mailbox of their own become unselectable nodes. Siblings are
+// ordered with the special-use mailboxes first, then alphabetically.
+func buildMailboxTree(mailboxes []MailboxInfo) []*mailboxNode {
+	root := &mailboxNode{}
+	nodes := make(map[string]*mailboxNode)
+	for _, mb := range mailboxes {
+		levels := []string{mb.Name}
+		if mb.Delim != 0 {
+			levels = strings.Split(mb.Name, string(mb.Delim))
+		}
+
+		parent, path := root, ""
+		for i, level := range levels {
+			if i > 0 {
+				path += string(mb.Delim)
+			}
+			path += level
+			node := nodes[path]
+			if node == nil {
+				node = &mailboxNode{
+					info:  MailboxInfo{Name: path, Delim: mb.Delim, NoSelect: true},
+					label: level,
+				}
+				nodes[path] = node
+				parent.children = append(parent.children, node)
+			}
+			parent = node
+		}
+		parent.info = mb
+	}
+
+	sortMailboxTree(root.children)
+	return root.children
+}
+
+func sortMailboxTree(nodes []*mailboxNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		ri, rj := specialUseRank(nodes[i].info.SpecialUse), specialUseRank(nodes[j].info.SpecialUse)
+		if ri != rj {
+			return ri < rj
+		}
+		return strings.ToLower(nodes[i].label) < strings.ToLower(nodes[j].label)
+	})
+	for _, node := range nodes {
+		sortMailboxTree(node.children)
+	}
 }
 
-// MailboxList is the mailbox list view
+// mailboxPrompt is the question the mailbox list is asking
+type mailboxPrompt int
+
+const (
+	noPrompt mailboxPrompt = iota
+	createPrompt
+	renamePrompt
+	deletePrompt
+)
+
+// MailboxList is the mailbox list view. With more than one account it
+// lists the accounts as well, the mailboxes of the active one below it.
+// Mailboxes are created, renamed and deleted through a prompt below the
+// list.
 type MailboxList struct {
-	list list.Model
-	keys KeyMap
+	list      list.Model
+	keys      KeyMap
+	height    int
+	accounts  []string
+	active    string
+	mailboxes []MailboxInfo
+	unified   bool
+
+	// collapsed holds the mailboxes whose children are hidden
+	collapsed map[string]bool
+	counts    map[string]MailboxCounts
+
+	// subscriptions is whether the server reports which mailboxes are
+	// subscribed; only then can subscribedOnly hide the others
+	subscriptions  bool
+	subscribedOnly bool
+
+	prompt    textinput.Model
+	prompting mailboxPrompt
+	target    MailboxInfo
+	promptErr error
 }
 
 // NewMailboxList creates a new mailbox list view
@@ -66,25 +280,381 @@ func NewMailboxList(keys KeyMap) MailboxList {
 	l.SetShowHelp(false)
 
 	return MailboxList{
-		list: l,
-		keys: keys,
+		list:      l,
+		keys:      keys,
+		collapsed: make(map[string]bool),
+		prompt:    textinput.New(),
 	}
 }
 
 // SetSize updates the mailbox list dimensions
 func (m *MailboxList) SetSize(width, height int) {
-	m.list.SetSize(width, height-3) // Account for title and status bar
+	m.height = height
+	m.list.SetSize(width, m.listHeight())
+}
+
+// listHeight leaves room for the title and status bar, and for the prompt
+// while one is open
+func (m MailboxList) listHeight() int {
+	if m.prompting != noPrompt {
+		return m.height - 4
+	}
+	return m.height - 3
+}
+
+// SetAccounts sets the accounts to switch between and the active one
+func (m *MailboxList) SetAccounts(accounts []string, active string) {
+	m.accounts = accounts
+	m.SetActiveAccount(active)
+}
+
+// SetActiveAccount moves the mailbox list under the named account and
+// puts the cursor on it
+func (m *MailboxList) SetActiveAccount(name string) {
+	m.active = name
+	m.mailboxes = nil
+	m.counts = nil
+	m.closePrompt()
+	m.rebuild()
+	for i, item := range m.list.Items() {
+		if item.(mailboxItem).account == name {
+			m.list.Select(i)
+			break
+		}
+	}
+}
+
+// SetUnified adds All Inboxes at the top of the list
+func (m *MailboxList) SetUnified(unified bool) {
+	m.unified = unified
+	m.rebuild()
+}
+
+// SetMailboxes updates the mailboxes of the active account.
+// subscriptions tells whether their subscription state is known.
+func (m *MailboxList) SetMailboxes(mailboxes []MailboxInfo, subscriptions bool) {
+	m.mailboxes = mailboxes
+	m.subscriptions = subscriptions
+	m.rebuild()
+}
+
+// SetCounts updates the message counts shown next to the mailboxes
+func (m *MailboxList) SetCounts(counts map[string]MailboxCounts) {
+	m.counts = counts
+	m.rebuild()
+}
+
+// SetCollapsed sets the mailboxes of the active account that start
+// collapsed
+func (m *MailboxList) SetCollapsed(mailboxes []string) {
+	m.collapsed = make(map[string]bool, len(mailboxes))
+	for _, name := range mailboxes {
+		m.collapsed[name] = true
+	}
+	m.rebuild()
+}
+
+// SetSubscribedOnly hides the mailboxes that aren't subscribed
+func (m *MailboxList) SetSubscribedOnly(subscribedOnly bool) {
+	m.subscribedOnly = subscribedOnly
+	m.rebuild()
+}
+
+// Prompting reports whether the list is asking for a name or confirmation,
+// so keys go to the prompt
+func (m MailboxList) Prompting() bool {
+	return m.prompting != noPrompt
 }
 
-// SetMailboxes updates the mailbox list
-func (m *MailboxList) SetMailboxes(mailboxes []string) {
-	items := make([]list.Item, len(mailboxes))
-	for i, mb := range mailboxes {
-		items[i] = mailboxItem{name: mb}
+// visibleMailboxes returns the mailboxes to list. When only subscribed
+// ones are shown, INBOX stays since servers rarely list it as subscribed.
+func (m MailboxList) visibleMailboxes() []MailboxInfo {
+	if !m.subscribedOnly || !m.subscriptions {
+		return m.mailboxes
+	}
+
+	var visible []MailboxInfo
+	for _, mb := range m.mailboxes {
+		if mb.Subscribed || strings.EqualFold(mb.Name, "INBOX") {
+			visible = append(visible, mb)
+		}
+	}
+	return visible
+}
+
+func (m *MailboxList) rebuild() {
+	tree := buildMailboxTree(m.visibleMailboxes())
+	items := make([]list.Item, 0, len(m.accounts)+len(m.mailboxes)+1)
+	if m.unified {
+		items = append(items, mailboxItem{name: unifiedTitle, unified: true})
+	}
+
+	if len(m.accounts) < 2 {
+		m.list.SetItems(m.appendTree(items, tree, 0))
+		return
+	}
+
+	for _, name := range m.accounts {
+		items = append(items, mailboxItem{name: name, account: name})
+		if name == m.active {
+			items = m.appendTree(items, tree, 1)
+		}
 	}
 	m.list.SetItems(items)
 }
 
+// appendTree adds the rows of nodes and, unless they are collapsed, of
+// their children
+func (m MailboxList) appendTree(items []list.Item, nodes []*mailboxNode, depth int) []list.Item {
+	for _, node := range nodes {
+		collapsed := m.collapsed[node.info.Name] && len(node.children) > 0
+		counts, counted := m.counts[node.info.Name]
+		items = append(items, mailboxItem{
+			name:         node.info.Name,
+			label:        node.label,
+			use:          node.info.SpecialUse,
+			depth:        depth,
+			unsubscribed: m.subscriptions && !node.info.Subscribed && !node.info.NoSelect,
+			noSelect:     node.info.NoSelect,
+			children:     len(node.children) > 0,
+			collapsed:    collapsed,
+			counted:      counted,
+			unread:       counts.Unread,
+			total:        counts.Total,
+		})
+		if !collapsed {
+			items = m.appendTree(items, node.children, depth+1)
+		}
+	}
+	return items
+}
+
+// selectName puts the cursor on the mailbox called name
+func (m *MailboxList) selectName(name string) {
+	for i, item := range m.list.Items() {
+		if mb := item.(mailboxItem); mb.name == name && mb.account == "" && !mb.unified {
+			m.list.Select(i)
+			return
+		}
+	}
+}
+
+// setCollapsed collapses or expands the children of the selected mailbox
+// and asks for the change to be remembered
+func (m *MailboxList) setCollapsed(item mailboxItem, collapsed bool) tea.Cmd {
+	if collapsed {
+		m.collapsed[item.name] = true
+	} else {
+		delete(m.collapsed, item.name)
+	}
+	m.rebuild()
+	m.selectName(item.name)
+	return func() tea.Msg {
+		return MailboxCollapsedMsg{Mailbox: item.name, Collapsed: collapsed}
+	}
+}
+
+// updateTree handles the keys that fold the tree. h collapses the selected
+// mailbox, or moves to its parent when there is nothing to collapse; l
+// expands it.
+func (m MailboxList) updateTree(msg tea.KeyMsg) (MailboxList, tea.Cmd, bool) {
+	item, ok := m.list.SelectedItem().(mailboxItem)
+	if !ok || item.account != "" || item.unified {
+		return m, nil, false
+	}
+
+	switch {
+	case key.Matches(msg, m.keys.Collapse):
+		if item.children && !item.collapsed {
+			return m, m.setCollapsed(item, true), true
+		}
+		if delim := hierarchyDelim(m.mailboxes); delim != 0 {
+			if parent := mailboxParent(item.name, delim); parent != "" {
+				m.selectName(parent)
+			}
+		}
+		return m, nil, true
+	case key.Matches(msg, m.keys.Expand):
+		if item.collapsed {
+			return m, m.setCollapsed(item, false), true
+		}
+		return m, nil, true
+	case key.Matches(msg, m.keys.Enter) && item.noSelect && item.children:
+		// Levels that hold no mail of their own fold on enter
+		return m, m.setCollapsed(item, !item.collapsed), true
+	}
+	return m, nil, false
+}
+
+// selectedMailbox returns the mailbox under the cursor, if the cursor is
+// on one of the active account's mailboxes
+func (m MailboxList) selectedMailbox() (MailboxInfo, bool) {
+	selected, ok := m.list.SelectedItem().(mailboxItem)
+	if !ok || selected.account != "" || selected.unified {
+		return MailboxInfo{}, false
+	}
+	for _, mb := range m.mailboxes {
+		if mb.Name == selected.name {
+			return mb, true
+		}
+	}
+	return MailboxInfo{}, false
+}
+
+// hasMailbox reports whether the active account has a mailbox called name
+func (m MailboxList) hasMailbox(name string) bool {
+	for _, mb := range m.mailboxes {
+		if mb.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// openPrompt asks for a mailbox name, starting with value
+func (m *MailboxList) openPrompt(prompt mailboxPrompt, target MailboxInfo, label, value string) tea.Cmd {
+	m.prompting = prompt
+	m.target = target
+	m.promptErr = nil
+	m.prompt.Prompt = label
+	m.prompt.Placeholder = ""
+	if delim := hierarchyDelim(m.mailboxes); delim != 0 {
+		m.prompt.Placeholder = fmt.Sprintf("name, %c between levels", delim)
+	}
+	m.prompt.SetValue(value)
+	m.prompt.CursorEnd()
+	m.list.SetHeight(m.listHeight())
+	return m.prompt.Focus()
+}
+
+func (m *MailboxList) closePrompt() {
+	m.prompting = noPrompt
+	m.promptErr = nil
+	m.prompt.Blur()
+	m.list.SetHeight(m.listHeight())
+}
+
+// mailboxError reports a mailbox action that can't be done
+func mailboxError(format string, args ...any) tea.Cmd {
+	return func() tea.Msg {
+		return MailboxErrorMsg{Err: fmt.Errorf(format, args...)}
+	}
+}
+
+// updateKeys handles the mailbox management keys
+func (m MailboxList) updateKeys(msg tea.KeyMsg) (MailboxList, tea.Cmd, bool) {
+	switch {
+	case key.Matches(msg, m.keys.NewMailbox):
+		// Start among the siblings of the selected mailbox
+		value := ""
+		if selected, ok := m.selectedMailbox(); ok {
+			if parent := mailboxParent(selected.Name, selected.Delim); parent != "" {
+				value = parent + string(selected.Delim)
+			}
+		}
+		return m, m.openPrompt(createPrompt, MailboxInfo{}, "New mailbox: ", value), true
+
+	case key.Matches(msg, m.keys.RenameMailbox):
+		selected, ok := m.selectedMailbox()
+		if !ok {
+			return m, nil, true
+		}
+		if strings.EqualFold(selected.Name, "INBOX") {
+			return m, mailboxError("INBOX can't be renamed"), true
+		}
+		return m, m.openPrompt(renamePrompt, selected, "Rename to: ", selected.Name), true
+
+	case key.Matches(msg, m.keys.Delete):
+		selected, ok := m.selectedMailbox()
+		if !ok {
+			return m, nil, true
+		}
+		if strings.EqualFold(selected.Name, "INBOX") {
+			return m, mailboxError("INBOX can't be deleted"), true
+		}
+		m.prompting = deletePrompt
+		m.target = selected
+		m.list.SetHeight(m.listHeight())
+		return m, nil, true
+
+	case key.Matches(msg, m.keys.Subscribe):
+		selected, ok := m.selectedMailbox()
+		if !ok {
+			return m, nil, true
+		}
+		if !m.subscriptions {
+			return m, mailboxError("the server doesn't report subscriptions"), true
+		}
+		return m, func() tea.Msg {
+			return SubscribeRequestMsg{Mailbox: selected.Name, Subscribe: !selected.Subscribed}
+		}, true
+
+	case key.Matches(msg, m.keys.SubscribedOnly):
+		if !m.subscriptions {
+			return m, mailboxError("the server doesn't report subscriptions"), true
+		}
+		m.SetSubscribedOnly(!m.subscribedOnly)
+		return m, nil, true
+	}
+	return m, nil, false
+}
+
+// updatePrompt handles input while a prompt is open
+func (m MailboxList) updatePrompt(msg tea.Msg) (MailboxList, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if m.prompting == deletePrompt {
+		if !ok {
+			return m, nil
+		}
+		target := m.target
+		m.closePrompt()
+		if keyMsg.String() != "y" {
+			return m, nil
+		}
+		return m, func() tea.Msg {
+			return DeleteMailboxRequestMsg{Mailbox: target.Name}
+		}
+	}
+
+	if ok {
+		switch keyMsg.Type {
+		case tea.KeyEsc:
+			m.closePrompt()
+			return m, nil
+		case tea.KeyEnter:
+			name, err := checkMailboxName(m.prompt.Value(), hierarchyDelim(m.mailboxes))
+			if err != nil {
+				m.promptErr = err
+				return m, nil
+			}
+			target := m.target
+			if m.prompting == renamePrompt && name == target.Name {
+				m.closePrompt()
+				return m, nil
+			}
+			if m.hasMailbox(name) {
+				m.promptErr = fmt.Errorf("%s already exists", name)
+				return m, nil
+			}
+
+			prompt := m.prompting
+			m.closePrompt()
+			if prompt == renamePrompt {
+				return m, func() tea.Msg {
+					return RenameMailboxRequestMsg{Mailbox: target.Name, NewName: name}
+				}
+			}
+			return m, func() tea.Msg {
+				return CreateMailboxRequestMsg{Mailbox: name}
+			}
+		}
+	}
+
+	var cmd tea.Cmd
+	m.prompt, cmd = m.prompt.Update(msg)
+	return m, cmd
+}
+
 // Init initializes the mailbox list
 func (m MailboxList) Init() tea.Cmd {
 	return nil
@@ -94,21 +664,37 @@ func (m MailboxList) Init() tea.Cmd {
 func (m MailboxList) Update(msg tea.Msg) (MailboxList, tea.Cmd) {
 	var cmd tea.Cmd
 
+	if m.prompting != noPrompt {
+		return m.updatePrompt(msg)
+	}
+
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if updated, cmd, handled := m.updateTree(msg); handled {
+			return updated, cmd
+		}
+		if updated, cmd, handled := m.updateKeys(msg); handled {
+			return updated, cmd
+		}
 		switch {
 		case key.Matches(msg, m.keys.Enter):
 			if selected := m.list.SelectedItem(); selected != nil {
 				mailbox := selected.(mailboxItem)
-				if !strings.HasPrefix(mailbox.name, "---") {
+				if mailbox.unified {
+					return m, func() tea.Msg { return UnifiedSelectedMsg{} }
+				}
+				if mailbox.account != "" {
+					return m, func() tea.Msg {
+						return AccountSelectedMsg{Account: mailbox.account}
+					}
+				}
+				if info, ok := m.selectedMailbox(); ok && !info.NoSelect {
 					return m, func() tea.Msg {
-						return MailboxSelectedMsg{Mailbox: mailbox.name}
+						return MailboxSelectedMsg{Mailbox: info.Name}
 					}
 				}
 			}
 		}
-	case MailboxesLoadedMsg:
-		m.SetMailboxes(msg.Mailboxes)
 	}
 
 	m.list, cmd = m.list.Update(msg)
@@ -117,7 +703,20 @@ func (m MailboxList) Update(msg tea.Msg) (MailboxList, tea.Cmd) {
 
 // View renders the mailbox list
 func (m MailboxList) View() string {
+	view := m.list.View()
+	switch m.prompting {
+	case deletePrompt:
+		view = lipgloss.JoinVertical(lipgloss.Left, view,
+			ErrorStyle.Render(fmt.Sprintf("Delete %s and all its messages? (y/n)", m.target.Name)))
+	case createPrompt, renamePrompt:
+		line := m.prompt.View()
+		if m.promptErr != nil {
+			line += "  " + ErrorStyle.Render(m.promptErr.Error())
+		}
+		view = lipgloss.JoinVertical(lipgloss.Left, view, line)
+	}
+
 	return lipgloss.NewStyle().
 		Border(lipgloss.NormalBorder(), false, true, false, false).
-		Render(m.list.View())
+		Render(view)
 }
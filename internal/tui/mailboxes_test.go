@@ -0,0 +1,409 @@
+package tui
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	imapClient "github.com/chhlga/budge/internal/imap"
+)
+
+func TestMailboxCommands_manageMailboxes(t *testing.T) {
+	addr, cleanupServer := startIMAPMemServer(t)
+	defer cleanupServer()
+
+	client := imapClient.NewClient(&imapClient.Options{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "user",
+		Password: "pass",
+	})
+	defer func() { _ = client.Disconnect() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	if err := client.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error: %v", err)
+	}
+
+	list := func() map[string]MailboxInfo {
+		t.Helper()
+		msg := loadMailboxesCmd("work", client)()
+		loaded, ok := msg.(MailboxesLoadedMsg)
+		if !ok {
+			t.Fatalf("Expected MailboxesLoadedMsg, got %#v", msg)
+		}
+		if !loaded.Subscriptions {
+			t.Fatalf("Expected the server to report subscriptions")
+		}
+		byName := make(map[string]MailboxInfo)
+		for _, mb := range loaded.Mailboxes {
+			byName[mb.Name] = mb
+		}
+		return byName
+	}
+
+	for _, cmd := range []tea.Cmd{
+		createMailboxCmd("work", client, "Projects"),
+		createMailboxCmd("work", client, "Projects/Acme"),
+	} {
+		if msg, ok := cmd().(MailboxChangedMsg); !ok || msg.Account != "work" {
+			t.Fatalf("Expected MailboxChangedMsg for work, got %#v", msg)
+		}
+	}
+	acme, ok := list()["Projects/Acme"]
+	if !ok || !acme.Subscribed || acme.Delim != '/' {
+		t.Fatalf("Expected a subscribed Projects/Acme with / as delimiter, got %+v", acme)
+	}
+
+	if msg, ok := setSubscribedCmd("work", client, "Projects/Acme", false)().(MailboxChangedMsg); !ok {
+		t.Fatalf("Expected MailboxChangedMsg, got %#v", msg)
+	}
+	if list()["Projects/Acme"].Subscribed {
+		t.Errorf("Expected Projects/Acme to be unsubscribed")
+	}
+
+	msg := renameMailboxCmd("work", client, "Projects/Acme", "Clients", false)()
+	if changed, ok := msg.(MailboxChangedMsg); !ok || changed.NewName != "Clients" {
+		t.Fatalf("Expected the rename to be reported, got %#v", msg)
+	}
+	msg = deleteMailboxCmd("work", client, "Clients")()
+	if changed, ok := msg.(MailboxChangedMsg); !ok || !changed.Deleted {
+		t.Fatalf("Expected the deletion to be reported, got %#v", msg)
+	}
+	mailboxes := list()
+	if _, ok := mailboxes["Clients"]; ok {
+		t.Errorf("Expected Clients to be gone")
+	}
+	if _, ok := mailboxes["Projects/Acme"]; ok {
+		t.Errorf("Expected Projects/Acme to be renamed")
+	}
+
+	if _, ok := deleteMailboxCmd("work", client, "Missing")().(MailboxErrorMsg); !ok {
+		t.Errorf("Expected deleting a missing mailbox to fail")
+	}
+}
+
+func newMailboxTestList(t *testing.T, selected string) MailboxList {
+	t.Helper()
+
+	m := NewMailboxList(NewKeyMap())
+	m.SetAccounts([]string{"work"}, "work")
+	m.SetSize(80, 40)
+	m.SetMailboxes([]MailboxInfo{
+		{Name: "INBOX", Delim: '/', Subscribed: true, SpecialUse: SpecialInbox},
+		{Name: "Archive", Delim: '/'},
+		{Name: "Projects", Delim: '/', Subscribed: true},
+		{Name: "Projects/Acme", Delim: '/', Subscribed: true},
+	}, true)
+	for i, item := range m.list.Items() {
+		if item.(mailboxItem).name == selected {
+			m.list.Select(i)
+		}
+	}
+	return m
+}
+
+func typeKeys(m MailboxList, keys ...tea.KeyMsg) (MailboxList, tea.Cmd) {
+	var cmd tea.Cmd
+	for _, k := range keys {
+		m, cmd = m.Update(k)
+	}
+	return m, cmd
+}
+
+func runes(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+func TestMailboxList_createsAmongSiblings(t *testing.T) {
+	m := newMailboxTestList(t, "Projects/Acme")
+
+	m, _ = typeKeys(m, runes("n"))
+	if !m.Prompting() || m.prompt.Value() != "Projects/" {
+		t.Fatalf("Expected a prompt starting at Projects/, got %q", m.prompt.Value())
+	}
+
+	m, _ = typeKeys(m, runes("/Bad"), tea.KeyMsg{Type: tea.KeyEnter})
+	if m.promptErr == nil {
+		t.Fatalf("Expected an empty level to be rejected")
+	}
+
+	m.prompt.SetValue("Projects/Globex/")
+	m, cmd := typeKeys(m, tea.KeyMsg{Type: tea.KeyEnter})
+	request, ok := findMsg[CreateMailboxRequestMsg](cmd)
+	if !ok || request.Mailbox != "Projects/Globex" {
+		t.Fatalf("Expected a request for Projects/Globex, got %+v", request)
+	}
+	if m.Prompting() {
+		t.Errorf("Expected the prompt to close")
+	}
+}
+
+func TestMailboxList_renamesAndDeletes(t *testing.T) {
+	m := newMailboxTestList(t, "Projects")
+
+	m, _ = typeKeys(m, runes("e"))
+	if m.prompt.Value() != "Projects" {
+		t.Fatalf("Expected the prompt to start with the current name, got %q", m.prompt.Value())
+	}
+	m.prompt.SetValue("Archive")
+	m, _ = typeKeys(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if m.promptErr == nil {
+		t.Fatalf("Expected renaming onto an existing mailbox to be rejected")
+	}
+	m.prompt.SetValue("Work")
+	m, cmd := typeKeys(m, tea.KeyMsg{Type: tea.KeyEnter})
+	if request, _ := findMsg[RenameMailboxRequestMsg](cmd); request != (RenameMailboxRequestMsg{Mailbox: "Projects", NewName: "Work"}) {
+		t.Fatalf("Expected a request to rename Projects to Work, got %+v", request)
+	}
+
+	m, cmd = typeKeys(m, runes("d"), runes("n"))
+	if cmd != nil || m.Prompting() {
+		t.Fatalf("Expected n to cancel the deletion")
+	}
+	_, cmd = typeKeys(m, runes("d"), runes("y"))
+	if request, _ := findMsg[DeleteMailboxRequestMsg](cmd); request.Mailbox != "Projects" {
+		t.Fatalf("Expected a request to delete Projects, got %+v", request)
+	}
+
+	inbox := newMailboxTestList(t, "INBOX")
+	_, cmd = typeKeys(inbox, runes("d"))
+	if _, ok := findMsg[MailboxErrorMsg](cmd); !ok {
+		t.Errorf("Expected INBOX to be protected from deletion")
+	}
+}
+
+func TestMailboxList_subscriptions(t *testing.T) {
+	m := newMailboxTestList(t, "Archive")
+
+	_, cmd := typeKeys(m, runes("s"))
+	if request, _ := findMsg[SubscribeRequestMsg](cmd); request != (SubscribeRequestMsg{Mailbox: "Archive", Subscribe: true}) {
+		t.Fatalf("Expected a request to subscribe to Archive, got %+v", request)
+	}
+
+	m, _ = typeKeys(m, runes("S"))
+	var names []string
+	for _, item := range m.list.Items() {
+		names = append(names, item.(mailboxItem).name)
+	}
+	want := []string{"INBOX", "Projects", "Projects/Acme"}
+	if len(names) != len(want) {
+		t.Fatalf("Expected only subscribed mailboxes %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, names)
+			break
+		}
+	}
+
+	// Without subscription state from the server every mailbox stays
+	m.SetMailboxes([]MailboxInfo{{Name: "INBOX"}, {Name: "Archive"}}, false)
+	if got := len(m.list.Items()); got != 2 {
+		t.Errorf("Expected both mailboxes when subscriptions are unknown, got %d", got)
+	}
+}
+
+func TestRenamedMailbox_followsParent(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"Projects", "Work"},
+		{"Projects/Acme", "Work/Acme"},
+		{"ProjectsOld", "ProjectsOld"},
+	}
+	for _, tt := range tests {
+		if got := renamedMailbox(tt.name, "Projects", "Work", '/'); got != tt.want {
+			t.Errorf("renamedMailbox(%q) = %q, expected %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMailboxChanged_followsOpenMailbox(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.account().mailboxes = []MailboxInfo{{Name: "Projects", Delim: '/'}, {Name: "Projects/Acme", Delim: '/'}}
+	m.switchMailbox("Projects/Acme")
+
+	updated, _ := m.Update(MailboxChangedMsg{Account: m.account().name, Mailbox: "Projects", NewName: "Work"})
+	m = updated.(Model)
+	if m.currentMailbox != "Work/Acme" {
+		t.Fatalf("Expected the open mailbox to follow its parent, got %q", m.currentMailbox)
+	}
+
+	updated, _ = m.Update(MailboxChangedMsg{Account: m.account().name, Mailbox: "Work/Acme", Deleted: true})
+	m = updated.(Model)
+	if m.currentMailbox != "" {
+		t.Errorf("Expected the deleted mailbox to be closed, got %q", m.currentMailbox)
+	}
+}
+
+func TestMailboxList_rendersTree(t *testing.T) {
+	m := NewMailboxList(NewKeyMap())
+	m.SetAccounts([]string{"work"}, "work")
+	m.SetSize(80, 40)
+	m.SetMailboxes([]MailboxInfo{
+		{Name: "Projects/2024/Clients/Acme", Delim: '/'},
+		{Name: "archive", Delim: '/'},
+		{Name: "Projects", Delim: '/'},
+		{Name: "INBOX", Delim: '/', SpecialUse: SpecialInbox},
+	}, false)
+
+	want := []mailboxItem{
+		{name: "INBOX", label: "INBOX", use: SpecialInbox},
+		{name: "archive", label: "archive"},
+		{name: "Projects", label: "Projects", children: true},
+		{name: "Projects/2024", label: "2024", depth: 1, noSelect: true, children: true},
+		{name: "Projects/2024/Clients", label: "Clients", depth: 2, noSelect: true, children: true},
+		{name: "Projects/2024/Clients/Acme", label: "Acme", depth: 3},
+	}
+	items := m.list.Items()
+	if len(items) != len(want) {
+		t.Fatalf("Expected %d rows, got %d", len(want), len(items))
+	}
+	for i, item := range items {
+		if item.(mailboxItem) != want[i] {
+			t.Errorf("Row %d: expected %+v, got %+v", i, want[i], item)
+		}
+	}
+}
+
+func TestMailboxList_collapsesAndExpands(t *testing.T) {
+	m := newMailboxTestList(t, "Projects/Acme")
+
+	// h on a leaf moves to its parent, the next h collapses it
+	m, cmd := typeKeys(m, runes("h"))
+	if cmd != nil || m.list.SelectedItem().(mailboxItem).name != "Projects" {
+		t.Fatalf("Expected h to move to Projects, got %+v", m.list.SelectedItem())
+	}
+	m, cmd = typeKeys(m, runes("h"))
+	if collapsed, _ := findMsg[MailboxCollapsedMsg](cmd); collapsed != (MailboxCollapsedMsg{Mailbox: "Projects", Collapsed: true}) {
+		t.Fatalf("Expected Projects to be reported collapsed, got %+v", collapsed)
+	}
+	if got := len(m.list.Items()); got != 3 {
+		t.Errorf("Expected Projects/Acme to be hidden, got %d rows", got)
+	}
+
+	m, cmd = typeKeys(m, runes("l"))
+	if collapsed, _ := findMsg[MailboxCollapsedMsg](cmd); collapsed != (MailboxCollapsedMsg{Mailbox: "Projects"}) {
+		t.Fatalf("Expected Projects to be reported expanded, got %+v", collapsed)
+	}
+	if got := len(m.list.Items()); got != 4 {
+		t.Errorf("Expected Projects/Acme to be back, got %d rows", got)
+	}
+
+	m.SetCollapsed([]string{"Projects"})
+	if got := len(m.list.Items()); got != 3 {
+		t.Errorf("Expected remembered state to collapse Projects, got %d rows", got)
+	}
+}
+
+func TestLoadMailboxCountsCmd_countsUnreadAndTotal(t *testing.T) {
+	addr, cleanupServer := startIMAPMemServer(t)
+	defer cleanupServer()
+
+	client := imapClient.NewClient(&imapClient.Options{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "user",
+		Password: "pass",
+	})
+	defer func() { _ = client.Disconnect() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	if err := client.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error: %v", err)
+	}
+	if msg, ok := createMailboxCmd("work", client, "Lists")().(MailboxChangedMsg); !ok {
+		t.Fatalf("Expected MailboxChangedMsg, got %#v", msg)
+	}
+	for i := 0; i < 3; i++ {
+		appendMessage(t, client.Client(), "INBOX", "Subject: hello\r\nFrom: alice@example.com\r\n\r\nBody\r\n")
+	}
+	if msg := markReadCmd("work", client, "INBOX", 1, true)(); msg != nil {
+		t.Fatalf("Expected markReadCmd to succeed, got %#v", msg)
+	}
+
+	// Several mailboxes go through LIST-STATUS, a single one through STATUS
+	for _, mailboxes := range [][]string{{"INBOX", "Lists"}, {"INBOX"}} {
+		msg := loadMailboxCountsCmd("work", client, mailboxes)()
+		loaded, ok := msg.(MailboxCountsLoadedMsg)
+		if !ok {
+			t.Fatalf("Expected MailboxCountsLoadedMsg, got %#v", msg)
+		}
+		if got := loaded.Counts["INBOX"]; got != (MailboxCounts{Unread: 2, Total: 3}) {
+			t.Errorf("Expected 2 of 3 unread in INBOX, got %+v", got)
+		}
+		if _, ok := loaded.Counts["Lists"]; len(mailboxes) > 1 && !ok {
+			t.Errorf("Expected counts for Lists")
+		}
+	}
+}
+
+func TestMoveEmailCmd_movesToMailbox(t *testing.T) {
+	addr, cleanupServer := startIMAPMemServer(t)
+	defer cleanupServer()
+
+	client := imapClient.NewClient(&imapClient.Options{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Username: "user",
+		Password: "pass",
+	})
+	defer func() { _ = client.Disconnect() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	if err := client.Authenticate(ctx); err != nil {
+		t.Fatalf("Authenticate() error: %v", err)
+	}
+	if msg, ok := createMailboxCmd("work", client, "Archive")().(MailboxChangedMsg); !ok {
+		t.Fatalf("Expected MailboxChangedMsg, got %#v", msg)
+	}
+	appendMessage(t, client.Client(), "INBOX", "Subject: hello\r\nFrom: alice@example.com\r\n\r\nBody\r\n")
+
+	msg := moveEmailCmd("work", client, "INBOX", 1, "Archive")()
+	if moved, ok := msg.(EmailMovedMsg); !ok || moved.Mailbox != "INBOX" || moved.To != "Archive" {
+		t.Fatalf("Expected EmailMovedMsg, got %#v", msg)
+	}
+
+	loaded := loadMailboxCountsCmd("work", client, []string{"INBOX", "Archive"})().(MailboxCountsLoadedMsg)
+	if loaded.Counts["INBOX"].Total != 0 || loaded.Counts["Archive"].Total != 1 {
+		t.Errorf("Expected the message to be in Archive only, got %+v", loaded.Counts)
+	}
+
+	if _, ok := moveEmailCmd("work", client, "INBOX", 1, "Nowhere")().(ErrorMsg); !ok {
+		t.Errorf("Expected moving to a missing mailbox to fail")
+	}
+}
+
+func TestMailboxCountsLoaded_showsCountsOfActiveAccount(t *testing.T) {
+	m := newComposeTestModel(nil)
+	updated, _ := m.Update(MailboxesLoadedMsg{Account: m.account().name, Mailboxes: []MailboxInfo{{Name: "INBOX"}, {Name: "Lists"}}})
+	m = updated.(Model)
+
+	updated, _ = m.Update(MailboxCountsLoadedMsg{Account: m.account().name, Counts: map[string]MailboxCounts{"INBOX": {Unread: 4, Total: 10}}})
+	m = updated.(Model)
+	updated, _ = m.Update(MailboxCountsLoadedMsg{Account: m.account().name, Counts: map[string]MailboxCounts{"Lists": {Total: 7}}})
+	m = updated.(Model)
+
+	want := map[string]MailboxCounts{"INBOX": {Unread: 4, Total: 10}, "Lists": {Total: 7}}
+	for _, item := range m.mailboxList.list.Items() {
+		mb := item.(mailboxItem)
+		if !mb.counted || (MailboxCounts{Unread: mb.unread, Total: mb.total}) != want[mb.name] {
+			t.Errorf("Expected %s to show %+v, got %+v", mb.name, want[mb.name], mb)
+		}
+	}
+}
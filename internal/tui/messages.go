@@ -1,23 +1,98 @@
 package tui
 
 import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
 	"github.com/chhlga/budge/internal/email"
 	"github.com/chhlga/budge/internal/imap"
+	"github.com/chhlga/budge/internal/outbox"
 )
 
 // Custom message types for inter-component communication
 
-// ConnectCompleteMsg is sent when IMAP connection is established
-type ConnectCompleteMsg struct{}
+// ConnectCompleteMsg is sent when an account's IMAP connection is established
+type ConnectCompleteMsg struct {
+	Account string
+}
 
-// ConnectErrorMsg is sent when IMAP connection fails
+// ConnectErrorMsg is sent when an account's IMAP connection fails
 type ConnectErrorMsg struct {
-	Err error
+	Account string
+	Err     error
 }
 
-// MailboxesLoadedMsg is sent when mailbox list is fetched
+// AccountSelectedMsg is sent when the user switches to another account
+type AccountSelectedMsg struct {
+	Account string
+}
+
+// MailboxesLoadedMsg is sent when an account's mailbox list is fetched.
+// Subscriptions is false when the server cannot report which mailboxes
+// are subscribed; all of them are marked subscribed then.
 type MailboxesLoadedMsg struct {
-	Mailboxes []string
+	Account       string
+	Mailboxes     []MailboxInfo
+	Subscriptions bool
+}
+
+// MailboxCounts is the number of unread and of all messages in a mailbox
+type MailboxCounts struct {
+	Unread uint32
+	Total  uint32
+}
+
+// MailboxCountsLoadedMsg is sent when the message counts of some of an
+// account's mailboxes are fetched
+type MailboxCountsLoadedMsg struct {
+	Account string
+	Counts  map[string]MailboxCounts
+}
+
+// MailboxCollapsedMsg is sent when the user folds or unfolds a mailbox
+// in the tree
+type MailboxCollapsedMsg struct {
+	Mailbox   string
+	Collapsed bool
+}
+
+// CreateMailboxRequestMsg is sent when the user names a new mailbox
+type CreateMailboxRequestMsg struct {
+	Mailbox string
+}
+
+// RenameMailboxRequestMsg is sent when the user renames a mailbox
+type RenameMailboxRequestMsg struct {
+	Mailbox string
+	NewName string
+}
+
+// DeleteMailboxRequestMsg is sent when the user confirms deleting a mailbox
+type DeleteMailboxRequestMsg struct {
+	Mailbox string
+}
+
+// SubscribeRequestMsg is sent when the user subscribes to a mailbox or
+// unsubscribes from it
+type SubscribeRequestMsg struct {
+	Mailbox   string
+	Subscribe bool
+}
+
+// MailboxChangedMsg is sent when a mailbox was created, renamed, deleted
+// or (un)subscribed. NewName is set for renames and empty for deletions.
+type MailboxChangedMsg struct {
+	Account string
+	Mailbox string
+	NewName string
+	Deleted bool
+	Notice  string
+}
+
+// MailboxErrorMsg is sent when changing a mailbox fails
+type MailboxErrorMsg struct {
+	Err error
 }
 
 // MailboxSelectedMsg is sent when user selects a mailbox
@@ -25,21 +100,60 @@ type MailboxSelectedMsg struct {
 	Mailbox string
 }
 
-// EmailsLoadedMsg is sent when email list is fetched
-type EmailsLoadedMsg struct {
+// MailboxRef names a mailbox of an account. Messages of the unified inbox
+// carry the one they are from; it is empty for the mailbox that is open.
+type MailboxRef struct {
+	Account string
+	Mailbox string
+}
+
+// UnifiedSelectedMsg is sent when the user opens All Inboxes
+type UnifiedSelectedMsg struct{}
+
+// UnifiedEmailsLoadedMsg is sent when the messages of one of the mailboxes
+// merged into All Inboxes are fetched
+type UnifiedEmailsLoadedMsg struct {
+	Source MailboxRef
 	Emails []email.Message
 	Total  uint32
 }
 
+// EmailsLoadedMsg is sent when email list is fetched
+type EmailsLoadedMsg struct {
+	Mailbox string
+	Emails  []email.Message
+	Total   uint32
+}
+
+// LoadOlderRequestMsg is sent when the cursor nears the end of the email
+// list and older messages than the one with UID Before exist
+type LoadOlderRequestMsg struct {
+	Mailbox string
+	Before  uint32
+}
+
+// OlderEmailsLoadedMsg is sent when a page of older messages is fetched
+type OlderEmailsLoadedMsg struct {
+	Mailbox string
+	Emails  []email.Message
+	Total   uint32
+}
+
 // EmailSelectedMsg is sent when user selects an email
 type EmailSelectedMsg struct {
-	Email email.Message
+	Email  email.Message
+	Source MailboxRef
 }
 
-// EmailBodyLoadedMsg is sent when email body is fetched and rendered
+// EmailBodyLoadedMsg is sent when email body is fetched and rendered.
+// Account and Mailbox name where the message with UID lives.
 type EmailBodyLoadedMsg struct {
-	UID  uint32
-	Body string
+	Account string
+	Mailbox string
+	UID     uint32
+	Body    string
+	Message *email.Message
+	Raw     []byte
 }
 
 // SearchQueryMsg is sent when user submits search query
@@ -49,25 +163,53 @@ type SearchQueryMsg struct {
 
 type SearchCancelledMsg struct{}
 
-// ConnectionStateChangedMsg is sent when connection state changes
+// ConnectionStateChangedMsg is sent when an account's connection state changes
 type ConnectionStateChangedMsg struct {
-	State imap.ConnectionState
+	Account string
+	State   imap.ConnectionState
 }
 
-// ErrorMsg is a generic error message
+// ReconnectResultMsg is sent when an attempt to restore an account's lost
+// IMAP session has finished
+type ReconnectResultMsg struct {
+	Account string
+	Err     error
+}
+
+// ErrorMsg is a generic error message of an account's command. Retry,
+// when set, runs the failed command again.
 type ErrorMsg struct {
-	Err error
+	Account string
+	Err     error
+	Retry   tea.Cmd
 }
 
 // MarkReadRequestMsg requests marking an email as read/unread
 type MarkReadRequestMsg struct {
-	UID  uint32
-	Read bool
+	UID    uint32
+	Read   bool
+	Source MailboxRef
 }
 
 // DeleteEmailRequestMsg requests deleting an email
 type DeleteEmailRequestMsg struct {
-	UID uint32
+	UID    uint32
+	Source MailboxRef
+}
+
+// MoveEmailRequestMsg requests moving an email to another mailbox of its
+// account
+type MoveEmailRequestMsg struct {
+	UID    uint32
+	Source MailboxRef
+	To     string
+}
+
+// EmailMovedMsg is sent when an email was moved to another mailbox
+type EmailMovedMsg struct {
+	Account string
+	Mailbox string
+	To      string
 }
 
 // NewEmailMsg is sent when new emails are detected via push notification
@@ -76,6 +218,21 @@ type NewEmailMsg struct {
 	Count   uint32
 }
 
+// EmailExpungedMsg is sent when a message is removed from the watched mailbox
+type EmailExpungedMsg struct {
+	Mailbox string
+	SeqNum  uint32
+}
+
+// FlagsChangedMsg is sent when the flags of a message in the watched
+// mailbox change; UID is zero when the server did not report it
+type FlagsChangedMsg struct {
+	Mailbox string
+	SeqNum  uint32
+	UID     uint32
+	Flags   []string
+}
+
 // StartIdleMonitoringMsg starts monitoring mailbox for new emails
 type StartIdleMonitoringMsg struct {
 	Mailbox string
@@ -89,3 +246,167 @@ type LoadingMsg struct {
 }
 
 type LoadingClearedMsg struct{}
+
+// SendEmailRequestMsg requests sending a composed message
+type SendEmailRequestMsg struct {
+	Draft email.Message
+}
+
+// EmailSentMsg is sent when the outgoing server accepted a message.
+// Raw holds the exact bytes that were transmitted.
+type EmailSentMsg struct {
+	Subject string
+	From    string
+	Raw     []byte
+}
+
+// SentCopySavedMsg is sent when a copy of a sent message was stored
+type SentCopySavedMsg struct {
+	Account string
+	Mailbox string
+}
+
+// SentCopyErrorMsg is sent when a sent message could not be copied to
+// the Sent mailbox. The message itself was delivered.
+type SentCopyErrorMsg struct {
+	Err error
+}
+
+// SendErrorMsg is sent when a message could not be sent
+type SendErrorMsg struct {
+	Err error
+}
+
+// ComposeCancelledMsg is sent when the user leaves compose without sending
+type ComposeCancelledMsg struct{}
+
+// SaveDraftRequestMsg is sent when the user saves the compose form as a draft
+type SaveDraftRequestMsg struct {
+	Draft email.Message
+}
+
+// DraftSavedMsg is sent when a draft has been stored on the server.
+// UID is zero when the server does not report it.
+type DraftSavedMsg struct {
+	Account string
+	Mailbox string
+	UID     uint32
+}
+
+// DraftDeletedMsg is sent when a sent draft has been removed from the server
+type DraftDeletedMsg struct {
+	Mailbox string
+	UID     uint32
+}
+
+// DraftErrorMsg is sent when saving or removing a draft fails
+type DraftErrorMsg struct {
+	Err error
+}
+
+// DraftOpenedMsg is sent when a draft from the server is ready for editing
+type DraftOpenedMsg struct {
+	Account string
+	Draft   email.Message
+}
+
+// AttachmentLoadedMsg is sent when a file picked for attaching has been read
+type AttachmentLoadedMsg struct {
+	Attachment email.Attachment
+}
+
+// AttachmentErrorMsg is sent when a picked file could not be attached
+type AttachmentErrorMsg struct {
+	Err error
+}
+
+// EditorFinishedMsg is sent when the external editor exits, carrying
+// the saved contents of the message template
+type EditorFinishedMsg struct {
+	Text string
+	Err  error
+}
+
+// ReplyRequestMsg requests a reply draft for the email in the reader
+type ReplyRequestMsg struct {
+	All bool
+}
+
+// ForwardRequestMsg requests forwarding an email, either quoted inline or
+// with the original attached as message/rfc822
+type ForwardRequestMsg struct {
+	UID          uint32
+	AsAttachment bool
+	Source       MailboxRef
+}
+
+// ForwardReadyMsg is sent when a forward draft has been prepared
+type ForwardReadyMsg struct {
+	Draft email.Message
+}
+
+// ScheduleSendRequestMsg requests sending the composed message at a later time
+type ScheduleSendRequestMsg struct {
+	Draft email.Message
+	At    time.Time
+}
+
+// SendScheduledMsg is sent when a message was put in the outbox to be sent
+// later. Undo marks the short undo-send delay rather than a user-chosen time.
+type SendScheduledMsg struct {
+	Entry outbox.Entry
+	Undo  bool
+}
+
+// EmailQueuedMsg is sent when a message could not be delivered right now
+// and was stored in the outbox for a later retry
+type EmailQueuedMsg struct {
+	Entry outbox.Entry
+	Err   error
+}
+
+// OutboxTickMsg triggers a delivery attempt for due outbox messages
+type OutboxTickMsg struct{}
+
+// OutboxLoadedMsg carries the current outbox contents. Unreadable reports
+// entries that were set aside instead.
+type OutboxLoadedMsg struct {
+	Entries    []outbox.Entry
+	Unreadable error
+}
+
+// OutboxFlushedMsg is sent after due outbox messages were retried
+type OutboxFlushedMsg struct {
+	Sent       []outbox.Entry
+	Entries    []outbox.Entry
+	Unreadable error
+}
+
+// OutboxErrorMsg is sent when the outbox on disk could not be read or updated
+type OutboxErrorMsg struct {
+	Err error
+}
+
+// OutboxEditRequestMsg requests reopening a queued message in compose
+type OutboxEditRequestMsg struct {
+	ID string
+}
+
+// OutboxCancelRequestMsg requests dropping a queued message
+type OutboxCancelRequestMsg struct {
+	ID string
+}
+
+// OutboxRetryRequestMsg requests sending a queued message right away
+type OutboxRetryRequestMsg struct {
+	ID string
+}
+
+// OutboxEntryOpenedMsg is sent when a queued message is ready for editing.
+// Undone is set instead of ID when undo-send took the entry out of the
+// outbox.
+type OutboxEntryOpenedMsg struct {
+	ID     string
+	Undone string
+	Draft  email.Message
+}
@@ -1,8 +1,11 @@
 package tui
 
 import (
+	"context"
 	"testing"
 
+	tea "github.com/charmbracelet/bubbletea"
+
 	"github.com/chhlga/budge/internal/email"
 )
 
@@ -70,3 +73,32 @@ func TestStartIdleMonitoringMsg(t *testing.T) {
 func TestStopIdleMonitoringMsg(t *testing.T) {
 	_ = StopIdleMonitoringMsg{}
 }
+
+func TestMailboxUpdate_flagChangeUpdatesListAndKeepsWaiting(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.currentMailbox = "INBOX"
+	m.emailList.SetEmails([]email.Message{{UID: 7, Subject: "Hi"}}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	monitor := &mailboxMonitor{mailbox: "INBOX", ctx: ctx, cancel: cancel, updates: make(chan tea.Msg, 1)}
+
+	updated, cmd := m.Update(mailboxUpdateMsg{
+		monitor: monitor,
+		update:  FlagsChangedMsg{Mailbox: "INBOX", SeqNum: 1, UID: 7, Flags: []string{"\\Seen"}},
+	})
+	m = updated.(Model)
+
+	if flags := m.emailList.emails[0].Flags; len(flags) != 1 || flags[0] != "\\Seen" {
+		t.Errorf("Expected flags [\\Seen], got %v", flags)
+	}
+	if cmd == nil {
+		t.Fatalf("Expected a command waiting for the next update")
+	}
+
+	monitor.publish("INBOX", NewEmailMsg{Mailbox: "INBOX", Count: 2})
+	next, ok := cmd().(mailboxUpdateMsg)
+	if !ok || next.update != (NewEmailMsg{Mailbox: "INBOX", Count: 2}) {
+		t.Errorf("Expected the next update from the same monitor, got %#v", next)
+	}
+}
@@ -1,14 +1,22 @@
 package tui
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/chhlga/budge/internal/cache"
 	"github.com/chhlga/budge/internal/config"
+	"github.com/chhlga/budge/internal/email"
+	"github.com/chhlga/budge/internal/folders"
 	"github.com/chhlga/budge/internal/imap"
+	"github.com/chhlga/budge/internal/outbox"
 )
 
 // viewState represents the current active view
@@ -19,8 +27,15 @@ const (
 	emailListView
 	emailReaderView
 	searchView
+	composeView
+	outboxView
 )
 
+// Sender delivers outgoing messages, typically an *smtp.Client
+type Sender interface {
+	Send(ctx context.Context, from string, recipients []string, msg []byte) error
+}
+
 // Model is the root TUI model
 type Model struct {
 	state  viewState
@@ -34,48 +49,292 @@ type Model struct {
 	emailList   EmailList
 	emailReader EmailReader
 	search      Search
+	compose     Compose
+	outboxList  OutboxList
 	statusBar   StatusBar
 
 	// Services
-	imapClient *imap.Client
-	cache      *cache.Cache
-	config     *config.Config
+	accounts []*account
+	active   int
+	outbox   *outbox.Outbox
+	folders  *folders.State
+	config   *config.Config
 
 	currentMailbox string
 	loading        bool
 	loadingText    string
 
+	// unified holds the messages of each mailbox merged into All Inboxes
+	// while it is open, and is nil otherwise
+	unified map[MailboxRef]EmailsLoadedMsg
+
+	// mailboxCtx is cancelled when the user leaves the current mailbox so
+	// pending loads for it are dropped
+	mailboxCtx    context.Context
+	cancelMailbox context.CancelFunc
+
 	inSearchResults     bool
 	preSearchEmailState EmailsLoadedMsg
+
+	composeReturnState viewState
+
+	outboxReturnState viewState
+	flushingOutbox    bool
+
+	// Message held back for the undo-send delay
+	undo *pendingUndo
+
+	// heldDrafts are the server drafts of messages that wait in the outbox
+	// and can still be taken back, by outbox entry ID
+	heldDrafts map[string]serverDraft
+}
+
+// serverDraft is a draft saved in an account's Drafts mailbox
+type serverDraft struct {
+	account string
+	mailbox string
+	uid     uint32
+}
+
+// pendingUndo is a sent message that can still be taken back
+type pendingUndo struct {
+	id       string
+	deadline time.Time
+}
+
+// undoTickMsg updates the undo-send countdown once a second
+type undoTickMsg struct {
+	id string
 }
 
-// NewModel creates a new root model
-func NewModel(cfg *config.Config, client *imap.Client) Model {
+// NewModel creates a new root model for the given accounts, the first of
+// which is opened. Without accounts, those of cfg are used unconnected.
+func NewModel(cfg *config.Config, accounts []Account) Model {
 	keys := NewKeyMap()
 
-	return Model{
+	if len(accounts) == 0 {
+		for _, acctCfg := range cfg.AccountList() {
+			accounts = append(accounts, Account{Config: acctCfg})
+		}
+	}
+	accts := make([]*account, len(accounts))
+	names := make([]string, len(accounts))
+	for i, a := range accounts {
+		accts[i] = newAccount(a)
+		names[i] = accts[i].name
+	}
+
+	mailboxList := NewMailboxList(keys)
+	mailboxList.SetAccounts(names, names[0])
+	mailboxList.SetSubscribedOnly(cfg.Behavior.SubscribedOnly)
+
+	mailboxCtx, cancelMailbox := context.WithCancel(context.Background())
+
+	m := Model{
 		state:       mailboxListView,
 		keys:        keys,
-		mailboxList: NewMailboxList(keys),
+		mailboxList: mailboxList,
 		emailList:   NewEmailList(keys),
 		emailReader: NewEmailReader(keys),
 		search:      NewSearch(keys),
+		compose:     NewCompose(keys),
+		outboxList:  NewOutboxList(keys),
 		statusBar:   NewStatusBar(),
-		imapClient:  client,
-		cache:       cache.New(100), // Cache 100 email bodies
+		accounts:    accts,
 		config:      cfg,
+
+		mailboxCtx:    mailboxCtx,
+		cancelMailbox: cancelMailbox,
 	}
+	// All Inboxes is only worth offering when it merges something
+	m.mailboxList.SetUnified(len(m.unifiedSources()) > 1)
+	m.applyAccount()
+	return m
+}
+
+// account returns the account the user is working in
+func (m Model) account() *account {
+	return m.accounts[m.active]
+}
+
+// accountByName returns the named account, or nil if there is none
+func (m Model) accountByName(name string) *account {
+	for _, acct := range m.accounts {
+		if acct.name == name {
+			return acct
+		}
+	}
+	return nil
+}
+
+// applyAccount sets up compose and the status bar for the active account
+func (m *Model) applyAccount() {
+	acct := m.account()
+	m.compose.SetAttachmentLimit(int64(acct.config.SMTP.MaxAttachmentMB) << 20)
+	m.compose.SetIdentities(acct.identities)
+	if len(m.accounts) > 1 {
+		m.statusBar.SetAccount(acct.name)
+	}
+}
+
+// switchAccount makes the named account the active one and opens its
+// mailbox list
+func (m *Model) switchAccount(name string) tea.Cmd {
+	for i, acct := range m.accounts {
+		if acct.name != name || i == m.active {
+			continue
+		}
+		m.account().stopMonitoring()
+		m.active = i
+		m.applyAccount()
+		m.mailboxList.SetActiveAccount(name)
+		if m.folders != nil {
+			m.mailboxList.SetCollapsed(m.folders.Collapsed(name))
+		}
+		m.mailboxList.SetMailboxes(acct.mailboxes, acct.subscriptions)
+		m.mailboxList.SetCounts(acct.counts)
+		m.switchMailbox("")
+		m.emailList.SetMailbox("")
+		m.emailList.SetEmails(nil, 0)
+		state := imap.StateDisconnected
+		if acct.client != nil {
+			state = acct.client.State()
+		}
+		var notice tea.Cmd
+		m.statusBar, notice = m.statusBar.Update(ConnectionStateChangedMsg{Account: name, State: state})
+		cmds := []tea.Cmd{notice}
+		if acct.retry != nil {
+			cmds = append(cmds, m.statusBar.SetNotice(name+" is not connected (ctrl+r: retry)", true))
+		}
+		if acct.mailboxes == nil && acct.client != nil && acct.online {
+			cmds = append(cmds, loadMailboxesCmd(acct.name, acct.client))
+		}
+		return tea.Batch(cmds...)
+	}
+	return nil
+}
+
+// unifiedSources lists the mailboxes merged into All Inboxes, in account
+// order
+func (m Model) unifiedSources() []MailboxRef {
+	var sources []MailboxRef
+	for _, acct := range m.accounts {
+		for _, mailbox := range acct.config.Inboxes() {
+			sources = append(sources, MailboxRef{Account: acct.name, Mailbox: mailbox})
+		}
+	}
+	return sources
+}
+
+// openUnified shows All Inboxes and starts loading each of its mailboxes
+func (m *Model) openUnified() tea.Cmd {
+	m.account().stopMonitoring()
+	m.switchMailbox("")
+	m.unified = make(map[MailboxRef]EmailsLoadedMsg)
+
+	m.emailList.SetMailbox(unifiedTitle)
+	m.emailList.SetMergedEmails(nil, nil, 0)
+
+	var cmds []tea.Cmd
+	for _, source := range m.unifiedSources() {
+		cmds = append(cmds, m.loadUnifiedSource(source))
+	}
+	return tea.Batch(cmds...)
+}
+
+// loadUnifiedSource fetches the newest page of a mailbox of All Inboxes
+func (m Model) loadUnifiedSource(source MailboxRef) tea.Cmd {
+	acct := m.accountByName(source.Account)
+	if acct == nil || acct.client == nil {
+		return nil
+	}
+	return loadUnifiedCmd(m.mailboxCtx, acct.client, source, uint32(m.config.Behavior.PageSize))
+}
+
+// showUnified merges the loaded mailboxes of All Inboxes into the email
+// list, which sorts them
+func (m *Model) showUnified() {
+	var emails []email.Message
+	var sources []MailboxRef
+	var total uint32
+	for _, source := range m.unifiedSources() {
+		loaded, ok := m.unified[source]
+		if !ok {
+			continue
+		}
+		for _, msg := range loaded.Emails {
+			emails = append(emails, msg)
+			sources = append(sources, source)
+		}
+		total += loaded.Total
+	}
+	m.emailList.SetMergedEmails(emails, sources, total)
+}
+
+// messageSource returns the account and mailbox a message of the email
+// list or reader lives in
+func (m Model) messageSource(source MailboxRef) (*account, string) {
+	if source.Mailbox == "" {
+		return m.account(), m.currentMailbox
+	}
+	return m.accountByName(source.Account), source.Mailbox
+}
+
+// outgoing returns a sender for mail from any account, or nil when no
+// account can send
+func (m Model) outgoing() Sender {
+	for _, acct := range m.accounts {
+		if acct.sender != nil {
+			return accountSender(m.accounts)
+		}
+	}
+	return nil
+}
+
+// SetSender configures the transport used for outgoing mail of the active
+// account. Without a sender the compose view still works but sending fails.
+func (m *Model) SetSender(sender Sender) {
+	m.account().sender = sender
+}
+
+// SetOutbox configures the persistent queue for messages that could not
+// be sent right away. Without an outbox such failures are only reported.
+func (m *Model) SetOutbox(queue *outbox.Outbox) {
+	m.outbox = queue
+}
+
+// SetFolders configures where the collapsed mailboxes of the tree are
+// remembered. Without it every mailbox starts expanded.
+func (m *Model) SetFolders(state *folders.State) {
+	m.folders = state
+	m.mailboxList.SetCollapsed(state.Collapsed(m.account().name))
 }
 
 // Init initializes the model
 func (m Model) Init() tea.Cmd {
-	return tea.Batch(
+	cmds := []tea.Cmd{
 		m.mailboxList.Init(),
 		m.emailList.Init(),
 		m.emailReader.Init(),
 		m.search.Init(),
-		connectCmd(m.imapClient),
-	)
+		m.compose.Init(),
+		m.outboxList.Init(),
+	}
+	// Every account connects on its own, so one slow server doesn't hold
+	// up the others
+	for _, acct := range m.accounts {
+		if acct.client == nil {
+			continue
+		}
+		cmds = append(cmds,
+			connectCmd(acct.name, acct.client),
+			waitForConnectionStateCmd(acct.name, acct.connStates),
+		)
+	}
+	if m.outbox != nil {
+		cmds = append(cmds, loadOutboxCmd(m.outbox), outboxTickCmd())
+	}
+	return tea.Batch(cmds...)
 }
 
 // Update handles messages and updates the model
@@ -86,30 +345,64 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// Global message handling
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		// Text entry views receive every key except ctrl+c
+		if m.state == composeView || m.state == mailboxListView && m.mailboxList.Prompting() ||
+			m.state == emailListView && m.emailList.Moving() {
+			if msg.String() == "ctrl+c" {
+				return m, tea.Quit
+			}
+			break
+		}
+
 		// Global keys (always active)
 		switch {
 		case key.Matches(msg, m.keys.Quit):
 			return m, tea.Quit
+		case key.Matches(msg, m.keys.Retry) && m.account().retry != nil:
+			retry := m.account().retry
+			m.account().retry = nil
+			m.err = nil
+			return m, retry
 		case key.Matches(msg, m.keys.ViewMailboxes):
 			m.state = mailboxListView
-			m.statusBar.SetHelpText("enter: select | r: refresh | q: quit")
-			return m, stopMonitoringCmd(m.currentMailbox)
+			m.statusBar.SetHelpText(mailboxHelpText)
+			// Counts may have changed while the mailbox list was hidden
+			m.account().stopMonitoring()
+			return m, m.account().loadCounts()
 		case key.Matches(msg, m.keys.ViewEmails):
 			m.state = emailListView
-			m.statusBar.SetHelpText("enter: read | s: sort | f: filter | m: mark | d: delete | /: search | q: quit")
+			m.statusBar.SetHelpText("enter: read | c: compose | s: sort | f: filter | m: mark | M: move | d: delete | /: search | q: quit")
 			if m.currentMailbox != "" {
 				interval := time.Duration(m.config.Behavior.PollInterval) * time.Second
-				return m, startMonitoringCmd(m.imapClient, m.currentMailbox, interval)
+				return m, m.account().startMonitoring(m.currentMailbox, interval)
 			}
 			return m, nil
 		case key.Matches(msg, m.keys.ViewReader):
 			m.state = emailReaderView
-			m.statusBar.SetHelpText("2: back to list | q: quit")
+			m.statusBar.SetHelpText("2: back to list | r: reply | R: reply all | F: forward | c: compose | q: quit")
 			return m, nil
+		case key.Matches(msg, m.keys.ViewOutbox) && m.state != searchView:
+			if m.state != outboxView {
+				m.outboxReturnState = m.state
+			}
+			m.state = outboxView
+			m.statusBar.SetHelpText(outboxHelpText)
+			if m.outbox == nil {
+				return m, nil
+			}
+			return m, loadOutboxCmd(m.outbox)
+		case key.Matches(msg, m.keys.UndoSend) && m.undo != nil && m.state != searchView:
+			id := m.undo.id
+			m.undo = nil
+			m.statusBar.SetCountdown("")
+			return m, undoSendCmd(m.outbox, id)
 		case key.Matches(msg, m.keys.Search):
 			m.state = searchView
 			m.statusBar.SetHelpText("enter: search | esc: cancel")
 			return m, nil
+		case key.Matches(msg, m.keys.Compose) && m.state != searchView:
+			m.compose.Reset()
+			return m, m.openCompose()
 		}
 
 	case tea.WindowSizeMsg:
@@ -123,77 +416,381 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.emailList.SetSize(m.width, availableHeight)
 		m.emailReader.SetSize(m.width, availableHeight)
 		m.search.SetSize(m.width, availableHeight)
+		m.compose.SetSize(m.width, availableHeight)
+		m.outboxList.SetSize(m.width, availableHeight)
 		m.statusBar.SetSize(m.width)
 
 	case ErrorMsg:
+		// Whatever failed, a page that was loading won't arrive; scrolling
+		// on asks for it again
+		m.emailList.stopLoadingOlder()
+		if imap.IsConnectionError(msg.Err) {
+			// Connection trouble is temporary; keep the view usable. All
+			// Inboxes runs commands on other accounts than the active one.
+			acct := m.accountByName(msg.Account)
+			if acct == nil {
+				acct = m.account()
+			}
+			notice := msg.Err.Error()
+			if acct != m.account() {
+				notice = acct.name + ": " + notice
+			}
+			if msg.Retry != nil {
+				acct.retry = msg.Retry
+				if acct == m.account() {
+					notice += " (ctrl+r: retry)"
+				}
+			}
+			m.statusBar, cmd = m.statusBar.Update(msg)
+			cmds = append(cmds, cmd, m.statusBar.SetNotice(notice, true))
+			if errors.Is(msg.Err, imap.ErrConnectionLost) && !acct.reconnecting {
+				acct.reconnecting = true
+				cmds = append(cmds, reconnectCmd(acct.name, acct.client))
+			}
+			return m, tea.Batch(cmds...)
+		}
 		m.err = msg.Err
 		return m, nil
 
+	case ReconnectResultMsg:
+		acct := m.accountByName(msg.Account)
+		if acct == nil {
+			return m, nil
+		}
+		if errors.Is(msg.Err, imap.ErrConnectionLost) {
+			return m, reconnectCmd(acct.name, acct.client)
+		}
+		acct.reconnecting = false
+		return m, nil
+
+	case connectionStateUpdateMsg:
+		next, cmd := m.Update(ConnectionStateChangedMsg{Account: msg.account, State: msg.state})
+		if acct := m.accountByName(msg.account); acct != nil {
+			cmd = tea.Batch(cmd, waitForConnectionStateCmd(acct.name, acct.connStates))
+		}
+		return next, cmd
+
+	case ConnectionStateChangedMsg:
+		acct := m.accountByName(msg.Account)
+		if acct == nil {
+			break
+		}
+		switch msg.State {
+		case imap.StateDisconnected:
+			if acct.online {
+				acct.online = false
+				acct.sessionLost = true
+			}
+		case imap.StateAuthenticated:
+			acct.online = true
+			if acct.sessionLost {
+				// Pick up whatever changed while the session was down
+				acct.sessionLost = false
+				cmds = append(cmds, loadMailboxesCmd(acct.name, acct.client))
+				if acct == m.account() {
+					m.err = nil
+					if m.currentMailbox != "" {
+						cmds = append(cmds, m.reloadEmails())
+					}
+				}
+			}
+		}
+		if acct != m.account() {
+			// The status bar shows the active account only
+			return m, tea.Batch(cmds...)
+		}
+
 	case ConnectCompleteMsg:
+		acct := m.accountByName(msg.Account)
+		if acct == nil {
+			return m, nil
+		}
 		return m, tea.Batch(
-			func() tea.Msg { return ConnectionStateChangedMsg{State: m.imapClient.State()} },
-			loadMailboxesCmd(m.imapClient),
+			func() tea.Msg { return ConnectionStateChangedMsg{Account: acct.name, State: acct.client.State()} },
+			loadMailboxesCmd(acct.name, acct.client),
 		)
 
 	case ConnectErrorMsg:
+		acct := m.accountByName(msg.Account)
+		if acct == nil {
+			return m, nil
+		}
+		acct.retry = connectCmd(acct.name, acct.client)
+		if acct != m.account() {
+			// Other accounts stay usable while this one is down; ctrl+r
+			// retries it once it is the active one
+			notice := fmt.Sprintf("%s: %v", acct.name, msg.Err)
+			return m, m.statusBar.SetNotice(notice, true)
+		}
 		m.err = msg.Err
 		return m, nil
 
+	case AccountSelectedMsg:
+		return m, m.switchAccount(msg.Account)
+
+	case MailboxesLoadedMsg:
+		acct := m.accountByName(msg.Account)
+		if acct == nil {
+			return m, nil
+		}
+		acct.mailboxes = resolveSpecialUses(msg.Mailboxes, acct.config.SpecialMailboxes)
+		acct.subscriptions = msg.Subscriptions
+		var notice tea.Cmd
+		if missing := missingOverrides(acct.mailboxes, acct.config.SpecialMailboxes); len(missing) > 0 {
+			text := "special_mailboxes: " + strings.Join(missing, ", ")
+			if acct != m.account() {
+				text = acct.name + ": " + text
+			}
+			notice = m.statusBar.SetNotice(text, true)
+		}
+		if acct != m.account() {
+			return m, notice
+		}
+		m.mailboxList.SetMailboxes(acct.mailboxes, msg.Subscriptions)
+		return m, tea.Batch(notice, acct.loadCounts())
+
+	case MailboxCountsLoadedMsg:
+		acct := m.accountByName(msg.Account)
+		if acct == nil {
+			return m, nil
+		}
+		if acct.counts == nil {
+			acct.counts = make(map[string]MailboxCounts)
+		}
+		for name, counts := range msg.Counts {
+			acct.counts[name] = counts
+		}
+		if acct == m.account() {
+			m.mailboxList.SetCounts(acct.counts)
+		}
+		return m, nil
+
+	case MailboxCollapsedMsg:
+		if m.folders == nil {
+			return m, nil
+		}
+		return m, saveCollapsedCmd(m.folders, m.account().name, msg.Mailbox, msg.Collapsed)
+
+	case CreateMailboxRequestMsg:
+		acct := m.account()
+		return m, createMailboxCmd(acct.name, acct.client, msg.Mailbox)
+
+	case RenameMailboxRequestMsg:
+		acct := m.account()
+		subscribed := false
+		for _, mb := range acct.mailboxes {
+			if mb.Name == msg.Mailbox {
+				subscribed = acct.subscriptions && mb.Subscribed
+				break
+			}
+		}
+		return m, renameMailboxCmd(acct.name, acct.client, msg.Mailbox, msg.NewName, subscribed)
+
+	case DeleteMailboxRequestMsg:
+		acct := m.account()
+		return m, deleteMailboxCmd(acct.name, acct.client, msg.Mailbox)
+
+	case SubscribeRequestMsg:
+		acct := m.account()
+		return m, setSubscribedCmd(acct.name, acct.client, msg.Mailbox, msg.Subscribe)
+
+	case MailboxChangedMsg:
+		acct := m.accountByName(msg.Account)
+		if acct == nil {
+			return m, nil
+		}
+		cmds = append(cmds, m.statusBar.SetNotice(msg.Notice, false), loadMailboxesCmd(acct.name, acct.client))
+		if acct == m.account() && m.unified == nil && m.currentMailbox != "" {
+			cmds = append(cmds, m.followMailboxChange(msg, hierarchyDelim(acct.mailboxes)))
+		}
+		return m, tea.Batch(cmds...)
+
+	case MailboxErrorMsg:
+		return m, m.statusBar.SetNotice(msg.Err.Error(), true)
+
 	case MailboxSelectedMsg:
 		m.state = emailListView
-		m.statusBar.SetHelpText("enter: read | s: sort | f: filter | m: mark | d: delete | /: search | q: quit")
+		m.statusBar.SetHelpText("enter: read | c: compose | s: sort | f: filter | m: mark | M: move | d: delete | /: search | q: quit")
+		if msg.Mailbox != m.currentMailbox || m.unified != nil {
+			m.emailList.SetEmails(nil, 0)
+		}
 		m.emailList.SetMailbox(msg.Mailbox)
-		m.currentMailbox = msg.Mailbox
+		m.switchMailbox(msg.Mailbox)
 
 		interval := time.Duration(m.config.Behavior.PollInterval) * time.Second
 		return m, tea.Batch(
-			loadEmailsCmd(m.imapClient, msg.Mailbox, uint32(m.config.Behavior.PageSize)),
-			startMonitoringCmd(m.imapClient, msg.Mailbox, interval),
+			m.reloadEmails(),
+			m.account().startMonitoring(msg.Mailbox, interval),
 		)
+	case LoadOlderRequestMsg:
+		if msg.Mailbox != m.currentMailbox || m.unified != nil {
+			return m, nil
+		}
+		return m, loadOlderEmailsCmd(m.mailboxCtx, m.account().name, m.account().client, m.currentMailbox, msg.Before, uint32(m.config.Behavior.PageSize))
+
+	case OlderEmailsLoadedMsg:
+		if msg.Mailbox != m.currentMailbox || m.unified != nil || m.inSearchResults {
+			return m, nil
+		}
+		m.emailList.AppendEmails(msg.Emails, msg.Total)
+		return m, nil
+
+	case UnifiedSelectedMsg:
+		m.state = emailListView
+		m.statusBar.SetHelpText("enter: read | c: compose | s: sort | f: filter | m: mark | M: move | d: delete | q: quit")
+		return m, m.openUnified()
+
+	case UnifiedEmailsLoadedMsg:
+		if m.unified == nil {
+			return m, nil
+		}
+		m.unified[msg.Source] = EmailsLoadedMsg{Mailbox: msg.Source.Mailbox, Emails: msg.Emails, Total: msg.Total}
+		m.showUnified()
+		return m, nil
+
 	case EmailsLoadedMsg:
+		if msg.Mailbox != m.currentMailbox || m.unified != nil {
+			return m, nil
+		}
 		m.emailList.SetEmails(msg.Emails, msg.Total)
-		m.statusBar.SetHelpText("enter: read | s: sort | f: filter | m: mark | d: delete | /: search | q: quit")
+		m.statusBar.SetHelpText("enter: read | c: compose | s: sort | f: filter | m: mark | M: move | d: delete | /: search | q: quit")
 		return m, nil
 
 	case EmailSelectedMsg:
+		acct, mailbox := m.messageSource(msg.Source)
+		if acct == nil {
+			return m, nil
+		}
 		selectedEmail := msg.Email
 		if selectedEmail.IsUnread() {
 			selectedEmail.Flags = addFlag(selectedEmail.Flags, "\\Seen")
-			m.emailList.markSeenLocal(selectedEmail.UID, true)
+			m.emailList.markSeenLocal(msg.Source, selectedEmail.UID, true)
 			if m.inSearchResults {
 				m.preSearchEmailState.Emails = markSeenInSlice(m.preSearchEmailState.Emails, selectedEmail.UID, true)
 			}
 		}
+		if drafts, ok := acct.specialMailbox(SpecialDrafts); ok && drafts == mailbox {
+			return m, tea.Batch(
+				func() tea.Msg { return LoadingMsg{Text: "Opening draft..."} },
+				openDraftCmd(acct.name, acct.client, acct.cache, mailbox, selectedEmail.UID),
+			)
+		}
 		m.state = emailReaderView
-		m.statusBar.SetHelpText("2: back to list | q: quit")
-		m.emailReader.SetEmail(selectedEmail)
-		cmds = append(cmds, loadEmailBodyCmd(m.imapClient, m.cache, selectedEmail.UID))
+		m.statusBar.SetHelpText("2: back to list | r: reply | R: reply all | F: forward | c: compose | q: quit")
+		m.emailReader.SetEmail(selectedEmail, msg.Source)
+		cmds = append(cmds, loadEmailBodyCmd(m.mailboxCtx, acct.name, acct.client, acct.cache, mailbox, selectedEmail.UID))
 		if msg.Email.IsUnread() {
-			cmds = append(cmds, markReadCmd(m.imapClient, selectedEmail.UID, true))
+			cmds = append(cmds, markReadCmd(acct.name, acct.client, mailbox, selectedEmail.UID, true))
 		}
 		return m, tea.Batch(cmds...)
 
 	case EmailBodyLoadedMsg:
+		// The body of a message the user already moved on from must not
+		// replace the one being read, or a reply would answer the wrong one
+		acct, mailbox := m.messageSource(m.emailReader.Source())
+		if acct == nil || acct.name != msg.Account || mailbox != msg.Mailbox || !m.emailReader.Showing(msg.UID) {
+			return m, nil
+		}
 		m.emailReader.SetBody(msg.Body)
+		m.emailReader.SetOriginal(msg.Message)
 		return m, nil
 
+	case ReplyRequestMsg:
+		original := m.emailReader.Original()
+		if original == nil {
+			return m, m.statusBar.SetNotice("Message is still loading", true)
+		}
+		// Reply as the account the message was sent to
+		acct, _ := m.messageSource(m.emailReader.Source())
+		if acct == nil {
+			acct = m.account()
+		}
+		reply := email.NewReply(original, acct.selfAddresses(), msg.All)
+		if idx := email.MatchIdentity(acct.identities, original); idx >= 0 {
+			reply.From = []email.Address{acct.identities[idx].From}
+		}
+		m.compose.SetIdentities(acct.identities)
+		m.compose.SetDraft(*reply)
+		return m, m.openCompose()
+
+	case ForwardRequestMsg:
+		acct, mailbox := m.messageSource(msg.Source)
+		if acct == nil {
+			return m, nil
+		}
+		return m, tea.Batch(
+			func() tea.Msg { return LoadingMsg{Text: "Preparing forward..."} },
+			forwardEmailCmd(acct.name, acct.client, acct.cache, mailbox, msg.UID, msg.AsAttachment),
+		)
+
+	case ForwardReadyMsg:
+		m.compose.SetDraft(msg.Draft)
+		m.statusBar, cmd = m.statusBar.Update(LoadingClearedMsg{})
+		return m, tea.Batch(cmd, m.openCompose())
+
+	case DraftOpenedMsg:
+		m.compose.SetDraft(msg.Draft)
+		m.compose.SetDraftUID(msg.Account, msg.Draft.UID)
+		m.statusBar, cmd = m.statusBar.Update(LoadingClearedMsg{})
+		return m, tea.Batch(cmd, m.openCompose())
+
 	case MarkReadRequestMsg:
-		return m, markReadCmd(m.imapClient, msg.UID, msg.Read)
+		acct, mailbox := m.messageSource(msg.Source)
+		if acct == nil {
+			return m, nil
+		}
+		if m.unified != nil {
+			// No mailbox is watched, so nothing reports the change back
+			m.emailList.markSeenLocal(msg.Source, msg.UID, msg.Read)
+		}
+		return m, markReadCmd(acct.name, acct.client, mailbox, msg.UID, msg.Read)
 
 	case DeleteEmailRequestMsg:
-		return m, deleteEmailCmd(m.imapClient, msg.UID)
+		acct, mailbox := m.messageSource(msg.Source)
+		if acct == nil {
+			return m, nil
+		}
+		if m.unified != nil {
+			return m, tea.Sequence(
+				deleteEmailCmd(acct.name, acct.client, mailbox, msg.UID),
+				m.loadUnifiedSource(msg.Source),
+			)
+		}
+		return m, deleteEmailCmd(acct.name, acct.client, mailbox, msg.UID)
+
+	case MoveEmailRequestMsg:
+		acct, mailbox := m.messageSource(msg.Source)
+		if acct == nil || msg.To == mailbox {
+			return m, nil
+		}
+		if acct.mailboxes != nil && !slices.ContainsFunc(acct.mailboxes, func(mb MailboxInfo) bool { return mb.Name == msg.To }) {
+			return m, m.statusBar.SetNotice(fmt.Sprintf("%s has no mailbox %s", acct.name, msg.To), true)
+		}
+		return m, moveEmailCmd(acct.name, acct.client, mailbox, msg.UID, msg.To)
+
+	case EmailMovedMsg:
+		cmds = append(cmds, m.statusBar.SetNotice("Moved to "+msg.To, false))
+		if acct := m.accountByName(msg.Account); acct != nil {
+			cmds = append(cmds, loadMailboxCountsCmd(acct.name, acct.client, []string{msg.Mailbox, msg.To}))
+		}
+		// Nothing watches the mailboxes of All Inboxes
+		source := MailboxRef{Account: msg.Account, Mailbox: msg.Mailbox}
+		if _, ok := m.unified[source]; ok {
+			cmds = append(cmds, m.loadUnifiedSource(source))
+		}
+		return m, tea.Batch(cmds...)
 
 	case SearchQueryMsg:
 		m.inSearchResults = true
-		m.preSearchEmailState = EmailsLoadedMsg{Emails: m.emailList.emails, Total: m.emailList.total}
 		m.state = emailListView
 		m.statusBar.SetHelpText("Searching...")
 		if m.currentMailbox == "" {
-			m.currentMailbox = m.config.Behavior.DefaultFolder
+			m.switchMailbox(m.account().config.DefaultFolder)
 		}
+		m.preSearchEmailState = EmailsLoadedMsg{Mailbox: m.currentMailbox, Emails: m.emailList.emails, Total: m.emailList.total}
 		return m, tea.Batch(
 			func() tea.Msg { return LoadingMsg{Text: "Searching..."} },
-			searchEmailsCmd(m.imapClient, m.currentMailbox, msg.Query),
+			searchEmailsCmd(m.mailboxCtx, m.account().name, m.account().client, m.currentMailbox, msg.Query),
 		)
 
 	case SearchCancelledMsg:
@@ -204,25 +801,258 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		cmds = append(cmds, cmd)
 		return m, tea.Batch(cmds...)
 
+	case mailboxUpdateMsg:
+		next, cmd := m.Update(msg.update)
+		return next, tea.Batch(cmd, waitForMailboxUpdateCmd(msg.monitor))
+
 	case NewEmailMsg:
 		if msg.Mailbox == m.currentMailbox {
-			return m, loadEmailsCmd(m.imapClient, msg.Mailbox, uint32(m.config.Behavior.PageSize))
+			return m, tea.Batch(m.reloadEmails(), m.refreshCounts(msg.Mailbox))
+		}
+		return m, nil
+
+	case EmailExpungedMsg:
+		if msg.Mailbox == m.currentMailbox {
+			return m, tea.Batch(m.reloadEmails(), m.refreshCounts(msg.Mailbox))
 		}
 		return m, nil
 
+	case FlagsChangedMsg:
+		if msg.Mailbox != m.currentMailbox {
+			return m, nil
+		}
+		if msg.UID == 0 {
+			return m, tea.Batch(m.reloadEmails(), m.refreshCounts(msg.Mailbox))
+		}
+		m.emailList.setFlagsLocal(msg.UID, msg.Flags)
+		return m, m.refreshCounts(msg.Mailbox)
+
 	case StartIdleMonitoringMsg:
 		if msg.Mailbox != "" {
-			m.currentMailbox = msg.Mailbox
+			m.switchMailbox(msg.Mailbox)
 			interval := time.Duration(m.config.Behavior.PollInterval) * time.Second
-			return m, startMonitoringCmd(m.imapClient, msg.Mailbox, interval)
+			return m, m.account().startMonitoring(msg.Mailbox, interval)
 		}
 		return m, nil
 
 	case StopIdleMonitoringMsg:
-		if m.currentMailbox != "" {
-			return m, stopMonitoringCmd(m.currentMailbox)
+		m.account().stopMonitoring()
+		return m, nil
+
+	case SendEmailRequestMsg:
+		draft, err := m.withSender(msg.Draft)
+		if err != nil {
+			return m, func() tea.Msg { return SendErrorMsg{Err: err} }
+		}
+		acct := m.senderAccount(draft)
+		if acct.sender == nil {
+			return m, func() tea.Msg {
+				return SendErrorMsg{Err: fmt.Errorf("sending is not configured, add an smtp section to the config")}
+			}
+		}
+		if delay := acct.config.SMTP.UndoSendSeconds; delay > 0 && m.outbox != nil {
+			at := time.Now().Add(time.Duration(delay) * time.Second)
+			return m, scheduleSendCmd(m.outbox, draft, at, m.compose.OutboxID(), true)
+		}
+		m.statusBar.SetHelpText("Sending...")
+		return m, tea.Batch(
+			func() tea.Msg { return LoadingMsg{Text: "Sending..."} },
+			sendEmailCmd(acct.sender, m.outbox, draft, m.compose.OutboxID()),
+		)
+
+	case EmailSentMsg:
+		cmds = append(cmds, m.finishCompose("")...)
+		if len(msg.Raw) > 0 {
+			cmds = append(cmds, m.saveSentCopy(msg.From, msg.Raw))
+		}
+		m.statusBar, cmd = m.statusBar.Update(msg)
+		cmds = append(cmds, cmd)
+		return m, tea.Batch(cmds...)
+
+	case EmailQueuedMsg:
+		cmds = append(cmds, m.finishCompose("")...)
+		m.statusBar, cmd = m.statusBar.Update(msg)
+		cmds = append(cmds, cmd)
+		return m, tea.Batch(cmds...)
+
+	case OutboxTickMsg:
+		if m.outbox == nil {
+			return m, nil
+		}
+		cmds = append(cmds, outboxTickCmd())
+		if sender := m.outgoing(); sender != nil && !m.flushingOutbox {
+			m.flushingOutbox = true
+			cmds = append(cmds, flushOutboxCmd(sender, m.outbox, m.editedOutboxID()))
+		}
+		return m, tea.Batch(cmds...)
+
+	case OutboxFlushedMsg:
+		m.flushingOutbox = false
+		m.setOutboxEntries(msg.Entries)
+		if len(msg.Sent) > 0 {
+			for _, entry := range msg.Sent {
+				cmds = append(cmds, m.saveSentCopy(entry.From, entry.Raw), m.releaseDraft(entry.ID))
+			}
+			cmds = append(cmds, m.statusBar.SetNotice(fmt.Sprintf("Sent %d queued message(s)", len(msg.Sent)), false))
+		}
+		if msg.Unreadable != nil {
+			cmds = append(cmds, m.statusBar.SetNotice("Outbox: "+msg.Unreadable.Error(), true))
+		}
+		return m, tea.Batch(cmds...)
+
+	case OutboxLoadedMsg:
+		m.setOutboxEntries(msg.Entries)
+		if msg.Unreadable != nil {
+			return m, m.statusBar.SetNotice("Outbox: "+msg.Unreadable.Error(), true)
 		}
 		return m, nil
+
+	case OutboxErrorMsg:
+		m.flushingOutbox = false
+		m.statusBar, cmd = m.statusBar.Update(LoadingClearedMsg{})
+		return m, tea.Batch(cmd, m.statusBar.SetNotice("Outbox: "+msg.Err.Error(), true))
+
+	case OutboxEditRequestMsg:
+		return m, openOutboxEntryCmd(m.outbox, msg.ID)
+
+	case OutboxEntryOpenedMsg:
+		m.compose.SetDraft(msg.Draft)
+		m.compose.SetOutboxID(msg.ID)
+		if draft, ok := m.heldDrafts[msg.Undone]; ok {
+			// The server draft is still there, saving replaces it again
+			delete(m.heldDrafts, msg.Undone)
+			m.compose.SetDraftUID(draft.account, draft.uid)
+		}
+		return m, tea.Batch(m.openCompose(), loadOutboxCmd(m.outbox))
+
+	case OutboxCancelRequestMsg:
+		return m, tea.Batch(
+			cancelOutboxCmd(m.outbox, msg.ID),
+			m.statusBar.SetNotice("Queued message cancelled", false),
+		)
+
+	case OutboxRetryRequestMsg:
+		sender := m.outgoing()
+		if sender == nil || m.flushingOutbox {
+			// Only mark it due, the next tick sends it
+			return m, retryOutboxCmd(nil, m.outbox, msg.ID)
+		}
+		m.flushingOutbox = true
+		return m, retryOutboxCmd(sender, m.outbox, msg.ID)
+
+	case ScheduleSendRequestMsg:
+		draft, err := m.withSender(msg.Draft)
+		if err != nil {
+			return m, func() tea.Msg { return SendErrorMsg{Err: err} }
+		}
+		acct := m.senderAccount(draft)
+		if acct.sender == nil || m.outbox == nil {
+			return m, func() tea.Msg {
+				return SendErrorMsg{Err: fmt.Errorf("sending later needs an smtp section and the outbox")}
+			}
+		}
+		return m, scheduleSendCmd(m.outbox, draft, msg.At, m.compose.OutboxID(), false)
+
+	case SendScheduledMsg:
+		// The draft stays on the server while the message can be taken back
+		held := ""
+		if msg.Undo {
+			held = msg.Entry.ID
+		}
+		cmds = append(cmds, m.finishCompose(held)...)
+		if !msg.Undo {
+			at := msg.Entry.NextAttempt.Format("Mon Jan 02 15:04")
+			return m, tea.Batch(append(cmds, m.statusBar.SetNotice("Scheduled for "+at, false))...)
+		}
+		m.undo = &pendingUndo{id: msg.Entry.ID, deadline: msg.Entry.NextAttempt}
+		cmds = append(cmds, m.tickUndo())
+		return m, tea.Batch(cmds...)
+
+	case undoTickMsg:
+		if m.undo == nil || m.undo.id != msg.id {
+			return m, nil
+		}
+		if time.Now().Before(m.undo.deadline) {
+			return m, m.tickUndo()
+		}
+		m.undo = nil
+		m.statusBar.SetCountdown("")
+		sender := m.outgoing()
+		if sender == nil || m.flushingOutbox {
+			return m, nil // The outbox tick sends it
+		}
+		m.flushingOutbox = true
+		return m, flushOutboxCmd(sender, m.outbox, m.editedOutboxID())
+
+	case SaveDraftRequestMsg:
+		draft, err := m.withSender(msg.Draft)
+		if err != nil {
+			return m, func() tea.Msg { return DraftErrorMsg{Err: err} }
+		}
+		// The draft goes to the account it is from. A previous revision
+		// is only replaced there; one left in another account is removed
+		// once this one is stored.
+		acct := m.senderAccount(draft)
+		drafts, exists := acct.specialMailbox(SpecialDrafts)
+		var replace uint32
+		if m.compose.DraftAccount() == acct.name {
+			replace = m.compose.DraftUID()
+		}
+		return m, tea.Batch(
+			func() tea.Msg { return LoadingMsg{Text: "Saving draft..."} },
+			saveDraftCmd(acct.name, acct.client, drafts, !exists, draft, replace),
+		)
+
+	case DraftSavedMsg:
+		if previous := m.composeDraft(); previous.uid != 0 && previous.account != msg.Account {
+			cmds = append(cmds, m.deleteDraft(previous))
+		}
+		m.compose.SetDraftUID(msg.Account, msg.UID)
+		m.statusBar, cmd = m.statusBar.Update(LoadingClearedMsg{})
+		cmds = append(cmds, cmd, m.statusBar.SetNotice("Draft saved to "+msg.Mailbox, false))
+		acct := m.accountByName(msg.Account)
+		if acct != nil && !hasMailbox(acct, SpecialDrafts) {
+			cmds = append(cmds, loadMailboxesCmd(acct.name, acct.client))
+		}
+		if acct == m.account() && m.unified == nil && msg.Mailbox == m.currentMailbox {
+			cmds = append(cmds, m.reloadEmails())
+		}
+		return m, tea.Batch(cmds...)
+
+	case SentCopySavedMsg:
+		if acct := m.accountByName(msg.Account); acct != nil && !hasMailbox(acct, SpecialSent) {
+			cmds = append(cmds, loadMailboxesCmd(acct.name, acct.client))
+		}
+		if msg.Mailbox == m.currentMailbox {
+			cmds = append(cmds, m.reloadEmails())
+		}
+		return m, tea.Batch(cmds...)
+
+	case SentCopyErrorMsg:
+		return m, m.statusBar.SetNotice(msg.Err.Error(), true)
+
+	case DraftDeletedMsg:
+		if msg.Mailbox == m.currentMailbox {
+			return m, m.reloadEmails()
+		}
+		return m, nil
+
+	case DraftErrorMsg:
+		if m.state == composeView {
+			m.compose.SetError(msg.Err)
+		}
+		m.statusBar, cmd = m.statusBar.Update(LoadingClearedMsg{})
+		return m, tea.Batch(cmd, m.statusBar.SetNotice(msg.Err.Error(), true))
+
+	case SendErrorMsg:
+		m.compose.SetError(msg.Err)
+		m.statusBar.SetHelpText(composeHelpText)
+		m.statusBar, cmd = m.statusBar.Update(msg)
+		return m, cmd
+
+	case ComposeCancelledMsg:
+		m.closeCompose()
+		return m, nil
 	}
 
 	// Update status bar
@@ -247,6 +1077,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.emailReader, cmd = m.emailReader.Update(msg)
 	case searchView:
 		m.search, cmd = m.search.Update(msg)
+	case composeView:
+		m.compose, cmd = m.compose.Update(msg)
+	case outboxView:
+		if keyMsg, ok := msg.(tea.KeyMsg); ok && key.Matches(keyMsg, m.keys.Back) {
+			m.closeOutbox()
+			return m, tea.Batch(cmds...)
+		}
+		m.outboxList, cmd = m.outboxList.Update(msg)
 	}
 	cmds = append(cmds, cmd)
 
@@ -262,6 +1100,9 @@ func (m Model) View() string {
 	// Render error if present
 	if m.err != nil {
 		errorView := ErrorStyle.Render("Error: " + m.err.Error())
+		if m.account().retry != nil {
+			errorView += "\n\nctrl+r: retry | q: quit"
+		}
 		return lipgloss.JoinVertical(lipgloss.Left,
 			errorView,
 			m.statusBar.View(),
@@ -279,6 +1120,10 @@ func (m Model) View() string {
 		mainView = m.emailReader.View()
 	case searchView:
 		mainView = m.search.View()
+	case composeView:
+		mainView = m.compose.View()
+	case outboxView:
+		mainView = m.outboxList.View()
 	default:
 		mainView = "Unknown view"
 	}
@@ -289,3 +1134,235 @@ func (m Model) View() string {
 		m.statusBar.View(),
 	)
 }
+
+const composeHelpText = "tab: next field | ctrl+g: attach | ctrl+l: identity | ctrl+e: $EDITOR | ctrl+o: save draft | ctrl+s: send | alt+s: send later | esc: cancel"
+
+// openCompose switches to the compose view, remembering where to return
+func (m *Model) openCompose() tea.Cmd {
+	if m.state != composeView {
+		m.composeReturnState = m.state
+	}
+	m.state = composeView
+	m.statusBar.SetHelpText(composeHelpText)
+	return m.compose.Focus()
+}
+
+// finishCompose clears the compose form after its message has left,
+// removing the server draft it was reopened from. When held names the
+// outbox entry of a message that can still be undone, the draft is kept
+// until that entry is sent.
+func (m *Model) finishCompose(held string) []tea.Cmd {
+	var cmds []tea.Cmd
+	draft := m.composeDraft()
+	// An outbox entry reopened for editing hands its draft on to the
+	// message that replaces it
+	if replaced, ok := m.heldDrafts[m.compose.OutboxID()]; ok {
+		delete(m.heldDrafts, m.compose.OutboxID())
+		if draft.uid == 0 {
+			draft = replaced
+		}
+	}
+	switch {
+	case draft.uid == 0:
+	case held != "":
+		if m.heldDrafts == nil {
+			m.heldDrafts = make(map[string]serverDraft)
+		}
+		m.heldDrafts[held] = draft
+	default:
+		cmds = append(cmds, m.deleteDraft(draft))
+	}
+	if m.outbox != nil {
+		cmds = append(cmds, loadOutboxCmd(m.outbox))
+	}
+	m.compose.Reset()
+	m.closeCompose()
+	return cmds
+}
+
+// composeDraft returns where the server copy of the draft being composed
+// is stored
+func (m Model) composeDraft() serverDraft {
+	draft := serverDraft{account: m.compose.DraftAccount(), uid: m.compose.DraftUID()}
+	if acct := m.accountByName(draft.account); acct != nil {
+		draft.mailbox, _ = acct.specialMailbox(SpecialDrafts)
+	}
+	return draft
+}
+
+// releaseDraft removes the server draft held back for an outbox entry
+// that has now been sent
+func (m *Model) releaseDraft(id string) tea.Cmd {
+	draft, ok := m.heldDrafts[id]
+	if !ok {
+		return nil
+	}
+	delete(m.heldDrafts, id)
+	return m.deleteDraft(draft)
+}
+
+// deleteDraft removes a server draft once its message has left
+func (m Model) deleteDraft(draft serverDraft) tea.Cmd {
+	acct := m.accountByName(draft.account)
+	if acct == nil {
+		return nil
+	}
+	return deleteDraftCmd(acct.client, draft.mailbox, draft.uid)
+}
+
+// saveSentCopy stores a delivered message in the Sent mailbox of the
+// account it was sent from, unless that account's server files it itself
+func (m Model) saveSentCopy(from string, raw []byte) tea.Cmd {
+	acct := accountFor(m.accounts, from)
+	if acct == nil || acct.config.SMTP.SkipSentCopy {
+		return nil
+	}
+	sent, exists := acct.specialMailbox(SpecialSent)
+	return saveSentCopyCmd(acct.name, acct.client, sent, !exists, raw)
+}
+
+const mailboxHelpText = "enter: select | h/l: collapse/expand | n: new | e: rename | d: delete | s: subscribe | S: subscribed only | c: compose | q: quit"
+
+const outboxHelpText = "enter: edit | r: retry now | x: cancel | esc: back | q: quit"
+
+// closeOutbox returns to the view that was active before the outbox
+func (m *Model) closeOutbox() {
+	m.state = m.outboxReturnState
+	m.setHelpTextForState()
+}
+
+// setOutboxEntries refreshes the outbox view and the status bar counter
+func (m *Model) setOutboxEntries(entries []outbox.Entry) {
+	m.outboxList.SetEntries(entries)
+	m.statusBar.SetOutboxCount(len(entries))
+}
+
+// editedOutboxID returns the outbox entry open in compose, which must not
+// be retried while the user is changing it
+func (m Model) editedOutboxID() string {
+	if m.state != composeView {
+		return ""
+	}
+	return m.compose.OutboxID()
+}
+
+// switchMailbox makes mailbox the current one, dropping whatever was still
+// being loaded for the previous mailbox
+func (m *Model) switchMailbox(mailbox string) {
+	if mailbox == m.currentMailbox && m.unified == nil {
+		return
+	}
+	if m.cancelMailbox != nil {
+		m.cancelMailbox()
+	}
+	m.mailboxCtx, m.cancelMailbox = context.WithCancel(context.Background())
+	m.currentMailbox = mailbox
+	m.unified = nil
+}
+
+// refreshCounts fetches the message counts of a mailbox of the active
+// account after the monitor saw it change
+func (m Model) refreshCounts(mailbox string) tea.Cmd {
+	acct := m.account()
+	return loadMailboxCountsCmd(acct.name, acct.client, []string{mailbox})
+}
+
+// followMailboxChange keeps the open mailbox in step with a rename of it,
+// or of a mailbox it is nested in, and closes it when it was deleted
+func (m *Model) followMailboxChange(msg MailboxChangedMsg, delim rune) tea.Cmd {
+	switch {
+	case msg.Deleted && msg.Mailbox == m.currentMailbox:
+		m.account().stopMonitoring()
+		m.switchMailbox("")
+		m.emailList.SetMailbox("")
+		m.emailList.SetEmails(nil, 0)
+		return nil
+	case msg.NewName != "":
+		renamed := renamedMailbox(m.currentMailbox, msg.Mailbox, msg.NewName, delim)
+		if renamed == m.currentMailbox {
+			return nil
+		}
+		m.account().stopMonitoring()
+		m.switchMailbox(renamed)
+		m.emailList.SetMailbox(renamed)
+		return m.reloadEmails()
+	}
+	return nil
+}
+
+// reloadEmails fetches the newest messages of the current mailbox, as many
+// as are listed so the older pages loaded so far stay
+func (m Model) reloadEmails() tea.Cmd {
+	count := uint32(m.config.Behavior.PageSize)
+	if listed := uint32(len(m.emailList.emails)); listed > count && !m.inSearchResults {
+		count = listed
+	}
+	return loadEmailsCmd(m.mailboxCtx, m.account().name, m.account().client, m.currentMailbox, count)
+}
+
+// closeCompose returns to the view that was active before composing
+func (m *Model) closeCompose() {
+	m.state = m.composeReturnState
+	// A reply may have borrowed the identities of another account
+	m.compose.SetIdentities(m.account().identities)
+	m.setHelpTextForState()
+}
+
+// setHelpTextForState shows the key hints of the active view
+func (m *Model) setHelpTextForState() {
+	switch m.state {
+	case mailboxListView:
+		m.statusBar.SetHelpText(mailboxHelpText)
+	case emailReaderView:
+		m.statusBar.SetHelpText("2: back to list | r: reply | R: reply all | F: forward | c: compose | q: quit")
+	case outboxView:
+		m.statusBar.SetHelpText(outboxHelpText)
+	default:
+		m.statusBar.SetHelpText("enter: read | c: compose | s: sort | f: filter | m: mark | M: move | d: delete | /: search | q: quit")
+	}
+}
+
+// hasMailbox reports whether the account's server has a mailbox with the
+// special use, so a newly created one shows up in the list
+func hasMailbox(acct *account, use SpecialUse) bool {
+	_, exists := acct.specialMailbox(use)
+	return exists
+}
+
+// tickUndo refreshes the undo-send countdown and schedules the next tick
+func (m *Model) tickUndo() tea.Cmd {
+	remaining := time.Until(m.undo.deadline)
+	seconds := int(math.Ceil(remaining.Seconds()))
+	m.statusBar.SetCountdown(fmt.Sprintf("⏳ Sending in %ds, u to undo", max(seconds, 0)))
+
+	id := m.undo.id
+	next := remaining % time.Second
+	if next <= 0 {
+		next = time.Second
+	}
+	return tea.Tick(next, func(time.Time) tea.Msg {
+		return undoTickMsg{id: id}
+	})
+}
+
+// senderAccount returns the account draft is from. A reply from All
+// Inboxes may be from another account than the active one.
+func (m Model) senderAccount(draft email.Message) *account {
+	if acct := accountFor(m.accounts, draft.From[0].Email); acct != nil {
+		return acct
+	}
+	return m.account()
+}
+
+// withSender fills in the default sender when the draft has none
+func (m Model) withSender(draft email.Message) (email.Message, error) {
+	if len(draft.From) > 0 {
+		return draft, nil
+	}
+	from, err := fromAddress(m.account().config)
+	if err != nil {
+		return draft, err
+	}
+	draft.From = []email.Address{from}
+	return draft, nil
+}
@@ -0,0 +1,151 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/chhlga/budge/internal/outbox"
+)
+
+var outboxDetailStyle = lipgloss.NewStyle().Foreground(dimColor)
+
+// outboxItem implements list.Item interface
+type outboxItem struct {
+	entry outbox.Entry
+}
+
+func (o outboxItem) Title() string       { return o.entry.Subject }
+func (o outboxItem) Description() string { return "" }
+func (o outboxItem) FilterValue() string { return "" }
+
+// outboxDelegate renders a queued message as its subject followed by the
+// recipients and delivery state
+type outboxDelegate struct{}
+
+func (d outboxDelegate) Height() int                             { return 2 }
+func (d outboxDelegate) Spacing() int                            { return 1 }
+func (d outboxDelegate) Update(_ tea.Msg, _ *list.Model) tea.Cmd { return nil }
+func (d outboxDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	entry := item.(outboxItem).entry
+
+	subject := entry.Subject
+	if subject == "" {
+		subject = "(no subject)"
+	}
+	if index == m.Index() {
+		subject = SelectedItemStyle.Render("▶ " + subject)
+	} else {
+		subject = "  " + subject
+	}
+
+	detail := fmt.Sprintf("  to %s · %s", strings.Join(entry.Recipients, ", "), outboxState(entry, time.Now()))
+
+	fmt.Fprint(w, subject+"\n"+outboxDetailStyle.Render(detail))
+}
+
+// outboxState describes where a queued message is in its retry cycle
+func outboxState(entry outbox.Entry, now time.Time) string {
+	switch {
+	case entry.Held:
+		return ErrorStyle.Render("held: " + entry.LastError)
+	case entry.Attempts == 0 && entry.NextAttempt.After(now):
+		return "scheduled for " + entry.NextAttempt.Format("Mon Jan 02 15:04")
+	case entry.Attempts == 0:
+		return "waiting to send"
+	case !entry.NextAttempt.After(now):
+		return fmt.Sprintf("attempt %d failed (%s), retrying now", entry.Attempts, entry.LastError)
+	default:
+		wait := entry.NextAttempt.Sub(now).Round(time.Second)
+		return fmt.Sprintf("attempt %d failed (%s), retry in %s", entry.Attempts, entry.LastError, wait)
+	}
+}
+
+// OutboxList is the view of messages waiting to be sent
+type OutboxList struct {
+	list list.Model
+	keys KeyMap
+}
+
+// NewOutboxList creates a new outbox view
+func NewOutboxList(keys KeyMap) OutboxList {
+	l := list.New([]list.Item{}, outboxDelegate{}, 0, 0)
+	l.Title = "Outbox"
+	l.SetShowStatusBar(false)
+	l.SetFilteringEnabled(false)
+	l.SetShowHelp(false)
+
+	return OutboxList{
+		list: l,
+		keys: keys,
+	}
+}
+
+// SetSize updates the outbox list dimensions
+func (o *OutboxList) SetSize(width, height int) {
+	o.list.SetSize(width, height-1)
+}
+
+// SetEntries replaces the listed messages, keeping the cursor in range
+func (o *OutboxList) SetEntries(entries []outbox.Entry) {
+	items := make([]list.Item, len(entries))
+	for i, entry := range entries {
+		items[i] = outboxItem{entry: entry}
+	}
+	o.list.SetItems(items)
+}
+
+// Len returns the number of listed messages
+func (o OutboxList) Len() int {
+	return len(o.list.Items())
+}
+
+func (o OutboxList) selected() (outbox.Entry, bool) {
+	item, ok := o.list.SelectedItem().(outboxItem)
+	return item.entry, ok
+}
+
+// Init initializes the outbox list
+func (o OutboxList) Init() tea.Cmd {
+	return nil
+}
+
+// Update handles messages for the outbox list
+func (o OutboxList) Update(msg tea.Msg) (OutboxList, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		entry, ok := o.selected()
+		switch {
+		case key.Matches(msg, o.keys.Enter) && ok:
+			return o, func() tea.Msg { return OutboxEditRequestMsg{ID: entry.ID} }
+		case key.Matches(msg, o.keys.OutboxCancel) && ok:
+			return o, func() tea.Msg { return OutboxCancelRequestMsg{ID: entry.ID} }
+		case key.Matches(msg, o.keys.OutboxRetry) && ok:
+			return o, func() tea.Msg { return OutboxRetryRequestMsg{ID: entry.ID} }
+		}
+	case OutboxLoadedMsg:
+		o.SetEntries(msg.Entries)
+	}
+
+	o.list, cmd = o.list.Update(msg)
+	return o, cmd
+}
+
+// View renders the outbox list
+func (o OutboxList) View() string {
+	if o.Len() == 0 {
+		return lipgloss.JoinVertical(lipgloss.Left,
+			TitleStyle.Render("Outbox"),
+			"",
+			"  Nothing waiting to be sent",
+		)
+	}
+	return o.list.View()
+}
@@ -3,6 +3,7 @@ package tui
 import (
 	"fmt"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -12,7 +13,10 @@ import (
 // EmailReader is the email reader view with scrolling viewport
 type EmailReader struct {
 	viewport viewport.Model
+	keys     KeyMap
 	email    *email.Message
+	source   MailboxRef
+	original *email.Message // Fully parsed message, set once the body loads
 	body     string
 	ready    bool
 	width    int
@@ -22,6 +26,7 @@ type EmailReader struct {
 // NewEmailReader creates a new email reader view
 func NewEmailReader(keys KeyMap) EmailReader {
 	return EmailReader{
+		keys:  keys,
 		ready: false,
 	}
 }
@@ -45,12 +50,35 @@ func (r *EmailReader) SetSize(width, height int) {
 	}
 }
 
-// SetEmail sets the current email
-func (r *EmailReader) SetEmail(msg email.Message) {
+// SetEmail sets the current email and the mailbox it is from
+func (r *EmailReader) SetEmail(msg email.Message, source MailboxRef) {
 	r.email = &msg
+	r.source = source
+	r.original = nil
 	r.body = "" // Reset body, will be loaded separately
 }
 
+// SetOriginal stores the fully parsed message used for replies
+func (r *EmailReader) SetOriginal(msg *email.Message) {
+	r.original = msg
+}
+
+// Original returns the fully parsed message, or nil while it is loading
+func (r EmailReader) Original() *email.Message {
+	return r.original
+}
+
+// Source returns the mailbox the current email is from, empty for the
+// mailbox that is open
+func (r EmailReader) Source() MailboxRef {
+	return r.source
+}
+
+// Showing reports whether the message with uid is the one being read
+func (r EmailReader) Showing(uid uint32) bool {
+	return r.email != nil && r.email.UID == uid
+}
+
 // SetBody sets the rendered email body
 func (r *EmailReader) SetBody(body string) {
 	r.body = body
@@ -68,11 +96,25 @@ func (r EmailReader) Update(msg tea.Msg) (EmailReader, tea.Cmd) {
 	var cmd tea.Cmd
 
 	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, r.keys.Reply):
+			return r, func() tea.Msg { return ReplyRequestMsg{All: false} }
+		case key.Matches(msg, r.keys.ReplyAll):
+			return r, func() tea.Msg { return ReplyRequestMsg{All: true} }
+		case key.Matches(msg, r.keys.Forward), key.Matches(msg, r.keys.ForwardAs):
+			if r.email == nil {
+				break
+			}
+			request := ForwardRequestMsg{UID: r.email.UID, AsAttachment: key.Matches(msg, r.keys.ForwardAs), Source: r.source}
+			return r, func() tea.Msg { return request }
+		}
 	case EmailSelectedMsg:
-		r.SetEmail(msg.Email)
+		r.SetEmail(msg.Email, msg.Source)
 	case EmailBodyLoadedMsg:
-		if r.email != nil && r.email.UID == msg.UID {
+		if r.Showing(msg.UID) {
 			r.SetBody(msg.Body)
+			r.SetOriginal(msg.Message)
 		}
 	}
 
@@ -0,0 +1,68 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// scheduleFormatHint lists the inputs parseSendTime understands
+const scheduleFormatHint = "17:30, tomorrow 09:00, 2026-01-02 08:00 or +2h"
+
+// parseSendTime reads the time a message should be sent at, relative to
+// now. A bare clock time means the next time the clock shows it.
+func parseSendTime(input string, now time.Time) (time.Time, error) {
+	input = strings.ToLower(strings.TrimSpace(input))
+	if input == "" {
+		return time.Time{}, fmt.Errorf("enter a time, e.g. %s", scheduleFormatHint)
+	}
+
+	var at time.Time
+
+	switch {
+	case strings.HasPrefix(input, "+"):
+		d, err := time.ParseDuration(input[1:])
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("invalid delay %q", input)
+		}
+		at = now.Add(d)
+
+	case strings.HasPrefix(input, "tomorrow"):
+		clock, err := parseClock(strings.TrimSpace(strings.TrimPrefix(input, "tomorrow")), now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		at = clock.AddDate(0, 0, 1)
+
+	case strings.Contains(input, "-"):
+		t, err := time.ParseInLocation("2006-01-02 15:04", input, now.Location())
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD HH:MM", input)
+		}
+		at = t
+
+	default:
+		clock, err := parseClock(input, now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		at = clock
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+	}
+
+	if !at.After(now) {
+		return time.Time{}, fmt.Errorf("%s is in the past", at.Format("Jan 02 15:04"))
+	}
+	return at, nil
+}
+
+// parseClock returns today's date at the given HH:MM
+func parseClock(input string, now time.Time) (time.Time, error) {
+	t, err := time.Parse("15:04", input)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, use HH:MM", input)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location()), nil
+}
@@ -0,0 +1,47 @@
+package tui
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSendTime(t *testing.T) {
+	now := time.Date(2026, 3, 14, 16, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"+2h", now.Add(2 * time.Hour), false},
+		{"+90m", now.Add(90 * time.Minute), false},
+		{"17:30", time.Date(2026, 3, 14, 17, 30, 0, 0, time.Local), false},
+		{"09:00", time.Date(2026, 3, 15, 9, 0, 0, 0, time.Local), false},
+		{"tomorrow 08:15", time.Date(2026, 3, 15, 8, 15, 0, 0, time.Local), false},
+		{"Tomorrow 08:15", time.Date(2026, 3, 15, 8, 15, 0, 0, time.Local), false},
+		{"2026-04-01 10:00", time.Date(2026, 4, 1, 10, 0, 0, 0, time.Local), false},
+		{"2026-03-01 10:00", time.Time{}, true},
+		{"", time.Time{}, true},
+		{"+0s", time.Time{}, true},
+		{"+soon", time.Time{}, true},
+		{"25:00", time.Time{}, true},
+		{"next week", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSendTime(tt.input, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tt.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.input, tt.want, got)
+		}
+	}
+}
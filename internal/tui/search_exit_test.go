@@ -69,7 +69,7 @@ func TestEscInEmailListView_exitsSearchResultsRestoresPreviousListAndClearsFilte
 	}
 
 	searchResults := []email.Message{{UID: 10, Subject: "hello"}}
-	updated, _ = m.Update(EmailsLoadedMsg{Emails: searchResults, Total: uint32(len(searchResults))})
+	updated, _ = m.Update(EmailsLoadedMsg{Mailbox: "INBOX", Emails: searchResults, Total: uint32(len(searchResults))})
 	m = updated.(Model)
 	if len(m.emailList.emails) != 1 || m.emailList.emails[0].UID != 10 {
 		t.Fatalf("expected search results to be loaded")
@@ -0,0 +1,177 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// SpecialUse is the role of a mailbox as RFC 6154 defines them, plus
+// INBOX. Features that file messages somewhere, like saving drafts or
+// sent copies, find their mailbox by it.
+type SpecialUse string
+
+const (
+	SpecialInbox   SpecialUse = "inbox"
+	SpecialSent    SpecialUse = "sent"
+	SpecialDrafts  SpecialUse = "drafts"
+	SpecialArchive SpecialUse = "archive"
+	SpecialAll     SpecialUse = "all"
+	SpecialFlagged SpecialUse = "flagged"
+	SpecialJunk    SpecialUse = "junk"
+	SpecialTrash   SpecialUse = "trash"
+)
+
+// specialUses lists the roles in the order they are listed in, with the
+// LIST attribute that marks them, the names budge looks for on servers
+// without SPECIAL-USE (the first is the one a missing mailbox is created
+// under) and the icon shown in front of them
+var specialUses = []struct {
+	use   SpecialUse
+	attr  imap.MailboxAttr
+	names []string
+	icon  string
+}{
+	{SpecialInbox, "", []string{"INBOX"}, "📥"},
+	{SpecialSent, imap.MailboxAttrSent, []string{"Sent", "Sent Messages", "Sent Items", "[Gmail]/Sent Mail"}, "📤"},
+	{SpecialDrafts, imap.MailboxAttrDrafts, []string{"Drafts", "Draft", "[Gmail]/Drafts"}, "📝"},
+	{SpecialArchive, imap.MailboxAttrArchive, []string{"Archive"}, "📦"},
+	{SpecialAll, imap.MailboxAttrAll, []string{"All Mail", "[Gmail]/All Mail"}, "📚"},
+	{SpecialFlagged, imap.MailboxAttrFlagged, []string{"Flagged", "Starred", "[Gmail]/Starred"}, "⭐"},
+	{SpecialJunk, imap.MailboxAttrJunk, []string{"Junk", "Spam", "[Gmail]/Spam"}, "🚫"},
+	{SpecialTrash, imap.MailboxAttrTrash, []string{"Trash", "Deleted Items", "[Gmail]/Trash"}, "🚮"},
+}
+
+// specialUseOf returns the role a LIST attribute marks, if any
+func specialUseOf(attr imap.MailboxAttr) SpecialUse {
+	for _, entry := range specialUses {
+		if entry.attr != "" && strings.EqualFold(string(attr), string(entry.attr)) {
+			return entry.use
+		}
+	}
+	return ""
+}
+
+// specialUseRank orders mailboxes with a role before the others, in the
+// order of specialUses
+func specialUseRank(use SpecialUse) int {
+	for rank, entry := range specialUses {
+		if entry.use == use {
+			return rank
+		}
+	}
+	return len(specialUses)
+}
+
+// specialUseIcon returns the icon of a role, or an empty string
+func specialUseIcon(use SpecialUse) string {
+	for _, entry := range specialUses {
+		if entry.use == use {
+			return entry.icon
+		}
+	}
+	return ""
+}
+
+// specialUseName returns the generic name of a role's mailbox
+func specialUseName(use SpecialUse) string {
+	for _, entry := range specialUses {
+		if entry.use == use {
+			return entry.names[0]
+		}
+	}
+	return string(use)
+}
+
+// SpecialMailboxes maps each role to the mailbox of an account that has it
+type SpecialMailboxes map[SpecialUse]string
+
+// Mailbox returns the mailbox with the given role
+func (s SpecialMailboxes) Mailbox(use SpecialUse) (string, bool) {
+	name, ok := s[use]
+	return name, ok
+}
+
+// Use returns the role of a mailbox, or an empty string when it has none
+func (s SpecialMailboxes) Use(mailbox string) SpecialUse {
+	for use, name := range s {
+		if name == mailbox {
+			return use
+		}
+	}
+	return ""
+}
+
+// resolveSpecialUses works out the role of every mailbox. A mailbox named
+// in the account's special_mailboxes gets that role, then the SPECIAL-USE
+// attributes count, and a role neither gives falls to the first mailbox
+// found under one of its usual names. Each role ends up with at most one
+// mailbox.
+func resolveSpecialUses(mailboxes []MailboxInfo, overrides map[string]string) []MailboxInfo {
+	resolved := make([]MailboxInfo, len(mailboxes))
+	copy(resolved, mailboxes)
+
+	taken := make(map[SpecialUse]bool)
+	for use, name := range overrides {
+		for i := range resolved {
+			if resolved[i].Name == name {
+				resolved[i].SpecialUse = SpecialUse(use)
+				taken[SpecialUse(use)] = true
+			}
+		}
+	}
+
+	for i, mb := range resolved {
+		if mb.SpecialUse == "" || overrides[string(mb.SpecialUse)] == mb.Name {
+			continue
+		}
+		if taken[mb.SpecialUse] {
+			resolved[i].SpecialUse = ""
+			continue
+		}
+		taken[mb.SpecialUse] = true
+	}
+
+	for _, entry := range specialUses {
+		for _, name := range entry.names {
+			if taken[entry.use] {
+				break
+			}
+			for i, mb := range resolved {
+				if mb.SpecialUse == "" && strings.EqualFold(mb.Name, name) {
+					resolved[i].SpecialUse = entry.use
+					taken[entry.use] = true
+					break
+				}
+			}
+		}
+	}
+	return resolved
+}
+
+// missingOverrides lists the roles whose special_mailboxes entry names no
+// mailbox of the account, as "no mailbox Gesendet for sent"
+func missingOverrides(mailboxes []MailboxInfo, overrides map[string]string) []string {
+	var missing []string
+	for _, entry := range specialUses {
+		name, ok := overrides[string(entry.use)]
+		if !ok || slices.ContainsFunc(mailboxes, func(mb MailboxInfo) bool { return mb.Name == name }) {
+			continue
+		}
+		missing = append(missing, fmt.Sprintf("no mailbox %s for %s", name, entry.use))
+	}
+	return missing
+}
+
+// specialMailboxes collects the roles of resolved mailboxes
+func specialMailboxes(mailboxes []MailboxInfo) SpecialMailboxes {
+	special := make(SpecialMailboxes)
+	for _, mb := range mailboxes {
+		if mb.SpecialUse != "" {
+			special[mb.SpecialUse] = mb.Name
+		}
+	}
+	return special
+}
@@ -0,0 +1,171 @@
+package tui
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+func TestNewMailboxInfo_readsSpecialUse(t *testing.T) {
+	info := newMailboxInfo(&imap.ListData{
+		Mailbox: "Gesendet",
+		Delim:   '/',
+		Attrs:   []imap.MailboxAttr{imap.MailboxAttrHasNoChildren, imap.MailboxAttrSent},
+	}, false)
+	if info.SpecialUse != SpecialSent {
+		t.Errorf("Expected \\Sent to mark the Sent mailbox, got %q", info.SpecialUse)
+	}
+}
+
+func TestResolveSpecialUses(t *testing.T) {
+	tests := []struct {
+		name      string
+		mailboxes []MailboxInfo
+		overrides map[string]string
+		want      SpecialMailboxes
+	}{
+		{
+			name: "attributes",
+			mailboxes: []MailboxInfo{
+				{Name: "INBOX"},
+				{Name: "[Gmail]/Sent Mail", SpecialUse: SpecialSent},
+				{Name: "[Gmail]/Drafts", SpecialUse: SpecialDrafts},
+				{Name: "[Gmail]/All Mail", SpecialUse: SpecialAll},
+				{Name: "[Gmail]/Spam", SpecialUse: SpecialJunk},
+				{Name: "Sent"},
+			},
+			want: SpecialMailboxes{
+				SpecialInbox:  "INBOX",
+				SpecialSent:   "[Gmail]/Sent Mail",
+				SpecialDrafts: "[Gmail]/Drafts",
+				SpecialAll:    "[Gmail]/All Mail",
+				SpecialJunk:   "[Gmail]/Spam",
+			},
+		},
+		{
+			name:      "usual names without SPECIAL-USE",
+			mailboxes: []MailboxInfo{{Name: "Inbox"}, {Name: "drafts"}, {Name: "Trash"}, {Name: "Sent Messages"}, {Name: "[Gmail]/Starred"}},
+			want: SpecialMailboxes{
+				SpecialInbox:   "Inbox",
+				SpecialSent:    "Sent Messages",
+				SpecialDrafts:  "drafts",
+				SpecialFlagged: "[Gmail]/Starred",
+				SpecialTrash:   "Trash",
+			},
+		},
+		{
+			name:      "first usual name wins",
+			mailboxes: []MailboxInfo{{Name: "INBOX"}, {Name: "[Gmail]/Sent Mail"}, {Name: "Sent"}},
+			want:      SpecialMailboxes{SpecialInbox: "INBOX", SpecialSent: "Sent"},
+		},
+		{
+			name: "overrides win",
+			mailboxes: []MailboxInfo{
+				{Name: "INBOX"},
+				{Name: "Sent", SpecialUse: SpecialSent},
+				{Name: "Gesendet"},
+				{Name: "Papierkorb"},
+			},
+			overrides: map[string]string{"sent": "Gesendet", "trash": "Papierkorb"},
+			want: SpecialMailboxes{
+				SpecialInbox: "INBOX",
+				SpecialSent:  "Gesendet",
+				SpecialTrash: "Papierkorb",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := specialMailboxes(resolveSpecialUses(tt.mailboxes, tt.overrides))
+			if len(got) != len(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			for use, name := range tt.want {
+				if got[use] != name {
+					t.Errorf("Expected %s to be %q, got %q", use, name, got[use])
+				}
+			}
+		})
+	}
+}
+
+func TestMailboxList_ordersSpecialMailboxesFirst(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.account().config.SpecialMailboxes = map[string]string{"trash": "Corbeille"}
+	updated, _ := m.Update(MailboxesLoadedMsg{Account: m.account().name, Mailboxes: []MailboxInfo{
+		{Name: "Alpha"},
+		{Name: "Corbeille"},
+		{Name: "Envoyés", SpecialUse: SpecialSent},
+		{Name: "INBOX"},
+	}})
+	m = updated.(Model)
+
+	want := []struct {
+		name string
+		use  SpecialUse
+	}{
+		{"INBOX", SpecialInbox},
+		{"Envoyés", SpecialSent},
+		{"Corbeille", SpecialTrash},
+		{"Alpha", ""},
+	}
+	items := m.mailboxList.list.Items()
+	if len(items) != len(want) {
+		t.Fatalf("Expected %d rows, got %d", len(want), len(items))
+	}
+	for i, item := range items {
+		if got := item.(mailboxItem); got.name != want[i].name || got.use != want[i].use {
+			t.Errorf("Row %d: expected %s (%q), got %s (%q)", i, want[i].name, want[i].use, got.name, got.use)
+		}
+	}
+
+	if sent, ok := m.account().specialMailbox(SpecialSent); !ok || sent != "Envoyés" {
+		t.Errorf("Expected sent copies to go to Envoyés, got %q", sent)
+	}
+}
+
+func TestSpecialMailbox_findsUsualNamesWithoutSpecialUse(t *testing.T) {
+	client := connectTestClient(t)
+	for _, name := range []string{"[Gmail]/Sent Mail", "[Gmail]/Drafts", "[Gmail]/All Mail"} {
+		if err := client.Client().Create(name, nil).Wait(); err != nil {
+			t.Fatalf("Create(%s) error: %v", name, err)
+		}
+	}
+
+	loaded, ok := loadMailboxesCmd("work", client)().(MailboxesLoadedMsg)
+	if !ok {
+		t.Fatalf("Expected MailboxesLoadedMsg")
+	}
+	m := newComposeTestModel(nil)
+	loaded.Account = m.account().name
+	updated, _ := m.Update(loaded)
+	m = updated.(Model)
+
+	for use, want := range map[SpecialUse]string{
+		SpecialSent:   "[Gmail]/Sent Mail",
+		SpecialDrafts: "[Gmail]/Drafts",
+		SpecialAll:    "[Gmail]/All Mail",
+	} {
+		if got, ok := m.account().specialMailbox(use); !ok || got != want {
+			t.Errorf("Expected %s to be %q, got %q (found: %v)", use, want, got, ok)
+		}
+	}
+}
+
+func TestMailboxesLoaded_warnsAboutMissingOverride(t *testing.T) {
+	m := newComposeTestModel(nil)
+	m.account().config.SpecialMailboxes = map[string]string{"sent": "Gesendet", "trash": "Papierkorb"}
+	updated, _ := m.Update(MailboxesLoadedMsg{Account: m.account().name, Mailboxes: []MailboxInfo{
+		{Name: "INBOX"},
+		{Name: "Papierkorb"},
+	}})
+	m = updated.(Model)
+
+	if want := "special_mailboxes: no mailbox Gesendet for sent"; m.statusBar.notice != want || !m.statusBar.noticeIsError {
+		t.Errorf("Expected the warning %q, got %q", want, m.statusBar.notice)
+	}
+	if trash, ok := m.account().specialMailbox(SpecialTrash); !ok || trash != "Papierkorb" {
+		t.Errorf("Expected the other override to apply, got %q", trash)
+	}
+}
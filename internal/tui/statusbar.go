@@ -2,17 +2,31 @@ package tui
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
+// noticeDuration is how long a one-off notice stays in the status bar
+const noticeDuration = 5 * time.Second
+
+type noticeExpiredMsg struct {
+	id int
+}
+
 type StatusBar struct {
+	account         string
 	connectionState string
 	helpText        string
 	loading         bool
 	loadingText     string
+	notice          string
+	noticeIsError   bool
+	noticeID        int
+	outboxCount     int
+	countdown       string
 	spinner         spinner.Model
 	width           int
 }
@@ -32,14 +46,43 @@ func (s *StatusBar) SetConnectionState(state string) {
 	s.connectionState = state
 }
 
+// SetAccount names the account the connection state belongs to. It is
+// left empty when there is only one.
+func (s *StatusBar) SetAccount(name string) {
+	s.account = name
+}
+
 func (s *StatusBar) SetHelpText(text string) {
 	s.helpText = text
 }
 
+// SetOutboxCount sets the number of messages waiting in the outbox
+func (s *StatusBar) SetOutboxCount(n int) {
+	s.outboxCount = n
+}
+
+// SetCountdown shows a running countdown that takes precedence over
+// notices until it is cleared with an empty string
+func (s *StatusBar) SetCountdown(text string) {
+	s.countdown = text
+}
+
 func (s *StatusBar) SetSize(width int) {
 	s.width = width
 }
 
+// SetNotice shows a temporary message and returns the command that clears it
+func (s *StatusBar) SetNotice(text string, isError bool) tea.Cmd {
+	s.noticeID++
+	s.notice = text
+	s.noticeIsError = isError
+
+	id := s.noticeID
+	return tea.Tick(noticeDuration, func(time.Time) tea.Msg {
+		return noticeExpiredMsg{id: id}
+	})
+}
+
 func (s StatusBar) Update(msg tea.Msg) (StatusBar, tea.Cmd) {
 	var cmd tea.Cmd
 
@@ -73,6 +116,23 @@ func (s StatusBar) Update(msg tea.Msg) (StatusBar, tea.Cmd) {
 	case ConnectErrorMsg:
 		s.loading = false
 		s.loadingText = ""
+	case EmailSentMsg:
+		s.loading = false
+		s.loadingText = ""
+		cmd = s.SetNotice("Message sent", false)
+	case SendErrorMsg:
+		s.loading = false
+		s.loadingText = ""
+		cmd = s.SetNotice("Send failed: "+msg.Err.Error(), true)
+	case EmailQueuedMsg:
+		s.loading = false
+		s.loadingText = ""
+		cmd = s.SetNotice("Can't reach the server, message queued in the outbox", false)
+	case noticeExpiredMsg:
+		if msg.id == s.noticeID {
+			s.notice = ""
+			s.noticeIsError = false
+		}
 	case spinner.TickMsg:
 		if s.loading {
 			s.spinner, cmd = s.spinner.Update(msg)
@@ -84,9 +144,24 @@ func (s StatusBar) Update(msg tea.Msg) (StatusBar, tea.Cmd) {
 
 func (s *StatusBar) View() string {
 	left := fmt.Sprintf("📡 %s", s.connectionState)
+	if s.account != "" {
+		left = fmt.Sprintf("📡 %s: %s", s.account, s.connectionState)
+	}
 
-	if s.loading && s.loadingText != "" {
+	if s.countdown != "" {
+		left = WarningStyle.Render(s.countdown)
+	} else if s.loading && s.loadingText != "" {
 		left = fmt.Sprintf("%s %s", s.spinner.View(), s.loadingText)
+	} else if s.notice != "" {
+		left = s.notice
+		if s.noticeIsError {
+			left = ErrorStyle.Render(left)
+		}
+	}
+
+	// Queued mail stays visible while notices come and go
+	if s.outboxCount > 0 {
+		left += fmt.Sprintf(" | 📤 %d queued", s.outboxCount)
 	}
 
 	right := s.helpText
@@ -9,6 +9,7 @@ var (
 	textColor      = lipgloss.Color("#FAFAFA")
 	dimColor       = lipgloss.Color("#666666")
 	errorColor     = lipgloss.Color("#FF0000")
+	warningColor   = lipgloss.Color("#FFA500")
 
 	// Styles
 	TitleStyle = lipgloss.NewStyle().
@@ -25,6 +26,9 @@ var (
 			Foreground(errorColor).
 			Bold(true)
 
+	WarningStyle = lipgloss.NewStyle().
+			Foreground(warningColor)
+
 	BorderStyle = lipgloss.NewStyle().
 			Border(lipgloss.RoundedBorder()).
 			BorderForeground(secondaryColor).
@@ -34,6 +38,10 @@ var (
 				Foreground(primaryColor).
 				Bold(true)
 
+	AccountStyle = lipgloss.NewStyle().
+			Foreground(secondaryColor).
+			Bold(true)
+
 	UnreadStyle = lipgloss.NewStyle().
 			Bold(true).
 			Foreground(textColor)
@@ -0,0 +1,155 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/chhlga/budge/internal/email"
+)
+
+func openUnified(t *testing.T) Model {
+	t.Helper()
+
+	m := newAccountsTestModel(nil, nil)
+	updated, _ := m.Update(UnifiedSelectedMsg{})
+	m = updated.(Model)
+	if m.state != emailListView || m.unified == nil {
+		t.Fatalf("Expected All Inboxes to open in the email list")
+	}
+
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	work := MailboxRef{Account: "work", Mailbox: "INBOX"}
+	home := MailboxRef{Account: "home", Mailbox: "Inbox"}
+	for _, msg := range []UnifiedEmailsLoadedMsg{
+		{Source: work, Emails: []email.Message{
+			{UID: 1, Subject: "work old", Date: day},
+			{UID: 2, Subject: "work new", Date: day.Add(48 * time.Hour)},
+		}, Total: 2},
+		{Source: home, Emails: []email.Message{
+			{UID: 1, Subject: "home", Date: day.Add(24 * time.Hour)},
+		}, Total: 1},
+	} {
+		updated, _ = m.Update(msg)
+		m = updated.(Model)
+	}
+	return m
+}
+
+func TestUnified_mergesMailboxesByDate(t *testing.T) {
+	m := openUnified(t)
+
+	items := m.emailList.list.Items()
+	want := []struct{ subject, tag string }{
+		{"work new", "work/INBOX"},
+		{"home", "home/Inbox"},
+		{"work old", "work/INBOX"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("Expected %d merged messages, got %d", len(want), len(items))
+	}
+	for i, item := range items {
+		got := item.(emailItem)
+		if got.msg.Subject != want[i].subject || got.tag != want[i].tag {
+			t.Errorf("Item %d: expected %q tagged %q, got %q tagged %q", i, want[i].subject, want[i].tag, got.msg.Subject, got.tag)
+		}
+	}
+	if m.emailList.total != 3 {
+		t.Errorf("Expected the totals to add up, got %d", m.emailList.total)
+	}
+
+	// Leaving All Inboxes drops what is still loading for it
+	m.switchMailbox("INBOX")
+	updated, _ := m.Update(UnifiedEmailsLoadedMsg{Source: MailboxRef{Account: "home", Mailbox: "Inbox"}})
+	m = updated.(Model)
+	if m.unified != nil {
+		t.Errorf("Expected a late result not to reopen All Inboxes")
+	}
+}
+
+func TestUnified_routesActionsToSource(t *testing.T) {
+	m := openUnified(t)
+
+	// Both mailboxes have a message with UID 1; pick the one from home
+	m.emailList.list.Select(1)
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'m'}})
+	if cmd == nil {
+		t.Fatalf("Expected m to request marking the message")
+	}
+	request, ok := cmd().(MarkReadRequestMsg)
+	if !ok {
+		t.Fatalf("Expected MarkReadRequestMsg, got %T", cmd())
+	}
+	if request.UID != 1 || request.Source != (MailboxRef{Account: "home", Mailbox: "Inbox"}) {
+		t.Fatalf("Expected the request to name home's Inbox, got %+v", request)
+	}
+
+	acct, mailbox := m.messageSource(request.Source)
+	if acct == nil || acct.name != "home" || mailbox != "Inbox" {
+		t.Fatalf("Expected the request to be routed to home's Inbox, got %v %q", acct, mailbox)
+	}
+
+	updated, _ := m.Update(request)
+	m = updated.(Model)
+	for i, msg := range m.emailList.emails {
+		seen := !msg.IsUnread()
+		if want := m.emailList.sources[i].Account == "home"; seen != want {
+			t.Errorf("Expected only home's message to be marked read, %q seen=%v", msg.Subject, seen)
+		}
+	}
+
+	// Moving asks for the destination first; it is a mailbox of home
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'M'}})
+	m = updated.(Model)
+	if !m.emailList.Moving() {
+		t.Fatalf("Expected M to ask where to move the message")
+	}
+	m = typeText(m, "Archive")
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	move, ok := cmd().(MoveEmailRequestMsg)
+	if !ok || move.UID != 1 || move.To != "Archive" || move.Source != request.Source {
+		t.Fatalf("Expected the move to name home's Inbox and Archive, got %#v", move)
+	}
+
+	updated, _ = m.Update(MailboxesLoadedMsg{Account: "home", Mailboxes: []MailboxInfo{{Name: "Inbox"}}})
+	m = updated.(Model)
+	updated, _ = m.Update(move)
+	m = updated.(Model)
+	if !strings.Contains(m.statusBar.notice, "home has no mailbox Archive") {
+		t.Errorf("Expected the move to be checked against home's mailboxes, got %q", m.statusBar.notice)
+	}
+	updated, _ = m.Update(MailboxesLoadedMsg{Account: "home", Mailboxes: []MailboxInfo{{Name: "Inbox"}, {Name: "Archive"}}})
+	m = updated.(Model)
+	if _, cmd = m.Update(move); cmd == nil {
+		t.Errorf("Expected the message to be moved within home")
+	}
+}
+
+func TestUnified_replyUsesSourceAccount(t *testing.T) {
+	m := openUnified(t)
+
+	m.emailList.list.Select(1)
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	updated, _ := m.Update(cmd())
+	m = updated.(Model)
+	if m.emailReader.Source().Account != "home" {
+		t.Fatalf("Expected the reader to remember the source, got %+v", m.emailReader.Source())
+	}
+
+	m.emailReader.SetOriginal(&email.Message{
+		From:    []email.Address{{Email: "friend@example.com"}},
+		To:      []email.Address{{Email: "alias@home.example"}},
+		Subject: "home",
+	})
+	updated, _ = m.Update(ReplyRequestMsg{})
+	m = updated.(Model)
+
+	draft, err := m.compose.Draft()
+	if err != nil {
+		t.Fatalf("Draft() error: %v", err)
+	}
+	if len(draft.From) != 1 || draft.From[0].Email != "alias@home.example" {
+		t.Errorf("Expected the reply to be sent as home's identity, got %+v", draft.From)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/chhlga/budge/internal/secret"
+	"golang.org/x/term"
+)
+
+// runKeyring asks for a password without echoing it and saves it in the
+// system keyring for the username given as the only argument
+func runKeyring(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: budge keyring <username>")
+	}
+	username := args[0]
+
+	fmt.Fprintf(os.Stderr, "Password for %s: ", username)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+	if len(password) == 0 {
+		return errors.New("empty password")
+	}
+
+	if err := secret.StoreInKeyring(username, string(password)); err != nil {
+		return err
+	}
+	fmt.Printf("Password for %s stored in the system keyring\n", username)
+	return nil
+}
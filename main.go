@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/chhlga/budge/internal/config"
+	"github.com/chhlga/budge/internal/folders"
 	"github.com/chhlga/budge/internal/imap"
+	"github.com/chhlga/budge/internal/oauth"
+	"github.com/chhlga/budge/internal/outbox"
+	"github.com/chhlga/budge/internal/smtp"
 	"github.com/chhlga/budge/internal/tui"
 )
 
@@ -21,6 +27,15 @@ func main() {
 
 	configPath := filepath.Join(homeDir, ".config", "budge", "config.yaml")
 
+	// budge keyring stores the password that keyring: true reads, so it
+	// must work before the config can be loaded
+	if len(os.Args) > 1 && os.Args[1] == "keyring" {
+		if err := runKeyring(os.Args[2:]); err != nil {
+			log.Fatalf("failed to store password: %v", err)
+		}
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.Load(configPath)
 	if err != nil {
@@ -38,24 +53,51 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Run: chmod 600 %s\n\n", configPath)
 	}
 
-	// Create IMAP client
-	imapOpts := &imap.Options{
-		Host:     cfg.Server.Host,
-		Port:     cfg.Server.Port,
-		TLS:      cfg.Server.TLS,
-		STARTTLS: cfg.Server.STARTTLS,
-		Username: cfg.Credentials.Username,
-		Password: cfg.Credentials.Password,
+	// budge auth obtains the OAuth2 token the mail session signs in with
+	if len(os.Args) > 1 && os.Args[1] == "auth" {
+		if err := runAuth(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("sign-in failed: %v", err)
+		}
+		return
 	}
 
-	client := imap.NewClient(imapOpts)
+	// Notice connections that died while budge sat idle
+	keepaliveCtx, stopKeepalive := context.WithCancel(context.Background())
+	defer stopKeepalive()
 
-	// Note: Actual IMAP connection and operations will be done via tea.Cmd
-	// to avoid blocking the TUI event loop. For this initial version,
-	// we're just creating the client wrapper.
+	// Every account gets a connection of its own. Actual IMAP connection
+	// and operations are done via tea.Cmd to avoid blocking the TUI event
+	// loop.
+	var accounts []tui.Account
+	for _, acct := range cfg.AccountList() {
+		account, err := newAccount(acct)
+		if err != nil {
+			log.Fatalf("account %s: %v", acct.Name, err)
+		}
+		account.Client.Keepalive(keepaliveCtx)
+		accounts = append(accounts, account)
+	}
 
 	// Create TUI model
-	model := tui.NewModel(cfg, client)
+	model := tui.NewModel(cfg, accounts)
+
+	// Messages that can't be sent while offline wait in the outbox
+	if dir, err := outbox.DefaultDir(); err == nil {
+		if queue, err := outbox.Open(dir, nil); err == nil {
+			model.SetOutbox(queue)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: outbox disabled: %v\n", err)
+		}
+	}
+
+	// Collapsed folders stay collapsed between runs
+	if path, err := folders.DefaultPath(); err == nil {
+		if state, err := folders.Load(path); err == nil {
+			model.SetFolders(state)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: folder state not loaded: %v\n", err)
+		}
+	}
 
 	// Run the TUI
 	p := tea.NewProgram(model, tea.WithAltScreen(), tea.WithMouseCellMotion())
@@ -63,3 +105,58 @@ func main() {
 		log.Fatalf("error running TUI: %v", err)
 	}
 }
+
+// newAccount creates the IMAP client of an account and, when it has an
+// smtp section, its sender. Sending is optional, budge works read-only
+// without one.
+func newAccount(acct config.AccountConfig) (tui.Account, error) {
+	var tokens *oauth.TokenSource
+	if acct.UsesOAuth2() {
+		store, err := tokenStore(acct)
+		if err != nil {
+			return tui.Account{}, err
+		}
+		if _, err := store.Load(); err != nil {
+			return tui.Account{}, fmt.Errorf("failed to load OAuth2 token: %w", err)
+		}
+		tokens = oauth.NewTokenSource(acct.Credentials.OAuth2.OAuth2Client(), store)
+	}
+
+	imapOpts := &imap.Options{
+		Host:              acct.Server.Host,
+		Port:              acct.Server.Port,
+		TLS:               acct.Server.TLS,
+		STARTTLS:          acct.Server.STARTTLS,
+		Username:          acct.Credentials.Username,
+		Password:          acct.Credentials.Password,
+		AuthMechanism:     acct.Credentials.Auth,
+		CommandTimeout:    time.Duration(acct.Server.Timeout) * time.Second,
+		KeepaliveInterval: time.Duration(acct.Server.Keepalive) * time.Second,
+	}
+	if tokens != nil {
+		imapOpts.OAuth2Token = tokens.AccessToken
+	}
+
+	account := tui.Account{
+		Config: acct,
+		Client: imap.NewClient(imapOpts),
+	}
+
+	if acct.SMTP.Enabled() {
+		smtpOpts := &smtp.Options{
+			Host:          acct.SMTP.Host,
+			Port:          acct.SMTP.Port,
+			TLS:           acct.SMTP.TLS,
+			STARTTLS:      acct.SMTP.STARTTLS,
+			Username:      acct.SMTP.Username,
+			Password:      acct.SMTP.Password,
+			AuthMechanism: acct.SMTP.Auth,
+		}
+		if tokens != nil {
+			smtpOpts.OAuth2Token = tokens.AccessToken
+		}
+		account.Sender = smtp.NewClient(smtpOpts)
+	}
+
+	return account, nil
+}